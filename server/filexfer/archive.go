@@ -0,0 +1,134 @@
+package filexfer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	ArchiveCompNone = "none"
+	ArchiveCompZstd = "zstd"
+)
+
+// maxArchiveChunkBytes bounds one FA/1 chunk; servers send 256 KiB chunks.
+const maxArchiveChunkBytes = 64 * 1024 * 1024
+
+type ArchiveTransferRequest struct {
+	TransferID string
+	// Comp is ArchiveCompNone (the default) for a plain tar stream or
+	// ArchiveCompZstd to have the server compress it.
+	Comp string
+	// Mode is the load strategy, "fast" (the default) or "gentle".
+	Mode         string
+	AgePublicKey string
+	AgeIdentity  string
+}
+
+type ArchiveTransferResponse struct {
+	// WireBytes is the number of archive bytes written to the destination.
+	WireBytes int64
+}
+
+// ArchiveTransfer streams every file of a transfer as one POSIX tar archive
+// into w. Ownership, mode and mtime come from the server's file metadata. The
+// server refuses with a CONFLICT error if the transfer root changed since its
+// manifest was built. Archives do not acknowledge progress.
+func (c *Client) ArchiveTransfer(ctx context.Context, request ArchiveTransferRequest, w io.Writer) (ArchiveTransferResponse, error) {
+	if c == nil {
+		return ArchiveTransferResponse{}, errors.New("nil client")
+	}
+	if request.TransferID == "" {
+		return ArchiveTransferResponse{}, errors.New("missing transfer id")
+	}
+	if w == nil {
+		return ArchiveTransferResponse{}, errors.New("missing archive destination")
+	}
+	switch request.Comp {
+	case "":
+		request.Comp = ArchiveCompNone
+	case ArchiveCompNone, ArchiveCompZstd:
+	default:
+		return ArchiveTransferResponse{}, fmt.Errorf("unsupported archive compression %q", request.Comp)
+	}
+	return c.archiveTransferTCP(ctx, request, w)
+}
+
+func (c *Client) archiveTransferTCP(ctx context.Context, request ArchiveTransferRequest, w io.Writer) (ArchiveTransferResponse, error) {
+	state, err := c.resolveTCPAuthState(request.AgePublicKey, request.AgeIdentity)
+	if err != nil {
+		return ArchiveTransferResponse{}, err
+	}
+	conn, err := c.dialTCP(ctx)
+	if err != nil {
+		return ArchiveTransferResponse{}, fmt.Errorf("dial file listener: %w", err)
+	}
+	conn = closeOnCancel(ctx, conn)
+	defer conn.Close()
+	if err := c.sendTCPAuth(conn, state); err != nil {
+		return ArchiveTransferResponse{}, fmt.Errorf("send AUTH: %w", err)
+	}
+
+	cmd := fmt.Sprintf("ARCHIVE %s comp=%s", request.TransferID, request.Comp)
+	if request.Mode != "" {
+		cmd += " mode=" + request.Mode
+	}
+	cmd += traceParentOption(ctx)
+	if err := c.sendTCPCommand(conn, state, cmd); err != nil {
+		return ArchiveTransferResponse{}, fmt.Errorf("send ARCHIVE: %w", err)
+	}
+	responseReader, err := c.responseReaderForTCP(conn, state)
+	if err != nil {
+		return ArchiveTransferResponse{}, fmt.Errorf("initialize ARCHIVE response stream: %w", err)
+	}
+	br := bufio.NewReader(responseReader)
+	var resp ArchiveTransferResponse
+	for {
+		line, err := readTCPLine(br, maxTCPLineBytes)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return resp, ctxErr
+			}
+			return resp, fmt.Errorf("read ARCHIVE chunk: %w", err)
+		}
+		if err := parseErrControlFrame(line); err != nil {
+			return resp, err
+		}
+		n, err := parseArchiveChunkLine(line)
+		if err != nil {
+			return resp, err
+		}
+		if n == 0 {
+			break
+		}
+		copied, err := io.CopyN(w, br, n)
+		resp.WireBytes += copied
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return resp, ctxErr
+			}
+			return resp, fmt.Errorf("copy ARCHIVE chunk: %w", err)
+		}
+	}
+	if _, err := readTCPStatus(br); err != nil {
+		return resp, fmt.Errorf("read ARCHIVE status: %w", err)
+	}
+	return resp, nil
+}
+
+// parseArchiveChunkLine decodes an "FA/1 <n>" chunk header; 0 ends the archive.
+func parseArchiveChunkLine(line string) (int64, error) {
+	rest, found := strings.CutPrefix(strings.TrimRight(line, "\r\n"), "FA/1 ")
+	if !found {
+		return 0, fmt.Errorf("unexpected ARCHIVE line: %s", strings.TrimSpace(line))
+	}
+	n, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || n < 0 || n > maxArchiveChunkBytes {
+		return 0, fmt.Errorf("invalid ARCHIVE chunk size %q", rest)
+	}
+	return n, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/trace"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -22,6 +24,8 @@ import (
 
 	"filippo.io/age"
 	intencoding "github.com/jolynch/pinch/internal/filexfer/encoding"
+	"github.com/jolynch/pinch/internal/filexfer/source"
+	"github.com/jolynch/pinch/tracing"
 	"github.com/jolynch/pinch/utils"
 	"github.com/zeebo/xxh3"
 )
@@ -54,6 +58,18 @@ type TransferStatus struct {
 	PercentFiles   float64        `json:"percent_files"`
 	PercentBytes   float64        `json:"percent_bytes"`
 	DownloadStatus DownloadStatus `json:"download_status"`
+	// LinkMbps is the server's current link hint for the transfer; see
+	// LinkSource for whether it came from the TXFER probe or a client.
+	LinkMbps   int64  `json:"link_mbps"`
+	LinkRTTMS  int64  `json:"link_rtt_ms,omitempty"`
+	LinkSource string `json:"link_source,omitempty"`
+	LinkAgeMS  int64  `json:"link_age_ms,omitempty"`
+	// Paused is set between PauseTransfer and ResumeTransfer.
+	Paused bool `json:"paused,omitempty"`
+	// ExpiresAtMS is when the server reaps the transfer unless a SEND, ACK
+	// or RenewTransfer extends it by TTLMS first; 0 while Paused.
+	ExpiresAtMS int64 `json:"expires_at_ms"`
+	TTLMS       int64 `json:"ttl_ms"`
 }
 
 type ClientOption interface {
@@ -126,6 +142,66 @@ func WithComp(comp string) ClientOption {
 	})
 }
 
+// Extended trailer metadata a client may ask the server to capture.
+const (
+	FileMetadataAtime  = "atime"
+	FileMetadataXattrs = "xattrs"
+)
+
+// WithFileMetadata asks the server to include extended metadata (atime,
+// xattrs) in terminal trailers. Unknown names are dropped.
+func WithFileMetadata(keys ...string) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.FileMetadata = normalizeFileMetadata(keys)
+	})
+}
+
+func normalizeFileMetadata(keys []string) []string {
+	var out []string
+	for _, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		switch key {
+		case FileMetadataAtime, FileMetadataXattrs:
+			if !slices.Contains(out, key) {
+				out = append(out, key)
+			}
+		}
+	}
+	return out
+}
+
+// WithSparse asks the server to send holes in sparse files as payload-less
+// hole frames. Readers still see zeros for those extents.
+func WithSparse(enabled bool) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.Sparse = enabled
+	})
+}
+
+// Consistency modes for files that change between TXFER and SEND.
+const (
+	ConsistencyStrict = "strict" // fail the file with ErrFileChanged
+	ConsistencyWarn   = "warn"   // server logs and sends current bytes
+	ConsistencyIgnore = "ignore" // no checks
+)
+
+// WithConsistency selects how the server handles files that changed since
+// TXFER; empty leaves the server default (warn).
+func WithConsistency(mode string) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.Consistency = normalizeConsistency(mode)
+	})
+}
+
+func normalizeConsistency(mode string) string {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case ConsistencyStrict, ConsistencyWarn, ConsistencyIgnore:
+		return mode
+	default:
+		return ""
+	}
+}
+
 func normalizeComp(comp string) string {
 	switch strings.ToLower(strings.TrimSpace(comp)) {
 	case EncodingLz4:
@@ -151,7 +227,17 @@ type Client struct {
 	AckRequestTimeout       time.Duration
 	SocketReadBufferBytes   int
 	LoadStrategy            string
-	Comp                    string // adapt|none|lz4|zstd; empty means server default (adapt)
+	Comp                    string   // adapt|none|lz4|zstd; empty means server default (adapt)
+	FileMetadata            []string // extended trailer metadata, see WithFileMetadata
+	Sparse                  bool     // request hole frames for sparse files
+	Consistency             string   // strict|warn|ignore; empty means server default (warn)
+	AdaptiveConcurrency     bool     // see WithAdaptiveConcurrency
+	MinConcurrency          int
+	MaxConcurrency          int
+	Replicas                []ReplicaServer // see WithReplica
+	ReplicaStallTimeout     time.Duration
+	RemoteReadAheadBytes    int64       // see WithRemoteReadAhead
+	Retry                   RetryPolicy // see WithRetryPolicy
 
 	// Context dialer allows clients to setup custom connections
 	// For example injecting TLS
@@ -162,6 +248,12 @@ type Client struct {
 
 	// scratchBufferPool caches reusable temporary byte buffers.
 	scratchBufferPool sync.Pool
+
+	// link is the passive link estimate from this client's downloads.
+	link linkEstimator
+
+	// idleConns keeps plaintext keepalive connections for RemoteFile reads.
+	idleConns idleConnPool
 }
 
 type Manifest struct {
@@ -174,17 +266,28 @@ type Manifest struct {
 }
 
 type ManifestEntry struct {
-	ID       uint64
-	Size     int64
-	Mtime    int64
-	Mode     os.FileMode
-	Path     string
-	Progress ManifestProgress
+	ID    uint64
+	Size  int64
+	Mtime int64
+	Mode  os.FileMode
+	Path  string
+	// HoleBytes is how much of Size the source file leaves unallocated;
+	// non-zero means the file is sparse.
+	HoleBytes int64
+	Progress  ManifestProgress
+}
+
+// AllocatedSize is the number of bytes the source file occupies on disk.
+func (e ManifestEntry) AllocatedSize() int64 {
+	return e.Size - e.HoleBytes
 }
 
 type ManifestProgress struct {
 	AckBytes     int64
 	MetadataDone bool
+	// Sink is an opaque resume token a Sink saved for the file, such as an
+	// S3 multipart upload id and the ETags of its uploaded parts.
+	Sink string
 }
 
 type FileTrailerMetadata struct {
@@ -195,6 +298,10 @@ type FileTrailerMetadata struct {
 	GID     string
 	User    string
 	Group   string
+	// Extended metadata, present only when requested with WithFileMetadata.
+	AtimeNS         int64
+	Xattrs          map[string][]byte
+	XattrsTruncated bool
 }
 
 type FileFrameMeta struct {
@@ -211,6 +318,9 @@ type FileFrameMeta struct {
 	HashToken       string
 	FileHashToken   string
 	TrailerMetadata *FileTrailerMetadata
+	// Hole marks a zero-filled extent the server sent without payload
+	// (frame headers only).
+	Hole bool
 }
 
 type DownloadProgressUpdate struct {
@@ -230,8 +340,8 @@ type DownloadFileResponse struct {
 }
 
 type DownloadBatchRequest struct {
-	Manifest *Manifest
-	FileIDs  []uint64
+	Manifest     *Manifest
+	FileIDs      []uint64
 	OutputWriter func(ManifestEntry, int64) (io.WriteCloser, func() error, error)
 	// BatchMaxBytes is the unit of parallel work for a single large file: when a file
 	// exceeds BatchMaxBytes, it is split into windows of this size and downloaded
@@ -243,6 +353,25 @@ type DownloadBatchRequest struct {
 	AgePublicKey    string
 	AgeIdentity     string
 	ProgressUpdates chan<- DownloadProgressUpdate
+
+	// replicas, when set, spreads the batch over the primary server and
+	// its replicas; see StartFromManifestRequest.Replicas.
+	replicas *replicaPool
+	// resume, when set, records acknowledged offsets and resumes files
+	// from them when they are past the manifest's.
+	resume *resumeOffsets
+}
+
+// emitProgress sends update to ProgressUpdates without blocking.
+func (req DownloadBatchRequest) emitProgress(update DownloadProgressUpdate) {
+	req.resume.observe(update.FileID, update.AckBytes)
+	if req.ProgressUpdates == nil {
+		return
+	}
+	select {
+	case req.ProgressUpdates <- update:
+	default:
+	}
 }
 
 type DownloadBatchResponse struct {
@@ -253,13 +382,30 @@ type StartFromManifestRequest struct {
 	Manifest     *Manifest
 	Entries      []ManifestEntry
 	OutputWriter func(ManifestEntry, int64) (io.WriteCloser, func() error, error)
+	// Sink, used when OutputWriter is nil, opens each window's writer and
+	// finishes each file before OnFileDone.
+	Sink         Sink
 	AgePublicKey string
 	AgeIdentity  string
 	Concurrency  int
 	// BatchMaxBytes is the unit of parallel work per file. See DownloadBatchRequest.BatchMaxBytes.
-	BatchMaxBytes   int64
+	BatchMaxBytes int64
+	// Order is the order batches are handed to workers; the zero value
+	// keeps manifest order.
+	Order           TransferOrder
 	ProgressUpdates chan<- DownloadProgressUpdate
 	OnFileDone      func(StartFileDoneEvent)
+	// OnConcurrencyChange reports adaptive controller decisions; it is
+	// only called when the client has AdaptiveConcurrency set.
+	OnConcurrencyChange func(ConcurrencyDecision)
+	// OnRetry reports each failed batch about to be retried under the
+	// client's RetryPolicy.
+	OnRetry func(BatchRetry)
+	// Replicas are other servers' transfers of the same root, from
+	// OpenReplicas. Batches and split windows are spread over them and the
+	// client's own server, weighted by throughput, and move to another
+	// server when one fails or stalls.
+	Replicas []Replica
 }
 
 type StartFileDoneEvent struct {
@@ -273,6 +419,12 @@ type StartFromManifestResponse struct {
 	Failed           int
 	TransferredBytes int64
 	Errors           []error
+	// Retries is how many batch retries the run made.
+	Retries int
+	// Link is the client's passive link estimate when the run finished.
+	Link LinkEstimate
+	// Replicas is each server's share of the run when Replicas were given.
+	Replicas []ReplicaStats
 }
 
 type FetchManifestRequest struct {
@@ -282,6 +434,13 @@ type FetchManifestRequest struct {
 	Mode         string
 	LinkMbps     int64
 	Concurrency  int
+	// TTL, when > 0, asks the server to keep the transfer this long after
+	// its last SEND, ACK or RenewTransfer; the server caps it. It is sent
+	// in whole seconds, rounded up.
+	TTL time.Duration
+	// Sparse asks for <size>/<allocated> tokens on files with holes, which
+	// ManifestEntry.HoleBytes carries.
+	Sparse       bool
 	AgePublicKey string
 	AgeIdentity  string
 }
@@ -300,6 +459,11 @@ type ProbeResponse struct {
 	LinkMbps             int64
 	SuggestedConcurrency int
 	ServerSendBufBytes   int64
+	// ServerAgePublicKey is the age recipient announced by the server, if any.
+	ServerAgePublicKey string
+	// ServerAgePublicKeys are all the recipients the server accepts, primary
+	// first; during a key rotation they include the keys being retired.
+	ServerAgePublicKeys []string
 }
 
 type FetchManifestResponse struct {
@@ -363,6 +527,30 @@ type GetTransferStatusResponse struct {
 	Status *TransferStatus
 }
 
+type WatchTransferRequest struct {
+	TransferID string
+	// Heartbeat is how often an idle server proves the stream is alive;
+	// zero uses the server default.
+	Heartbeat time.Duration
+	// Sparse asks for <size>/<allocated> tokens on added files with holes.
+	Sparse bool
+}
+
+const (
+	WatchEventAdd     = "add"
+	WatchEventGrow    = "grow"
+	WatchEventReplace = "replace"
+)
+
+// WatchEvent is one change the server saw under a transfer root. Add
+// carries a full manifest entry for a new file id; grow and replace carry
+// only the ID, Size and Mtime of an existing entry. Replace means the file
+// shrank or was swapped out, so previously downloaded bytes are stale.
+type WatchEvent struct {
+	Kind  string
+	Entry ManifestEntry
+}
+
 type fileMissingError struct {
 	Status int
 	Body   string
@@ -374,6 +562,51 @@ func (e *fileMissingError) Error() string {
 
 var ErrFileMissing = errors.New("file missing")
 
+// ErrFileChanged matches errors for files the server saw change since TXFER
+// under strict consistency. The concrete error is a *FileChangedError.
+var ErrFileChanged = errors.New("file changed")
+
+// FileChangedError reports an ERR CHANGED status. Size and MtimeNS describe
+// the file as the server now sees it and has re-recorded it; a retry should
+// start over from offset 0 with that size.
+type FileChangedError struct {
+	FileID  uint64
+	Size    int64
+	MtimeNS int64
+	Message string
+}
+
+func (e *FileChangedError) Error() string {
+	return "CHANGED " + e.Message
+}
+
+func (e *FileChangedError) Is(target error) bool {
+	return target == ErrFileChanged
+}
+
+// parseFileChangedError reads the leading fid=, size= and mtime= tokens of an
+// ERR CHANGED message.
+func parseFileChangedError(message string) *FileChangedError {
+	out := &FileChangedError{Size: -1, Message: message}
+	for _, field := range strings.Fields(message) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			break
+		}
+		switch key {
+		case "fid":
+			out.FileID, _ = strconv.ParseUint(value, 10, 64)
+		case "size":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				out.Size = v
+			}
+		case "mtime":
+			out.MtimeNS, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return out
+}
+
 const (
 	// Window and batch sizes. The window is max in-flight bytes per file; the batch is
 	// the unit of parallel work. parallelism = window / batch.
@@ -428,7 +661,15 @@ func NewClient(fileAddr string, opts ...ClientOption) *Client {
 	return c
 }
 
-func (c *Client) FetchManifest(ctx context.Context, request FetchManifestRequest) (FetchManifestResponse, error) {
+func (c *Client) FetchManifest(ctx context.Context, request FetchManifestRequest) (resp FetchManifestResponse, err error) {
+	ctx, span := tracing.Start(ctx, "client.txfer", "directory", request.Directory)
+	defer func() {
+		if resp.Manifest != nil {
+			span.SetAttributes("txfer", resp.Manifest.TransferID, "files", len(resp.Manifest.Entries))
+		}
+		span.SetError(err)
+		span.End()
+	}()
 	ctx, task := trace.NewTask(ctx, "fetch-manifest")
 	defer task.End()
 	if c == nil {
@@ -484,7 +725,15 @@ func SaveManifest(path string, manifest *Manifest) error {
 			return fmt.Errorf("create manifest parent directory: %w", err)
 		}
 	}
-	if err := os.WriteFile(path, raw, 0o644); err != nil {
+	// Write a sibling temp file and rename it over the manifest so a crash
+	// mid-write never leaves a truncated .fm2 behind.
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, raw, 0o644); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
 		return fmt.Errorf("write manifest: %w", err)
 	}
 	return nil
@@ -517,6 +766,16 @@ func (m *Manifest) EntryByID(id uint64) (ManifestEntry, bool) {
 	return ManifestEntry{}, false
 }
 
+// ServerPath is entry's path on the server. Object-store roots such as
+// s3://bucket/prefix are joined with a plain slash so the scheme survives;
+// local roots are joined and cleaned as filesystem paths.
+func (m *Manifest) ServerPath(entry ManifestEntry) string {
+	if source.IsRemote(m.Root) {
+		return strings.TrimRight(m.Root, "/") + "/" + entry.Path
+	}
+	return filepath.Clean(filepath.Join(m.Root, filepath.FromSlash(entry.Path)))
+}
+
 func (c *Client) FetchFile(ctx context.Context, request FetchFileRequest) (FetchFileResponse, error) {
 	_ = request.AckBytes
 	if len(request.Files) != 1 {
@@ -638,6 +897,61 @@ func (c *Client) GetTransferStatus(ctx context.Context, request GetTransferStatu
 	return c.getTransferStatusTCP(ctx, request)
 }
 
+// CancelTransfer asks the server to abort the transfer's in-flight SENDs,
+// which end with ERR CANCELLED, and forget the transfer.
+func (c *Client) CancelTransfer(ctx context.Context, transferID string) error {
+	return c.controlTransfer(ctx, "CANCEL", transferID)
+}
+
+// PauseTransfer holds the transfer's SENDs between frames until
+// ResumeTransfer.
+func (c *Client) PauseTransfer(ctx context.Context, transferID string) error {
+	return c.controlTransfer(ctx, "PAUSE", transferID)
+}
+
+func (c *Client) ResumeTransfer(ctx context.Context, transferID string) error {
+	return c.controlTransfer(ctx, "RESUME", transferID)
+}
+
+// RenewTransfer extends the transfer's lease on the server, for clients
+// that hold a transfer without sending or acking, and returns the new
+// expiry.
+func (c *Client) RenewTransfer(ctx context.Context, transferID string) (time.Time, error) {
+	if c == nil {
+		return time.Time{}, errors.New("nil client")
+	}
+	if transferID == "" {
+		return time.Time{}, errors.New("missing transfer id")
+	}
+	return c.renewTransferTCP(ctx, transferID)
+}
+
+func (c *Client) controlTransfer(ctx context.Context, verb string, transferID string) error {
+	if c == nil {
+		return errors.New("nil client")
+	}
+	if transferID == "" {
+		return errors.New("missing transfer id")
+	}
+	return c.controlTransferTCP(ctx, verb, transferID)
+}
+
+// WatchTransfer streams changes under the transfer root to fn until ctx is
+// cancelled, the server goes away, or fn returns an error. New files are
+// registered server-side, so SEND and ACK accept their ids immediately.
+func (c *Client) WatchTransfer(ctx context.Context, request WatchTransferRequest, fn func(WatchEvent) error) error {
+	if c == nil {
+		return errors.New("nil client")
+	}
+	if request.TransferID == "" {
+		return errors.New("missing transfer id")
+	}
+	if fn == nil {
+		return errors.New("missing watch callback")
+	}
+	return c.watchTransferTCP(ctx, request, fn)
+}
+
 type downloadBatchPlan struct {
 	entry      ManifestEntry
 	serverPath string
@@ -654,9 +968,17 @@ type splitWindowResult struct {
 	window   splitWindow
 	response DownloadFileResponse
 	ack      AcknowledgeFileProgressRequest
+	// source is the replica that sent the window and must receive its ACK;
+	// nil means the client's own server.
+	source *replicaSource
 }
 
-func (c *Client) DownloadFilesFromManifestBatch(ctx context.Context, req DownloadBatchRequest) (DownloadBatchResponse, error) {
+func (c *Client) DownloadFilesFromManifestBatch(ctx context.Context, req DownloadBatchRequest) (_ DownloadBatchResponse, err error) {
+	ctx, span := tracing.Start(ctx, "client.batch", "files", len(req.FileIDs))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
 	ctx, task := trace.NewTask(ctx, "download-batch")
 	defer task.End()
 	if req.Manifest == nil {
@@ -668,6 +990,7 @@ func (c *Client) DownloadFilesFromManifestBatch(ctx context.Context, req Downloa
 	if req.OutputWriter == nil {
 		return DownloadBatchResponse{}, errors.New("missing output writer callback")
 	}
+	span.SetAttributes("txfer", req.Manifest.TransferID)
 	explicitBatch := req.BatchMaxBytes > 0 || (c != nil && c.BatchMaxBytes > 0)
 	req.BatchMaxBytes = c.effectiveBatchMaxBytes(req.BatchMaxBytes)
 	windowBytes := c.FileRequestWindowBytes
@@ -688,7 +1011,7 @@ func (c *Client) DownloadFilesFromManifestBatch(ctx context.Context, req Downloa
 		if err != nil {
 			return DownloadBatchResponse{}, err
 		}
-		resumeFrom := entry.Progress.AckBytes
+		resumeFrom := max(entry.Progress.AckBytes, req.resume.offset(fileID))
 		if resumeFrom < 0 {
 			return DownloadBatchResponse{}, fmt.Errorf("file %d resume offset must be >= 0", fileID)
 		}
@@ -710,6 +1033,9 @@ func (c *Client) DownloadFilesFromManifestBatch(ctx context.Context, req Downloa
 	if shouldSplitSingleFileBatch(req, plans) {
 		return c.downloadManifestBatchWindows(ctx, req, plans[0])
 	}
+	if req.replicas != nil {
+		return req.replicas.downloadSequential(ctx, req, plans, targets)
+	}
 	return c.downloadManifestBatchSequential(ctx, req, plans, targets)
 }
 
@@ -753,6 +1079,7 @@ func (c *Client) downloadManifestGroupSequential(
 	}
 	defer stream.Close()
 	br := bufio.NewReader(stream)
+	observer := c.frameObserver(ctx)
 
 	results := make([]DownloadFileResponse, 0, len(plans))
 	pendingAcks := make([]AcknowledgeFileProgressRequest, 0, len(plans))
@@ -821,6 +1148,9 @@ func (c *Client) downloadManifestGroupSequential(
 			headerTrimmed := strings.TrimRight(headerLine, "\r\n")
 			if isStatusLine(headerTrimmed) {
 				_ = closeWriter()
+				if err := parseErrControlFrame(headerTrimmed); err != nil {
+					return nil, nil, nil, err
+				}
 				return nil, nil, nil, fmt.Errorf("unexpected status line before file complete: %s", headerTrimmed)
 			}
 			frameMeta, parseErr := parseFXHeader(headerTrimmed)
@@ -840,14 +1170,8 @@ func (c *Client) downloadManifestGroupSequential(
 				frameBuf, releaseFrameBuf = c.acquireFrameReadBuffer(frameMeta.MaxWireSizeHint)
 			}
 
-			payloadReader := io.LimitReader(br, frameMeta.WireSize)
-			logicalReader, decodeErr := decodePayloadReader(payloadReader, frameMeta.Comp, frameMeta.Enc, nil)
-			if decodeErr != nil {
-				_ = closeWriter()
-				return nil, nil, nil, fmt.Errorf("decode payload reader: %w", decodeErr)
-			}
 			frameStartOffset := offset
-			copyErr := copyStreamWithProgress(io.MultiWriter(writer, fileHasher, windowHasher), logicalReader, frameBuf, nil, func(written int64) error {
+			onWrite := func(written int64) error {
 				emitProgressUpdate(DownloadProgressUpdate{
 					TransferID:  req.Manifest.TransferID,
 					FileID:      plan.entry.ID,
@@ -856,15 +1180,30 @@ func (c *Client) downloadManifestGroupSequential(
 					UpdateTime:  time.Now(),
 				})
 				return nil
-			})
-			closeLogicalErr := logicalReader.Close()
-			if copyErr != nil {
-				_ = closeWriter()
-				return nil, nil, nil, fmt.Errorf("stream output file: %w", copyErr)
 			}
-			if closeLogicalErr != nil {
-				_ = closeWriter()
-				return nil, nil, nil, closeLogicalErr
+			if holes, ok := writer.(HoleWriter); ok && frameMeta.Hole {
+				if err := holes.WriteHole(frameMeta.Size); err != nil {
+					_ = closeWriter()
+					return nil, nil, nil, fmt.Errorf("stream output file: %w", err)
+				}
+				hashZeros(frameMeta.Size, fileHasher, windowHasher)
+				_ = onWrite(frameMeta.Size)
+			} else {
+				logicalReader, decodeErr := decodeFrameReader(br, frameMeta, nil)
+				if decodeErr != nil {
+					_ = closeWriter()
+					return nil, nil, nil, fmt.Errorf("decode payload reader: %w", decodeErr)
+				}
+				copyErr := copyStreamWithProgress(io.MultiWriter(writer, fileHasher, windowHasher), logicalReader, frameBuf, nil, onWrite)
+				closeLogicalErr := logicalReader.Close()
+				if copyErr != nil {
+					_ = closeWriter()
+					return nil, nil, nil, fmt.Errorf("stream output file: %w", copyErr)
+				}
+				if closeLogicalErr != nil {
+					_ = closeWriter()
+					return nil, nil, nil, closeLogicalErr
+				}
 			}
 			meta.Size += frameMeta.Size
 			meta.WireSize += frameMeta.WireSize
@@ -877,6 +1216,10 @@ func (c *Client) downloadManifestGroupSequential(
 				_ = closeWriter()
 				return nil, nil, nil, fmt.Errorf("read frame trailer: %w", trailerReadErr)
 			}
+			if err := parseErrControlFrame(strings.TrimRight(trailerLine, "\r\n")); err != nil {
+				_ = closeWriter()
+				return nil, nil, nil, err
+			}
 			trailer, trailerErr := parseFXTrailer(strings.TrimRight(trailerLine, "\r\n"))
 			if trailerErr != nil {
 				_ = closeWriter()
@@ -887,6 +1230,7 @@ func (c *Client) downloadManifestGroupSequential(
 				return nil, nil, nil, fmt.Errorf("trailer file id mismatch: expected=%d got=%d", plan.entry.ID, trailer.FileID)
 			}
 			lastTrailerTS = trailer.TS
+			observer.ObserveFrame(frameMeta.WireSize, trailer.TS, time.Now().UnixMilli())
 			if trailer.Metadata != nil {
 				lastMetadata = cloneTrailerMetadata(trailer.Metadata)
 			}
@@ -1006,15 +1350,7 @@ func (c *Client) downloadManifestBatchSequential(
 	if ackTimeout <= 0 {
 		ackTimeout = defaultClientAckRequestTimeout
 	}
-	emitProgressUpdate := func(update DownloadProgressUpdate) {
-		if req.ProgressUpdates == nil {
-			return
-		}
-		select {
-		case req.ProgressUpdates <- update:
-		default:
-		}
-	}
+	emitProgressUpdate := req.emitProgress
 
 	windowBytes := c.FileRequestWindowBytes
 	if windowBytes <= 0 {
@@ -1039,8 +1375,8 @@ func (c *Client) downloadManifestBatchSequential(
 	} else {
 		planGroups, targetGroups := splitSequentialGroups(plans, targets, k)
 		type groupResult struct {
-			files     []DownloadFileResponse
-			acks      []AcknowledgeFileProgressRequest
+			files      []DownloadFileResponse
+			acks       []AcknowledgeFileProgressRequest
 			progresses []seqAckProgress
 		}
 		results := make([]groupResult, len(planGroups))
@@ -1114,15 +1450,7 @@ func (c *Client) downloadManifestBatchWindows(
 	if ackTimeout <= 0 {
 		ackTimeout = defaultClientAckRequestTimeout
 	}
-	emitProgressUpdate := func(update DownloadProgressUpdate) {
-		if req.ProgressUpdates == nil {
-			return
-		}
-		select {
-		case req.ProgressUpdates <- update:
-		default:
-		}
-	}
+	emitProgressUpdate := req.emitProgress
 	if plan.resumeFrom > 0 {
 		emitProgressUpdate(DownloadProgressUpdate{
 			TransferID:  req.Manifest.TransferID,
@@ -1186,7 +1514,13 @@ func (c *Client) downloadManifestBatchWindows(
 			}
 			defer func() { <-limiter }()
 
-			result, err := c.downloadSplitWindow(ctx, req, plan, window, w, s, emitProgressUpdate)
+			var result splitWindowResult
+			var err error
+			if req.replicas != nil {
+				result, err = req.replicas.downloadWindow(ctx, req, plan, window, w, s, emitProgressUpdate)
+			} else {
+				result, err = c.downloadSplitWindow(ctx, req, plan, window, w, s, emitProgressUpdate)
+			}
 			if err != nil {
 				setErr(err)
 				return
@@ -1211,25 +1545,40 @@ func (c *Client) downloadManifestBatchWindows(
 		pending[result.window.start] = result
 
 		// Collect the contiguous chain of completed windows starting at nextAckOffset.
-		var ackBatch []AcknowledgeFileProgressRequest
+		var chain []splitWindowResult
 		for next := nextAckOffset; ; {
 			ready, ok := pending[next]
 			if !ok {
 				break
 			}
-			ackBatch = append(ackBatch, ready.ack)
+			chain = append(chain, ready)
 			next = ready.window.end
 		}
-		if len(ackBatch) == 0 {
+		if len(chain) == 0 {
 			continue
 		}
 		_, ackTask := trace.NewTask(requestCtx, "ack")
-		ackErr := retryAck(requestCtx, func(callCtx context.Context) error {
-			ackCtx, cancelAck := context.WithTimeout(callCtx, ackTimeout)
-			defer cancelAck()
-			_, err := c.acknowledgeFileProgressBatch(ackCtx, ackBatch)
-			return err
-		})
+		var ackErr error
+		// Each window is acknowledged to the server that sent it, since
+		// that server holds its window hash.
+		for len(chain) > 0 && ackErr == nil {
+			source := chain[0].source
+			var ackBatch []AcknowledgeFileProgressRequest
+			for len(chain) > 0 && chain[0].source == source {
+				ackBatch = append(ackBatch, chain[0].ack)
+				chain = chain[1:]
+			}
+			ackClient := c
+			if source != nil {
+				ackClient = source.client
+			}
+			ackErr = retryAck(requestCtx, func(callCtx context.Context) error {
+				ackCtx, cancelAck := context.WithTimeout(callCtx, ackTimeout)
+				defer cancelAck()
+				_, err := ackClient.acknowledgeFileProgressBatch(ackCtx, ackBatch)
+				return err
+			})
+		}
 		ackTask.End()
 		if ackErr != nil {
 			setErr(fmt.Errorf("acknowledge download failed: %w", ackErr))
@@ -1311,7 +1660,12 @@ func (c *Client) downloadSplitWindow(
 	writer io.WriteCloser,
 	syncOutput func() error,
 	emitProgressUpdate func(DownloadProgressUpdate),
-) (splitWindowResult, error) {
+) (_ splitWindowResult, err error) {
+	ctx, span := tracing.Start(ctx, "client.window", "txfer", req.Manifest.TransferID, "fid", plan.entry.ID, "offset", window.start, "size", window.size)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
 	ctx, windowTask := trace.NewTask(ctx, "download-window")
 	defer windowTask.End()
 	start := time.Now()
@@ -1360,7 +1714,7 @@ func (c *Client) downloadSplitWindow(
 	defer releaseFrameBuf()
 
 	windowHasher := xxh3.New128()
-	copyErr := copyStreamWithProgress(io.MultiWriter(writer, windowHasher), reader, frameBuf, nil, func(written int64) error {
+	copyErr := copyStreamWithProgress(writer, reader, frameBuf, windowHasher, func(written int64) error {
 		emitProgressUpdate(DownloadProgressUpdate{
 			TransferID:  req.Manifest.TransferID,
 			FileID:      plan.entry.ID,
@@ -1492,13 +1846,18 @@ func aggregateSplitWindowResults(
 	return aggregate, nil
 }
 
-func (c *Client) StartFromManifest(ctx context.Context, req StartFromManifestRequest) (StartFromManifestResponse, error) {
+func (c *Client) StartFromManifest(ctx context.Context, req StartFromManifestRequest) (_ StartFromManifestResponse, err error) {
 	if c == nil {
 		return StartFromManifestResponse{}, errors.New("nil client")
 	}
 	if req.Manifest == nil {
 		return StartFromManifestResponse{}, errors.New("nil manifest")
 	}
+	ctx, span := tracing.Start(ctx, "client.start", "txfer", req.Manifest.TransferID)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
 	entries := req.Entries
 	if entries == nil {
 		entries = req.Manifest.Entries
@@ -1509,11 +1868,18 @@ func (c *Client) StartFromManifest(ctx context.Context, req StartFromManifestReq
 	if len(entries) == 0 {
 		return resp, nil
 	}
+	if req.OutputWriter == nil && req.Sink != nil {
+		req.OutputWriter = req.Sink.OpenWindow
+	}
 	if req.OutputWriter == nil {
 		return StartFromManifestResponse{}, errors.New("missing output writer callback")
 	}
 	if req.Concurrency <= 0 {
-		if req.Manifest.Concurrency > 0 {
+		// A live link estimate from earlier downloads beats the manifest's
+		// one-off probe.
+		if suggested := c.link.Estimate().suggestedConcurrency(); suggested > 0 {
+			req.Concurrency = suggested
+		} else if req.Manifest.Concurrency > 0 {
 			req.Concurrency = req.Manifest.Concurrency
 		} else {
 			req.Concurrency = DefaultClientConcurrency()
@@ -1521,54 +1887,54 @@ func (c *Client) StartFromManifest(ctx context.Context, req StartFromManifestReq
 	}
 	req.Concurrency = clampConcurrency(req.Concurrency)
 	batchMaxBytes := c.effectiveBatchMaxBytes(req.BatchMaxBytes)
-	batches := buildManifestBatchesByBytes(entries, batchMaxBytes)
+	batches := buildOrderedManifestBatches(entries, batchMaxBytes, req.Order)
 	workCh := make(chan []ManifestEntry)
+	submitted := make(chan struct{})
 	errCh := make(chan error, len(entries))
 	var wg sync.WaitGroup
 	var downloaded atomic.Int64
 	var transferred atomic.Int64
+	var retries atomic.Int64
+
+	var replicas *replicaPool
+	if len(req.Replicas) > 0 {
+		replicas = c.newReplicaPool(req.Manifest, req.Replicas)
+	}
+
+	workers := req.Concurrency
+	var controller *concurrencyController
+	if c.AdaptiveConcurrency {
+		controller = newConcurrencyController(req.Concurrency, c.MinConcurrency, c.MaxConcurrency, req.OnConcurrencyChange)
+		workers = controller.max
+		controlCtx, stopControl := context.WithCancel(ctx)
+		defer stopControl()
+		go controller.run(controlCtx, defaultConcurrencyInterval)
+		ctx = withFrameObserver(ctx, controller)
+	}
 
-	worker := func() {
+	worker := func(id int) {
 		defer wg.Done()
-		for batch := range workCh {
-			if len(batch) == 0 {
-				continue
-			}
-			fileIDs := make([]uint64, 0, len(batch))
-			for _, entry := range batch {
-				fileIDs = append(fileIDs, entry.ID)
-			}
-			startOne := time.Now()
-			downloadBatchResp, err := c.DownloadFilesFromManifestBatch(ctx, DownloadBatchRequest{
-				Manifest:        req.Manifest,
-				FileIDs:         fileIDs,
-				OutputWriter:    req.OutputWriter,
-				BatchMaxBytes:   batchMaxBytes,
-				AgePublicKey:    req.AgePublicKey,
-				AgeIdentity:     req.AgeIdentity,
-				ProgressUpdates: req.ProgressUpdates,
-			})
-			if err != nil {
-				errCh <- fmt.Errorf("batch first-id=%d count=%d: %w", batch[0].ID, len(batch), err)
-				continue
+		for {
+			if controller != nil && !controller.acquire(ctx, submitted, id) {
+				return
 			}
-			elapsedBatch := time.Since(startOne)
-			for _, downloadResp := range downloadBatchResp.Files {
-				downloaded.Add(1)
-				transferred.Add(downloadResp.Meta.Size)
-				if req.OnFileDone != nil {
-					req.OnFileDone(StartFileDoneEvent{
-						File:    downloadResp,
-						Elapsed: elapsedBatch,
-					})
+			batch, ok := <-workCh
+			if !ok {
+				if controller != nil {
+					controller.release()
 				}
+				return
+			}
+			c.downloadStartBatch(ctx, req, batch, batchMaxBytes, replicas, errCh, &downloaded, &transferred, &retries)
+			if controller != nil {
+				controller.release()
 			}
 		}
 	}
 
-	for i := 0; i < req.Concurrency; i++ {
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go worker()
+		go worker(i)
 	}
 	submitBatch := func(batch []ManifestEntry) bool {
 		select {
@@ -1584,6 +1950,7 @@ func (c *Client) StartFromManifest(ctx context.Context, req StartFromManifestReq
 		}
 	}
 	close(workCh)
+	close(submitted)
 	wg.Wait()
 	close(errCh)
 
@@ -1592,10 +1959,90 @@ func (c *Client) StartFromManifest(ctx context.Context, req StartFromManifestReq
 	}
 	resp.Downloaded = int(downloaded.Load())
 	resp.TransferredBytes = transferred.Load()
+	resp.Retries = int(retries.Load())
 	resp.Failed = len(resp.Errors)
+	resp.Link = c.link.Estimate()
+	if replicas != nil {
+		resp.Replicas = replicas.stats()
+	}
 	return resp, nil
 }
 
+// downloadStartBatch downloads one StartFromManifest batch, reporting
+// finished files to OnFileDone and a failure as a BatchError on errCh.
+func (c *Client) downloadStartBatch(
+	ctx context.Context,
+	req StartFromManifestRequest,
+	batch []ManifestEntry,
+	batchMaxBytes int64,
+	replicas *replicaPool,
+	errCh chan<- error,
+	downloaded *atomic.Int64,
+	transferred *atomic.Int64,
+	retries *atomic.Int64,
+) {
+	if len(batch) == 0 {
+		return
+	}
+	fileIDs := make([]uint64, 0, len(batch))
+	for _, entry := range batch {
+		fileIDs = append(fileIDs, entry.ID)
+	}
+	startOne := time.Now()
+	downloadBatchResp, err := c.downloadBatchWithRetry(ctx, DownloadBatchRequest{
+		Manifest:        req.Manifest,
+		FileIDs:         fileIDs,
+		OutputWriter:    req.OutputWriter,
+		BatchMaxBytes:   batchMaxBytes,
+		AgePublicKey:    req.AgePublicKey,
+		AgeIdentity:     req.AgeIdentity,
+		ProgressUpdates: req.ProgressUpdates,
+		replicas:        replicas,
+	}, req.OnRetry, retries)
+	if err != nil {
+		errCh <- &BatchError{FileIDs: fileIDs, Err: err}
+		return
+	}
+	elapsedBatch := time.Since(startOne)
+	for _, downloadResp := range downloadBatchResp.Files {
+		if req.Sink != nil {
+			fileID := downloadResp.Meta.FileID
+			entryIdx := slices.IndexFunc(batch, func(entry ManifestEntry) bool { return entry.ID == fileID })
+			if entryIdx < 0 {
+				errCh <- &BatchError{FileIDs: []uint64{fileID}, Err: errors.New("downloaded file not in batch")}
+				continue
+			}
+			if err := req.Sink.FinishFile(ctx, batch[entryIdx]); err != nil {
+				errCh <- &BatchError{FileIDs: []uint64{fileID}, Err: fmt.Errorf("finish file %d: %w", fileID, err)}
+				continue
+			}
+		}
+		downloaded.Add(1)
+		transferred.Add(downloadResp.Meta.Size)
+		if req.OnFileDone != nil {
+			req.OnFileDone(StartFileDoneEvent{
+				File:    downloadResp,
+				Elapsed: elapsedBatch,
+			})
+		}
+	}
+}
+
+// BatchError is a failed StartFromManifest batch. FileIDs lists every file
+// in the batch, including those the batch never reached.
+type BatchError struct {
+	FileIDs []uint64
+	Err     error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch first-id=%d count=%d: %v", e.FileIDs[0], len(e.FileIDs), e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
 func (c *Client) effectiveBatchMaxBytes(reqBatchMaxBytes int64) int64 {
 	if reqBatchMaxBytes > 0 {
 		return reqBatchMaxBytes
@@ -1671,9 +2118,15 @@ func summarizeProbeSamples(results []probeResponse, probeBytes int64) ProbeRespo
 	totalIntervalMS := int64(0)
 	serverCPU := 0
 	serverSendBufBytes := int64(0)
+	serverKey := ""
+	var serverKeys []string
 	for _, result := range results {
 		serverCPU = result.ServerCPU
 		serverSendBufBytes = result.ServerWmemBytes
+		if result.ServerKey != "" {
+			serverKey = result.ServerKey
+			serverKeys = result.ServerKeys
+		}
 		intervalMS := max(int64(1), (result.CTS1-result.CTS0)-(result.STS1-result.STS0))
 		totalIntervalMS += intervalMS
 	}
@@ -1681,10 +2134,12 @@ func summarizeProbeSamples(results []probeResponse, probeBytes int64) ProbeRespo
 	mbps := ((probeBytes * 8 * 1000) / avgMS) / 1_000_000
 	roundedMbps := ((mbps + 50) / 100) * 100
 	return ProbeResponse{
-		ServerCPU:          serverCPU,
-		AvgLatencyMS:       avgMS,
-		LinkMbps:           roundedMbps,
-		ServerSendBufBytes: serverSendBufBytes,
+		ServerCPU:           serverCPU,
+		AvgLatencyMS:        avgMS,
+		LinkMbps:            roundedMbps,
+		ServerSendBufBytes:  serverSendBufBytes,
+		ServerAgePublicKey:  serverKey,
+		ServerAgePublicKeys: serverKeys,
 	}
 }
 
@@ -1748,10 +2203,15 @@ func buildAcknowledgeFileProgressCommand(request AcknowledgeFileProgressRequest)
 	return acknowledgeFileProgressCommand{request: request, ackToken: ackToken}, nil
 }
 
-func (c *Client) acknowledgeFileProgressBatch(ctx context.Context, requests []AcknowledgeFileProgressRequest) (AcknowledgeFileProgressResponse, error) {
+func (c *Client) acknowledgeFileProgressBatch(ctx context.Context, requests []AcknowledgeFileProgressRequest) (_ AcknowledgeFileProgressResponse, err error) {
 	if len(requests) == 0 {
 		return AcknowledgeFileProgressResponse{}, errors.New("missing ack requests")
 	}
+	ctx, span := tracing.Start(ctx, "client.ack", "txfer", requests[0].TransferID, "files", len(requests))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
 	commands := make([]acknowledgeFileProgressCommand, 0, len(requests))
 	for _, request := range requests {
 		cmd, err := buildAcknowledgeFileProgressCommand(request)
@@ -1759,6 +2219,7 @@ func (c *Client) acknowledgeFileProgressBatch(ctx context.Context, requests []Ac
 			return AcknowledgeFileProgressResponse{}, err
 		}
 		commands = append(commands, cmd)
+		c.link.observeAck(request.DeltaBytes, request.RecvMS)
 	}
 	return c.acknowledgeFileProgressBatchTCP(ctx, commands)
 }
@@ -1812,7 +2273,6 @@ func retryAck(ctx context.Context, fn func(context.Context) error) error {
 	return lastErr
 }
 
-
 func copyCompCounts(src map[string]uint64) map[string]uint64 {
 	if len(src) == 0 {
 		return nil
@@ -1835,6 +2295,11 @@ func mergeCompCounts(dst map[string]uint64, src map[string]uint64) {
 }
 
 func copyStreamWithProgress(dst io.Writer, src io.Reader, buf []byte, hash *xxh3.Hasher128, onWrite func(written int64) error) error {
+	if stream, ok := src.(*fileStream); ok {
+		if holes, ok := dst.(HoleWriter); ok {
+			return stream.copyTo(dst, holes, buf, hash, onWrite)
+		}
+	}
 	var written int64
 	for {
 		n, readErr := src.Read(buf)
@@ -1863,6 +2328,29 @@ func copyStreamWithProgress(dst io.Writer, src io.Reader, buf []byte, hash *xxh3
 	}
 }
 
+// HoleWriter is implemented by output writers that can skip a zero-filled
+// extent without being handed its bytes, such as a file that punches it as
+// a hole. Hole frames go to WriteHole instead of being decoded to zeros and
+// passed to Write.
+type HoleWriter interface {
+	WriteHole(n int64) error
+}
+
+// holeZeros is the block hole extents are hashed from, so a hole costs a
+// hash pass over one cached block rather than a buffer of its full size.
+var holeZeros [64 * 1024]byte
+
+// hashZeros folds n zero bytes into each hasher.
+func hashZeros(n int64, hashers ...*xxh3.Hasher128) {
+	for n > 0 {
+		step := min(n, int64(len(holeZeros)))
+		for _, h := range hashers {
+			_, _ = h.Write(holeZeros[:step])
+		}
+		n -= step
+	}
+}
+
 func effectiveFrameReadBufferSize(baseSize int, maxWireHint int64, capSize int) int {
 	if baseSize <= 0 {
 		baseSize = defaultClientFrameBufferBytes
@@ -2076,6 +2564,9 @@ func marshalManifest(manifest *Manifest) ([]byte, error) {
 		if entry.Size < 0 {
 			return nil, fmt.Errorf("manifest size must be >= 0 for id=%d", entry.ID)
 		}
+		if entry.HoleBytes < 0 || entry.HoleBytes > entry.Size {
+			return nil, fmt.Errorf("manifest hole bytes out of range for id=%d", entry.ID)
+		}
 		if strings.Contains(entry.Path, `\`) {
 			return nil, fmt.Errorf("manifest path contains backslash: %q", entry.Path)
 		}
@@ -2093,7 +2584,11 @@ func marshalManifest(manifest *Manifest) ([]byte, error) {
 			return nil, fmt.Errorf("encode manifest mtime id=%d: %w", entry.ID, err)
 		}
 		pathToken := encodePathToken(prevPath, entry.Path)
-		fmt.Fprintf(&b, "%d %d %s %s %s\n", entry.ID, entry.Size, mtimeToken, modeToken, pathToken)
+		sizeToken := strconv.FormatInt(entry.Size, 10)
+		if entry.HoleBytes > 0 {
+			sizeToken += "/" + strconv.FormatInt(entry.AllocatedSize(), 10)
+		}
+		fmt.Fprintf(&b, "%d %s %s %s %s\n", entry.ID, sizeToken, mtimeToken, modeToken, pathToken)
 		prevPath = entry.Path
 		prevMtime = mtimeRaw
 	}
@@ -2142,8 +2637,8 @@ func resolveManifestEntryPath(manifest *Manifest, fileID uint64) (ManifestEntry,
 	if !ok {
 		return ManifestEntry{}, "", fmt.Errorf("file id %d not in manifest", fileID)
 	}
-	serverPath := filepath.Clean(filepath.Join(manifest.Root, filepath.FromSlash(entry.Path)))
-	if !filepath.IsAbs(serverPath) {
+	serverPath := manifest.ServerPath(entry)
+	if !source.IsRemote(serverPath) && !filepath.IsAbs(serverPath) {
 		return ManifestEntry{}, "", fmt.Errorf("resolved file path is not absolute: %s", serverPath)
 	}
 	return entry, serverPath, nil
@@ -2154,6 +2649,7 @@ func cloneTrailerMetadata(meta *FileTrailerMetadata) *FileTrailerMetadata {
 		return nil
 	}
 	cloned := *meta
+	cloned.Xattrs = maps.Clone(meta.Xattrs)
 	return &cloned
 }
 
@@ -2248,6 +2744,7 @@ func parseManifestEntry(line string, prevPath string, prevMtime string) (Manifes
 	if err != nil {
 		return ManifestEntry{}, "", "", fmt.Errorf("invalid manifest id: %w", err)
 	}
+	sizeRaw, allocatedRaw, sparse := strings.Cut(sizeRaw, "/")
 	sizeU, err := strconv.ParseUint(sizeRaw, 10, 64)
 	if err != nil {
 		return ManifestEntry{}, "", "", fmt.Errorf("invalid manifest size: %w", err)
@@ -2255,6 +2752,14 @@ func parseManifestEntry(line string, prevPath string, prevMtime string) (Manifes
 	if sizeU > uint64(^uint64(0)>>1) {
 		return ManifestEntry{}, "", "", errors.New("manifest size overflows int64")
 	}
+	holeBytes := int64(0)
+	if sparse {
+		allocated, err := strconv.ParseUint(allocatedRaw, 10, 64)
+		if err != nil || allocated > sizeU {
+			return ManifestEntry{}, "", "", errors.New("invalid manifest allocated size")
+		}
+		holeBytes = int64(sizeU - allocated)
+	}
 
 	mtimeResolved, err := decodeMtimeToken(prevMtime, mtimeToken)
 	if err != nil {
@@ -2288,11 +2793,12 @@ func parseManifestEntry(line string, prevPath string, prevMtime string) (Manifes
 	}
 
 	entry := ManifestEntry{
-		ID:    id,
-		Size:  int64(sizeU),
-		Mtime: int64(mtimeNanos),
-		Mode:  mode,
-		Path:  pathResolved,
+		ID:        id,
+		Size:      int64(sizeU),
+		Mtime:     int64(mtimeNanos),
+		Mode:      mode,
+		Path:      pathResolved,
+		HoleBytes: holeBytes,
 	}
 	return entry, pathResolved, mtimeResolved, nil
 }
@@ -2316,7 +2822,6 @@ func parseManifestModeToken(raw string) (os.FileMode, error) {
 	return os.FileMode(v), nil
 }
 
-
 func parseLenPrefixedPrefix(raw string) (string, int, error) {
 	sep := strings.IndexByte(raw, ':')
 	if sep <= 0 {
@@ -2411,6 +2916,7 @@ type fileStream struct {
 	closed     bool
 	pending    *FileFrameMeta
 	releaseBr  func()
+	observer   frameObserver
 }
 
 type readerWithCloser struct {
@@ -2536,11 +3042,11 @@ func (c *Client) newFileStream(respBody io.ReadCloser, ageIdentity string, fileS
 	bufferHint := c.fileStreamBufferHint(fileSizeHint, firstMeta.Size)
 	readBuf, release := c.acquireFrameReadBuffer(bufferHint)
 	stream := &fileStream{
-		respBody: respBody,
-		br:       newPooledLineReader(probe, readBuf),
-		identity: identity,
-		meta:     &FileFrameMeta{},
-		pending:  &firstMeta,
+		respBody:  respBody,
+		br:        newPooledLineReader(probe, readBuf),
+		identity:  identity,
+		meta:      &FileFrameMeta{},
+		pending:   &firstMeta,
 		releaseBr: release,
 	}
 	if err := stream.openNextFrame(); err != nil {
@@ -2593,6 +3099,80 @@ func (s *fileStream) Read(p []byte) (int, error) {
 	}
 }
 
+// copyTo copies the rest of the stream like copyStreamWithProgress, except
+// that hole frames go to holes.WriteHole and are hashed with hashZeros
+// instead of being decoded into buf and written to dst.
+func (s *fileStream) copyTo(dst io.Writer, holes HoleWriter, buf []byte, hash *xxh3.Hasher128, onWrite func(written int64) error) error {
+	var written int64
+	advance := func(p []byte, n int64) error {
+		if p != nil {
+			if _, err := dst.Write(p); err != nil {
+				return err
+			}
+			if hash != nil {
+				_, _ = hash.Write(p)
+			}
+		}
+		written += n
+		if onWrite != nil {
+			return onWrite(written)
+		}
+		return nil
+	}
+	for {
+		if s.pendingErr != nil {
+			err := s.pendingErr
+			s.pendingErr = nil
+			return err
+		}
+		if s.finished {
+			return nil
+		}
+		if s.logical == nil {
+			if err := s.openNextFrame(); err != nil {
+				if errors.Is(err, io.EOF) {
+					s.finished = true
+					return nil
+				}
+				return err
+			}
+		}
+		if s.frameMeta.Hole && s.logicalRead == 0 {
+			size := s.frameMeta.Size
+			if err := holes.WriteHole(size); err != nil {
+				return err
+			}
+			if hash != nil {
+				hashZeros(size, hash)
+			}
+			s.logicalRead = size
+			if err := s.finishFrame(); err != nil {
+				return err
+			}
+			if err := advance(nil, size); err != nil {
+				return err
+			}
+			continue
+		}
+		n, err := s.logical.Read(buf)
+		if n > 0 {
+			s.logicalRead += int64(n)
+			if err := advance(buf[:n], int64(n)); err != nil {
+				return err
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+		if err := s.finishFrame(); err != nil {
+			return err
+		}
+	}
+}
+
 func (s *fileStream) Close() error {
 	if s.closed {
 		return nil
@@ -2680,8 +3260,7 @@ func (s *fileStream) openNextFrame() error {
 		}
 	}
 
-	payloadReader := io.LimitReader(s.br, meta.WireSize)
-	logicalReader, err := decodePayloadReader(payloadReader, meta.Comp, meta.Enc, s.identity)
+	logicalReader, err := decodeFrameReader(s.br, meta, s.identity)
 	if err != nil {
 		return fmt.Errorf("decode payload reader: %w", err)
 	}
@@ -2701,6 +3280,9 @@ func (s *fileStream) finishFrame() error {
 	if err != nil {
 		return fmt.Errorf("read frame trailer: %w", err)
 	}
+	if err := parseErrControlFrame(strings.TrimRight(trailerLine, "\r\n")); err != nil {
+		return err
+	}
 	trailer, err := parseFXTrailer(strings.TrimRight(trailerLine, "\r\n"))
 	if err != nil {
 		return err
@@ -2733,6 +3315,9 @@ func (s *fileStream) finishFrame() error {
 		s.meta.Comp = "mixed"
 	}
 	s.meta.TrailerTS = trailer.TS
+	if s.observer != nil {
+		s.observer.ObserveFrame(s.frameMeta.WireSize, trailer.TS, time.Now().UnixMilli())
+	}
 	s.meta.HashToken = trailer.HashToken
 	if trailer.FileHashToken != "" {
 		s.meta.FileHashToken = trailer.FileHashToken
@@ -2798,6 +3383,10 @@ func parseFXHeader(line string) (FileFrameMeta, error) {
 	if comp == "" || enc == "" {
 		return FileFrameMeta{}, errors.New("missing required frame properties")
 	}
+	hole := props["hole"] == "1"
+	if hole && wsize != 0 {
+		return FileFrameMeta{}, errors.New("hole frame must have wsize=0")
+	}
 	return FileFrameMeta{
 		FileID:          fileID,
 		Comp:            comp,
@@ -2807,6 +3396,7 @@ func parseFXHeader(line string) (FileFrameMeta, error) {
 		WireSize:        wsize,
 		MaxWireSizeHint: maxWSizeHint,
 		HeaderTS:        ts,
+		Hole:            hole,
 	}, nil
 }
 
@@ -2908,6 +3498,34 @@ func parseFXTrailer(line string) (frameTrailer, error) {
 			case "group":
 				meta.Group = val
 				hasMeta = true
+			case "atime_ns":
+				atimeVal, parseErr := strconv.ParseInt(val, 10, 64)
+				if parseErr != nil || atimeVal < 0 {
+					return frameTrailer{}, errors.New("invalid trailer meta:atime_ns")
+				}
+				meta.AtimeNS = atimeVal
+				hasMeta = true
+			case "xattrs_truncated":
+				meta.XattrsTruncated = val == "1"
+				hasMeta = true
+			default:
+				rawName, ok := strings.CutPrefix(key, "xattr.")
+				if !ok {
+					continue
+				}
+				name, nameErr := intencoding.DecodeXattrName(rawName)
+				if nameErr != nil || name == "" {
+					return frameTrailer{}, fmt.Errorf("invalid trailer xattr name %q", rawName)
+				}
+				value, decodeErr := base64.StdEncoding.DecodeString(val)
+				if decodeErr != nil {
+					return frameTrailer{}, fmt.Errorf("invalid trailer xattr %q value", name)
+				}
+				if meta.Xattrs == nil {
+					meta.Xattrs = make(map[string][]byte)
+				}
+				meta.Xattrs[name] = value
+				hasMeta = true
 			}
 		}
 	}
@@ -2974,6 +3592,23 @@ func validHashToken(raw string) bool {
 	return len(parts) == 2 && parts[0] != "" && parts[1] != ""
 }
 
+// decodeFrameReader returns the logical bytes of the frame described by meta
+// whose payload follows in r. Hole frames have no payload and read as zeros,
+// for sinks that are not a HoleWriter.
+func decodeFrameReader(r io.Reader, meta FileFrameMeta, identity age.Identity) (io.ReadCloser, error) {
+	if meta.Hole {
+		return io.NopCloser(io.LimitReader(zeroReader{}, meta.Size)), nil
+	}
+	return decodePayloadReader(io.LimitReader(r, meta.WireSize), meta.Comp, meta.Enc, identity)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
 func decodePayloadReader(payload io.Reader, comp string, enc string, identity age.Identity) (io.ReadCloser, error) {
 	switch enc {
 	case "none":
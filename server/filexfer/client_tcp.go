@@ -17,6 +17,7 @@ import (
 
 	"filippo.io/age"
 	ftcp "github.com/jolynch/pinch/internal/filexfer/ftcp"
+	"github.com/jolynch/pinch/tracing"
 )
 
 const maxTCPLineBytes = 4 * 1024 * 1024
@@ -29,13 +30,15 @@ type tcpAuthState struct {
 }
 
 type probeResponse struct {
-	ServerCPU      int
-	CTS0           int64
-	CTS1           int64
-	STS0           int64
-	STS1           int64
-	ProbeBytes     int64
+	ServerCPU       int
+	CTS0            int64
+	CTS1            int64
+	STS0            int64
+	STS1            int64
+	ProbeBytes      int64
 	ServerWmemBytes int64
+	ServerKey       string
+	ServerKeys      []string
 }
 
 func (c *Client) dialTCP(ctx context.Context) (net.Conn, error) {
@@ -74,6 +77,60 @@ func (c *Client) dialTCP(ctx context.Context) (net.Conn, error) {
 	return conn, nil
 }
 
+// closeOnCancel closes conn when ctx is cancelled so a stream blocked on a
+// stalled server unblocks; closing the returned conn releases the hook.
+func closeOnCancel(ctx context.Context, conn net.Conn) net.Conn {
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	return &cancelConn{Conn: conn, stop: stop}
+}
+
+type cancelConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *cancelConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// fileMetadataOption returns " meta=<keys>" when extended trailer metadata
+// was requested, otherwise "".
+func (c *Client) fileMetadataOption() string {
+	if len(c.FileMetadata) == 0 {
+		return ""
+	}
+	return " meta=" + strings.Join(c.FileMetadata, ",")
+}
+
+// sparseOption returns " sparse=1" when hole frames were requested.
+func (c *Client) sparseOption() string {
+	if !c.Sparse {
+		return ""
+	}
+	return " sparse=1"
+}
+
+// consistencyOption returns " consistency=<mode>" when a mode was chosen.
+func (c *Client) consistencyOption() string {
+	if c.Consistency == "" {
+		return ""
+	}
+	return " consistency=" + c.Consistency
+}
+
+// traceParentOption returns " traceparent=<value>" for the active span in ctx,
+// or "" when tracing is off. SEND, ACK and PROBE servers that predate tracing
+// ignore the key; TXFER only accepts it from servers that understand it, so it
+// is only sent when the client is tracing.
+func traceParentOption(ctx context.Context) string {
+	tp := tracing.TraceParent(ctx)
+	if tp == "" {
+		return ""
+	}
+	return " traceparent=" + tp
+}
+
 func makeLenToken(raw string) string {
 	return strconv.Itoa(len(raw)) + ":" + raw
 }
@@ -83,6 +140,9 @@ func parseErrControlFrame(line string) error {
 	if !ok {
 		return nil
 	}
+	if code == "CHANGED" {
+		return parseFileChangedError(msg)
+	}
 	return controlFrameError{Code: code, Message: msg}
 }
 
@@ -258,7 +318,20 @@ func (c *Client) sendTCPCommand(conn net.Conn, state tcpAuthState, payload strin
 	if err := writeTCPLine(ew, payload); err != nil {
 		return err
 	}
-	return ew.Close()
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	return closeTCPWrite(conn)
+}
+
+// closeTCPWrite half-closes conn once an encrypted request is written: the
+// server's age reader only knows the last chunk has ended when it sees EOF.
+// Encrypted sessions carry one command, so nothing else is sent on conn.
+func closeTCPWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
 }
 
 func (c *Client) responseReaderForTCP(conn net.Conn, state tcpAuthState) (io.Reader, error) {
@@ -300,9 +373,16 @@ func (c *Client) fetchManifestTCP(ctx context.Context, request FetchManifestRequ
 	if request.MaxChunkSize > 0 {
 		cmd += " max-manifest-chunk-size=" + strconv.Itoa(request.MaxChunkSize)
 	}
+	if request.Sparse {
+		cmd += " sparse=1"
+	}
 	cmd += " mode=" + request.Mode
 	cmd += " link-mbps=" + strconv.FormatInt(request.LinkMbps, 10)
 	cmd += " concurrency=" + strconv.Itoa(request.Concurrency)
+	if request.TTL > 0 {
+		cmd += " ttl=" + strconv.FormatInt(int64((request.TTL+time.Second-1)/time.Second), 10)
+	}
+	cmd += traceParentOption(ctx)
 	if err := c.sendTCPCommand(conn, state, cmd); err != nil {
 		return FetchManifestResponse{}, fmt.Errorf("send TXFER: %w", err)
 	}
@@ -360,6 +440,7 @@ func (c *Client) fetchFileWindowTCP(
 	if err != nil {
 		return nil, nil, fmt.Errorf("dial file listener: %w", err)
 	}
+	conn = closeOnCancel(ctx, conn)
 	if err := c.sendTCPAuth(conn, state); err != nil {
 		conn.Close()
 		return nil, nil, fmt.Errorf("send AUTH: %w", err)
@@ -387,10 +468,16 @@ func (c *Client) fetchFileWindowTCP(
 		cmd.WriteString(" size=")
 		cmd.WriteString(strconv.FormatInt(effectiveSize, 10))
 	}
+	cmd.WriteString(c.fileMetadataOption())
+	cmd.WriteString(c.sparseOption())
+	cmd.WriteString(c.consistencyOption())
+	cmd.WriteString(c.linkOption())
+	cmd.WriteString(traceParentOption(ctx))
 	if err := c.sendTCPCommand(conn, state, cmd.String()); err != nil {
 		conn.Close()
 		return nil, nil, fmt.Errorf("send SEND: %w", err)
 	}
+	sentAt := time.Now()
 
 	responseReader, err := c.responseReaderForTCP(conn, state)
 	if err != nil {
@@ -403,6 +490,7 @@ func (c *Client) fetchFileWindowTCP(
 		conn.Close()
 		return nil, nil, fmt.Errorf("read SEND response: %w", err)
 	}
+	c.link.observeRTT(time.Since(sentAt))
 	trimmed := strings.TrimRight(firstLine, "\r\n")
 	if err := parseErrControlFrame(trimmed); err != nil {
 		conn.Close()
@@ -427,6 +515,9 @@ func (c *Client) fetchFileWindowTCP(
 		stream.Close()
 		return nil, nil, fmt.Errorf("file id mismatch: expected %d got %d", fileID, meta.FileID)
 	}
+	if fs, ok := stream.(*fileStream); ok {
+		fs.observer = c.frameObserver(ctx)
+	}
 	return stream, meta, nil
 }
 
@@ -451,6 +542,7 @@ func (c *Client) fetchFileBatchTCP(
 	if err != nil {
 		return nil, fmt.Errorf("dial file listener: %w", err)
 	}
+	conn = closeOnCancel(ctx, conn)
 	if err := c.sendTCPAuth(conn, state); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("send AUTH: %w", err)
@@ -458,6 +550,7 @@ func (c *Client) fetchFileBatchTCP(
 
 	var b strings.Builder
 	loadStrategy := normalizeLoadStrategy(c.LoadStrategy)
+	linkOption := c.linkOption()
 	b.WriteString("SEND ")
 	b.WriteString(txferID)
 	for _, t := range targets {
@@ -483,11 +576,17 @@ func (c *Client) fetchFileBatchTCP(
 			b.WriteString(" size=")
 			b.WriteString(strconv.FormatInt(t.Size, 10))
 		}
+		b.WriteString(c.fileMetadataOption())
+		b.WriteString(c.sparseOption())
+		b.WriteString(c.consistencyOption())
+		b.WriteString(linkOption)
 	}
+	b.WriteString(traceParentOption(ctx))
 	if err := c.sendTCPCommand(conn, state, b.String()); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("send SEND batch: %w", err)
 	}
+	sentAt := time.Now()
 
 	responseReader, err := c.responseReaderForTCP(conn, state)
 	if err != nil {
@@ -500,6 +599,7 @@ func (c *Client) fetchFileBatchTCP(
 		conn.Close()
 		return nil, fmt.Errorf("read SEND response: %w", err)
 	}
+	c.link.observeRTT(time.Since(sentAt))
 	trimmed := strings.TrimRight(firstLine, "\r\n")
 	if err := parseErrControlFrame(trimmed); err != nil {
 		conn.Close()
@@ -612,7 +712,10 @@ func (c *Client) sendTCPProbe(conn net.Conn, state tcpAuthState, cmd string, pro
 		_ = ew.Close()
 		return err
 	}
-	return ew.Close()
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	return closeTCPWrite(conn)
 }
 
 type firstReadTimestampReader struct {
@@ -668,6 +771,17 @@ func parseProbeResponseLine(line string) (probeResponse, error) {
 	if wmemBytes < 0 {
 		wmemBytes = 0
 	}
+	serverKey := strings.TrimSpace(p["key"])
+	var serverKeys []string
+	for _, key := range strings.Split(p["keys"], ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			serverKeys = append(serverKeys, key)
+		}
+	}
+	// Servers that predate keys= accept only the key they announce.
+	if len(serverKeys) == 0 && serverKey != "" {
+		serverKeys = []string{serverKey}
+	}
 	return probeResponse{
 		ServerCPU:       serverCPU,
 		CTS0:            cts0,
@@ -675,6 +789,8 @@ func parseProbeResponseLine(line string) (probeResponse, error) {
 		STS1:            sts1,
 		ProbeBytes:      probeBytes,
 		ServerWmemBytes: wmemBytes,
+		ServerKey:       serverKey,
+		ServerKeys:      serverKeys,
 	}, nil
 }
 
@@ -728,6 +844,7 @@ func (c *Client) acknowledgeFileProgressBatchTCP(ctx context.Context, commands [
 			cmd.WriteString(strconv.FormatInt(request.SyncMS, 10))
 		}
 	}
+	cmd.WriteString(traceParentOption(ctx))
 	if err := c.sendTCPCommand(conn, state, cmd.String()); err != nil {
 		return AcknowledgeFileProgressResponse{}, fmt.Errorf("send ACK: %w", err)
 	}
@@ -777,6 +894,158 @@ func (c *Client) getTransferStatusTCP(ctx context.Context, request GetTransferSt
 	return GetTransferStatusResponse{Status: &status}, nil
 }
 
+// controlTransferTCP sends CANCEL, PAUSE or RESUME, which answer with a
+// bare OK.
+func (c *Client) controlTransferTCP(ctx context.Context, verb string, transferID string) error {
+	_, err := c.transferCommandTCP(ctx, verb, transferID)
+	return err
+}
+
+func (c *Client) renewTransferTCP(ctx context.Context, transferID string) (time.Time, error) {
+	message, err := c.transferCommandTCP(ctx, "RENEW", transferID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, field := range strings.Fields(message) {
+		if raw, ok := strings.CutPrefix(field, "expires-at-ms="); ok {
+			ms, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid RENEW expires-at-ms %q", raw)
+			}
+			return time.UnixMilli(ms), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("missing expires-at-ms in RENEW response: %s", message)
+}
+
+// transferCommandTCP sends a one-line command that names a transfer and
+// returns the message of its OK response.
+func (c *Client) transferCommandTCP(ctx context.Context, verb string, transferID string) (string, error) {
+	state, err := c.resolveTCPAuthState("", "")
+	if err != nil {
+		return "", err
+	}
+	conn, err := c.dialTCP(ctx)
+	if err != nil {
+		return "", fmt.Errorf("dial file listener: %w", err)
+	}
+	defer conn.Close()
+	if err := c.sendTCPAuth(conn, state); err != nil {
+		return "", fmt.Errorf("send AUTH: %w", err)
+	}
+	if err := c.sendTCPCommand(conn, state, verb+" "+transferID); err != nil {
+		return "", fmt.Errorf("send %s: %w", verb, err)
+	}
+	responseReader, err := c.responseReaderForTCP(conn, state)
+	if err != nil {
+		return "", fmt.Errorf("initialize %s response stream: %w", verb, err)
+	}
+	message, err := readTCPStatus(bufio.NewReader(responseReader))
+	if err != nil {
+		return "", fmt.Errorf("read %s response: %w", verb, err)
+	}
+	return message, nil
+}
+
+func (c *Client) watchTransferTCP(ctx context.Context, request WatchTransferRequest, fn func(WatchEvent) error) error {
+	state, err := c.resolveTCPAuthState("", "")
+	if err != nil {
+		return err
+	}
+	conn, err := c.dialTCP(ctx)
+	if err != nil {
+		return fmt.Errorf("dial file listener: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	if err := c.sendTCPAuth(conn, state); err != nil {
+		return fmt.Errorf("send AUTH: %w", err)
+	}
+
+	cmd := "WATCH " + request.TransferID
+	if request.Heartbeat > 0 {
+		cmd += fmt.Sprintf(" heartbeat-ms=%d", request.Heartbeat.Milliseconds())
+	}
+	if request.Sparse {
+		cmd += " sparse=1"
+	}
+	cmd += traceParentOption(ctx)
+	if err := c.sendTCPCommand(conn, state, cmd); err != nil {
+		return fmt.Errorf("send WATCH: %w", err)
+	}
+	responseReader, err := c.responseReaderForTCP(conn, state)
+	if err != nil {
+		return fmt.Errorf("initialize WATCH response stream: %w", err)
+	}
+	br := bufio.NewReader(responseReader)
+	for {
+		line, err := readTCPLine(br, maxTCPLineBytes)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if errors.Is(err, io.EOF) {
+				return errors.New("WATCH stream closed by server")
+			}
+			return fmt.Errorf("read WATCH event: %w", err)
+		}
+		if err := parseErrControlFrame(line); err != nil {
+			return err
+		}
+		event, ok, err := parseWatchEventLine(line)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
+
+// parseWatchEventLine decodes one FW/1 line; ok is false for heartbeat and
+// padding lines.
+func parseWatchEventLine(line string) (WatchEvent, bool, error) {
+	rest, found := strings.CutPrefix(strings.TrimRight(line, "\r\n"), "FW/1 ")
+	if !found {
+		return WatchEvent{}, false, fmt.Errorf("unexpected WATCH line: %s", strings.TrimSpace(line))
+	}
+	kind, payload, _ := strings.Cut(rest, " ")
+	switch kind {
+	case "heartbeat", "pad":
+		return WatchEvent{}, false, nil
+	case WatchEventAdd:
+		entry, _, _, err := parseManifestEntry(payload, "", "")
+		if err != nil {
+			return WatchEvent{}, false, fmt.Errorf("invalid WATCH add: %w", err)
+		}
+		return WatchEvent{Kind: kind, Entry: entry}, true, nil
+	case WatchEventGrow, WatchEventReplace:
+		fields := strings.Fields(payload)
+		if len(fields) != 3 {
+			return WatchEvent{}, false, fmt.Errorf("invalid WATCH %s", kind)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return WatchEvent{}, false, fmt.Errorf("invalid WATCH %s file id: %w", kind, err)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			return WatchEvent{}, false, fmt.Errorf("invalid WATCH %s size", kind)
+		}
+		mtime, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return WatchEvent{}, false, fmt.Errorf("invalid WATCH %s mtime: %w", kind, err)
+		}
+		return WatchEvent{Kind: kind, Entry: ManifestEntry{ID: id, Size: size, Mtime: mtime}}, true, nil
+	default:
+		return WatchEvent{}, false, fmt.Errorf("unknown WATCH event %q", kind)
+	}
+}
+
 func (c *Client) fetchChecksumStreamTCP(ctx context.Context, request FetchChecksumStreamRequest) (io.ReadCloser, error) {
 	state, err := c.resolveTCPAuthState(request.AgePublicKey, request.AgeIdentity)
 	if err != nil {
@@ -806,6 +1075,7 @@ func (c *Client) fetchChecksumStreamTCP(ctx context.Context, request FetchChecks
 		checksums,
 		makeLenToken(request.FullPath),
 	)
+	cmd += c.fileMetadataOption()
 	if err := c.sendTCPCommand(conn, state, cmd); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("send CXSUM: %w", err)
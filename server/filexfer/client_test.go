@@ -9,12 +9,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -22,6 +25,8 @@ import (
 	"filippo.io/age"
 	intencoding "github.com/jolynch/pinch/internal/filexfer/encoding"
 	intftcp "github.com/jolynch/pinch/internal/filexfer/ftcp"
+	"github.com/jolynch/pinch/internal/filexfer/s3/s3test"
+	"github.com/jolynch/pinch/tracing"
 	"github.com/zeebo/xxh3"
 )
 
@@ -31,6 +36,7 @@ type ftcpTestServer struct {
 	listener net.Listener
 	wg       sync.WaitGroup
 	handler  func(intftcp.Request, io.Writer) error
+	accepted atomic.Int64
 }
 
 func (s *ftcpTestServer) Close() {
@@ -65,6 +71,7 @@ func newFTCPTestServer(t *testing.T, handler func(intftcp.Request, io.Writer) er
 			if err != nil {
 				return
 			}
+			s.accepted.Add(1)
 			s.wg.Add(1)
 			go func(c net.Conn) {
 				defer s.wg.Done()
@@ -132,8 +139,22 @@ func serveFTCPTestConn(conn net.Conn, handler func(intftcp.Request, io.Writer) e
 			}
 		}
 	}
-	if err := handler(cmdReq, responseOut); err != nil {
-		_, _ = io.WriteString(responseOut, "ERR INTERNAL "+err.Error()+"\r\n")
+	for {
+		if err := handler(cmdReq, responseOut); err != nil {
+			_, _ = io.WriteString(responseOut, "ERR INTERNAL "+err.Error()+"\r\n")
+			break
+		}
+		if !cmdReq.KeepAlive || responseOut != io.Writer(conn) {
+			break
+		}
+		next, err := readCompatLine(br)
+		if err != nil {
+			return
+		}
+		if cmdReq, err = intftcp.ParseRequest([]byte(next)); err != nil {
+			_, _ = io.WriteString(conn, "ERR BAD_REQUEST "+err.Error()+"\r\n")
+			return
+		}
 	}
 	_ = closeResponse()
 }
@@ -490,6 +511,29 @@ func TestParseFXTrailerParsesMetadata(t *testing.T) {
 	}
 }
 
+func TestParseFXTrailerParsesExtendedMetadata(t *testing.T) {
+	trailer, err := parseFXTrailer("FXT/1 7 status=ok ts=1001 next=0 meta:mtime_ns=5 meta:atime_ns=9 meta:xattr.user.a%3Db=dmFsdWU= meta:xattr.security.selinux=AA== meta:xattrs_truncated=1 hash=xxh64:0123456789abcdef")
+	if err != nil {
+		t.Fatalf("parseFXTrailer failed: %v", err)
+	}
+	meta := trailer.Metadata
+	if meta == nil || meta.MtimeNS != 5 || meta.AtimeNS != 9 || !meta.XattrsTruncated {
+		t.Fatalf("unexpected metadata: %+v", meta)
+	}
+	if string(meta.Xattrs["user.a=b"]) != "value" || !bytes.Equal(meta.Xattrs["security.selinux"], []byte{0}) {
+		t.Fatalf("unexpected xattrs: %+v", meta.Xattrs)
+	}
+	cloned := cloneTrailerMetadata(meta)
+	cloned.Xattrs["user.a=b"] = []byte("other")
+	if string(meta.Xattrs["user.a=b"]) != "value" {
+		t.Fatalf("cloneTrailerMetadata shared the xattr map")
+	}
+
+	if _, err := parseFXTrailer("FXT/1 7 status=ok ts=1001 meta:xattr.user.a=!!! hash=xxh64:0123456789abcdef"); err == nil {
+		t.Fatalf("expected error for invalid xattr value")
+	}
+}
+
 func TestParseFXTrailerWithoutTrailerHash(t *testing.T) {
 	trailer, err := parseFXTrailer("FXT/1 7 status=ok ts=1001 next=0 file-hash=xxh128:0123456789abcdef0123456789abcdef")
 	if err != nil {
@@ -623,6 +667,59 @@ func TestParseManifestSingleChunk(t *testing.T) {
 	}
 }
 
+func TestParseManifestSparseEntries(t *testing.T) {
+	raw := strings.Join([]string{
+		"FM/2 tx123 5:/root mode=fast link-mbps=1000 concurrency=8",
+		"0 1048576/4096 0:100 0644 0:8:disk.img",
+		"1 7 2:1 0600 0:5:b.txt",
+		"",
+	}, "\n")
+	manifest, err := parseManifest([]byte(raw))
+	if err != nil {
+		t.Fatalf("parseManifest failed: %v", err)
+	}
+	sparse := manifest.Entries[0]
+	if sparse.Size != 1<<20 || sparse.HoleBytes != 1<<20-4096 || sparse.AllocatedSize() != 4096 {
+		t.Fatalf("unexpected sparse entry: %+v", sparse)
+	}
+	if dense := manifest.Entries[1]; dense.HoleBytes != 0 || dense.AllocatedSize() != 7 {
+		t.Fatalf("unexpected dense entry: %+v", dense)
+	}
+	encoded, err := MarshalManifest(manifest)
+	if err != nil {
+		t.Fatalf("MarshalManifest failed: %v", err)
+	}
+	if string(encoded) != raw {
+		t.Fatalf("sparse manifest did not round trip:\n%s", encoded)
+	}
+	for _, bad := range []string{"0 5/6 0:100 0644 0:1:a", "0 5/x 0:100 0644 0:1:a"} {
+		if _, err := parseManifest([]byte("FM/2 tx 1:/ mode=fast link-mbps=1 concurrency=1\n" + bad + "\n")); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestParseFXHeaderHoleFrame(t *testing.T) {
+	meta, err := parseFXHeader("FX/1 7 offset=0 size=65536 wsize=0 comp=none enc=none hash=xxh128:abc hole=1 ts=1000")
+	if err != nil {
+		t.Fatalf("parseFXHeader failed: %v", err)
+	}
+	if !meta.Hole || meta.Size != 65536 {
+		t.Fatalf("unexpected hole frame meta: %+v", meta)
+	}
+	logical, err := decodeFrameReader(strings.NewReader("not payload"), meta, nil)
+	if err != nil {
+		t.Fatalf("decodeFrameReader failed: %v", err)
+	}
+	got, err := io.ReadAll(logical)
+	if err != nil || !bytes.Equal(got, make([]byte, 65536)) {
+		t.Fatalf("hole frame should read as %d zeros, got %d bytes err=%v", meta.Size, len(got), err)
+	}
+	if _, err := parseFXHeader("FX/1 7 offset=0 size=5 wsize=5 comp=none enc=none hash=xxh128:abc hole=1 ts=1000"); err == nil {
+		t.Fatalf("expected error for hole frame with payload")
+	}
+}
+
 func TestParseManifestMultiChunk(t *testing.T) {
 	raw := strings.Join([]string{
 		"FM/2 tx456 6:/root2 mode=gentle link-mbps=500 concurrency=4",
@@ -833,6 +930,63 @@ func TestFetchFileIncludesSendMode(t *testing.T) {
 	}
 }
 
+type discardSpanExporter struct{}
+
+func (discardSpanExporter) Export(context.Context, string, []tracing.SpanData) error { return nil }
+func (discardSpanExporter) Close() error                                             { return nil }
+
+func TestFetchFilePropagatesTraceParent(t *testing.T) {
+	frame := buildFXFrame(t, 7, "none", 0, []byte("hello"), nil)
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		mu.Lock()
+		got = append(got, req.TraceParent)
+		mu.Unlock()
+		_, err := io.WriteString(out, frame)
+		return err
+	})
+	defer srv.Close()
+
+	tracer := tracing.NewTracer("test", discardSpanExporter{})
+	defer tracer.Shutdown()
+	ctx, span := tracer.Start(context.Background(), "test-root")
+	defer span.End()
+
+	client := NewClient(srv.URL)
+	for _, reqCtx := range []context.Context{ctx, context.Background()} {
+		resp, err := client.FetchFile(reqCtx, FetchFileRequest{
+			TransferID: "tx",
+			Files:      []FetchFileTarget{{FileID: 7, FullPath: "/root/a.txt"}},
+			AckBytes:   -1,
+		})
+		if err != nil {
+			t.Fatalf("FetchFile failed: %v", err)
+		}
+		if _, err := readAndClose(t, resp.Reader); err != nil {
+			t.Fatalf("FetchFile read failed: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 SEND requests, got %d", len(got))
+	}
+	sc, err := tracing.ParseTraceParent(got[0])
+	if err != nil {
+		t.Fatalf("traced SEND carried invalid traceparent %q: %v", got[0], err)
+	}
+	if sc.TraceID != span.SpanContext().TraceID {
+		t.Fatalf("traceparent trace id %s does not match caller %s", sc.TraceID, span.SpanContext().TraceID)
+	}
+	if got[1] != "" {
+		t.Fatalf("untraced SEND should not carry traceparent, got %q", got[1])
+	}
+}
+
 func TestFetchFileDecryptsWholeResponseWithAge(t *testing.T) {
 	identity, err := age.GenerateX25519Identity()
 	if err != nil {
@@ -1657,6 +1811,354 @@ func TestDownloadFilesFromManifestBatchSplitsLargeSingleFileWindows(t *testing.T
 	}
 }
 
+func TestStartFromManifestReportsChangedFile(t *testing.T) {
+	manifest := &Manifest{
+		TransferID: "txchanged",
+		Root:       "/remote",
+		Entries:    []ManifestEntry{{ID: 0, Size: 5, Path: "a.txt"}},
+	}
+	var consistency string
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		if req.Verb != intftcp.VerbSEND {
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+		consistency = req.Params[1]["consistency"]
+		// The payload goes out, then the server finds the file grew and
+		// sends ERR CHANGED in place of the terminal trailer.
+		frame := buildFXFrame(t, 0, "none", 0, []byte("hello"), nil)
+		frame = frame[:strings.Index(frame, "FXT/1")]
+		_, err := io.WriteString(out, frame+"ERR CHANGED fid=0 size=9 mtime=42 changed during send: size 5->9\r\n")
+		return err
+	})
+	defer srv.Close()
+
+	client := NewClient(srv.URL, WithConsistency("Strict"))
+	resp, err := client.StartFromManifest(context.Background(), StartFromManifestRequest{
+		Manifest:    manifest,
+		Concurrency: 1,
+		OutputWriter: func(ManifestEntry, int64) (io.WriteCloser, func() error, error) {
+			return noOpWriteCloser{Writer: io.Discard}, func() error { return nil }, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartFromManifest failed: %v", err)
+	}
+	if consistency != ConsistencyStrict {
+		t.Fatalf("expected consistency=strict on SEND, got %q", consistency)
+	}
+	if len(resp.Errors) != 1 {
+		t.Fatalf("expected one error, got %v", resp.Errors)
+	}
+	if !errors.Is(resp.Errors[0], ErrFileChanged) {
+		t.Fatalf("expected ErrFileChanged, got %v", resp.Errors[0])
+	}
+	var changed *FileChangedError
+	var batchErr *BatchError
+	if !errors.As(resp.Errors[0], &changed) || !errors.As(resp.Errors[0], &batchErr) {
+		t.Fatalf("expected FileChangedError within BatchError, got %T", resp.Errors[0])
+	}
+	if changed.FileID != 0 || changed.Size != 9 || changed.MtimeNS != 42 {
+		t.Fatalf("unexpected changed identity: %+v", changed)
+	}
+	if len(batchErr.FileIDs) != 1 || batchErr.FileIDs[0] != 0 {
+		t.Fatalf("unexpected batch file ids: %v", batchErr.FileIDs)
+	}
+}
+
+func TestStartFromManifestRetriesBatchFromAckedOffset(t *testing.T) {
+	manifest := &Manifest{
+		TransferID: "txretry",
+		Root:       "/remote",
+		Entries:    []ManifestEntry{{ID: 0, Size: 12, Path: "big.bin"}},
+	}
+	payload := []byte("abcdefghijkl")
+	var (
+		mu        sync.Mutex
+		ackedSize int64
+		// retryOffsets are the SENDs after the dropped one, and ackedAtDrop
+		// the acked prefix when it was dropped. Windows race for the worker
+		// slot, so offset 8 may arrive before 0 and 4.
+		retryOffsets []int64
+		ackedAtDrop  int64
+		dropped      bool
+	)
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			sendParam := req.Params[len(req.Params)-1]
+			offset, _ := strconv.ParseInt(sendParam["offset"], 10, 64)
+			size, _ := strconv.ParseInt(sendParam["size"], 10, 64)
+			mu.Lock()
+			if dropped {
+				retryOffsets = append(retryOffsets, offset)
+			}
+			drop := offset == 8 && !dropped
+			if drop {
+				dropped, ackedAtDrop = true, ackedSize
+			}
+			mu.Unlock()
+			if drop {
+				// The connection closes before any response.
+				return nil
+			}
+			_, err := io.WriteString(out, buildFXFrame(t, 0, "none", offset, payload[offset:offset+size], nil))
+			return err
+		case intftcp.VerbACK:
+			mu.Lock()
+			for _, param := range req.Params {
+				if size, _, ok := strings.Cut(param["ack-token"], "@"); ok {
+					n, _ := strconv.ParseInt(size, 10, 64)
+					ackedSize = max(ackedSize, n)
+				}
+			}
+			mu.Unlock()
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer srv.Close()
+
+	client := NewClient(srv.URL, WithFileRequestWindowBytes(4), WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond}))
+	var retried []BatchRetry
+	out := make([]byte, len(payload))
+	resp, err := client.StartFromManifest(context.Background(), StartFromManifestRequest{
+		Manifest:      manifest,
+		Concurrency:   1,
+		BatchMaxBytes: 4,
+		OutputWriter: func(_ ManifestEntry, offset int64) (io.WriteCloser, func() error, error) {
+			return noOpWriteCloser{Writer: &offsetWriter{buf: out, off: offset}}, func() error { return nil }, nil
+		},
+		OnRetry: func(retry BatchRetry) { retried = append(retried, retry) },
+	})
+	if err != nil {
+		t.Fatalf("StartFromManifest failed: %v", err)
+	}
+	if len(resp.Errors) != 0 || resp.Downloaded != 1 || resp.Retries != 1 {
+		t.Fatalf("unexpected response: downloaded=%d retries=%d errors=%v", resp.Downloaded, resp.Retries, resp.Errors)
+	}
+	if len(retried) != 1 || retried[0].Attempt != 1 || !IsRetryable(retried[0].Err) {
+		t.Fatalf("unexpected retries: %+v", retried)
+	}
+	if string(out) != string(payload) {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// The retry resumes at the acked offset instead of starting over.
+	if !slices.Contains(retryOffsets, 8) {
+		t.Fatalf("expected the dropped window to be sent again, got %v", retryOffsets)
+	}
+	for _, offset := range retryOffsets {
+		if offset < ackedAtDrop {
+			t.Fatalf("SEND at %d after the drop re-fetches bytes acked before it (%d): %v", offset, ackedAtDrop, retryOffsets)
+		}
+	}
+}
+
+type offsetWriter struct {
+	buf []byte
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n := copy(w.buf[w.off:], p)
+	w.off += int64(n)
+	return n, nil
+}
+
+func TestStartFromManifestDoesNotRetryPermanentErrors(t *testing.T) {
+	manifest := &Manifest{
+		TransferID: "txnoretry",
+		Root:       "/remote",
+		Entries:    []ManifestEntry{{ID: 0, Size: 5, Path: "a.txt"}},
+	}
+	var sends atomic.Int64
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		sends.Add(1)
+		_, err := io.WriteString(out, "ERR NOT_AUTHORIZED path outside root\r\n")
+		return err
+	})
+	defer srv.Close()
+
+	client := NewClient(srv.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond}))
+	resp, err := client.StartFromManifest(context.Background(), StartFromManifestRequest{
+		Manifest:    manifest,
+		Concurrency: 1,
+		OutputWriter: func(ManifestEntry, int64) (io.WriteCloser, func() error, error) {
+			return noOpWriteCloser{Writer: io.Discard}, func() error { return nil }, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartFromManifest failed: %v", err)
+	}
+	if len(resp.Errors) != 1 || resp.Retries != 0 || sends.Load() != 1 {
+		t.Fatalf("expected one unretried failure, got errors=%v retries=%d sends=%d", resp.Errors, resp.Retries, sends.Load())
+	}
+}
+
+func TestStartFromManifestDoesNotRetrySinkErrors(t *testing.T) {
+	manifest := &Manifest{
+		TransferID: "txsinkfull",
+		Root:       "/remote",
+		Entries:    []ManifestEntry{{ID: 0, Size: 5, Path: "a.txt"}},
+	}
+	var sends atomic.Int64
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		sends.Add(1)
+		_, err := io.WriteString(out, buildFXFrame(t, 0, "none", 0, []byte("hello"), nil))
+		return err
+	})
+	defer srv.Close()
+
+	client := NewClient(srv.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond}))
+	resp, err := client.StartFromManifest(context.Background(), StartFromManifestRequest{
+		Manifest:    manifest,
+		Concurrency: 1,
+		OutputWriter: func(ManifestEntry, int64) (io.WriteCloser, func() error, error) {
+			return nil, nil, &os.PathError{Op: "open", Path: "/out/a.txt", Err: syscall.ENOSPC}
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartFromManifest failed: %v", err)
+	}
+	if len(resp.Errors) != 1 || resp.Retries != 0 || sends.Load() != 1 {
+		t.Fatalf("expected one unretried sink failure, got errors=%v retries=%d sends=%d", resp.Errors, resp.Retries, sends.Load())
+	}
+	if !errors.Is(resp.Errors[0], syscall.ENOSPC) {
+		t.Fatalf("expected ENOSPC, got %v", resp.Errors[0])
+	}
+}
+
+// holeRecorder is an output sink that records which bytes arrive through
+// Write and which through WriteHole.
+type holeRecorder struct {
+	mu      *sync.Mutex
+	data    []byte
+	pos     int64
+	written *int64
+	holes   *int64
+}
+
+func (w *holeRecorder) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	copy(w.data[w.pos:], p)
+	w.pos += int64(len(p))
+	*w.written += int64(len(p))
+	return len(p), nil
+}
+
+func (w *holeRecorder) WriteHole(n int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pos += n
+	*w.holes += n
+	return nil
+}
+
+func (w *holeRecorder) Close() error { return nil }
+
+func TestStartFromManifestHandsHoleFramesToHoleWriter(t *testing.T) {
+	root := t.TempDir()
+	const size = 4 << 20
+	head, tail := bytes.Repeat([]byte("head"), 1024), bytes.Repeat([]byte("tail"), 1024)
+	fd, err := os.Create(filepath.Join(root, "disk.img"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := fd.WriteAt(head, 0); err != nil {
+		t.Fatalf("write head: %v", err)
+	}
+	if _, err := fd.WriteAt(tail, size-int64(len(tail))); err != nil {
+		t.Fatalf("write tail: %v", err)
+	}
+	if err := fd.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	want := make([]byte, size)
+	copy(want, head)
+	copy(want[size-len(tail):], tail)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen tcp: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = intftcp.Serve(ln, intftcp.ServerOptions{}) }()
+
+	// One batch takes the whole file; a small batch splits it into windows
+	// that each carry their own hole frames.
+	for _, batchBytes := range []int64{0, 1 << 20} {
+		client := NewClient(ln.Addr().String(), WithSparse(true))
+		fetched, err := client.FetchManifest(context.Background(), FetchManifestRequest{Directory: root, Mode: "fast", LinkMbps: 1000, Concurrency: 2, Sparse: true})
+		if err != nil {
+			t.Fatalf("FetchManifest failed: %v", err)
+		}
+		if len(fetched.Manifest.Entries) != 1 || fetched.Manifest.Entries[0].HoleBytes == 0 {
+			t.Skipf("filesystem did not report holes: %+v", fetched.Manifest.Entries)
+		}
+		var mu sync.Mutex
+		var written, holes int64
+		got := make([]byte, size)
+		resp, err := client.StartFromManifest(context.Background(), StartFromManifestRequest{
+			Manifest:      fetched.Manifest,
+			Concurrency:   2,
+			BatchMaxBytes: batchBytes,
+			OutputWriter: func(_ ManifestEntry, offset int64) (io.WriteCloser, func() error, error) {
+				return &holeRecorder{mu: &mu, data: got, pos: offset, written: &written, holes: &holes}, nil, nil
+			},
+		})
+		if err != nil || len(resp.Errors) != 0 {
+			t.Fatalf("batch=%d: StartFromManifest failed: err=%v errors=%v", batchBytes, err, resp.Errors)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("batch=%d: output differs from source", batchBytes)
+		}
+		if holes < size/2 || written+holes != size {
+			t.Fatalf("batch=%d: expected the holes to skip Write, written=%d holes=%d", batchBytes, written, holes)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffAndClassification(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.5}.withDefaults()
+	noJitter := func() float64 { return 0 }
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 9: time.Second} {
+		if got := policy.backoff(attempt, noJitter); got != want {
+			t.Fatalf("attempt %d: backoff %s, want %s", attempt, got, want)
+		}
+	}
+	if got := policy.backoff(1, func() float64 { return 1 }); got != 50*time.Millisecond {
+		t.Fatalf("full jitter: backoff %s, want 50ms", got)
+	}
+
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{io.ErrUnexpectedEOF, true},
+		{fmt.Errorf("read frame header: %w", io.EOF), true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{fmt.Errorf("write request: %w", syscall.EPIPE), true},
+		{fmt.Errorf("create output writer for file 1: %w", &os.PathError{Op: "open", Path: "/out/a", Err: syscall.EACCES}), false},
+		{fmt.Errorf("write file 1: %w", &os.PathError{Op: "write", Path: "/out/a", Err: syscall.ENOSPC}), false},
+		{errors.New("window hash mismatch"), false},
+		{controlFrameError{Code: "INTERNAL"}, true},
+		{controlFrameError{Code: "TIMEOUT"}, true},
+		{controlFrameError{Code: "HTTP_503"}, true},
+		{controlFrameError{Code: "BAD_REQUEST"}, false},
+		{fmt.Errorf("wrapped: %w", ErrFileMissing), false},
+		{&FileChangedError{FileID: 1}, false},
+		{context.Canceled, false},
+	} {
+		if got := IsRetryable(tc.err); got != tc.want {
+			t.Fatalf("IsRetryable(%v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
+
 func TestDownloadFilesFromManifestBatchUsesMultiACK(t *testing.T) {
 	outRoot := t.TempDir()
 	manifest := &Manifest{
@@ -2291,3 +2793,699 @@ func TestFetchFileTransferNotFoundDoesNotAckMissing(t *testing.T) {
 		t.Fatalf("expected only one request without missing-ack retry, got %d", requests)
 	}
 }
+
+func TestWatchTransferParsesEvents(t *testing.T) {
+	var gotReq intftcp.Request
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		gotReq = req
+		_, err := io.WriteString(out, strings.Join([]string{
+			"FW/1 heartbeat 1700000000000",
+			"FW/1 add 3 10/4 0:1700000000000000000 0644 0:9:sub/c.txt",
+			"FW/1 pad ....",
+			"FW/1 grow 0 20 1700000000000000001",
+			"FW/1 replace 1 2 1700000000000000002",
+		}, "\n")+"\n")
+		return err
+	})
+	defer srv.Close()
+
+	stop := errors.New("stop")
+	var events []WatchEvent
+	err := NewClient(srv.URL).WatchTransfer(context.Background(), WatchTransferRequest{TransferID: "tx", Heartbeat: 5 * time.Second, Sparse: true}, func(event WatchEvent) error {
+		events = append(events, event)
+		if len(events) == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	if gotReq.Verb != intftcp.VerbWATCH || gotReq.Params[0]["txferid"] != "tx" || gotReq.Params[0]["heartbeat-ms"] != "5000" || gotReq.Params[0]["sparse"] != "1" {
+		t.Fatalf("unexpected WATCH request: %+v", gotReq)
+	}
+	add := events[0]
+	if add.Kind != WatchEventAdd || add.Entry.ID != 3 || add.Entry.Size != 10 || add.Entry.HoleBytes != 6 || add.Entry.Path != "sub/c.txt" || add.Entry.Mode != 0o644 {
+		t.Fatalf("unexpected add event: %+v", add)
+	}
+	if grow := events[1]; grow.Kind != WatchEventGrow || grow.Entry.ID != 0 || grow.Entry.Size != 20 || grow.Entry.Mtime != 1700000000000000001 {
+		t.Fatalf("unexpected grow event: %+v", grow)
+	}
+	if replace := events[2]; replace.Kind != WatchEventReplace || replace.Entry.ID != 1 || replace.Entry.Size != 2 {
+		t.Fatalf("unexpected replace event: %+v", replace)
+	}
+}
+
+func TestParseTransferOrder(t *testing.T) {
+	order, err := ParseTransferOrder("priority=metadata/**, smallest-first ,priority=*.json")
+	if err != nil {
+		t.Fatalf("ParseTransferOrder failed: %v", err)
+	}
+	if order.Policy != OrderSmallestFirst || len(order.PriorityGlobs) != 2 || order.PriorityGlobs[0] != "metadata/**" || order.PriorityGlobs[1] != "*.json" {
+		t.Fatalf("unexpected order: %+v", order)
+	}
+	if got := order.String(); got != "priority=metadata/**,priority=*.json,smallest-first" {
+		t.Fatalf("unexpected order string %q", got)
+	}
+	if order, err := ParseTransferOrder(""); err != nil || order.Policy != OrderManifest {
+		t.Fatalf("expected manifest order by default, got %+v err=%v", order, err)
+	}
+	for _, bad := range []string{"fastest", "mix,largest-first", "priority=", "priority=[a"} {
+		if _, err := ParseTransferOrder(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestMatchPathGlob(t *testing.T) {
+	cases := []struct {
+		glob string
+		name string
+		want bool
+	}{
+		{"metadata/**", "metadata/a.json", true},
+		{"metadata/**", "metadata/x/y/z.bin", true},
+		{"metadata/**", "data/metadata/a.json", false},
+		{"**/*.json", "a.json", true},
+		{"**/*.json", "x/y/a.json", true},
+		{"*.json", "x/a.json", false},
+		{"x/*/c", "x/b/c", true},
+	}
+	for _, tc := range cases {
+		if got := matchPathGlob(tc.glob, tc.name); got != tc.want {
+			t.Fatalf("matchPathGlob(%q, %q) = %t, want %t", tc.glob, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBuildOrderedManifestBatches(t *testing.T) {
+	entries := []ManifestEntry{
+		{ID: 0, Size: 50, Path: "data/big.bin"},
+		{ID: 1, Size: 3, Path: "data/a.txt"},
+		{ID: 2, Size: 40, Path: "data/big2.bin"},
+		{ID: 3, Size: 2, Path: "metadata/index.json"},
+		{ID: 4, Size: 1, Path: "data/b.txt"},
+		{ID: 5, Size: 4, Path: "data/c.txt"},
+	}
+	batchIDs := func(batches [][]ManifestEntry) [][]uint64 {
+		out := make([][]uint64, 0, len(batches))
+		for _, batch := range batches {
+			ids := make([]uint64, 0, len(batch))
+			for _, entry := range batch {
+				ids = append(ids, entry.ID)
+			}
+			out = append(out, ids)
+		}
+		return out
+	}
+	cases := []struct {
+		order TransferOrder
+		want  string
+	}{
+		{TransferOrder{}, "[[0] [1] [2] [3 4 5]]"},
+		{TransferOrder{Policy: OrderSmallestFirst}, "[[4 3 1 5] [2] [0]]"},
+		{TransferOrder{Policy: OrderLargestFirst}, "[[0] [2] [5 1 3 4]]"},
+		{TransferOrder{Policy: OrderMix}, "[[0] [1] [2] [3 4 5]]"},
+		{TransferOrder{Policy: OrderSmallestFirst, PriorityGlobs: []string{"metadata/**"}}, "[[3] [4 1 5] [2] [0]]"},
+	}
+	for _, tc := range cases {
+		got := fmt.Sprint(batchIDs(buildOrderedManifestBatches(entries, 10, tc.order)))
+		if got != tc.want {
+			t.Fatalf("order=%s: got batches %s, want %s", tc.order, got, tc.want)
+		}
+	}
+
+	mixed := []ManifestEntry{
+		{ID: 0, Size: 1, Path: "a"},
+		{ID: 1, Size: 1, Path: "b"},
+		{ID: 2, Size: 20, Path: "c"},
+		{ID: 3, Size: 20, Path: "d"},
+		{ID: 4, Size: 1, Path: "e"},
+	}
+	if got := fmt.Sprint(batchIDs(buildOrderedManifestBatches(mixed, 2, TransferOrder{Policy: OrderMix}))); got != "[[2] [0 1] [3] [4]]" {
+		t.Fatalf("mix: got batches %s", got)
+	}
+}
+
+func TestConcurrencyControllerAIMD(t *testing.T) {
+	var decisions []ConcurrencyDecision
+	ctrl := newConcurrencyController(4, 2, 8, func(d ConcurrencyDecision) { decisions = append(decisions, d) })
+	now := ctrl.lastTick
+	tick := func(bytes int64, lagMS int64, active int) {
+		ctrl.mu.Lock()
+		ctrl.active = active
+		ctrl.mu.Unlock()
+		ctrl.ObserveFrame(bytes, 1000, 1000+lagMS)
+		now = now.Add(time.Second)
+		ctrl.tick(now)
+	}
+
+	tick(100, 10, 4) // saturated, no history: probe up
+	tick(120, 10, 5) // goodput held: probe up again
+	if got := ctrl.Limit(); got != 6 {
+		t.Fatalf("expected additive increase to 6, got %d (decisions %v)", got, decisions)
+	}
+	tick(50, 10, 6) // goodput fell after the increase: back off one
+	if got := ctrl.Limit(); got != 5 || decisions[len(decisions)-1].Reason != ConcurrencyBackoff {
+		t.Fatalf("expected backoff to 5, got %d (decisions %v)", got, decisions)
+	}
+	tick(50, 10, 5) // cooldown
+	tick(50, 10, 5) // cooldown
+	if got := ctrl.Limit(); got != 5 {
+		t.Fatalf("expected hold during cooldown, got %d", got)
+	}
+	tick(60, 10, 3) // not saturated: hold
+	if got := ctrl.Limit(); got != 5 {
+		t.Fatalf("expected hold while workers idle, got %d", got)
+	}
+	tick(60, 500, 5) // frames queueing: multiplicative decrease
+	last := decisions[len(decisions)-1]
+	if got := ctrl.Limit(); got != 4 || last.Reason != ConcurrencyDelay || last.QueueDelay != 490*time.Millisecond {
+		t.Fatalf("expected delay decrease to 4, got %d (%v)", got, last)
+	}
+	for i := 0; i < 10; i++ {
+		tick(60, 500, 4)
+	}
+	if got := ctrl.Limit(); got != 2 {
+		t.Fatalf("expected decreases to stop at the minimum, got %d", got)
+	}
+}
+
+func TestConcurrencyControllerGatesWorkers(t *testing.T) {
+	ctrl := newConcurrencyController(2, 1, 4, nil)
+	done := make(chan struct{})
+	if !ctrl.acquire(context.Background(), done, 1) {
+		t.Fatalf("expected worker under the limit to acquire")
+	}
+	acquired := make(chan bool, 1)
+	go func() { acquired <- ctrl.acquire(context.Background(), done, 2) }()
+	select {
+	case <-acquired:
+		t.Fatalf("worker above the limit acquired")
+	case <-time.After(20 * time.Millisecond):
+	}
+	ctrl.ObserveFrame(10, 0, 0)
+	ctrl.tick(ctrl.lastTick.Add(time.Second)) // active=1 < limit=2: hold
+	ctrl.mu.Lock()
+	ctrl.active = 2
+	ctrl.mu.Unlock()
+	ctrl.ObserveFrame(10, 0, 0)
+	ctrl.tick(ctrl.lastTick.Add(time.Second))
+	if !<-acquired {
+		t.Fatalf("expected parked worker to acquire after the limit grew")
+	}
+	go func() { acquired <- ctrl.acquire(context.Background(), done, 3) }()
+	close(done)
+	if <-acquired {
+		t.Fatalf("expected parked worker to give up once all work was handed out")
+	}
+}
+
+func TestLinkEstimatorFromFramesAndAcks(t *testing.T) {
+	var l linkEstimator
+	if est := l.Estimate(); est.Mbps != 0 || est.suggestedConcurrency() != 0 {
+		t.Fatalf("expected empty estimate, got %v", est)
+	}
+	// 125KB every 100ms is 10Mbps; the trailer lag grows by 30ms at the end.
+	for i := int64(0); i <= 10; i++ {
+		lag := int64(20)
+		if i >= 9 {
+			lag = 50
+		}
+		l.ObserveFrame(125_000, 1000+i*100, 1000+i*100+lag)
+	}
+	l.observeAck(62_500, 100) // one stream: 5Mbps
+	l.observeRTT(30 * time.Millisecond)
+	l.observeRTT(12 * time.Millisecond)
+	l.observeRTT(40 * time.Millisecond)
+
+	est := l.Estimate()
+	if est.Mbps != 10 || est.StreamMbps != 5 || est.RTT != 12*time.Millisecond || est.Samples != 2 {
+		t.Fatalf("unexpected estimate: %v", est)
+	}
+	if est.QueueDelay <= 0 || est.QueueDelay >= 30*time.Millisecond {
+		t.Fatalf("expected smoothed queue delay below 30ms, got %s", est.QueueDelay)
+	}
+	if got := est.suggestedConcurrency(); got != 2 {
+		t.Fatalf("expected 2 streams to fill the link, got %d", got)
+	}
+
+	// A long idle gap starts a new span instead of reading as a slow link.
+	l.ObserveFrame(1, 0, 60_000)
+	l.ObserveFrame(125_000, 0, 60_600)
+	if got := l.Estimate().Mbps; got < 1 || got > 10 {
+		t.Fatalf("expected idle gap to be skipped, got %dMbps", got)
+	}
+}
+
+func TestStartFromManifestSendsLinkHint(t *testing.T) {
+	manifest := &Manifest{
+		TransferID: "txlink",
+		Root:       "/remote",
+		Mode:       LoadStrategyFast,
+		Entries:    []ManifestEntry{{ID: 0, Size: 5, Path: "a"}},
+	}
+	var mu sync.Mutex
+	var hints []string
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			for _, item := range req.Params[1:] {
+				mu.Lock()
+				hints = append(hints, item["link-mbps"]+"/"+item["link-rtt-ms"])
+				mu.Unlock()
+				if _, err := io.WriteString(out, buildFXFrame(t, 0, "none", 0, []byte("hello"), nil)); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbACK:
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer srv.Close()
+
+	client := NewClient(srv.URL)
+	client.link.ObserveFrame(0, 0, 1000)
+	client.link.ObserveFrame(125_000, 0, 1100)
+	client.link.ObserveFrame(500_000, 0, 1500)
+	client.link.observeRTT(7 * time.Millisecond)
+	resp, err := client.StartFromManifest(context.Background(), StartFromManifestRequest{
+		Manifest: manifest,
+		OutputWriter: func(ManifestEntry, int64) (io.WriteCloser, func() error, error) {
+			return noOpWriteCloser{Writer: io.Discard}, func() error { return nil }, nil
+		},
+	})
+	if err != nil || resp.Downloaded != 1 {
+		t.Fatalf("StartFromManifest failed: resp=%+v err=%v", resp, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hints) != 1 || hints[0] != "10/7" {
+		t.Fatalf("expected SEND to carry link-mbps=10 link-rtt-ms=7, got %v", hints)
+	}
+	if resp.Link.Mbps != 10 {
+		t.Fatalf("expected response to report the link estimate, got %v", resp.Link)
+	}
+}
+
+func TestStartFromManifestAdaptiveConcurrencyDownloadsAll(t *testing.T) {
+	manifest := &Manifest{
+		TransferID:  "txadaptive",
+		Root:        "/remote",
+		Mode:        LoadStrategyFast,
+		Concurrency: 2,
+	}
+	for i := 0; i < 30; i++ {
+		manifest.Entries = append(manifest.Entries, ManifestEntry{ID: uint64(i), Size: 5, Path: fmt.Sprintf("f%02d", i)})
+	}
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			for _, item := range req.Params[1:] {
+				fileID, err := strconv.ParseUint(item["fid"], 10, 64)
+				if err != nil {
+					return err
+				}
+				if _, err := io.WriteString(out, buildFXFrame(t, fileID, "none", 0, []byte("hello"), nil)); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbACK:
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer srv.Close()
+
+	client := NewClient(srv.URL, WithAdaptiveConcurrency(1, 4))
+	resp, err := client.StartFromManifest(context.Background(), StartFromManifestRequest{
+		Manifest: manifest,
+		OutputWriter: func(ManifestEntry, int64) (io.WriteCloser, func() error, error) {
+			return noOpWriteCloser{Writer: io.Discard}, func() error { return nil }, nil
+		},
+		BatchMaxBytes: 5,
+	})
+	if err != nil {
+		t.Fatalf("StartFromManifest failed: %v", err)
+	}
+	if resp.Downloaded != len(manifest.Entries) || len(resp.Errors) != 0 {
+		t.Fatalf("expected all files downloaded, got %d errors=%v", resp.Downloaded, resp.Errors)
+	}
+}
+
+func TestOpenReplicasVerifiesManifest(t *testing.T) {
+	primaryRaw := strings.Join([]string{
+		"FM/2 txprimary 7:/remote mode=fast link-mbps=1000 concurrency=2",
+		"0 5 0:100 0644 0:5:a.txt",
+		"1 3 0:100 0644 0:5:b.txt",
+		"",
+	}, "\n")
+	primary, err := parseManifest([]byte(primaryRaw))
+	if err != nil {
+		t.Fatalf("parse manifest: %v", err)
+	}
+	replicaRaw := strings.Replace(primaryRaw, "txprimary", "txreplica", 1)
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		if req.Verb != intftcp.VerbTXFER {
+			return fmt.Errorf("expected TXFER, got %v", req.Verb)
+		}
+		if got := req.Params[0]["directory"]; got != "/remote" {
+			return fmt.Errorf("expected TXFER directory /remote, got %q", got)
+		}
+		if _, err := io.WriteString(out, replicaRaw); err != nil {
+			return err
+		}
+		_, err := io.WriteString(out, "OK\r\n")
+		return err
+	})
+	defer srv.Close()
+
+	client := NewClient("127.0.0.1:1", WithReplica(srv.URL, ""))
+	replicas, err := client.OpenReplicas(context.Background(), OpenReplicasRequest{Manifest: primary})
+	if err != nil {
+		t.Fatalf("OpenReplicas failed: %v", err)
+	}
+	if len(replicas) != 1 || replicas[0].Addr != srv.URL || replicas[0].TransferID != "txreplica" {
+		t.Fatalf("unexpected replicas: %+v", replicas)
+	}
+
+	changed, err := parseManifest([]byte(strings.Replace(replicaRaw, "1 3 0:100", "1 4 0:100", 1)))
+	if err != nil {
+		t.Fatalf("parse manifest: %v", err)
+	}
+	err = verifyReplicaManifest(srv.URL, primary, changed)
+	var mismatch *ReplicaMismatchError
+	if !errors.As(err, &mismatch) || mismatch.FileID != 1 || !strings.Contains(mismatch.Reason, "size") {
+		t.Fatalf("expected size mismatch on fd=1, got %v", err)
+	}
+}
+
+func TestStartFromManifestFailsOverToReplica(t *testing.T) {
+	manifest := &Manifest{
+		TransferID:  "txprimary",
+		Root:        "/remote",
+		Mode:        LoadStrategyFast,
+		Concurrency: 2,
+		Entries: []ManifestEntry{
+			{ID: 0, Size: 10, Path: "a"},
+			{ID: 1, Size: 3, Path: "b"},
+		},
+	}
+	payloads := map[uint64][]byte{0: []byte("0123456789"), 1: []byte("xyz")}
+	var mu sync.Mutex
+	primaryAcks := 0
+	var replicaAcks []string
+	primary := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			_, err := io.WriteString(out, "ERR INTERNAL replica down\r\n")
+			return err
+		case intftcp.VerbACK:
+			mu.Lock()
+			primaryAcks++
+			mu.Unlock()
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer primary.Close()
+	replica := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			if got := req.Params[0]["txferid"]; got != "txreplica" {
+				return fmt.Errorf("expected replica transfer id, got %q", got)
+			}
+			for _, item := range req.Params[1:] {
+				fileID, err := strconv.ParseUint(item["fid"], 10, 64)
+				if err != nil {
+					return err
+				}
+				payload := payloads[fileID]
+				offset, _ := strconv.ParseInt(item["offset"], 10, 64)
+				end := int64(len(payload))
+				if size, _ := strconv.ParseInt(item["size"], 10, 64); size > 0 {
+					end = min(end, offset+size)
+				}
+				if _, err := io.WriteString(out, buildFXFrame(t, fileID, "none", offset, payload[offset:end], nil)); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbACK:
+			mu.Lock()
+			replicaAcks = append(replicaAcks, req.Params[0]["txferid"])
+			mu.Unlock()
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer replica.Close()
+
+	client := NewClient(primary.URL, WithFileRequestWindowBytes(5), WithReplica(replica.URL, ""))
+	resp, err := client.StartFromManifest(context.Background(), StartFromManifestRequest{
+		Manifest:      manifest,
+		BatchMaxBytes: 4,
+		Replicas:      []Replica{{Addr: replica.URL, TransferID: "txreplica"}},
+		OutputWriter: func(ManifestEntry, int64) (io.WriteCloser, func() error, error) {
+			return noOpWriteCloser{Writer: io.Discard}, func() error { return nil }, nil
+		},
+	})
+	if err != nil || resp.Downloaded != 2 || len(resp.Errors) != 0 {
+		t.Fatalf("StartFromManifest failed: resp=%+v err=%v", resp, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if primaryAcks != 0 {
+		t.Fatalf("expected no ACKs to the failing primary, got %d", primaryAcks)
+	}
+	if len(replicaAcks) == 0 {
+		t.Fatalf("expected ACKs to the replica")
+	}
+	for _, tid := range replicaAcks {
+		if tid != "txreplica" {
+			t.Fatalf("expected replica ACKs to carry the replica transfer id, got %q", tid)
+		}
+	}
+	if len(resp.Replicas) != 2 || resp.Replicas[0].Bytes != 0 || resp.Replicas[1].Bytes == 0 {
+		t.Fatalf("unexpected replica stats: %+v", resp.Replicas)
+	}
+}
+
+func TestReplicaPoolMovesStalledRequest(t *testing.T) {
+	manifest := &Manifest{TransferID: "txprimary"}
+	client := NewClient("127.0.0.1:1", WithReplicaStallTimeout(20*time.Millisecond))
+	pool := client.newReplicaPool(manifest, []Replica{{Addr: "127.0.0.1:2", TransferID: "txreplica"}})
+	pool.random = func() float64 { return 0 }
+	var order []string
+	err := pool.run(context.Background(), func(ctx context.Context, src *replicaSource) (int64, error) {
+		order = append(order, src.manifest.TransferID)
+		if src.manifest.TransferID == "txprimary" {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return 100, nil
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if strings.Join(order, ",") != "txprimary,txreplica" {
+		t.Fatalf("expected stalled primary then replica, got %v", order)
+	}
+	stats := pool.stats()
+	if stats[0].Failures != 1 || stats[1].Requests != 1 || stats[1].Bytes != 100 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	// The stalled primary cools down, so the next request skips it.
+	if src := pool.pick(nil); src != pool.sources[1] {
+		t.Fatalf("expected cooling primary to be skipped, got %s", src.addr)
+	}
+}
+
+func TestRemoteFileReadsRangesOverOneConnection(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCD")
+	manifest := &Manifest{
+		TransferID: "txremote",
+		Root:       "/remote",
+		Mode:       LoadStrategyFast,
+		Entries:    []ManifestEntry{{ID: 3, Size: int64(len(content)), Path: "data.parquet"}},
+	}
+	var mu sync.Mutex
+	var ranges []string
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		if req.Verb != intftcp.VerbSEND {
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+		item := req.Params[1]
+		if !req.KeepAlive {
+			return errors.New("expected keepalive=1")
+		}
+		offset, err := strconv.ParseInt(item["offset"], 10, 64)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseInt(item["size"], 10, 64)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		ranges = append(ranges, item["offset"]+"+"+item["size"])
+		mu.Unlock()
+		if _, err := io.WriteString(out, buildFXFrame(t, 3, "none", offset, content[offset:offset+size], nil)); err != nil {
+			return err
+		}
+		_, err = io.WriteString(out, "OK\r\n")
+		return err
+	})
+	defer srv.Close()
+
+	client := NewClient(srv.URL, WithRemoteReadAhead(16))
+	defer client.CloseIdleConnections()
+	f, err := client.OpenRemoteFile(manifest, 3)
+	if err != nil {
+		t.Fatalf("OpenRemoteFile failed: %v", err)
+	}
+	defer f.Close()
+
+	footer := make([]byte, 4)
+	if n, err := f.ReadAt(footer, 24); err != nil || n != 4 || string(footer) != "opqr" {
+		t.Fatalf("unexpected read: n=%d err=%v data=%q", n, err, footer)
+	}
+	// The first read fetched 16 bytes ahead, so the footer is cached.
+	if n, err := f.ReadAt(footer, int64(len(content))-4); err != nil || n != 4 || string(footer) != "ABCD" {
+		t.Fatalf("unexpected cached footer read: n=%d err=%v data=%q", n, err, footer)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	all, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(all, content) {
+		t.Fatalf("unexpected full read: err=%v data=%q", err, all)
+	}
+	if n, err := f.ReadAt(footer, int64(len(content))); n != 0 || err != io.EOF {
+		t.Fatalf("expected EOF past the end, got n=%d err=%v", n, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(ranges, ",") != "24+16,0+40" {
+		t.Fatalf("unexpected SEND ranges: %v", ranges)
+	}
+	if got := srv.accepted.Load(); got != 1 {
+		t.Fatalf("expected one reused connection, got %d", got)
+	}
+}
+
+func TestS3SinkResumesMultipartUploadFromSavedState(t *testing.T) {
+	fake := s3test.New()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	const partSize = 5 * 1024 * 1024
+	content := bytes.Repeat([]byte("0123456789abcdef"), (2*partSize+partSize/2)/16)
+	entry := ManifestEntry{ID: 7, Size: int64(len(content)), Mtime: 42, Path: "dir/big.bin"}
+	var saved string
+	newSink := func() *S3Sink {
+		sink, err := NewS3Sink(S3SinkConfig{
+			URL:       "s3://bucket/backup",
+			Endpoint:  srv.URL,
+			PartSize:  partSize,
+			SaveState: func(fileID uint64, state string) error { saved = state; return nil },
+		})
+		if err != nil {
+			t.Fatalf("NewS3Sink: %v", err)
+		}
+		return sink
+	}
+	writeWindow := func(sink *S3Sink, entry ManifestEntry, offset int64) {
+		t.Helper()
+		w, sync, err := sink.OpenWindow(entry, offset)
+		if err != nil {
+			t.Fatalf("OpenWindow(%d): %v", offset, err)
+		}
+		end := min(offset+partSize, entry.Size)
+		if _, err := w.Write(content[offset : offset+100]); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		// A replica failover rewinds the window and writes it again.
+		if _, err := w.(io.Seeker).Seek(-100, io.SeekCurrent); err != nil {
+			t.Fatalf("rewind: %v", err)
+		}
+		if _, err := w.Write(content[offset:end]); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := sync(); err != nil {
+			t.Fatalf("sync(%d): %v", offset, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+
+	first := newSink()
+	writeWindow(first, entry, 0)
+	writeWindow(first, entry, partSize)
+	if !strings.Contains(saved, "parts=1%3A") || fake.Uploads() != 1 {
+		t.Fatalf("expected saved part ETags for one upload, got %q uploads=%d", saved, fake.Uploads())
+	}
+
+	// A new run resumes from the .progress token after the first two parts.
+	entry.Progress = ManifestProgress{AckBytes: 2 * partSize, Sink: saved}
+	second := newSink()
+	if !second.Resumable(entry) {
+		t.Fatalf("expected saved state to be resumable")
+	}
+	writeWindow(second, entry, 2*partSize)
+	if err := second.FinishFile(context.Background(), entry); err != nil {
+		t.Fatalf("FinishFile: %v", err)
+	}
+	got, ok := fake.Object("bucket", "backup/dir/big.bin")
+	if !ok || !bytes.Equal(got, content) {
+		t.Fatalf("unexpected object: ok=%t len=%d", ok, len(got))
+	}
+	if fake.Requests("CreateMultipartUpload") != 1 || fake.Uploads() != 0 {
+		t.Fatalf("expected one completed upload, creates=%d open=%d", fake.Requests("CreateMultipartUpload"), fake.Uploads())
+	}
+	// A completion lost before it was recorded is accepted on retry.
+	entry.Progress.Sink = saved
+	if err := newSink().FinishFile(context.Background(), entry); err != nil {
+		t.Fatalf("repeated FinishFile: %v", err)
+	}
+
+	changed := entry
+	changed.Mtime++
+	if second.Resumable(changed) {
+		t.Fatalf("state for another mtime must not resume")
+	}
+
+	small := ManifestEntry{ID: 8, Size: 3, Path: "small.txt"}
+	w, sync, err := second.OpenWindow(small, 0)
+	if err != nil {
+		t.Fatalf("OpenWindow small: %v", err)
+	}
+	if _, err := io.WriteString(w, "abc"); err != nil {
+		t.Fatalf("write small: %v", err)
+	}
+	if err := sync(); err != nil {
+		t.Fatalf("sync small: %v", err)
+	}
+	_ = w.Close()
+	if err := second.FinishFile(context.Background(), small); err != nil {
+		t.Fatalf("FinishFile small: %v", err)
+	}
+	if got, _ := fake.Object("bucket", "backup/small.txt"); string(got) != "abc" || fake.Requests("PutObject") != 1 {
+		t.Fatalf("expected one PutObject for a single-part file, got %q puts=%d", got, fake.Requests("PutObject"))
+	}
+}
@@ -0,0 +1,230 @@
+package filexfer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConcurrencyInterval = time.Second
+	// concurrencyDelayThreshold is the queueing delay (frame receive time
+	// past the fastest frame seen) that counts as congestion.
+	concurrencyDelayThreshold = 200 * time.Millisecond
+	// concurrencyGoodputDrop is the fraction of the previous interval's
+	// goodput below which the last increase is treated as harmful.
+	concurrencyGoodputDrop = 0.9
+	// concurrencyCooldown is how many intervals to hold after a decrease.
+	concurrencyCooldown = 2
+)
+
+// Concurrency controller decision reasons.
+const (
+	ConcurrencyIncrease = "increase" // saturated and goodput held up
+	ConcurrencyBackoff  = "backoff"  // goodput fell after an increase
+	ConcurrencyDelay    = "delay"    // frames are queueing
+)
+
+// ConcurrencyDecision is one change the adaptive controller made to the
+// number of active StartFromManifest workers.
+type ConcurrencyDecision struct {
+	From    int
+	To      int
+	Reason  string
+	Goodput float64 // wire bytes per second over the last interval
+	// QueueDelay is how far frames arrived behind the fastest frame seen,
+	// averaged over the last interval.
+	QueueDelay time.Duration
+}
+
+func (d ConcurrencyDecision) String() string {
+	return fmt.Sprintf("%d->%d reason=%s goodput=%.0fB/s queue-delay=%s", d.From, d.To, d.Reason, d.Goodput, d.QueueDelay.Round(time.Millisecond))
+}
+
+// WithAdaptiveConcurrency lets StartFromManifest grow and shrink its active
+// workers between minWorkers and maxWorkers, starting from the requested
+// concurrency. A zero minimum uses the auto-start floor; a zero maximum
+// allows up to four times the starting concurrency.
+func WithAdaptiveConcurrency(minWorkers int, maxWorkers int) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.AdaptiveConcurrency = true
+		c.MinConcurrency = minWorkers
+		c.MaxConcurrency = maxWorkers
+	})
+}
+
+// frameObserver is told about every frame a download completes: its wire
+// bytes and the server (trailer ts) and client receive times in unix ms.
+type frameObserver interface {
+	ObserveFrame(wireBytes int64, serverTS int64, recvTS int64)
+}
+
+type frameObserverKey struct{}
+
+func withFrameObserver(ctx context.Context, observer frameObserver) context.Context {
+	return context.WithValue(ctx, frameObserverKey{}, observer)
+}
+
+func frameObserverFromContext(ctx context.Context) frameObserver {
+	observer, _ := ctx.Value(frameObserverKey{}).(frameObserver)
+	return observer
+}
+
+// concurrencyController is an AIMD controller over the number of workers
+// allowed to hold a batch. Each interval it adds a worker while the pool is
+// saturated and goodput holds, backs off one worker if goodput fell after
+// an increase, and cuts by a quarter when frames start queueing (their
+// receive-minus-server-ts lag rises above the minimum seen; the clock offset
+// between hosts cancels out).
+type concurrencyController struct {
+	mu      sync.Mutex
+	min     int
+	max     int
+	limit   int
+	active  int
+	changed chan struct{}
+
+	bytes    int64
+	lagSum   int64
+	lagCount int64
+	minLag   int64
+
+	prevGoodput  float64
+	lastIncrease bool
+	cooldown     int
+	lastTick     time.Time
+	onDecision   func(ConcurrencyDecision)
+}
+
+func newConcurrencyController(start int, minWorkers int, maxWorkers int, onDecision func(ConcurrencyDecision)) *concurrencyController {
+	if minWorkers <= 0 {
+		minWorkers = minAutoStartConcurrency
+	}
+	if maxWorkers <= 0 {
+		maxWorkers = min(maxAutoStartConcurrency, 4*max(start, 1))
+	}
+	maxWorkers = max(maxWorkers, minWorkers)
+	return &concurrencyController{
+		min:        minWorkers,
+		max:        maxWorkers,
+		limit:      min(max(start, minWorkers), maxWorkers),
+		changed:    make(chan struct{}),
+		minLag:     math.MaxInt64,
+		lastTick:   time.Now(),
+		onDecision: onDecision,
+	}
+}
+
+func (c *concurrencyController) ObserveFrame(wireBytes int64, serverTS int64, recvTS int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bytes += wireBytes
+	if serverTS <= 0 || recvTS <= 0 {
+		return
+	}
+	lag := recvTS - serverTS
+	c.minLag = min(c.minLag, lag)
+	c.lagSum += lag
+	c.lagCount++
+}
+
+// Limit is the current number of workers allowed to hold a batch.
+func (c *concurrencyController) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+// acquire blocks worker until it fits under the limit. It returns false if
+// ctx or done ends first.
+func (c *concurrencyController) acquire(ctx context.Context, done <-chan struct{}, worker int) bool {
+	for {
+		c.mu.Lock()
+		if worker < c.limit {
+			c.active++
+			c.mu.Unlock()
+			return true
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-changed:
+		case <-done:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (c *concurrencyController) release() {
+	c.mu.Lock()
+	c.active--
+	c.mu.Unlock()
+}
+
+// run ticks the controller every interval until ctx is done.
+func (c *concurrencyController) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.tick(now)
+		}
+	}
+}
+
+func (c *concurrencyController) tick(now time.Time) {
+	c.mu.Lock()
+	elapsed := now.Sub(c.lastTick)
+	c.lastTick = now
+	bytes, lagSum, lagCount := c.bytes, c.lagSum, c.lagCount
+	c.bytes, c.lagSum, c.lagCount = 0, 0, 0
+	if elapsed <= 0 || bytes == 0 {
+		// Nothing finished (or frames are larger than an interval); there
+		// is no signal to act on.
+		c.mu.Unlock()
+		return
+	}
+	decision := ConcurrencyDecision{From: c.limit, Goodput: float64(bytes) / elapsed.Seconds()}
+	if lagCount > 0 {
+		decision.QueueDelay = time.Duration(lagSum/lagCount-c.minLag) * time.Millisecond
+	}
+	to := c.limit
+	switch {
+	case decision.QueueDelay > concurrencyDelayThreshold && c.limit > c.min:
+		to = max(c.min, c.limit-max(1, c.limit/4))
+		decision.Reason = ConcurrencyDelay
+	case c.lastIncrease && decision.Goodput < c.prevGoodput*concurrencyGoodputDrop && c.limit > c.min:
+		to = c.limit - 1
+		decision.Reason = ConcurrencyBackoff
+	case c.cooldown > 0:
+		c.cooldown--
+	case c.active >= c.limit && c.limit < c.max && decision.Goodput >= c.prevGoodput*concurrencyGoodputDrop:
+		to = c.limit + 1
+		decision.Reason = ConcurrencyIncrease
+	}
+	c.prevGoodput = decision.Goodput
+	c.lastIncrease = to > c.limit
+	if to < c.limit {
+		c.cooldown = concurrencyCooldown
+	}
+	if to == c.limit {
+		c.mu.Unlock()
+		return
+	}
+	c.limit = to
+	decision.To = to
+	close(c.changed)
+	c.changed = make(chan struct{})
+	onDecision := c.onDecision
+	c.mu.Unlock()
+	if onDecision != nil {
+		onDecision(decision)
+	}
+}
@@ -0,0 +1,188 @@
+package filexfer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// linkSampleInterval is the shortest span of finished frames folded
+	// into one aggregate throughput sample.
+	linkSampleInterval = 500 * time.Millisecond
+	// linkIdleReset drops a sample span that went this long without a frame
+	// so idle gaps do not read as a slow link.
+	linkIdleReset = 5 * time.Second
+	linkEWMAAlpha = 0.3
+)
+
+// LinkEstimate is the client's passive view of the link to the server,
+// built from the FX/1 frames and ACK telemetry of ordinary downloads.
+type LinkEstimate struct {
+	// Mbps is the smoothed aggregate goodput across all streams.
+	Mbps int64
+	// StreamMbps is the smoothed per-stream receive rate (ACK delta-bytes
+	// over recv-ms).
+	StreamMbps int64
+	// RTT is the fastest SEND round trip seen: command written to first
+	// response line.
+	RTT time.Duration
+	// QueueDelay is how far frames currently arrive behind the fastest
+	// frame seen (receive time minus trailer ts; clock offset cancels).
+	QueueDelay time.Duration
+	Samples    int
+}
+
+func (e LinkEstimate) String() string {
+	return fmt.Sprintf("mbps=%d stream-mbps=%d rtt=%s queue-delay=%s samples=%d", e.Mbps, e.StreamMbps, e.RTT.Round(time.Millisecond), e.QueueDelay.Round(time.Millisecond), e.Samples)
+}
+
+// suggestedConcurrency is how many streams it takes to fill the link at the
+// observed per-stream rate, or zero before both rates are known.
+func (e LinkEstimate) suggestedConcurrency() int {
+	if e.Mbps <= 0 || e.StreamMbps <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(e.Mbps) / float64(e.StreamMbps)))
+}
+
+// linkEstimator accumulates the passive link estimate. The zero value is
+// ready to use.
+type linkEstimator struct {
+	mu sync.Mutex
+
+	spanStart int64 // unix ms
+	spanLast  int64
+	spanBytes int64
+	bps       float64
+	samples   int
+
+	streamBps float64
+	rtt       time.Duration
+
+	minLag       int64
+	haveLag      bool
+	queueDelayMS float64
+}
+
+func (l *linkEstimator) ObserveFrame(wireBytes int64, serverTS int64, recvTS int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if serverTS > 0 && recvTS > 0 {
+		lag := recvTS - serverTS
+		if !l.haveLag || lag < l.minLag {
+			l.minLag, l.haveLag = lag, true
+		}
+		l.queueDelayMS = ewma(l.queueDelayMS, float64(lag-l.minLag), true)
+	}
+	if recvTS <= 0 {
+		return
+	}
+	if l.spanStart == 0 || recvTS-l.spanLast > linkIdleReset.Milliseconds() {
+		// The first frame of a span finished before the span began, so only
+		// its arrival time counts.
+		l.spanStart, l.spanLast, l.spanBytes = recvTS, recvTS, 0
+		return
+	}
+	l.spanLast = recvTS
+	l.spanBytes += wireBytes
+	elapsedMS := recvTS - l.spanStart
+	if elapsedMS < linkSampleInterval.Milliseconds() {
+		return
+	}
+	sample := float64(l.spanBytes) / (float64(elapsedMS) / 1000.0)
+	l.bps = ewma(l.bps, sample, l.samples > 0)
+	l.samples++
+	l.spanStart, l.spanBytes = recvTS, 0
+}
+
+// observeAck folds in one acknowledged window's delta-bytes and recv-ms.
+func (l *linkEstimator) observeAck(deltaBytes int64, recvMS int64) {
+	if deltaBytes <= 0 || recvMS <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sample := float64(deltaBytes) / (float64(recvMS) / 1000.0)
+	l.streamBps = ewma(l.streamBps, sample, l.streamBps > 0)
+}
+
+// observeRTT records one request round trip; the estimate keeps the minimum.
+func (l *linkEstimator) observeRTT(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rtt == 0 || d < l.rtt {
+		l.rtt = d
+	}
+}
+
+func (l *linkEstimator) Estimate() LinkEstimate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	est := LinkEstimate{
+		Mbps:       bpsToMbps(l.bps),
+		StreamMbps: bpsToMbps(l.streamBps),
+		RTT:        l.rtt,
+		QueueDelay: time.Duration(l.queueDelayMS * float64(time.Millisecond)),
+		Samples:    l.samples,
+	}
+	if est.Mbps == 0 {
+		// Before a full span finishes a single stream's rate is the best
+		// lower bound on the link.
+		est.Mbps = est.StreamMbps
+	}
+	return est
+}
+
+func ewma(prev float64, sample float64, initialized bool) float64 {
+	if !initialized {
+		return sample
+	}
+	return linkEWMAAlpha*sample + (1-linkEWMAAlpha)*prev
+}
+
+func bpsToMbps(bps float64) int64 {
+	return int64(math.Round(bps * 8 / 1_000_000))
+}
+
+// LinkEstimate returns the passive link estimate from this client's
+// downloads so far.
+func (c *Client) LinkEstimate() LinkEstimate {
+	return c.link.Estimate()
+}
+
+// linkOption is the SEND item hint carrying the current estimate to the
+// server's compression policy; empty until there is one.
+func (c *Client) linkOption() string {
+	est := c.link.Estimate()
+	if est.Mbps <= 0 {
+		return ""
+	}
+	opt := " link-mbps=" + strconv.FormatInt(est.Mbps, 10)
+	if est.RTT > 0 {
+		opt += " link-rtt-ms=" + strconv.FormatInt(max(1, est.RTT.Milliseconds()), 10)
+	}
+	return opt
+}
+
+// frameObserver is the client's link estimator plus any observer on ctx.
+func (c *Client) frameObserver(ctx context.Context) frameObserver {
+	if observer := frameObserverFromContext(ctx); observer != nil {
+		return frameObservers{&c.link, observer}
+	}
+	return &c.link
+}
+
+type frameObservers []frameObserver
+
+func (o frameObservers) ObserveFrame(wireBytes int64, serverTS int64, recvTS int64) {
+	for _, observer := range o {
+		observer.ObserveFrame(wireBytes, serverTS, recvTS)
+	}
+}
@@ -0,0 +1,175 @@
+package filexfer
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	// OrderManifest hands out files in manifest (directory walk) order.
+	OrderManifest = "manifest"
+	// OrderSmallestFirst maximizes early file-count progress.
+	OrderSmallestFirst = "smallest-first"
+	// OrderLargestFirst starts long files early so they do not set the tail.
+	OrderLargestFirst = "largest-first"
+	// OrderMix alternates large files, which download as parallel split
+	// windows, with batches of small files.
+	OrderMix = "mix"
+)
+
+// TransferOrder is the order StartFromManifest hands work to its workers.
+// Files matching an earlier PriorityGlobs pattern go before later ones and
+// before unmatched files; Policy orders files within each of those tiers.
+type TransferOrder struct {
+	Policy        string
+	PriorityGlobs []string
+}
+
+// ParseTransferOrder parses a comma-separated --order value: at most one
+// policy name and any number of priority=<glob> terms, for example
+// "priority=metadata/**,smallest-first". Globs match slash-separated
+// manifest paths with path.Match syntax per segment; "**" matches any
+// number of segments.
+func ParseTransferOrder(raw string) (TransferOrder, error) {
+	var order TransferOrder
+	for _, term := range strings.Split(raw, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		if glob, ok := strings.CutPrefix(term, "priority="); ok {
+			if err := validatePathGlob(glob); err != nil {
+				return TransferOrder{}, err
+			}
+			order.PriorityGlobs = append(order.PriorityGlobs, glob)
+			continue
+		}
+		switch strings.ToLower(term) {
+		case OrderManifest, OrderSmallestFirst, OrderLargestFirst, OrderMix:
+		default:
+			return TransferOrder{}, fmt.Errorf("unknown order %q (want manifest|smallest-first|largest-first|mix|priority=<glob>)", term)
+		}
+		if order.Policy != "" {
+			return TransferOrder{}, errors.New("order accepts at most one policy")
+		}
+		order.Policy = strings.ToLower(term)
+	}
+	if order.Policy == "" {
+		order.Policy = OrderManifest
+	}
+	return order, nil
+}
+
+func (o TransferOrder) String() string {
+	policy := o.Policy
+	if policy == "" {
+		policy = OrderManifest
+	}
+	terms := make([]string, 0, len(o.PriorityGlobs)+1)
+	for _, glob := range o.PriorityGlobs {
+		terms = append(terms, "priority="+glob)
+	}
+	return strings.Join(append(terms, policy), ",")
+}
+
+func validatePathGlob(glob string) error {
+	if glob == "" {
+		return errors.New("empty priority glob")
+	}
+	for _, segment := range strings.Split(glob, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid priority glob %q: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// matchPathGlob reports whether the slash-separated name matches glob.
+func matchPathGlob(glob string, name string) bool {
+	return matchGlobSegments(strings.Split(glob, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobSegments(glob []string, name []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchGlobSegments(glob[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], name[0]); !ok {
+			return false
+		}
+		glob, name = glob[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// buildOrderedManifestBatches packs entries into batches of at most maxBytes
+// (see buildManifestBatchesByBytes) in the order the policy asks for.
+// Batches never span priority tiers.
+func buildOrderedManifestBatches(entries []ManifestEntry, maxBytes int64, order TransferOrder) [][]ManifestEntry {
+	if maxBytes <= 0 {
+		maxBytes = defaultClientBatchMaxBytes
+	}
+	tiers := make([][]ManifestEntry, len(order.PriorityGlobs)+1)
+	for _, entry := range entries {
+		tier := len(order.PriorityGlobs)
+		for i, glob := range order.PriorityGlobs {
+			if matchPathGlob(glob, entry.Path) {
+				tier = i
+				break
+			}
+		}
+		tiers[tier] = append(tiers[tier], entry)
+	}
+	var batches [][]ManifestEntry
+	for _, tier := range tiers {
+		switch order.Policy {
+		case OrderSmallestFirst:
+			slices.SortStableFunc(tier, func(a, b ManifestEntry) int { return cmp.Compare(a.Size, b.Size) })
+		case OrderLargestFirst:
+			slices.SortStableFunc(tier, func(a, b ManifestEntry) int { return cmp.Compare(b.Size, a.Size) })
+		}
+		tierBatches := buildManifestBatchesByBytes(tier, maxBytes)
+		if order.Policy == OrderMix {
+			tierBatches = interleaveLargeBatches(tierBatches, maxBytes)
+		}
+		batches = append(batches, tierBatches...)
+	}
+	return batches
+}
+
+// interleaveLargeBatches alternates single-file batches of at least maxBytes
+// with the remaining small-file batches, keeping each group's order.
+func interleaveLargeBatches(batches [][]ManifestEntry, maxBytes int64) [][]ManifestEntry {
+	var large, small [][]ManifestEntry
+	for _, batch := range batches {
+		if len(batch) == 1 && batch[0].Size >= maxBytes {
+			large = append(large, batch)
+		} else {
+			small = append(small, batch)
+		}
+	}
+	out := make([][]ManifestEntry, 0, len(batches))
+	for len(large) > 0 || len(small) > 0 {
+		if len(large) > 0 {
+			out = append(out, large[0])
+			large = large[1:]
+		}
+		if len(small) > 0 {
+			out = append(out, small[0])
+			small = small[1:]
+		}
+	}
+	return out
+}
@@ -0,0 +1,355 @@
+package filexfer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRemoteReadAheadBytes = 256 * 1024
+	// remoteFileCacheBlocks is how many fetched ranges a RemoteFile keeps.
+	remoteFileCacheBlocks = 4
+	maxIdleRemoteConns    = 4
+	// remoteConnIdleTimeout drops pooled connections before the server's
+	// one minute keepalive timeout closes them.
+	remoteConnIdleTimeout = 30 * time.Second
+)
+
+// WithRemoteReadAhead sets the smallest range a RemoteFile fetches per read.
+func WithRemoteReadAhead(bytes int64) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.RemoteReadAheadBytes = bytes
+	})
+}
+
+// RemoteFile reads one manifest file straight from the server with ranged
+// SENDs, without downloading the rest of it. Each miss fetches at least the
+// client's read-ahead and the last few ranges are cached. ReadAt is safe for
+// concurrent use; Read and Seek share one position.
+type RemoteFile struct {
+	client    *Client
+	txferID   string
+	fileID    uint64
+	fullPath  string
+	size      int64
+	readAhead int64
+
+	mu     sync.Mutex
+	pos    int64
+	blocks []remoteBlock // least recently used first
+	closed bool
+}
+
+type remoteBlock struct {
+	offset int64
+	data   []byte
+}
+
+var (
+	_ io.ReaderAt       = (*RemoteFile)(nil)
+	_ io.ReadSeekCloser = (*RemoteFile)(nil)
+)
+
+// OpenRemoteFile opens fileID of manifest for random access reads.
+func (c *Client) OpenRemoteFile(manifest *Manifest, fileID uint64) (*RemoteFile, error) {
+	if c == nil {
+		return nil, errors.New("nil client")
+	}
+	if manifest == nil {
+		return nil, errors.New("nil manifest")
+	}
+	if manifest.TransferID == "" {
+		return nil, errors.New("missing transfer id")
+	}
+	entry, serverPath, err := resolveManifestEntryPath(manifest, fileID)
+	if err != nil {
+		return nil, err
+	}
+	readAhead := c.RemoteReadAheadBytes
+	if readAhead <= 0 {
+		readAhead = defaultRemoteReadAheadBytes
+	}
+	return &RemoteFile{
+		client:    c,
+		txferID:   manifest.TransferID,
+		fileID:    entry.ID,
+		fullPath:  serverPath,
+		size:      entry.Size,
+		readAhead: readAhead,
+	}, nil
+}
+
+// Size is the file's size in the manifest.
+func (f *RemoteFile) Size() int64 {
+	return f.size
+}
+
+func (f *RemoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < f.size {
+		at := off + int64(n)
+		copied, err := f.readCached(p[n:], at)
+		if err != nil {
+			return n, err
+		}
+		if copied > 0 {
+			n += copied
+			continue
+		}
+		want := min(max(int64(len(p)-n), f.readAhead), f.size-at)
+		data, err := f.client.fetchRemoteRange(context.Background(), f.txferID, f.fileID, f.fullPath, at, want)
+		if err != nil {
+			return n, err
+		}
+		if len(data) == 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		f.store(at, data)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *RemoteFile) readCached(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	for i, block := range f.blocks {
+		if off < block.offset || off >= block.offset+int64(len(block.data)) {
+			continue
+		}
+		f.blocks = append(append(f.blocks[:i:i], f.blocks[i+1:]...), block)
+		return copy(p, block.data[off-block.offset:]), nil
+	}
+	return 0, nil
+}
+
+func (f *RemoteFile) store(off int64, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.blocks) >= remoteFileCacheBlocks {
+		f.blocks = append(f.blocks[:0], f.blocks[1:]...)
+	}
+	f.blocks = append(f.blocks, remoteBlock{offset: off, data: data})
+}
+
+func (f *RemoteFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	off := f.pos
+	f.mu.Unlock()
+	n, err := f.ReadAt(p, off)
+	f.mu.Lock()
+	f.pos = off + int64(n)
+	f.mu.Unlock()
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *RemoteFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close drops the cache. Pooled connections belong to the client.
+func (f *RemoteFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	f.blocks = nil
+	return nil
+}
+
+// fetchRemoteRange reads [offset, offset+size) of one file with a single
+// SEND. Plaintext sessions ask for keepalive and return the connection to
+// the idle pool once the response ends; a pooled connection the server has
+// since closed is retried once on a fresh one.
+func (c *Client) fetchRemoteRange(ctx context.Context, txferID string, fileID uint64, fullPath string, offset int64, size int64) ([]byte, error) {
+	state, err := c.resolveTCPAuthState("", "")
+	if err != nil {
+		return nil, err
+	}
+	reuse := !state.hasAuth
+	for attempt := 0; ; attempt++ {
+		var conn net.Conn
+		pooled := false
+		if reuse && attempt == 0 {
+			conn = c.idleConns.get()
+			pooled = conn != nil
+		}
+		if conn == nil {
+			if conn, err = c.dialTCP(ctx); err != nil {
+				return nil, fmt.Errorf("dial file listener: %w", err)
+			}
+			if err := c.sendTCPAuth(conn, state); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("send AUTH: %w", err)
+			}
+		}
+		data, started, err := c.readRemoteRange(ctx, conn, state, reuse, txferID, fileID, fullPath, offset, size)
+		if err == nil {
+			if reuse {
+				c.idleConns.put(conn)
+			} else {
+				conn.Close()
+			}
+			return data, nil
+		}
+		conn.Close()
+		if !pooled || started {
+			return nil, err
+		}
+	}
+}
+
+// readRemoteRange issues one ranged SEND on conn. started reports whether
+// the server answered, which rules out a stale pooled connection.
+func (c *Client) readRemoteRange(ctx context.Context, conn net.Conn, state tcpAuthState, keepAlive bool, txferID string, fileID uint64, fullPath string, offset int64, size int64) ([]byte, bool, error) {
+	var cmd strings.Builder
+	cmd.WriteString("SEND ")
+	cmd.WriteString(txferID)
+	cmd.WriteString(" fd=")
+	cmd.WriteString(strconv.FormatUint(fileID, 10))
+	cmd.WriteString(" ")
+	cmd.WriteString(makeLenToken(fullPath))
+	cmd.WriteString(" mode=")
+	cmd.WriteString(normalizeLoadStrategy(c.LoadStrategy))
+	cmd.WriteString(" offset=")
+	cmd.WriteString(strconv.FormatInt(offset, 10))
+	cmd.WriteString(" size=")
+	cmd.WriteString(strconv.FormatInt(size, 10))
+	if c.Comp != "" {
+		cmd.WriteString(" comp=")
+		cmd.WriteString(c.Comp)
+	}
+	cmd.WriteString(c.consistencyOption())
+	cmd.WriteString(c.linkOption())
+	if keepAlive {
+		cmd.WriteString(" keepalive=1")
+	}
+	cmd.WriteString(traceParentOption(ctx))
+	if err := c.sendTCPCommand(conn, state, cmd.String()); err != nil {
+		return nil, false, fmt.Errorf("send SEND: %w", err)
+	}
+	sentAt := time.Now()
+	responseReader, err := c.responseReaderForTCP(conn, state)
+	if err != nil {
+		return nil, false, fmt.Errorf("initialize SEND response stream: %w", err)
+	}
+	// The server writes nothing after OK until the next command, so the
+	// buffered readers below never consume bytes of a later response.
+	br := bufio.NewReader(responseReader)
+	firstLine, err := br.ReadString('\n')
+	if err != nil {
+		return nil, false, fmt.Errorf("read SEND response: %w", err)
+	}
+	c.link.observeRTT(time.Since(sentAt))
+	trimmed := strings.TrimRight(firstLine, "\r\n")
+	if err := parseErrControlFrame(trimmed); err != nil {
+		var controlErr controlFrameError
+		if errors.As(err, &controlErr) && strings.EqualFold(controlErr.Code, "NOT_FOUND") {
+			return nil, true, fmt.Errorf("%w: %w", ErrFileMissing, &fileMissingError{Status: 404, Body: strings.TrimSpace(controlErr.Message)})
+		}
+		return nil, true, err
+	}
+	if _, ok := parseOKStatusLine(trimmed); ok {
+		return nil, true, errors.New("unexpected OK response for SEND")
+	}
+	body := io.NopCloser(io.MultiReader(strings.NewReader(firstLine), br))
+	stream, meta, err := c.newFileStream(body, "", size)
+	if err != nil {
+		return nil, true, err
+	}
+	defer stream.Close()
+	if meta.FileID != fileID {
+		return nil, true, fmt.Errorf("file id mismatch: expected %d got %d", fileID, meta.FileID)
+	}
+	if fs, ok := stream.(*fileStream); ok {
+		fs.observer = c.frameObserver(ctx)
+	}
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, true, err
+	}
+	return data, true, nil
+}
+
+// idleConnPool holds keepalive connections between RemoteFile reads.
+type idleConnPool struct {
+	mu    sync.Mutex
+	conns []idleConn
+}
+
+type idleConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+func (p *idleConnPool) get() net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.conns) > 0 {
+		last := p.conns[len(p.conns)-1]
+		p.conns = p.conns[:len(p.conns)-1]
+		if time.Since(last.since) < remoteConnIdleTimeout {
+			return last.conn
+		}
+		_ = last.conn.Close()
+	}
+	return nil
+}
+
+func (p *idleConnPool) put(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.conns) >= maxIdleRemoteConns {
+		_ = conn.Close()
+		return
+	}
+	p.conns = append(p.conns, idleConn{conn: conn, since: time.Now()})
+}
+
+func (p *idleConnPool) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, idle := range p.conns {
+		_ = idle.conn.Close()
+	}
+	p.conns = nil
+}
+
+// CloseIdleConnections closes connections kept open for RemoteFile reads.
+func (c *Client) CloseIdleConnections() {
+	c.idleConns.closeAll()
+}
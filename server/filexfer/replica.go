@@ -0,0 +1,516 @@
+package filexfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultReplicaStallTimeout is how long a replica may go without
+	// finishing a frame before its request is abandoned for another replica.
+	defaultReplicaStallTimeout = 30 * time.Second
+	// replicaFailureCooldown is how long a failed replica sits out; it
+	// doubles with each consecutive failure up to maxReplicaFailureCooldown.
+	replicaFailureCooldown    = 5 * time.Second
+	maxReplicaFailureCooldown = time.Minute
+	replicaGoodputAlpha       = 0.3
+)
+
+var errReplicaStalled = errors.New("replica stalled")
+
+// ReplicaServer is another pinch server holding the same tree as the
+// client's FileAddr.
+type ReplicaServer struct {
+	Addr string
+	// ServerAgePublicKey encrypts requests to this replica; empty uses the
+	// client's ServerAgePublicKey.
+	ServerAgePublicKey string
+}
+
+// WithReplica adds a replica server. OpenReplicas starts a transfer of the
+// manifest root on each one, and StartFromManifest spreads batches and split
+// windows over FileAddr and the opened replicas.
+func WithReplica(addr string, serverAgePublicKey string) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.Replicas = append(c.Replicas, ReplicaServer{Addr: addr, ServerAgePublicKey: serverAgePublicKey})
+	})
+}
+
+// WithReplicaStallTimeout sets how long a replica may go without finishing a
+// frame before its work moves to another replica.
+func WithReplicaStallTimeout(timeout time.Duration) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.ReplicaStallTimeout = timeout
+	})
+}
+
+// Replica is a replica server's own transfer of a manifest's root.
+type Replica struct {
+	Addr       string
+	TransferID string
+}
+
+type OpenReplicasRequest struct {
+	Manifest     *Manifest
+	AgePublicKey string
+	AgeIdentity  string
+}
+
+// ReplicaStats is one server's share of a StartFromManifest run. The
+// primary server is listed first.
+type ReplicaStats struct {
+	Addr       string
+	TransferID string
+	Bytes      int64
+	Requests   int
+	Failures   int
+	// Goodput is the smoothed bytes per second of its finished requests.
+	Goodput float64
+}
+
+// ReplicaMismatchError reports a replica whose manifest does not describe
+// the same files as the primary's.
+type ReplicaMismatchError struct {
+	Addr   string
+	FileID uint64
+	Reason string
+}
+
+func (e *ReplicaMismatchError) Error() string {
+	return fmt.Sprintf("replica %s does not match manifest at fd=%d: %s", e.Addr, e.FileID, e.Reason)
+}
+
+// OpenReplicas starts a transfer of req.Manifest's root on every configured
+// replica and checks each replica's manifest lists the same paths, sizes and
+// mtimes under the same file ids.
+func (c *Client) OpenReplicas(ctx context.Context, req OpenReplicasRequest) ([]Replica, error) {
+	if c == nil {
+		return nil, errors.New("nil client")
+	}
+	if req.Manifest == nil {
+		return nil, errors.New("nil manifest")
+	}
+	mode := req.Manifest.Mode
+	if mode == "" {
+		mode = normalizeLoadStrategy(c.LoadStrategy)
+	}
+	replicas := make([]Replica, 0, len(c.Replicas))
+	for _, server := range c.Replicas {
+		resp, err := c.replicaClient(server).FetchManifest(ctx, FetchManifestRequest{
+			Directory:    req.Manifest.Root,
+			Mode:         mode,
+			LinkMbps:     req.Manifest.LinkMbps,
+			Concurrency:  req.Manifest.Concurrency,
+			AgePublicKey: req.AgePublicKey,
+			AgeIdentity:  req.AgeIdentity,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("open replica %s: %w", server.Addr, err)
+		}
+		if err := verifyReplicaManifest(server.Addr, req.Manifest, resp.Manifest); err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, Replica{Addr: server.Addr, TransferID: resp.Manifest.TransferID})
+	}
+	return replicas, nil
+}
+
+func verifyReplicaManifest(addr string, primary *Manifest, replica *Manifest) error {
+	if replica == nil {
+		return &ReplicaMismatchError{Addr: addr, Reason: "empty manifest"}
+	}
+	byID := make(map[uint64]ManifestEntry, len(replica.Entries))
+	for _, entry := range replica.Entries {
+		byID[entry.ID] = entry
+	}
+	for _, want := range primary.Entries {
+		got, ok := byID[want.ID]
+		switch {
+		case !ok:
+			return &ReplicaMismatchError{Addr: addr, FileID: want.ID, Reason: "missing"}
+		case got.Path != want.Path:
+			return &ReplicaMismatchError{Addr: addr, FileID: want.ID, Reason: fmt.Sprintf("path %q != %q", got.Path, want.Path)}
+		case got.Size != want.Size:
+			return &ReplicaMismatchError{Addr: addr, FileID: want.ID, Reason: fmt.Sprintf("size %d != %d", got.Size, want.Size)}
+		case got.Mtime != want.Mtime:
+			return &ReplicaMismatchError{Addr: addr, FileID: want.ID, Reason: fmt.Sprintf("mtime %d != %d", got.Mtime, want.Mtime)}
+		}
+	}
+	return nil
+}
+
+// replicaClient is a client for server with c's settings.
+func (c *Client) replicaClient(server ReplicaServer) *Client {
+	r := NewClient(server.Addr)
+	r.ServerAgePublicKey = c.ServerAgePublicKey
+	if server.ServerAgePublicKey != "" {
+		r.ServerAgePublicKey = server.ServerAgePublicKey
+	}
+	r.FileRequestWindowBytes = c.FileRequestWindowBytes
+	r.BatchMaxBytes = c.BatchMaxBytes
+	r.FrameBufferBytes = c.FrameBufferBytes
+	r.MaxFrameReadBufferBytes = c.MaxFrameReadBufferBytes
+	r.AckRequestTimeout = c.AckRequestTimeout
+	r.SocketReadBufferBytes = c.SocketReadBufferBytes
+	r.LoadStrategy = c.LoadStrategy
+	r.Comp = c.Comp
+	r.FileMetadata = c.FileMetadata
+	r.Sparse = c.Sparse
+	r.Consistency = c.Consistency
+	r.contextDialer = c.contextDialer
+	return r
+}
+
+type replicaSource struct {
+	addr     string
+	client   *Client
+	manifest *Manifest
+
+	// Guarded by replicaPool.mu.
+	goodput     float64
+	bytes       int64
+	requests    int
+	failures    int
+	consecutive int
+	downUntil   time.Time
+}
+
+// replicaPool hands requests to the primary server and its replicas,
+// weighted by each one's observed goodput, and moves a request to another
+// server when one fails or stalls. Each request is acknowledged to the server
+// that sent it, since window hashes live on that server.
+type replicaPool struct {
+	mu           sync.Mutex
+	sources      []*replicaSource
+	stallTimeout time.Duration
+	random       func() float64
+}
+
+func (c *Client) newReplicaPool(manifest *Manifest, replicas []Replica) *replicaPool {
+	pool := &replicaPool{
+		sources:      []*replicaSource{{addr: c.FileAddr, client: c, manifest: manifest}},
+		stallTimeout: c.ReplicaStallTimeout,
+		random:       rand.Float64,
+	}
+	if pool.stallTimeout <= 0 {
+		pool.stallTimeout = defaultReplicaStallTimeout
+	}
+	for _, replica := range replicas {
+		server := ReplicaServer{Addr: replica.Addr}
+		for _, configured := range c.Replicas {
+			if configured.Addr == replica.Addr {
+				server = configured
+				break
+			}
+		}
+		replicaManifest := *manifest
+		replicaManifest.TransferID = replica.TransferID
+		pool.sources = append(pool.sources, &replicaSource{
+			addr:     replica.Addr,
+			client:   c.replicaClient(server),
+			manifest: &replicaManifest,
+		})
+	}
+	return pool
+}
+
+// pick chooses an untried source at random, weighted by goodput. Sources
+// without a measurement yet weigh as much as the average measured one.
+// Cooling-down sources are only used once every other has been tried.
+func (p *replicaPool) pick(tried map[*replicaSource]bool) *replicaSource {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var ready []*replicaSource
+	var cooling *replicaSource
+	for _, src := range p.sources {
+		if tried[src] {
+			continue
+		}
+		if now.Before(src.downUntil) {
+			if cooling == nil || src.downUntil.Before(cooling.downUntil) {
+				cooling = src
+			}
+			continue
+		}
+		ready = append(ready, src)
+	}
+	if len(ready) == 0 {
+		return cooling
+	}
+	measured, total := 0, 0.0
+	for _, src := range ready {
+		if src.goodput > 0 {
+			measured++
+			total += src.goodput
+		}
+	}
+	unmeasured := 1.0
+	if measured > 0 {
+		unmeasured = total / float64(measured)
+	}
+	weights := make([]float64, len(ready))
+	sum := 0.0
+	for i, src := range ready {
+		weights[i] = src.goodput
+		if weights[i] <= 0 {
+			weights[i] = unmeasured
+		}
+		sum += weights[i]
+	}
+	target := p.random() * sum
+	for i, w := range weights {
+		if target < w {
+			return ready[i]
+		}
+		target -= w
+	}
+	return ready[len(ready)-1]
+}
+
+func (p *replicaPool) succeed(src *replicaSource, bytes int64, elapsed time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	src.requests++
+	src.bytes += bytes
+	src.consecutive = 0
+	src.downUntil = time.Time{}
+	if elapsed > 0 && bytes > 0 {
+		sample := float64(bytes) / elapsed.Seconds()
+		if src.goodput <= 0 {
+			src.goodput = sample
+		} else {
+			src.goodput = replicaGoodputAlpha*sample + (1-replicaGoodputAlpha)*src.goodput
+		}
+	}
+}
+
+func (p *replicaPool) fail(src *replicaSource) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	src.requests++
+	src.failures++
+	src.consecutive++
+	cooldown := min(maxReplicaFailureCooldown, replicaFailureCooldown<<min(src.consecutive-1, 4))
+	src.downUntil = time.Now().Add(cooldown)
+}
+
+func (p *replicaPool) stats() []ReplicaStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]ReplicaStats, 0, len(p.sources))
+	for _, src := range p.sources {
+		out = append(out, ReplicaStats{
+			Addr:       src.addr,
+			TransferID: src.manifest.TransferID,
+			Bytes:      src.bytes,
+			Requests:   src.requests,
+			Failures:   src.failures,
+			Goodput:    src.goodput,
+		})
+	}
+	return out
+}
+
+// run calls fn on one source after another until it succeeds, a failure is
+// not the replica's fault, or every source has been tried. fn returns the
+// bytes it moved.
+func (p *replicaPool) run(ctx context.Context, fn func(context.Context, *replicaSource) (int64, error)) error {
+	tried := make(map[*replicaSource]bool, len(p.sources))
+	var errs []error
+	for {
+		src := p.pick(tried)
+		if src == nil {
+			return errors.Join(errs...)
+		}
+		tried[src] = true
+		start := time.Now()
+		attemptCtx, stop := p.watchStall(ctx)
+		n, err := fn(attemptCtx, src)
+		stalled := stop()
+		if err == nil {
+			p.succeed(src, n, time.Since(start))
+			return nil
+		}
+		if stalled {
+			err = fmt.Errorf("%w after %s: %w", errReplicaStalled, p.stallTimeout, err)
+		}
+		if ctx.Err() != nil || !isReplicaFault(err) {
+			return err
+		}
+		p.fail(src)
+		errs = append(errs, fmt.Errorf("replica %s: %w", src.addr, err))
+		var noRetry *replicaNoRetryError
+		if errors.As(err, &noRetry) {
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// isReplicaFault reports whether err says something about the server that
+// produced it, rather than about the file or the local output.
+func isReplicaFault(err error) bool {
+	var changed *FileChangedError
+	return !errors.Is(err, ErrFileMissing) && !errors.As(err, &changed)
+}
+
+// replicaNoRetryError is a replica failure whose request cannot be moved,
+// because its output could not be rewound.
+type replicaNoRetryError struct {
+	err error
+}
+
+func (e *replicaNoRetryError) Error() string {
+	return e.err.Error() + " (output cannot be rewound for failover)"
+}
+
+func (e *replicaNoRetryError) Unwrap() error {
+	return e.err
+}
+
+// watchStall returns a context that is cancelled when no frame finishes for
+// the stall timeout. stop ends the watch and reports whether it fired.
+func (p *replicaPool) watchStall(ctx context.Context) (context.Context, func() bool) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	watch := &stallWatch{}
+	watch.last.Store(time.Now().UnixNano())
+	if observer := frameObserverFromContext(ctx); observer != nil {
+		ctx = withFrameObserver(ctx, frameObservers{observer, watch})
+	} else {
+		ctx = withFrameObserver(ctx, watch)
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(max(p.stallTimeout/4, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if now.Sub(time.Unix(0, watch.last.Load())) > p.stallTimeout {
+					cancel(errReplicaStalled)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() bool {
+		close(done)
+		stalled := errors.Is(context.Cause(ctx), errReplicaStalled)
+		cancel(nil)
+		return stalled
+	}
+}
+
+type stallWatch struct {
+	last atomic.Int64 // unix ns
+}
+
+func (w *stallWatch) ObserveFrame(int64, int64, int64) {
+	w.last.Store(time.Now().UnixNano())
+}
+
+// downloadSequential downloads a multi-file batch from one source, moving
+// the whole batch to another source on failure. Files are re-requested from
+// their planned offsets, since nothing is acknowledged until the batch ends.
+func (p *replicaPool) downloadSequential(ctx context.Context, req DownloadBatchRequest, plans []downloadBatchPlan, targets []FetchFileTarget) (DownloadBatchResponse, error) {
+	var resp DownloadBatchResponse
+	err := p.run(ctx, func(ctx context.Context, src *replicaSource) (int64, error) {
+		srcReq := req
+		srcReq.Manifest = src.manifest
+		srcReq.replicas = nil
+		out, err := src.client.downloadManifestBatchSequential(ctx, srcReq, plans, targets)
+		if err != nil {
+			return 0, err
+		}
+		resp = out
+		var bytes int64
+		for _, file := range out.Files {
+			bytes += file.Meta.WireSize
+		}
+		return bytes, nil
+	})
+	return resp, err
+}
+
+// downloadWindow downloads one split window, moving it to another source on
+// failure. A window that owns the file's first writer rewinds it before a
+// retry; other windows reopen their output at the window offset.
+func (p *replicaPool) downloadWindow(
+	ctx context.Context,
+	req DownloadBatchRequest,
+	plan downloadBatchPlan,
+	window splitWindow,
+	writer io.WriteCloser,
+	syncOutput func() error,
+	emitProgressUpdate func(DownloadProgressUpdate),
+) (splitWindowResult, error) {
+	var rw *rewindWriter
+	if writer != nil {
+		rw = &rewindWriter{w: writer}
+	}
+	var result splitWindowResult
+	err := p.run(ctx, func(ctx context.Context, src *replicaSource) (int64, error) {
+		srcReq := req
+		srcReq.Manifest = src.manifest
+		var w io.WriteCloser
+		if rw != nil {
+			w = rw
+		}
+		out, err := src.client.downloadSplitWindow(ctx, srcReq, plan, window, w, syncOutput, emitProgressUpdate)
+		if err != nil {
+			if rw != nil && !rw.rewind() {
+				return 0, &replicaNoRetryError{err: err}
+			}
+			return 0, err
+		}
+		out.source = src
+		result = out
+		return out.response.Meta.WireSize, nil
+	})
+	if rw != nil {
+		if closeErr := rw.w.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("close output for file %d: %w", plan.entry.ID, closeErr)
+		}
+	}
+	return result, err
+}
+
+// rewindWriter defers Close so a failed window can be retried on the same
+// output, seeking back over whatever the failed attempt wrote.
+type rewindWriter struct {
+	w       io.WriteCloser
+	written int64
+}
+
+func (r *rewindWriter) Write(p []byte) (int, error) {
+	n, err := r.w.Write(p)
+	r.written += int64(n)
+	return n, err
+}
+
+func (r *rewindWriter) Close() error { return nil }
+
+func (r *rewindWriter) rewind() bool {
+	if r.written == 0 {
+		return true
+	}
+	seeker, ok := r.w.(io.Seeker)
+	if !ok {
+		return false
+	}
+	if _, err := seeker.Seek(-r.written, io.SeekCurrent); err != nil {
+		return false
+	}
+	r.written = 0
+	return true
+}
@@ -0,0 +1,198 @@
+package filexfer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryJitter         = 0.2
+)
+
+// RetryPolicy is how StartFromManifest retries a failed batch. A retry
+// resumes each file from the last offset the server acknowledged, so only
+// unacknowledged bytes are sent again. Zero fields take their defaults.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a batch is tried in all; 1 disables
+	// retries. Default 3.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry; each later retry
+	// doubles it up to MaxBackoff. Defaults 500ms and 30s.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction of each wait that is randomized, in [0, 1].
+	// Default 0.2; a negative value disables jitter.
+	Jitter float64
+	// Retryable decides which errors are retried; nil uses IsRetryable.
+	Retryable func(error) bool
+}
+
+// WithRetryPolicy sets how StartFromManifest retries failed batches.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return clientOptionFunc(func(c *Client) {
+		c.Retry = policy
+	})
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	switch {
+	case p.Jitter == 0:
+		p.Jitter = defaultRetryJitter
+	case p.Jitter < 0:
+		p.Jitter = 0
+	case p.Jitter > 1:
+		p.Jitter = 1
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+	return p
+}
+
+// backoff is the wait before retry number attempt (1-based), with up to
+// Jitter of it drawn from random, a source of [0, 1).
+func (p RetryPolicy) backoff(attempt int, random func() float64) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, p.MaxBackoff)
+	if p.Jitter > 0 {
+		wait -= time.Duration(p.Jitter * random() * float64(wait))
+	}
+	return wait
+}
+
+// IsRetryable reports whether a failed batch is worth trying again.
+// Connection failures (net.Error, resets, refused or broken connections) and
+// streams cut short (io.EOF, io.ErrUnexpectedEOF) are, as are the server's
+// INTERNAL and TIMEOUT codes and 5xx statuses. Everything else fails fast:
+// missing and changed files, cancellation, other server codes, and local
+// sink errors such as a full disk or a permission denied on the output,
+// which a retry would only hit again.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrFileMissing) || errors.Is(err, ErrFileChanged) {
+		return false
+	}
+	var controlErr controlFrameError
+	if errors.As(err, &controlErr) {
+		code := strings.ToUpper(controlErr.Code)
+		return code == "INTERNAL" || code == "TIMEOUT" || strings.HasPrefix(code, "HTTP_5")
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// syscall.Errno satisfies net.Error too, so an errno decides on its
+	// own: a disk or permission errno from the sink is not a network one.
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE,
+			syscall.ETIMEDOUT, syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// BatchRetry describes a failed batch StartFromManifest is about to retry.
+type BatchRetry struct {
+	FileIDs []uint64
+	// Attempt is the attempt that failed, from 1.
+	Attempt int
+	Delay   time.Duration
+	Err     error
+}
+
+// resumeOffsets remembers the highest acknowledged offset of each file in
+// a batch, so a retry starts there rather than at the manifest's offset.
+type resumeOffsets struct {
+	mu      sync.Mutex
+	offsets map[uint64]int64
+}
+
+func newResumeOffsets() *resumeOffsets {
+	return &resumeOffsets{offsets: make(map[uint64]int64)}
+}
+
+func (r *resumeOffsets) observe(fileID uint64, ackBytes int64) {
+	if r == nil || ackBytes <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ackBytes > r.offsets[fileID] {
+		r.offsets[fileID] = ackBytes
+	}
+}
+
+func (r *resumeOffsets) offset(fileID uint64) int64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.offsets[fileID]
+}
+
+// sleepContext waits for d or until ctx is done, reporting whether the
+// full wait elapsed.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// downloadBatchWithRetry runs DownloadFilesFromManifestBatch under the
+// client's retry policy, reporting each retry to onRetry.
+func (c *Client) downloadBatchWithRetry(ctx context.Context, batchReq DownloadBatchRequest, onRetry func(BatchRetry), retries *atomic.Int64) (DownloadBatchResponse, error) {
+	policy := c.Retry.withDefaults()
+	batchReq.resume = newResumeOffsets()
+	for attempt := 1; ; attempt++ {
+		resp, err := c.DownloadFilesFromManifestBatch(ctx, batchReq)
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !policy.Retryable(err) {
+			return resp, err
+		}
+		delay := policy.backoff(attempt, rand.Float64)
+		if onRetry != nil {
+			onRetry(BatchRetry{FileIDs: batchReq.FileIDs, Attempt: attempt, Delay: delay, Err: err})
+		}
+		if !sleepContext(ctx, delay) {
+			return resp, err
+		}
+		retries.Add(1)
+	}
+}
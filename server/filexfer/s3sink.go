@@ -0,0 +1,420 @@
+package filexfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jolynch/pinch/internal/filexfer/s3"
+)
+
+type S3SinkConfig struct {
+	// URL is the destination, s3://bucket/prefix. Each file is stored at
+	// prefix/<manifest path>.
+	URL string
+	// Endpoint is the S3-compatible service URL; empty means AWS.
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	HTTPClient      *http.Client
+	// PartSize is the multipart part size. It must equal the BatchMaxBytes
+	// StartFromManifest splits files with, so each window is one part, and
+	// be at least 5 MiB.
+	PartSize int64
+	// SaveState persists a file's resume token (ManifestProgress.Sink). It
+	// is called after each part upload, before the part's bytes are
+	// acknowledged.
+	SaveState func(fileID uint64, state string) error
+}
+
+// S3Sink uploads downloaded files to an S3-compatible bucket. A file that
+// fits in one part is sent with a single PutObject. Larger files become
+// multipart uploads with one part per split window; part ETags are saved
+// through SaveState so a resumed download completes the same upload. An
+// upload abandoned by a changed source file is aborted.
+type S3Sink struct {
+	client    *s3.Client
+	bucket    string
+	prefix    string
+	partSize  int64
+	saveState func(uint64, string) error
+
+	mu      sync.Mutex
+	uploads map[uint64]*s3Upload
+}
+
+var _ Sink = (*S3Sink)(nil)
+
+type s3Upload struct {
+	mu    sync.Mutex
+	key   string
+	size  int64
+	mtime int64
+	id    string
+	etags map[int]string
+}
+
+func NewS3Sink(cfg S3SinkConfig) (*S3Sink, error) {
+	bucket, prefix, err := s3.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PartSize < s3.MinPartSize {
+		return nil, fmt.Errorf("s3 part size %d is below the %d byte minimum", cfg.PartSize, s3.MinPartSize)
+	}
+	client, err := s3.New(s3.Config{
+		Endpoint:        cfg.Endpoint,
+		Region:          cfg.Region,
+		AccessKeyID:     cfg.AccessKeyID,
+		SecretAccessKey: cfg.SecretAccessKey,
+		SessionToken:    cfg.SessionToken,
+		HTTPClient:      cfg.HTTPClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Sink{
+		client:    client,
+		bucket:    bucket,
+		prefix:    prefix,
+		partSize:  cfg.PartSize,
+		saveState: cfg.SaveState,
+		uploads:   make(map[uint64]*s3Upload),
+	}, nil
+}
+
+func (s *S3Sink) key(entry ManifestEntry) string {
+	if s.prefix == "" {
+		return entry.Path
+	}
+	return path.Join(s.prefix, entry.Path)
+}
+
+// Location is the s3:// URL entry is uploaded to.
+func (s *S3Sink) Location(entry ManifestEntry) string {
+	return "s3://" + s.bucket + "/" + s.key(entry)
+}
+
+// Resumable reports whether entry's saved progress can continue: its token
+// names a multipart upload for the same source size, mtime and part size.
+func (s *S3Sink) Resumable(entry ManifestEntry) bool {
+	_, ok := s.parseState(entry)
+	return ok
+}
+
+func (s *S3Sink) numParts(size int64) int {
+	return int(max(1, (size+s.partSize-1)/s.partSize))
+}
+
+func (s *S3Sink) OpenWindow(entry ManifestEntry, offset int64) (io.WriteCloser, func() error, error) {
+	if offset == 0 && entry.Size <= s.partSize {
+		w := &s3PartWriter{sink: s, key: s.key(entry), fileID: entry.ID, size: entry.Size}
+		w.begin()
+		return w, w.sync, nil
+	}
+	if offset%s.partSize != 0 {
+		return nil, nil, fmt.Errorf("s3 sink: offset %d is not aligned to the %d byte part size", offset, s.partSize)
+	}
+	if parts := s.numParts(entry.Size); parts > s3.MaxParts {
+		return nil, nil, fmt.Errorf("s3 sink: file needs %d parts, more than the %d S3 allows; raise the batch size", parts, s3.MaxParts)
+	}
+	up, err := s.upload(entry)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := &s3PartWriter{
+		sink:   s,
+		up:     up,
+		key:    up.key,
+		fileID: entry.ID,
+		part:   int(offset/s.partSize) + 1,
+		start:  offset,
+		size:   min(s.partSize, entry.Size-offset),
+	}
+	w.begin()
+	return w, w.sync, nil
+}
+
+// upload returns entry's multipart upload, resuming the one in its saved
+// token or creating one on first use.
+func (s *S3Sink) upload(entry ManifestEntry) (*s3Upload, error) {
+	s.mu.Lock()
+	up := s.uploads[entry.ID]
+	var stale *s3Upload
+	if up == nil || up.size != entry.Size || up.mtime != entry.Mtime {
+		stale = up
+		up = &s3Upload{key: s.key(entry), size: entry.Size, mtime: entry.Mtime, etags: make(map[int]string)}
+		if saved, ok := s.parseState(entry); ok {
+			up = saved
+		}
+		s.uploads[entry.ID] = up
+	}
+	s.mu.Unlock()
+	if stale != nil {
+		s.abort(stale)
+	}
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	if up.id != "" {
+		return up, nil
+	}
+	id, err := s.client.CreateMultipartUpload(context.Background(), s.bucket, up.key)
+	if err != nil {
+		return nil, fmt.Errorf("s3 sink: create multipart upload for %s: %w", up.key, err)
+	}
+	up.id = id
+	if err := s.saveLocked(entry.ID, up); err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+func (s *S3Sink) abort(up *s3Upload) {
+	up.mu.Lock()
+	id := up.id
+	up.mu.Unlock()
+	if id != "" {
+		_ = s.client.AbortMultipartUpload(context.Background(), s.bucket, up.key, id)
+	}
+}
+
+// recordPart saves a finished part's ETag; the caller acks the part's
+// bytes only after the token is persisted.
+func (s *S3Sink) recordPart(fileID uint64, up *s3Upload, part int, etag string) error {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	up.etags[part] = strings.Trim(etag, `"`)
+	return s.saveLocked(fileID, up)
+}
+
+func (s *S3Sink) saveLocked(fileID uint64, up *s3Upload) error {
+	if s.saveState == nil {
+		return nil
+	}
+	if err := s.saveState(fileID, s.encodeState(up)); err != nil {
+		return fmt.Errorf("s3 sink: save upload state: %w", err)
+	}
+	return nil
+}
+
+// FinishFile completes entry's multipart upload from its recorded parts.
+// A completion that an earlier run already made, lost before it was
+// recorded, is accepted when the object has the expected size.
+func (s *S3Sink) FinishFile(ctx context.Context, entry ManifestEntry) error {
+	s.mu.Lock()
+	up := s.uploads[entry.ID]
+	s.mu.Unlock()
+	if up == nil || up.size != entry.Size || up.mtime != entry.Mtime {
+		up, _ = s.parseState(entry)
+	}
+	if up == nil {
+		if entry.Size <= s.partSize {
+			// Sent with a single PutObject; nothing to complete.
+			return nil
+		}
+		return fmt.Errorf("s3 sink: no multipart upload recorded for %s", s.key(entry))
+	}
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	numParts := s.numParts(entry.Size)
+	parts := make([]s3.Part, 0, numParts)
+	for part := 1; part <= numParts; part++ {
+		etag, ok := up.etags[part]
+		if !ok {
+			return fmt.Errorf("s3 sink: part %d of %d for %s was never uploaded", part, numParts, up.key)
+		}
+		parts = append(parts, s3.Part{Number: part, ETag: `"` + etag + `"`})
+	}
+	err := s.client.CompleteMultipartUpload(ctx, s.bucket, up.key, up.id, parts)
+	if s3.IsCode(err, "NoSuchUpload") {
+		if info, headErr := s.client.HeadObject(ctx, s.bucket, up.key); headErr == nil && info.Size == entry.Size {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("s3 sink: complete upload for %s: %w", up.key, err)
+	}
+	s.mu.Lock()
+	if s.uploads[entry.ID] == up {
+		delete(s.uploads, entry.ID)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// encodeState renders an upload as a whitespace-free token for the
+// .progress file.
+func (s *S3Sink) encodeState(up *s3Upload) string {
+	numbers := make([]int, 0, len(up.etags))
+	for part := range up.etags {
+		numbers = append(numbers, part)
+	}
+	slices.Sort(numbers)
+	parts := make([]string, 0, len(numbers))
+	for _, part := range numbers {
+		parts = append(parts, strconv.Itoa(part)+":"+up.etags[part])
+	}
+	values := url.Values{}
+	values.Set("upload", up.id)
+	values.Set("part-size", strconv.FormatInt(s.partSize, 10))
+	values.Set("size", strconv.FormatInt(up.size, 10))
+	values.Set("mtime", strconv.FormatInt(up.mtime, 10))
+	values.Set("parts", strings.Join(parts, ","))
+	return values.Encode()
+}
+
+// parseState decodes entry's saved token, rejecting one made for another
+// version of the file or another part size.
+func (s *S3Sink) parseState(entry ManifestEntry) (*s3Upload, bool) {
+	if entry.Progress.Sink == "" {
+		return nil, false
+	}
+	values, err := url.ParseQuery(entry.Progress.Sink)
+	if err != nil || values.Get("upload") == "" {
+		return nil, false
+	}
+	if values.Get("part-size") != strconv.FormatInt(s.partSize, 10) ||
+		values.Get("size") != strconv.FormatInt(entry.Size, 10) ||
+		values.Get("mtime") != strconv.FormatInt(entry.Mtime, 10) {
+		return nil, false
+	}
+	up := &s3Upload{key: s.key(entry), size: entry.Size, mtime: entry.Mtime, id: values.Get("upload"), etags: make(map[int]string)}
+	if raw := values.Get("parts"); raw != "" {
+		for _, item := range strings.Split(raw, ",") {
+			number, etag, ok := strings.Cut(item, ":")
+			part, err := strconv.Atoi(number)
+			if !ok || err != nil || part < 1 || etag == "" {
+				return nil, false
+			}
+			up.etags[part] = etag
+		}
+	}
+	return up, true
+}
+
+var errS3WriterClosed = errors.New("s3 sink: writer closed before sync")
+
+type s3PartResult struct {
+	etag string
+	err  error
+}
+
+// s3PartWriter streams one window into a PutObject or UploadPart request
+// through a pipe. sync ends the request and records the part; a Seek back
+// to the window start restarts it, for replica failover.
+type s3PartWriter struct {
+	sink   *S3Sink
+	up     *s3Upload
+	key    string
+	fileID uint64
+	// part is the 1-based part number; 0 sends the file with PutObject.
+	part    int
+	start   int64
+	size    int64
+	written int64
+
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+	done   chan s3PartResult
+	result *s3PartResult
+}
+
+func (w *s3PartWriter) begin() {
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	done := make(chan s3PartResult, 1)
+	w.pw, w.cancel, w.done, w.result, w.written = pw, cancel, done, nil, 0
+	go func() {
+		var res s3PartResult
+		if w.part == 0 {
+			res.etag, res.err = w.sink.client.PutObject(ctx, w.sink.bucket, w.key, pr, w.size)
+		} else {
+			res.etag, res.err = w.sink.client.UploadPart(ctx, w.sink.bucket, w.key, w.up.id, w.part, pr, w.size)
+		}
+		if res.err != nil {
+			_ = pr.CloseWithError(res.err)
+		} else {
+			_ = pr.Close()
+		}
+		done <- res
+	}()
+}
+
+// wait ends the request, if it is still running, and returns its result.
+func (w *s3PartWriter) wait() s3PartResult {
+	if w.result == nil {
+		res := <-w.done
+		w.cancel()
+		w.result = &res
+	}
+	return *w.result
+}
+
+func (w *s3PartWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) > w.size {
+		return 0, fmt.Errorf("s3 sink: write past the end of part %d", w.part)
+	}
+	n, err := w.pw.Write(p)
+	w.written += int64(n)
+	if err != nil {
+		if res := w.wait(); res.err != nil {
+			return n, res.err
+		}
+	}
+	return n, err
+}
+
+func (w *s3PartWriter) Seek(offset int64, whence int) (int64, error) {
+	target := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		target = w.start + w.written + offset
+	default:
+		return 0, fmt.Errorf("s3 sink: unsupported seek whence %d", whence)
+	}
+	if target != w.start {
+		return 0, fmt.Errorf("s3 sink: can only rewind to the window start %d, not %d", w.start, target)
+	}
+	w.cancel()
+	_ = w.pw.CloseWithError(errS3WriterClosed)
+	w.wait()
+	w.begin()
+	return w.start, nil
+}
+
+func (w *s3PartWriter) sync() error {
+	if w.written != w.size {
+		return fmt.Errorf("s3 sink: part %d has %d of %d bytes", w.part, w.written, w.size)
+	}
+	_ = w.pw.Close()
+	res := w.wait()
+	if res.err != nil {
+		return res.err
+	}
+	if w.up == nil {
+		return nil
+	}
+	return w.sink.recordPart(w.fileID, w.up, w.part, res.etag)
+}
+
+func (w *s3PartWriter) Close() error {
+	if w.result == nil {
+		w.cancel()
+		_ = w.pw.CloseWithError(errS3WriterClosed)
+		w.wait()
+	}
+	return nil
+}
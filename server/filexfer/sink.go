@@ -0,0 +1,20 @@
+package filexfer
+
+import (
+	"context"
+	"io"
+)
+
+// Sink is where StartFromManifest puts downloaded files. It generalizes the
+// OutputWriter callback with a per-file completion step for destinations,
+// such as object stores, that only publish a file once all of it is there.
+type Sink interface {
+	// OpenWindow returns a writer for entry's bytes starting at offset and
+	// a sync func. Split files open one writer per window; sync is called
+	// once the window is fully written, before its bytes are acknowledged,
+	// so anything it persists survives a resume.
+	OpenWindow(entry ManifestEntry, offset int64) (io.WriteCloser, func() error, error)
+	// FinishFile is called once every byte of entry has been written,
+	// synced and acknowledged. It is not called for failed files.
+	FinishFile(ctx context.Context, entry ManifestEntry) error
+}
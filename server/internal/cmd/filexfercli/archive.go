@@ -0,0 +1,116 @@
+package filexfercli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/jolynch/pinch/filexfer"
+	"github.com/jolynch/pinch/internal/filexfer/encoding"
+)
+
+const (
+	startFormatTree   = "tree"
+	startFormatTar    = "tar"
+	startFormatTarZst = "tar.zst"
+)
+
+func resolveStartFormat(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", startFormatTree:
+		return startFormatTree, nil
+	case startFormatTar:
+		return startFormatTar, nil
+	case startFormatTarZst:
+		return startFormatTarZst, nil
+	default:
+		return "", fmt.Errorf("unsupported value %q (supported: tree, tar, tar.zst)", raw)
+	}
+}
+
+// startTreeOnlyFlags are start flags that only apply to tree downloads;
+// --format tar|tar.zst rejects them.
+var startTreeOnlyFlags = map[string]bool{
+	"out-root":          true,
+	"preserve":          true,
+	"map-owner-by-name": true,
+	"sparse":            true,
+	"no-sync":           true,
+	"replica":           true,
+	"replica-stall":     true,
+	"order":             true,
+	"concurrency":       true,
+	"adaptive":          true,
+	"max-concurrency":   true,
+	"consistency":       true,
+	"comp":              true,
+	"a":                 true,
+	"ack-every":         true,
+	"b":                 true,
+	"batch-size":        true,
+	"retry-attempts":    true,
+	"retry-backoff":     true,
+	"retry-failed":      true,
+}
+
+// runStartArchive pulls the whole transfer as one server-built tar stream
+// instead of a tree. With output on stdout, progress lines go to stderr so
+// the archive can be piped.
+func runStartArchive(client *Client, manifest *Manifest, format string, loadStrategy string, enc encryptionOptions, outPath string, stdout io.Writer, stderr io.Writer) int {
+	comp := ArchiveCompNone
+	if format == startFormatTarZst {
+		comp = ArchiveCompZstd
+	}
+	report := stdout
+	var out io.Writer = stdout
+	var outFile *os.File
+	if outPath == "" || outPath == "-" {
+		outPath = "stdout"
+		report = stderr
+	} else {
+		f, err := os.Create(outPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "create archive failed: %v\n", err)
+			return 1
+		}
+		outFile = f
+		out = f
+	}
+
+	start := time.Now()
+	resp, err := client.ArchiveTransfer(context.Background(), ArchiveTransferRequest{
+		TransferID:   manifest.TransferID,
+		Comp:         comp,
+		Mode:         loadStrategy,
+		AgePublicKey: enc.AgePublicKey,
+		AgeIdentity:  enc.AgeIdentity,
+	}, out)
+	if outFile != nil {
+		if closeErr := outFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(outPath)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "start failed: %v\n", err)
+		return 1
+	}
+	elapsed := time.Since(start)
+	fmt.Fprintf(
+		report,
+		"start-archive: tid=%s format=%s files=%d bytes=%s elapsed=%s rate=%s out=%s\n",
+		manifest.TransferID,
+		format,
+		len(manifest.Entries),
+		encoding.HumanBytes(resp.WireBytes),
+		elapsed.Round(time.Millisecond),
+		encoding.HumanRate(float64(resp.WireBytes)/max(elapsed.Seconds(), 1e-9)),
+		outPath,
+	)
+	return 0
+}
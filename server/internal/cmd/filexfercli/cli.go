@@ -3,12 +3,15 @@ package filexfercli
 import (
 	"bufio"
 	"context"
+	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"os"
+	"os/user"
 	"path/filepath"
 	"slices"
 	"sort"
@@ -20,10 +23,12 @@ import (
 
 	"runtime/trace"
 
-	"filippo.io/age"
 	. "github.com/jolynch/pinch/filexfer"
 	"github.com/jolynch/pinch/internal/filexfer/encoding"
+	"github.com/jolynch/pinch/internal/filexfer/source"
+	"github.com/jolynch/pinch/tracing"
 	"github.com/jolynch/pinch/utils"
+	"golang.org/x/sys/unix"
 )
 
 func startTracing(path string, stderr io.Writer) (stop func()) {
@@ -46,11 +51,19 @@ func startTracing(path string, stderr io.Writer) (stop func()) {
 	}
 }
 
+// traceExportEnv names the OTLP/HTTP endpoint or file that CLI spans are
+// exported to; the trace context is forwarded to the server on each command.
+const traceExportEnv = "PINCH_TRACE_EXPORT"
+
 const defaultVerboseStatusInterval = 10 * time.Second
 const defaultCLIAckEveryBytes int64 = 128 * 1024 * 1024
 const defaultVerboseProgressInterval = 2 * time.Second
 const defaultCLIProbeBytes int64 = 1 * 1024 * 1024
 
+// maxProgressLineBytes bounds one .progress line, which for an S3 upload
+// carries up to 10,000 part ETags.
+const maxProgressLineBytes = 1024 * 1024
+
 type synchronizedWriter struct {
 	mu *sync.Mutex
 	w  io.Writer
@@ -63,6 +76,32 @@ func (sw *synchronizedWriter) Write(p []byte) (int, error) {
 }
 
 func RunCLI(args []string, stdout io.Writer, stderr io.Writer) int {
+	outputRaw, args, err := splitGlobalOutput(args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		printCLIUsage(stderr)
+		return 2
+	}
+	outputFormat, err := resolveOutputFormat(outputRaw)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --output: %v\n", err)
+		return 2
+	}
+	if len(args) > 0 {
+		switch args[0] {
+		case "keygen", "list":
+			if outputFormat != outputText {
+				fmt.Fprintf(stderr, "--output %s is not supported by %s\n", outputFormat, args[0])
+				return 2
+			}
+		}
+		switch args[0] {
+		case "keygen":
+			return runKeygenCLI(args[1:], stdout, stderr)
+		case "list":
+			return runListCLI(args[1:], stdout, stderr)
+		}
+	}
 	if len(args) < 2 {
 		printCLIUsage(stderr)
 		return 2
@@ -75,6 +114,29 @@ func RunCLI(args []string, stdout io.Writer, stderr io.Writer) int {
 	}
 	cmd := args[1]
 	cmdArgs := args[2:]
+	if outputRaw != "" {
+		switch cmd {
+		case "transfer", "start", "status", "get":
+			// A later --output on the command itself wins.
+			cmdArgs = append([]string{"--output=" + outputRaw}, cmdArgs...)
+		default:
+			if outputFormat != outputText {
+				fmt.Fprintf(stderr, "--output %s is not supported by %s\n", outputFormat, cmd)
+				return 2
+			}
+		}
+	}
+
+	stopSpans, err := tracing.Setup("pinch-cli", os.Getenv(traceExportEnv))
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid $%s: %v\n", traceExportEnv, err)
+		return 2
+	}
+	defer func() {
+		if err := stopSpans(); err != nil {
+			fmt.Fprintf(stderr, "trace export: %v\n", err)
+		}
+	}()
 
 	switch cmd {
 	case "transfer":
@@ -83,8 +145,18 @@ func RunCLI(args []string, stdout io.Writer, stderr io.Writer) int {
 		return runStartCLI(serverURL, cmdArgs, stdout, stderr)
 	case "status":
 		return runStatusCLI(serverURL, cmdArgs, stdout, stderr)
+	case "cancel", "pause", "resume", "renew":
+		return runControlCLI(serverURL, cmd, cmdArgs, stdout, stderr)
 	case "get":
 		return runGetCLI(serverURL, cmdArgs, stdout, stderr)
+	case "mirror":
+		return runMirrorCLI(serverURL, cmdArgs, stdout, stderr)
+	case "follow":
+		return runFollowCLI(serverURL, cmdArgs, stdout, stderr)
+	case "mount":
+		return runMountCLI(serverURL, cmdArgs, stdout, stderr)
+	case "trust":
+		return runTrustCLI(serverURL, cmdArgs, stdout, stderr)
 	default:
 		fmt.Fprintf(stderr, "unknown cli command: %s\n", cmd)
 		printCLIUsage(stderr)
@@ -109,26 +181,20 @@ func validateServerURL(raw string) error {
 
 func printCLIUsage(w io.Writer) {
 	fmt.Fprintln(w, "usage:")
-	fmt.Fprintln(w, "  pinch cli <file-listener> transfer -s <abs> [--source-directory <abs>] [-o <manifest-path>] [--encrypt age] [--load-strategy fast|gentle] [--probe-bytes <size>] [-v|--verbose] [--max-manifest-chunk-size N]")
-	fmt.Fprintln(w, "  pinch cli <file-listener> start [--tid <id>] [--manifest <path>] [--out-root <dir>] [--encrypt age] [--concurrency N] [-a|--ack-every <size>] [--batch-size <size>] [--no-sync] [-v|--verbose]")
+	fmt.Fprintln(w, "  pinch cli [--output text|json|ndjson] <file-listener> <command> ...")
+	fmt.Fprintln(w, "  pinch cli <file-listener> transfer -s <abs> [--source-directory <abs>] [-o <manifest-path>] [--encrypt age] [--load-strategy fast|gentle] [--probe-bytes <size>] [-v|--verbose] [--max-manifest-chunk-size N] [--ttl <duration>]")
+	fmt.Fprintln(w, "  pinch cli <file-listener> start [--tid <id>] [--manifest <path>] [--out-root <dir>|s3://<bucket>/<prefix> | --format tar|tar.zst [-o <path>|-]] [--encrypt age] [--concurrency N] [--adaptive[=false]] [--max-concurrency N] [--order <policy>[,priority=<glob>...]] [--replica <file-listener>]... [--replica-stall <duration>] [--preserve mode,owner,times,xattrs] [--map-owner-by-name] [--sparse=false] [--consistency strict|warn|ignore] [-a|--ack-every <size>] [--batch-size <size>] [--no-sync] [--retry-attempts N] [--retry-backoff <duration>] [--retry-failed] [--dashboard auto|tty|lines|off] [-v|--verbose]")
 	fmt.Fprintln(w, "  pinch cli <file-listener> status --tid <id>")
-	fmt.Fprintln(w, "  pinch cli <file-listener> get [--tid <id>] --fd <uint64> [--manifest <path>] [--out-root <dir>] [-o <path|->] [--encrypt age] [--load-strategy fast|gentle] [-a|--ack-every <size>] [--batch-size <size>] [--no-sync] [-v|--verbose]")
-}
-
-func resolveEncryptionOptions(mode string) (string, string, error) {
-	mode = strings.ToLower(strings.TrimSpace(mode))
-	switch mode {
-	case "", "none":
-		return "", "", nil
-	case "age":
-		identity, err := age.GenerateX25519Identity()
-		if err != nil {
-			return "", "", fmt.Errorf("generate age identity: %w", err)
-		}
-		return identity.Recipient().String(), identity.String(), nil
-	default:
-		return "", "", fmt.Errorf("unsupported --encrypt value %q (only \"age\" is supported)", mode)
-	}
+	fmt.Fprintln(w, "  pinch cli <file-listener> cancel|pause|resume|renew --tid <id>")
+	fmt.Fprintln(w, "  pinch cli <file-listener> get [--tid <id>] --fd <uint64> [--manifest <path>] [--out-root <dir>] [-o <path|->] [--encrypt age] [--load-strategy fast|gentle] [--preserve mode,owner,times,xattrs] [--map-owner-by-name] [--sparse=false] [--consistency strict|warn|ignore] [-a|--ack-every <size>] [--batch-size <size>] [--no-sync] [-v|--verbose]")
+	fmt.Fprintln(w, "  pinch cli <file-listener> mirror [--tid <id>] [--manifest <path>] --out-root <dir> [--encrypt age] [--checksum] [--quarantine <dir>] [--dry-run] [-- <start flags>]")
+	fmt.Fprintln(w, "  pinch cli <file-listener> follow [--tid <id>] [--manifest <path>] [--out-root <dir>|s3://<bucket>/<prefix>] [--encrypt age] [--settle <duration>] [-- <start flags>]")
+	fmt.Fprintln(w, "  pinch cli <file-listener> mount [--tid <id>] [--manifest <path>] [--encrypt age] [--block-size <size>] [--cache <size>] [<manifest>] <mountpoint>")
+	fmt.Fprintln(w, "  pinch cli <file-listener> trust [--key <age-public-key>] [--identity <name>] [--replace]")
+	fmt.Fprintln(w, "  pinch cli keygen [--name <name>] [--force]")
+	fmt.Fprintln(w, "  pinch cli list")
+	fmt.Fprintln(w, "keys are stored in $"+configDirEnv+" (default: <user-config-dir>/pinch); --encrypt defaults to age when the server key is trusted")
+	fmt.Fprintln(w, "set $"+traceExportEnv+" to an OTLP/HTTP endpoint (http://host:4318) or a file path to export spans")
 }
 
 func resolveLoadStrategy(raw string) (string, error) {
@@ -142,6 +208,21 @@ func resolveLoadStrategy(raw string) (string, error) {
 	}
 }
 
+// maxChangedFileRequeues bounds how many times start retries files that
+// changed on the server under --consistency strict.
+const maxChangedFileRequeues = 3
+
+func resolveConsistency(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "":
+		return "", nil
+	case ConsistencyStrict, ConsistencyWarn, ConsistencyIgnore:
+		return strings.ToLower(strings.TrimSpace(raw)), nil
+	default:
+		return "", fmt.Errorf("unsupported --consistency value %q (supported: strict, warn, ignore)", raw)
+	}
+}
+
 func resolveComp(raw string) (string, error) {
 	switch strings.ToLower(strings.TrimSpace(raw)) {
 	case "":
@@ -153,6 +234,55 @@ func resolveComp(raw string) (string, error) {
 	}
 }
 
+// preserveOptions selects which trailer metadata is restored onto downloaded
+// files.
+type preserveOptions struct {
+	Mode   bool
+	Owner  bool
+	Times  bool
+	Xattrs bool
+	// OwnerByName maps ownership through the trailer user/group names on the
+	// local system, falling back to the numeric ids when a name is unknown.
+	OwnerByName bool
+}
+
+const defaultPreserve = "mode,owner,times"
+
+func resolvePreserve(raw string, ownerByName bool) (preserveOptions, error) {
+	opts := preserveOptions{OwnerByName: ownerByName}
+	for _, part := range strings.Split(raw, ",") {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "", "none":
+		case "mode":
+			opts.Mode = true
+		case "owner":
+			opts.Owner = true
+		case "times":
+			opts.Times = true
+		case "xattrs":
+			opts.Xattrs = true
+		case "all":
+			opts.Mode, opts.Owner, opts.Times, opts.Xattrs = true, true, true, true
+		default:
+			return preserveOptions{}, fmt.Errorf("unsupported --preserve value %q (supported: mode, owner, times, xattrs, all, none)", part)
+		}
+	}
+	return opts, nil
+}
+
+// clientOption asks the server for the extended trailer metadata these
+// options need to restore.
+func (p preserveOptions) clientOption() ClientOption {
+	var keys []string
+	if p.Times {
+		keys = append(keys, FileMetadataAtime)
+	}
+	if p.Xattrs {
+		keys = append(keys, FileMetadataXattrs)
+	}
+	return WithFileMetadata(keys...)
+}
+
 func runTransferCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writer) int {
 	fs := flag.NewFlagSet("transfer", flag.ContinueOnError)
 	fs.SetOutput(stderr)
@@ -163,6 +293,8 @@ func runTransferCLI(serverURL string, args []string, stdout io.Writer, stderr io
 	var probeBytesRaw string
 	var verbose bool
 	var maxChunk int
+	var ttl time.Duration
+	var outputRaw string
 	fs.StringVar(&sourceDir, "s", "", "absolute source directory to transfer")
 	fs.StringVar(&sourceDir, "source-directory", "", "absolute source directory to transfer")
 	fs.StringVar(&manifestOut, "o", "", "output path for saved manifest")
@@ -173,9 +305,21 @@ func runTransferCLI(serverURL string, args []string, stdout io.Writer, stderr io
 	fs.BoolVar(&verbose, "v", false, "disable front-coding")
 	fs.BoolVar(&verbose, "verbose", false, "disable front-coding")
 	fs.IntVar(&maxChunk, "max-manifest-chunk-size", 0, "max chunk bytes for manifest stream")
+	fs.DurationVar(&ttl, "ttl", 0, "keep the transfer this long after its last SEND, ACK or renew (0=server default)")
+	fs.StringVar(&outputRaw, "output", outputText, "result format: text|json|ndjson")
 	if err := fs.Parse(args); err != nil {
 		return 2
 	}
+	outputFormat, err := resolveOutputFormat(outputRaw)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --output: %v\n", err)
+		return 2
+	}
+	if outputFormat != outputText && manifestOut == "-" {
+		fmt.Fprintf(stderr, "--output %s cannot write the manifest to stdout; use -o <path>\n", outputFormat)
+		return 2
+	}
+	out := newCLIOutput(outputFormat, "transfer", stdout)
 	if sourceDir == "" {
 		fmt.Fprintln(stderr, "transfer requires --source-directory (or -s)")
 		return 2
@@ -184,6 +328,10 @@ func runTransferCLI(serverURL string, args []string, stdout io.Writer, stderr io
 		fmt.Fprintln(stderr, "--max-manifest-chunk-size must be >= 0")
 		return 2
 	}
+	if ttl < 0 {
+		fmt.Fprintln(stderr, "--ttl must be >= 0")
+		return 2
+	}
 	probeBytes, err := encoding.ParseByteSize(probeBytesRaw)
 	if err != nil {
 		fmt.Fprintf(stderr, "invalid --probe-bytes: %v\n", err)
@@ -198,13 +346,14 @@ func runTransferCLI(serverURL string, args []string, stdout io.Writer, stderr io
 		fmt.Fprintf(stderr, "invalid --load-strategy: %v\n", err)
 		return 2
 	}
-	agePublicKey, ageIdentity, err := resolveEncryptionOptions(encryptMode)
+	enc, err := resolveEncryptionOptions(serverURL, encryptMode)
 	if err != nil {
 		fmt.Fprintf(stderr, "invalid --encrypt: %v\n", err)
 		return 2
 	}
+	agePublicKey, ageIdentity := enc.AgePublicKey, enc.AgeIdentity
 
-	client := NewClient(serverURL, WithLoadStrategy(loadStrategy))
+	client := newCLIClient(serverURL, enc, WithLoadStrategy(loadStrategy))
 	start := time.Now()
 	probeResult, err := client.ProbeLink(context.Background(), ProbeRequest{
 		Samples:      3,
@@ -214,18 +363,50 @@ func runTransferCLI(serverURL string, args []string, stdout io.Writer, stderr io
 		AgeIdentity:  ageIdentity,
 	})
 	if err != nil {
-		fmt.Fprintf(stderr, "probe failed: %v\n", err)
-		return 1
+		return out.fail(stderr, "probe failed: %v", err)
 	}
-	fmt.Fprintf(
-		stdout,
-		"transfer-probe: strategy=%s server_cpu=%d avg_ms=%d est_link=%dMbps concurrency=%d\n",
-		loadStrategy,
-		probeResult.ServerCPU,
-		probeResult.AvgLatencyMS,
-		probeResult.LinkMbps,
-		probeResult.SuggestedConcurrency,
-	)
+	if announced := probeResult.ServerAgePublicKey; strings.ToLower(strings.TrimSpace(encryptMode)) != "none" && announced != "" {
+		// The probe is the first connection a workflow makes to a server,
+		// so its key is pinned here and later commands encrypt to it.
+		pin, err := pinServerKeyOnFirstUse(serverURL, announced, probeResult.ServerAgePublicKeys, client.ServerAgePublicKey)
+		if err != nil {
+			return out.fail(stderr, "transfer failed: %v", err)
+		}
+		switch pin {
+		case serverKeyPinned:
+			fmt.Fprintf(stderr, "pinned server key: addr=%s key=%s (first use)\n", serverURL, announced)
+		case serverKeyRotated:
+			fmt.Fprintf(stderr, "pinned server key: addr=%s old=%s key=%s (rotated)\n", serverURL, enc.ServerAgePublicKey, announced)
+		}
+		if pin != serverKeyUnchanged {
+			if enc, err = resolveEncryptionOptions(serverURL, encryptMode); err != nil {
+				return out.fail(stderr, "transfer failed: %v", err)
+			}
+			agePublicKey, ageIdentity = enc.AgePublicKey, enc.AgeIdentity
+			client = newCLIClient(serverURL, enc, WithLoadStrategy(loadStrategy))
+		}
+	}
+	warnUnencrypted(stderr, serverURL, encryptMode, enc)
+	if out.text() {
+		fmt.Fprintf(
+			stdout,
+			"transfer-probe: strategy=%s server_cpu=%d avg_ms=%d est_link=%dMbps concurrency=%d\n",
+			loadStrategy,
+			probeResult.ServerCPU,
+			probeResult.AvgLatencyMS,
+			probeResult.LinkMbps,
+			probeResult.SuggestedConcurrency,
+		)
+	}
+	out.update(func(r *outputResult) {
+		r.Probe = &outputProbe{
+			Strategy:    loadStrategy,
+			ServerCPU:   probeResult.ServerCPU,
+			AvgMS:       probeResult.AvgLatencyMS,
+			LinkMbps:    probeResult.LinkMbps,
+			Concurrency: probeResult.SuggestedConcurrency,
+		}
+	})
 	manifestResp, err := client.FetchManifest(context.Background(), FetchManifestRequest{
 		Directory:    sourceDir,
 		Verbose:      verbose,
@@ -233,79 +414,114 @@ func runTransferCLI(serverURL string, args []string, stdout io.Writer, stderr io
 		Mode:         loadStrategy,
 		LinkMbps:     probeResult.LinkMbps,
 		Concurrency:  probeResult.SuggestedConcurrency,
+		TTL:          ttl,
+		Sparse:       true,
 		AgePublicKey: agePublicKey,
 		AgeIdentity:  ageIdentity,
 	})
 	if err != nil {
-		fmt.Fprintf(stderr, "transfer failed: %v\n", err)
-		return 1
+		return out.fail(stderr, "transfer failed: %v", err)
 	}
 	manifest := manifestResp.Manifest
 
-	var total int64
+	var total, allocated int64
 	for _, e := range manifest.Entries {
 		total += e.Size
+		allocated += e.AllocatedSize()
+	}
+	if manifestOut == "" && !out.text() {
+		// stdout carries the result, so the manifest goes where start
+		// looks for it by default.
+		manifestOut = manifest.TransferID + ".fm2"
 	}
 	if manifestOut == "" || manifestOut == "-" {
 		manifestBytes, err := MarshalManifest(manifest)
 		if err != nil {
-			fmt.Fprintf(stderr, "encode manifest failed: %v\n", err)
-			return 1
+			return out.fail(stderr, "encode manifest failed: %v", err)
 		}
 		if _, err := stdout.Write(manifestBytes); err != nil {
-			fmt.Fprintf(stderr, "write manifest failed: %v\n", err)
-			return 1
+			return out.fail(stderr, "write manifest failed: %v", err)
 		}
 		fmt.Fprintf(
 			stderr,
-			"transfer loaded: tid=%s files=%d total_size=%d root=%s elapsed=%s manifest=stdout\n",
+			"transfer loaded: tid=%s files=%d total_size=%d allocated_size=%d root=%s elapsed=%s manifest=stdout\n",
 			manifest.TransferID,
 			len(manifest.Entries),
 			total,
+			allocated,
 			manifest.Root,
 			time.Since(start).Round(time.Millisecond),
 		)
 		return 0
 	}
 	if err := SaveManifest(manifestOut, manifest); err != nil {
-		fmt.Fprintf(stderr, "save manifest failed: %v\n", err)
-		return 1
+		return out.fail(stderr, "save manifest failed: %v", err)
 	}
-	fmt.Fprintf(
-		stdout,
-		"transfer loaded: tid=%s files=%d total_size=%d root=%s elapsed=%s manifest=%s\n",
-		manifest.TransferID,
-		len(manifest.Entries),
-		total,
-		manifest.Root,
-		time.Since(start).Round(time.Millisecond),
-		manifestOut,
-	)
-	return 0
+	elapsed := time.Since(start)
+	if out.text() {
+		fmt.Fprintf(
+			stdout,
+			"transfer loaded: tid=%s files=%d total_size=%d allocated_size=%d root=%s elapsed=%s manifest=%s\n",
+			manifest.TransferID,
+			len(manifest.Entries),
+			total,
+			allocated,
+			manifest.Root,
+			elapsed.Round(time.Millisecond),
+			manifestOut,
+		)
+	}
+	out.update(func(r *outputResult) {
+		r.Transfer = &outputTransfer{
+			TransferID:    manifest.TransferID,
+			Root:          manifest.Root,
+			Files:         len(manifest.Entries),
+			TotalSize:     total,
+			AllocatedSize: allocated,
+			Manifest:      manifestOut,
+			ElapsedMS:     elapsed.Milliseconds(),
+		}
+	})
+	return out.finish(0)
 }
 
 func runStatusCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writer) int {
 	fs := flag.NewFlagSet("status", flag.ContinueOnError)
 	fs.SetOutput(stderr)
 	var txferID string
+	var outputRaw string
 	fs.StringVar(&txferID, "tid", "", "transfer id")
+	fs.StringVar(&outputRaw, "output", outputText, "result format: text|json|ndjson")
 	if err := fs.Parse(args); err != nil {
 		return 2
 	}
+	outputFormat, err := resolveOutputFormat(outputRaw)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --output: %v\n", err)
+		return 2
+	}
 	if txferID == "" {
 		fmt.Fprintln(stderr, "status requires --tid")
 		return 2
 	}
 
-	client := NewClient(serverURL)
+	out := newCLIOutput(outputFormat, "status", stdout)
+	enc, err := resolveEncryptionOptions(serverURL, "")
+	if err != nil {
+		return out.fail(stderr, "status failed: %v", err)
+	}
+	client := newCLIClient(serverURL, enc)
 	statusResp, err := client.GetTransferStatus(context.Background(), GetTransferStatusRequest{
 		TransferID: txferID,
 	})
 	if err != nil {
-		fmt.Fprintf(stderr, "status failed: %v\n", err)
-		return 1
+		return out.fail(stderr, "status failed: %v", err)
 	}
 	status := statusResp.Status
+	if !out.text() {
+		out.update(func(r *outputResult) { r.Status = status })
+		return out.finish(0)
+	}
 
 	fmt.Fprintf(stdout, "transfer=%s files=%d done=%d done_size=%d total_size=%d\n", status.TransferID, status.NumFiles, status.Done, status.DoneSize, status.TotalSize)
 	fmt.Fprintf(stdout, "complete: files=%.2f%% bytes=%.2f%%\n", status.PercentFiles, status.PercentBytes)
@@ -317,9 +533,112 @@ func runStatusCLI(serverURL string, args []string, stdout io.Writer, stderr io.W
 		status.DownloadStatus.Done,
 		status.DownloadStatus.Missing,
 	)
+	fmt.Fprintln(stdout, formatStatusLink(status))
+	if status.ExpiresAtMS > 0 {
+		fmt.Fprintf(
+			stdout,
+			"lease: ttl=%s expires_in=%s\n",
+			time.Duration(status.TTLMS)*time.Millisecond,
+			time.Until(time.UnixMilli(status.ExpiresAtMS)).Round(time.Second),
+		)
+	}
+	if status.Paused {
+		fmt.Fprintln(stdout, "state: paused")
+	}
+	return 0
+}
+
+// runControlCLI runs cancel, pause, resume or renew against one transfer.
+func runControlCLI(serverURL string, cmd string, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var txferID string
+	fs.StringVar(&txferID, "tid", "", "transfer id")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if txferID == "" {
+		fmt.Fprintf(stderr, "%s requires --tid\n", cmd)
+		return 2
+	}
+
+	enc, err := resolveEncryptionOptions(serverURL, "")
+	if err != nil {
+		fmt.Fprintf(stderr, "%s failed: %v\n", cmd, err)
+		return 1
+	}
+	client := newCLIClient(serverURL, enc)
+	ctx := context.Background()
+	var state string
+	switch cmd {
+	case "cancel":
+		err, state = client.CancelTransfer(ctx, txferID), "cancelled"
+	case "pause":
+		err, state = client.PauseTransfer(ctx, txferID), "paused"
+	case "resume":
+		err, state = client.ResumeTransfer(ctx, txferID), "resumed"
+	default:
+		var expiresAt time.Time
+		expiresAt, err = client.RenewTransfer(ctx, txferID)
+		state = "renewed expires=" + expiresAt.UTC().Format(time.RFC3339)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s failed: %v\n", cmd, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "transfer=%s %s\n", txferID, state)
 	return 0
 }
 
+const (
+	// minTransferRenewInterval bounds how often keepTransferRenewed renews
+	// however short the lease.
+	minTransferRenewInterval = 100 * time.Millisecond
+	// transferRenewRetry is how soon a failed renewal is retried.
+	transferRenewRetry = 5 * time.Second
+)
+
+// keepTransferRenewed renews the transfer's lease every third of its TTL
+// until ctx is done, for commands such as follow and mount that hold a
+// transfer open while sending and acking nothing.
+func keepTransferRenewed(ctx context.Context, client *Client, txferID string, stderr io.Writer) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		expiresAt, err := client.RenewTransfer(ctx, txferID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Fprintf(stderr, "renew failed: %v\n", err)
+			timer.Reset(transferRenewRetry)
+			continue
+		}
+		timer.Reset(max(time.Until(expiresAt)/3, minTransferRenewInterval))
+	}
+}
+
+// formatStatusLink renders the server's current link hint for a transfer.
+func formatStatusLink(status *TransferStatus) string {
+	source := status.LinkSource
+	if source == "" {
+		source = "none"
+	}
+	line := fmt.Sprintf("link: mbps=%d source=%s", status.LinkMbps, source)
+	if status.LinkRTTMS > 0 {
+		line += fmt.Sprintf(" rtt=%dms", status.LinkRTTMS)
+	}
+	if status.LinkAgeMS > 0 {
+		line += fmt.Sprintf(" age=%s", (time.Duration(status.LinkAgeMS) * time.Millisecond).Round(time.Second))
+	}
+	return line
+}
+
 func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writer) int {
 	fs := flag.NewFlagSet("get", flag.ContinueOnError)
 	fs.SetOutput(stderr)
@@ -331,11 +650,16 @@ func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writ
 	var encryptMode string
 	var loadStrategyRaw string
 	var compRaw string
+	var consistencyRaw string
+	var preserveRaw string
+	var mapOwnerByName bool
+	var sparse bool
 	var ackEveryRaw string
 	var batchSizeRaw string
 	var noSync bool
 	var verbose bool
 	var traceFile string
+	var outputRaw string
 	fs.StringVar(&txferID, "tid", "", "transfer id")
 	fs.StringVar(&manifestPath, "manifest", "", "path to manifest file (default: <tid>.fm2)")
 	fs.StringVar(&fileIDRaw, "fd", "", "file id to download")
@@ -344,6 +668,10 @@ func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writ
 	fs.StringVar(&encryptMode, "encrypt", "", "response encryption mode (supported: age)")
 	fs.StringVar(&loadStrategyRaw, "load-strategy", LoadStrategyFast, "server load strategy (fast|gentle)")
 	fs.StringVar(&compRaw, "comp", "", "compression algorithm: adapt|none|lz4|zstd (default: adapt)")
+	fs.StringVar(&consistencyRaw, "consistency", "", "files changed since transfer: strict|warn|ignore (default: warn)")
+	fs.StringVar(&preserveRaw, "preserve", defaultPreserve, "metadata to restore: mode,owner,times,xattrs (or all|none)")
+	fs.BoolVar(&mapOwnerByName, "map-owner-by-name", false, "restore ownership by user/group name instead of numeric id")
+	fs.BoolVar(&sparse, "sparse", true, "request holes in sparse files as hole frames and keep them sparse on disk")
 	fs.BoolVar(&verbose, "v", false, "verbose progress output")
 	fs.BoolVar(&verbose, "verbose", false, "verbose progress output")
 	ackEveryRaw = encoding.HumanBytes(defaultCLIAckEveryBytes)
@@ -353,9 +681,20 @@ func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writ
 	fs.StringVar(&batchSizeRaw, "batch-size", ackEveryRaw, "parallel batch size, unit of work per concurrent request")
 	fs.BoolVar(&noSync, "no-sync", false, "ack without disk sync")
 	fs.StringVar(&traceFile, "trace", "", "write runtime/trace output to this file")
+	fs.StringVar(&outputRaw, "output", outputText, "result format: text|json|ndjson")
 	if err := fs.Parse(args); err != nil {
 		return 2
 	}
+	outputFormat, err := resolveOutputFormat(outputRaw)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --output: %v\n", err)
+		return 2
+	}
+	if outputFormat != outputText && outFile == "-" {
+		fmt.Fprintf(stderr, "--output %s cannot be combined with -o -\n", outputFormat)
+		return 2
+	}
+	out := newCLIOutput(outputFormat, "get", stdout)
 	stopTracing := startTracing(traceFile, stderr)
 	defer stopTracing()
 	if fileIDRaw == "" {
@@ -380,11 +719,13 @@ func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writ
 		fmt.Fprintln(stderr, "--batch-size must be > 0")
 		return 2
 	}
-	agePublicKey, ageIdentity, err := resolveEncryptionOptions(encryptMode)
+	enc, err := resolveEncryptionOptions(serverURL, encryptMode)
 	if err != nil {
 		fmt.Fprintf(stderr, "invalid --encrypt: %v\n", err)
 		return 2
 	}
+	warnUnencrypted(stderr, serverURL, encryptMode, enc)
+	agePublicKey, ageIdentity := enc.AgePublicKey, enc.AgeIdentity
 	loadStrategy, err := resolveLoadStrategy(loadStrategyRaw)
 	if err != nil {
 		fmt.Fprintf(stderr, "invalid --load-strategy: %v\n", err)
@@ -395,6 +736,16 @@ func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writ
 		fmt.Fprintf(stderr, "invalid --comp: %v\n", err)
 		return 2
 	}
+	consistency, err := resolveConsistency(consistencyRaw)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --consistency: %v\n", err)
+		return 2
+	}
+	preserve, err := resolvePreserve(preserveRaw, mapOwnerByName)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --preserve: %v\n", err)
+		return 2
+	}
 	fileID, err := parseFileID(fileIDRaw)
 	if err != nil {
 		fmt.Fprintf(stderr, "invalid --fd: %v\n", err)
@@ -402,15 +753,13 @@ func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writ
 	}
 	manifest, resolvedManifestPath, resolvedTxferID, err := loadManifestForGet(txferID, manifestPath)
 	if err != nil {
-		fmt.Fprintf(stderr, "load manifest failed: %v\n", err)
-		return 1
+		return out.fail(stderr, "load manifest failed: %v", err)
 	}
 	txferID = resolvedTxferID
 	progressPath := resolvedManifestPath + ".progress"
 	progressState, err := loadProgressState(progressPath)
 	if err != nil {
-		fmt.Fprintf(stderr, "load progress failed: %v\n", err)
-		return 1
+		return out.fail(stderr, "load progress failed: %v", err)
 	}
 	applyProgressStateToManifest(manifest, progressState)
 	progressUpdates := make(chan DownloadProgressUpdate, 128)
@@ -421,37 +770,37 @@ func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writ
 	}
 	forwardProgress := func(update DownloadProgressUpdate) {
 		applyProgressUpdateToManifest(manifest, update)
+		out.progress(update)
 		if onProgressUpdate != nil {
 			onProgressUpdate(update)
 		}
 	}
-	stopProgress, markMetadataDonePersisted := startProgressWriter(progressPath, progressState, progressUpdates, forwardProgress, stderr)
+	stopProgress, markMetadataDonePersisted, _, _ := startProgressWriter(progressPath, progressState, progressUpdates, forwardProgress, stderr)
 	markMetadataDone := func(fileID uint64) {
 		markManifestEntryMetadataDone(manifest, fileID)
 		markMetadataDonePersisted(fileID)
 	}
 	defer stopProgress()
 
-	client := NewClient(serverURL, WithLoadStrategy(loadStrategy), WithComp(comp))
+	client := newCLIClient(serverURL, enc, WithLoadStrategy(loadStrategy), WithComp(comp), preserve.clientOption(), WithSparse(sparse), WithConsistency(consistency))
 	start := time.Now()
 	entry, ok := manifest.EntryByID(fileID)
 	if !ok {
-		fmt.Fprintf(stderr, "get failed: file id %d not in manifest\n", fileID)
-		return 1
+		return out.fail(stderr, "get failed: file id %d not in manifest", fileID)
 	}
 	progress := entry.Progress
 	if progress.AckBytes >= entry.Size {
 		if !progress.MetadataDone {
-			if err := refreshCompletedFileMetadata(context.Background(), client, manifest, fileID, outRoot, outFile, agePublicKey, ageIdentity); err != nil {
-				fmt.Fprintf(stderr, "get metadata refresh failed: %v\n", err)
-				return 1
+			if err := refreshCompletedFileMetadata(context.Background(), client, manifest, fileID, outRoot, outFile, agePublicKey, ageIdentity, preserve); err != nil {
+				return out.fail(stderr, "get metadata refresh failed: %v", err)
 			}
 			markMetadataDone(fileID)
 			fmt.Fprintf(stderr, "get metadata refreshed: fd=%d\n", fileID)
-			return 0
+		} else {
+			fmt.Fprintf(stderr, "get skipped: already complete fd=%d ack=%d\n", fileID, progress.AckBytes)
 		}
-		fmt.Fprintf(stderr, "get skipped: already complete fd=%d ack=%d\n", fileID, progress.AckBytes)
-		return 0
+		out.update(func(r *outputResult) { r.Skipped = []uint64{fileID} })
+		return out.finish(0)
 	}
 	outputPath := resolveDownloadDestinationPath(entry, outRoot, outFile)
 	downloadBatchResp, err := client.DownloadFilesFromManifestBatch(context.Background(), DownloadBatchRequest{
@@ -460,7 +809,7 @@ func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writ
 		BatchMaxBytes: batchSize,
 		OutputWriter: func(entry ManifestEntry, offset int64) (io.WriteCloser, func() error, error) {
 			destPath := resolveDownloadDestinationPath(entry, outRoot, outFile)
-			return openDownloadOutput(entry, offset, destPath, stdout, noSync)
+			return openDownloadOutput(entry, offset, destPath, stdout, noSync, sparse)
 		},
 		AgePublicKey:    agePublicKey,
 		AgeIdentity:     ageIdentity,
@@ -468,21 +817,21 @@ func runGetCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writ
 	})
 	elapsed := time.Since(start)
 	if err != nil {
-		fmt.Fprintf(stderr, "get failed: %v\n", err)
-		return 1
+		return out.fail(stderr, "get failed: %v", err)
 	}
 	if len(downloadBatchResp.Files) != 1 {
-		fmt.Fprintf(stderr, "get failed: expected one downloaded file, got %d\n", len(downloadBatchResp.Files))
-		return 1
+		return out.fail(stderr, "get failed: expected one downloaded file, got %d", len(downloadBatchResp.Files))
 	}
 	downloadResp := downloadBatchResp.Files[0]
-	if err := applyDownloadedTrailerMetadata(outputPath, downloadResp.Meta.TrailerMetadata); err != nil {
-		fmt.Fprintf(stderr, "get failed: %v\n", err)
-		return 1
+	if err := applyDownloadedTrailerMetadata(outputPath, downloadResp.Meta.TrailerMetadata, preserve); err != nil {
+		return out.fail(stderr, "get failed: %v", err)
 	}
 	markMetadataDone(fileID)
-	printFileMetrics(stdout, manifest.TransferID, fileID, outputPath, downloadResp.Meta, downloadResp.LocalFileHash, elapsed)
-	return 0
+	if out.text() {
+		printFileMetrics(stdout, manifest.TransferID, fileID, outputPath, downloadResp.Meta, downloadResp.LocalFileHash, elapsed)
+	}
+	out.file(newOutputFile(manifest.TransferID, fileID, outputPath, downloadResp.Meta, downloadResp.LocalFileHash, downloadResp.WindowChecksumPassed, downloadResp.WindowChecksumTotal, elapsed))
+	return out.finish(0)
 }
 
 func runStartCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writer) int {
@@ -500,39 +849,136 @@ func runStartCLI(serverURL string, args []string, stdout io.Writer, stderr io.Wr
 	var ackEveryRaw string
 	var batchSizeRaw string
 	var compRaw string
+	var consistencyRaw string
+	var orderRaw string
+	var adaptive bool
+	var maxConcurrency int
+	var preserveRaw string
+	var mapOwnerByName bool
+	var sparse bool
 	var noSync bool
 	var verbose bool
+	var replicaAddrs stringListFlag
+	var replicaStall time.Duration
+	var formatRaw string
+	var archiveOut string
+	var outputRaw string
+	var dashboardRaw string
+	var retryAttempts int
+	var retryBackoff time.Duration
+	var retryFailed bool
 	fs.StringVar(&txferID, "tid", "", "transfer id")
 	fs.StringVar(&manifestPath, "manifest", "", "path to manifest file (default: <tid>.fm2)")
-	fs.StringVar(&outRoot, "out-root", ".", "output root")
+	fs.StringVar(&outRoot, "out-root", ".", "output root directory, or s3://bucket/prefix to upload")
+	fs.StringVar(&formatRaw, "format", startFormatTree, "output format: tree|tar|tar.zst")
+	fs.StringVar(&archiveOut, "o", "-", "archive output path for --format tar|tar.zst, or '-' for stdout")
+	fs.Var(&replicaAddrs, "replica", "file listener of a replica server with the same tree (repeatable)")
+	fs.DurationVar(&replicaStall, "replica-stall", 0, "move a request off a server with no frame for this long (0=30s)")
 	fs.StringVar(&encryptMode, "encrypt", "", "response encryption mode (supported: age)")
 	fs.BoolVar(&verbose, "v", false, "verbose progress output")
 	fs.BoolVar(&verbose, "verbose", false, "verbose progress output")
 	fs.IntVar(&concurrency, "concurrency", 0, "parallel download workers (0=manifest default)")
+	fs.BoolVar(&adaptive, "adaptive", true, "grow and shrink workers based on measured goodput (default off when --concurrency is set)")
+	fs.IntVar(&maxConcurrency, "max-concurrency", 0, "upper bound for --adaptive workers (0=4x starting concurrency)")
+	fs.StringVar(&orderRaw, "order", OrderManifest, "download order: manifest|smallest-first|largest-first|mix, plus priority=<glob> terms (comma-separated)")
 	ackEveryRaw = encoding.HumanBytes(defaultCLIAckEveryBytes)
 	fs.StringVar(&ackEveryRaw, "a", ackEveryRaw, "bytes between progress acks")
 	fs.StringVar(&ackEveryRaw, "ack-every", ackEveryRaw, "bytes between progress acks")
 	fs.StringVar(&batchSizeRaw, "b", ackEveryRaw, "parallel batch size, unit of work per concurrent request")
 	fs.StringVar(&batchSizeRaw, "batch-size", ackEveryRaw, "parallel batch size, unit of work per concurrent request")
 	fs.StringVar(&compRaw, "comp", "", "compression algorithm: adapt|none|lz4|zstd (default: adapt)")
+	fs.StringVar(&consistencyRaw, "consistency", "", "files changed since transfer: strict|warn|ignore (default: warn)")
+	fs.StringVar(&preserveRaw, "preserve", defaultPreserve, "metadata to restore: mode,owner,times,xattrs (or all|none)")
+	fs.BoolVar(&mapOwnerByName, "map-owner-by-name", false, "restore ownership by user/group name instead of numeric id")
+	fs.BoolVar(&sparse, "sparse", true, "request holes in sparse files as hole frames and keep them sparse on disk")
 	fs.BoolVar(&noSync, "no-sync", false, "ack without fdatasync")
 	var traceFile string
 	fs.StringVar(&traceFile, "trace", "", "write runtime/trace output to this file")
+	fs.StringVar(&outputRaw, "output", outputText, "result format: text|json|ndjson")
+	fs.IntVar(&retryAttempts, "retry-attempts", 3, "tries per batch for connection errors and server INTERNAL/TIMEOUT (1 disables retries)")
+	fs.DurationVar(&retryBackoff, "retry-backoff", 500*time.Millisecond, "wait before the first retry, doubling up to 30s, with jitter")
+	fs.BoolVar(&retryFailed, "retry-failed", false, "download only the files the last run recorded as failed in the .progress file")
+	fs.StringVar(&dashboardRaw, "dashboard", dashboardAuto, "progress display on stderr: auto|tty|lines|off (auto: tty on a terminal, else lines)")
 	if err := fs.Parse(args); err != nil {
 		return 2
 	}
+	outputFormat, err := resolveOutputFormat(outputRaw)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --output: %v\n", err)
+		return 2
+	}
+	dashboardMode, err := resolveDashboardMode(dashboardRaw, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --dashboard: %v\n", err)
+		return 2
+	}
 	stopTracing := startTracing(traceFile, stderr)
 	defer stopTracing()
 	concurrencyExplicit := false
+	adaptiveExplicit := false
 	fs.Visit(func(f *flag.Flag) {
-		if f.Name == "concurrency" {
+		switch f.Name {
+		case "concurrency":
 			concurrencyExplicit = true
+		case "adaptive", "max-concurrency":
+			adaptiveExplicit = true
 		}
 	})
 	if concurrencyExplicit && concurrency <= 0 {
 		fmt.Fprintln(stderr, "--concurrency must be > 0")
 		return 2
 	}
+	if concurrencyExplicit && !adaptiveExplicit {
+		// An explicit worker count is a cap the caller chose; only grow
+		// past it when they also ask for --adaptive or --max-concurrency.
+		adaptive = false
+	}
+	if maxConcurrency < 0 {
+		fmt.Fprintln(stderr, "--max-concurrency must be >= 0")
+		return 2
+	}
+	if replicaStall < 0 {
+		fmt.Fprintln(stderr, "--replica-stall must be >= 0")
+		return 2
+	}
+	if retryAttempts <= 0 {
+		fmt.Fprintln(stderr, "--retry-attempts must be > 0")
+		return 2
+	}
+	if retryBackoff <= 0 {
+		fmt.Fprintln(stderr, "--retry-backoff must be > 0")
+		return 2
+	}
+	format, err := resolveStartFormat(formatRaw)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --format: %v\n", err)
+		return 2
+	}
+	if format != startFormatTree && outputFormat != outputText {
+		fmt.Fprintf(stderr, "--output %s is not supported with --format %s\n", outputFormat, format)
+		return 2
+	}
+	if format != startFormatTree {
+		// The server builds the archive in one stream; flags that shape
+		// a per-file tree download would be silently ignored.
+		var unsupported []string
+		fs.Visit(func(f *flag.Flag) {
+			if startTreeOnlyFlags[f.Name] {
+				unsupported = append(unsupported, "--"+f.Name)
+			}
+		})
+		if len(unsupported) > 0 {
+			fmt.Fprintf(stderr, "%s not supported with --format %s\n", strings.Join(unsupported, ", "), format)
+			return 2
+		}
+	}
+	if format != startFormatTree {
+		dashboardMode = dashboardOff
+	}
+	// Everything printed during the run goes above the dashboard.
+	dashboard := newStartDashboard(dashboardMode, stderr)
+	stdout, stderr = dashboard.wrap(stdout), dashboard.wrap(stderr)
+	out := newCLIOutput(outputFormat, "start", stdout)
 	ackEvery, err := encoding.ParseByteSize(ackEveryRaw)
 	if err != nil {
 		fmt.Fprintf(stderr, "invalid --ack-every: %v\n", err)
@@ -551,30 +997,60 @@ func runStartCLI(serverURL string, args []string, stdout io.Writer, stderr io.Wr
 		fmt.Fprintln(stderr, "--batch-size must be > 0")
 		return 2
 	}
-	agePublicKey, ageIdentity, err := resolveEncryptionOptions(encryptMode)
+	order, err := ParseTransferOrder(orderRaw)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --order: %v\n", err)
+		return 2
+	}
+	enc, err := resolveEncryptionOptions(serverURL, encryptMode)
 	if err != nil {
 		fmt.Fprintf(stderr, "invalid --encrypt: %v\n", err)
 		return 2
 	}
+	warnUnencrypted(stderr, serverURL, encryptMode, enc)
+	agePublicKey, ageIdentity := enc.AgePublicKey, enc.AgeIdentity
+	replicaOpts := make([]ClientOption, 0, len(replicaAddrs))
+	for _, addr := range replicaAddrs {
+		replicaEnc, err := resolveEncryptionOptions(addr, encryptMode)
+		if err != nil {
+			fmt.Fprintf(stderr, "invalid --replica %s: %v\n", addr, err)
+			return 2
+		}
+		replicaOpts = append(replicaOpts, WithReplica(addr, replicaEnc.ServerAgePublicKey))
+	}
+	if replicaStall > 0 {
+		replicaOpts = append(replicaOpts, WithReplicaStallTimeout(replicaStall))
+	}
 	manifest, resolvedManifestPath, resolvedTxferID, err := loadManifestForStart(txferID, manifestPath)
 	if err != nil {
-		fmt.Fprintf(stderr, "load manifest failed: %v\n", err)
-		return 1
+		return out.fail(stderr, "load manifest failed: %v", err)
 	}
 	loadStrategy, err := resolveLoadStrategy(manifest.Mode)
 	if err != nil {
-		fmt.Fprintf(stderr, "load manifest failed: invalid manifest mode %q\n", manifest.Mode)
-		return 1
+		return out.fail(stderr, "load manifest failed: invalid manifest mode %q", manifest.Mode)
+	}
+	if format != startFormatTree {
+		client := newCLIClient(serverURL, enc)
+		return runStartArchive(client, manifest, format, loadStrategy, enc, archiveOut, stdout, stderr)
 	}
 	comp, err := resolveComp(compRaw)
 	if err != nil {
 		fmt.Fprintf(stderr, "invalid --comp: %v\n", err)
 		return 2
 	}
+	consistency, err := resolveConsistency(consistencyRaw)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --consistency: %v\n", err)
+		return 2
+	}
+	preserve, err := resolvePreserve(preserveRaw, mapOwnerByName)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --preserve: %v\n", err)
+		return 2
+	}
 	manifestConcurrency := manifest.Concurrency
 	if manifestConcurrency <= 0 {
-		fmt.Fprintf(stderr, "load manifest failed: invalid manifest concurrency %d\n", manifestConcurrency)
-		return 1
+		return out.fail(stderr, "load manifest failed: invalid manifest concurrency %d", manifestConcurrency)
 	}
 	effectiveConcurrency := manifestConcurrency
 	if concurrencyExplicit {
@@ -584,10 +1060,23 @@ func runStartCLI(serverURL string, args []string, stdout io.Writer, stderr io.Wr
 	progressPath := resolvedManifestPath + ".progress"
 	progressState, err := loadProgressState(progressPath)
 	if err != nil {
-		fmt.Fprintf(stderr, "load progress failed: %v\n", err)
-		return 1
+		return out.fail(stderr, "load progress failed: %v", err)
 	}
 	applyProgressStateToManifest(manifest, progressState)
+	var retryIDs map[uint64]bool
+	if retryFailed {
+		ids, err := loadFailedProgressIDs(progressPath)
+		if err != nil {
+			return out.fail(stderr, "load progress failed: %v", err)
+		}
+		if len(ids) == 0 {
+			fmt.Fprintf(stderr, "start: no failed files recorded in %s\n", progressPath)
+		}
+		retryIDs = make(map[uint64]bool, len(ids))
+		for _, fileID := range ids {
+			retryIDs[fileID] = true
+		}
+	}
 	progressUpdates := make(chan DownloadProgressUpdate, 1024)
 	var onStartProgressUpdate func(DownloadProgressUpdate)
 	if verbose {
@@ -596,107 +1085,239 @@ func runStartCLI(serverURL string, args []string, stdout io.Writer, stderr io.Wr
 	}
 	forwardProgress := func(update DownloadProgressUpdate) {
 		applyProgressUpdateToManifest(manifest, update)
+		out.progress(update)
+		dashboard.update(update)
 		if onStartProgressUpdate != nil {
 			onStartProgressUpdate(update)
 		}
 	}
-	stopProgress, markMetadataDonePersisted := startProgressWriter(progressPath, progressState, progressUpdates, forwardProgress, stderr)
+	stopProgressWriter, markMetadataDonePersisted, resetProgressPersisted, saveSinkState := startProgressWriter(progressPath, progressState, progressUpdates, forwardProgress, stderr)
+	stopProgress := sync.OnceFunc(stopProgressWriter)
 	markMetadataDone := func(fileID uint64) {
 		markManifestEntryMetadataDone(manifest, fileID)
 		markMetadataDonePersisted(fileID)
 	}
 	defer stopProgress()
-	client := NewClient(serverURL, WithLoadStrategy(loadStrategy), WithComp(comp))
+	sink, err := newStartSink(outRoot, batchSize, noSync, sparse, saveSinkState)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --out-root: %v\n", err)
+		return 2
+	}
+	s3Sink, toS3 := sink.(*S3Sink)
+	clientOpts := []ClientOption{WithLoadStrategy(loadStrategy), WithComp(comp), preserve.clientOption(), WithSparse(sparse), WithConsistency(consistency)}
+	clientOpts = append(clientOpts, WithRetryPolicy(RetryPolicy{MaxAttempts: retryAttempts, InitialBackoff: retryBackoff}))
+	if adaptive {
+		clientOpts = append(clientOpts, WithAdaptiveConcurrency(0, maxConcurrency))
+	}
+	clientOpts = append(clientOpts, replicaOpts...)
+	client := newCLIClient(serverURL, enc, clientOpts...)
 	serverSendBufBytes := int64(utils.MaxSocketWriteBufferBytes())
 	if miniProbe, err := client.ProbeLink(context.Background(), ProbeRequest{Samples: 1, ProbeBytes: 1}); err == nil && miniProbe.ServerSendBufBytes > 0 {
 		serverSendBufBytes = miniProbe.ServerSendBufBytes
 	}
-	fmt.Fprintf(
-		stdout,
-		"start-plan: strategy=%s link=%dMbps concurrency=%d (manifest=%d) cli-sendbuf=%s srv-recvbuf=%s\n",
-		loadStrategy,
-		manifest.LinkMbps,
-		effectiveConcurrency,
-		manifestConcurrency,
-		encoding.HumanBytes(serverSendBufBytes),
-		encoding.HumanBytes(int64(utils.MaxSocketReadBufferBytes())),
-	)
+	if out.text() {
+		fmt.Fprintf(
+			stdout,
+			"start-plan: strategy=%s link=%dMbps concurrency=%d (manifest=%d) cli-sendbuf=%s srv-recvbuf=%s order=%s adaptive=%t\n",
+			loadStrategy,
+			manifest.LinkMbps,
+			effectiveConcurrency,
+			manifestConcurrency,
+			encoding.HumanBytes(serverSendBufBytes),
+			encoding.HumanBytes(int64(utils.MaxSocketReadBufferBytes())),
+			order,
+			adaptive,
+		)
+	}
+	var replicas []Replica
+	if len(replicaAddrs) > 0 {
+		replicas, err = client.OpenReplicas(context.Background(), OpenReplicasRequest{Manifest: manifest, AgePublicKey: agePublicKey, AgeIdentity: ageIdentity})
+		if err != nil {
+			return out.fail(stderr, "start failed: %v", err)
+		}
+		for _, replica := range replicas {
+			if out.text() {
+				fmt.Fprintf(stdout, "start-replica: addr=%s tid=%s\n", replica.Addr, replica.TransferID)
+			}
+		}
+	}
 
 	startAll := time.Now()
 	var completed int64
 	var totalTransferred int64
+	var retries int
 	var failures []error
+	failedIDs := make(map[uint64]bool)
 	var failuresMu sync.Mutex
-	recordFailure := func(err error) {
+	var link LinkEstimate
+	var replicaStats []ReplicaStats
+	compCounts := make(map[string]uint64)
+	// recordFailure notes a failed file for the summary and for a later
+	// --retry-failed; a BatchError names its own files.
+	recordFailure := func(err error, fileIDs ...uint64) {
 		if err == nil {
 			return
 		}
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			fileIDs = append(fileIDs, batchErr.FileIDs...)
+		}
 		failuresMu.Lock()
 		failures = append(failures, err)
+		for _, fileID := range fileIDs {
+			failedIDs[fileID] = true
+		}
 		failuresMu.Unlock()
+		out.addError(err)
+		dashboard.addError(err)
 	}
 	var stopStatusPolling func()
 	if verbose {
 		stopStatusPolling = startVerboseStatusPolling(txferID, client, stderr)
 		defer stopStatusPolling()
 	}
-	pendingEntries := make([]ManifestEntry, 0, len(manifest.Entries))
-	for _, entry := range manifest.Entries {
+	// queueEntry reports whether entry still needs downloading, finishing
+	// files whose bytes are all acked but whose metadata was never applied.
+	queueEntry := func(entry ManifestEntry) bool {
 		progress := entry.Progress
-		if progress.AckBytes >= entry.Size {
-			if progress.MetadataDone {
-				completed++
-				continue
+		if progress.AckBytes < entry.Size {
+			return true
+		}
+		if !progress.MetadataDone && toS3 {
+			// Every part is uploaded; only the completion may be missing.
+			if err := s3Sink.FinishFile(context.Background(), entry); err != nil {
+				recordFailure(fmt.Errorf("id=%d finish failed: %w", entry.ID, err), entry.ID)
+				return false
 			}
-			if err := refreshCompletedFileMetadata(context.Background(), client, manifest, entry.ID, outRoot, "", agePublicKey, ageIdentity); err != nil {
-				recordFailure(fmt.Errorf("id=%d metadata refresh failed: %w", entry.ID, err))
-				continue
+			markMetadataDone(entry.ID)
+		} else if !progress.MetadataDone {
+			if err := refreshCompletedFileMetadata(context.Background(), client, manifest, entry.ID, outRoot, "", agePublicKey, ageIdentity, preserve); err != nil {
+				recordFailure(fmt.Errorf("id=%d metadata refresh failed: %w", entry.ID, err), entry.ID)
+				return false
 			}
 			markMetadataDone(entry.ID)
-			completed++
+		}
+		completed++
+		return false
+	}
+	pendingEntries := make([]ManifestEntry, 0, len(manifest.Entries))
+	for i, entry := range manifest.Entries {
+		if retryIDs != nil && !retryIDs[entry.ID] {
 			continue
 		}
-		pendingEntries = append(pendingEntries, entry)
+		if toS3 && entry.Progress.AckBytes > 0 && entry.Progress.AckBytes < entry.Size && !s3Sink.Resumable(entry) {
+			// No multipart upload to add the rest of the file to.
+			resetProgressPersisted(entry.ID)
+			manifest.Entries[i].Progress = ManifestProgress{}
+			entry = manifest.Entries[i]
+		}
+		if queueEntry(entry) {
+			pendingEntries = append(pendingEntries, entry)
+		}
 	}
-	startResp, err := client.StartFromManifest(context.Background(), StartFromManifestRequest{
-		Manifest: manifest,
-		Entries:  pendingEntries,
-		OutputWriter: func(entry ManifestEntry, offset int64) (io.WriteCloser, func() error, error) {
-			destPath := resolveDownloadDestinationPath(entry, outRoot, "")
-			return openDownloadOutput(entry, offset, destPath, nil, noSync)
-		},
+	out.plan(outputPlan{
+		TransferID:          txferID,
+		Strategy:            loadStrategy,
+		LinkMbps:            manifest.LinkMbps,
+		Concurrency:         effectiveConcurrency,
+		ManifestConcurrency: manifestConcurrency,
+		Order:               order.String(),
+		Adaptive:            adaptive,
+		Files:               len(manifest.Entries),
+		Pending:             len(pendingEntries),
+	})
+	dashboard.begin(manifest, completed)
+	startReq := StartFromManifestRequest{
+		Manifest:        manifest,
+		Entries:         pendingEntries,
+		Sink:            sink,
 		AgePublicKey:    agePublicKey,
 		AgeIdentity:     ageIdentity,
 		Concurrency:     effectiveConcurrency,
 		BatchMaxBytes:   batchSize,
+		Order:           order,
+		Replicas:        replicas,
 		ProgressUpdates: progressUpdates,
+		OnRetry: func(retry BatchRetry) {
+			fmt.Fprintf(stderr, "start-retry: first-id=%d count=%d attempt=%d/%d delay=%s err=%v\n", retry.FileIDs[0], len(retry.FileIDs), retry.Attempt, retryAttempts, retry.Delay.Round(time.Millisecond), retry.Err)
+		},
+		OnConcurrencyChange: func(decision ConcurrencyDecision) {
+			if verbose {
+				fmt.Fprintf(stderr, "start-concurrency: %d->%d reason=%s goodput=%s queue-delay=%s\n", decision.From, decision.To, decision.Reason, encoding.HumanRate(decision.Goodput), decision.QueueDelay.Round(time.Millisecond))
+			}
+		},
 		OnFileDone: func(evt StartFileDoneEvent) {
 			entry, ok := manifest.EntryByID(evt.File.Meta.FileID)
 			if !ok {
-				recordFailure(fmt.Errorf("id=%d metadata apply failed: file id not in manifest", evt.File.Meta.FileID))
+				recordFailure(fmt.Errorf("id=%d metadata apply failed: file id not in manifest", evt.File.Meta.FileID), evt.File.Meta.FileID)
 				return
 			}
-			destPath := resolveDownloadDestinationPath(entry, outRoot, "")
-			if err := applyDownloadedTrailerMetadata(destPath, evt.File.Meta.TrailerMetadata); err != nil {
-				recordFailure(fmt.Errorf("id=%d metadata apply failed: %w", evt.File.Meta.FileID, err))
-				return
+			destPath := sink.Location(entry)
+			if !toS3 {
+				if err := applyDownloadedTrailerMetadata(destPath, evt.File.Meta.TrailerMetadata, preserve); err != nil {
+					recordFailure(fmt.Errorf("id=%d metadata apply failed: %w", evt.File.Meta.FileID, err), evt.File.Meta.FileID)
+					return
+				}
 			}
 			markMetadataDone(evt.File.Meta.FileID)
-			printStartFileSummary(stdout, evt.File.Meta.FileID, destPath, evt.File.Meta, evt.File.LocalFileHash, evt.File.WindowChecksumPassed, evt.File.WindowChecksumTotal, evt.Elapsed)
+			file := newOutputFile(txferID, evt.File.Meta.FileID, destPath, evt.File.Meta, evt.File.LocalFileHash, evt.File.WindowChecksumPassed, evt.File.WindowChecksumTotal, evt.Elapsed)
+			failuresMu.Lock()
+			for comp, count := range file.CompCounts {
+				compCounts[comp] += count
+			}
+			failuresMu.Unlock()
+			if out.text() {
+				printStartFileSummary(stdout, evt.File.Meta.FileID, destPath, evt.File.Meta, evt.File.LocalFileHash, evt.File.WindowChecksumPassed, evt.File.WindowChecksumTotal, evt.Elapsed)
+			}
+			out.file(file)
+			dashboard.fileDone(file)
 		},
-	})
-	if err != nil {
-		fmt.Fprintf(stderr, "start failed: %v\n", err)
-		return 1
 	}
-	completed += int64(startResp.Downloaded)
-	totalTransferred += startResp.TransferredBytes
-	for _, startErr := range startResp.Errors {
-		recordFailure(startErr)
+	for round := 0; len(startReq.Entries) > 0; round++ {
+		startResp, err := client.StartFromManifest(context.Background(), startReq)
+		if err != nil {
+			dashboard.end()
+			return out.fail(stderr, "start failed: %v", err)
+		}
+		completed += int64(startResp.Downloaded)
+		totalTransferred += startResp.TransferredBytes
+		retries += startResp.Retries
+		link = startResp.Link
+		replicaStats = mergeReplicaStats(replicaStats, startResp.Replicas)
+		startReq.Entries = nil
+		for _, startErr := range startResp.Errors {
+			var changed *FileChangedError
+			var batchErr *BatchError
+			if round >= maxChangedFileRequeues || !errors.As(startErr, &changed) || !errors.As(startErr, &batchErr) || changed.Size < 0 {
+				recordFailure(startErr)
+				continue
+			}
+			// The server re-recorded the changed file; start it over at its
+			// new size and retry the rest of the batch it took down.
+			resetProgressPersisted(changed.FileID)
+			updateManifestEntryAfterChange(manifest, changed)
+			if err := SaveManifest(resolvedManifestPath, manifest); err != nil {
+				fmt.Fprintf(stderr, "warning: save manifest %s: %v\n", resolvedManifestPath, err)
+			}
+			dashboard.reset(changed.FileID, changed.Size)
+			fmt.Fprintf(stderr, "start-requeue: fd=%d changed on server, size=%d round=%d\n", changed.FileID, changed.Size, round+1)
+			for _, fileID := range batchErr.FileIDs {
+				if entry, ok := manifest.EntryByID(fileID); ok && queueEntry(entry) {
+					startReq.Entries = append(startReq.Entries, entry)
+				}
+			}
+		}
 	}
+	dashboard.end()
 	failuresMu.Lock()
 	finalFailures := append([]error(nil), failures...)
+	finalFailedIDs := slices.Sorted(maps.Keys(failedIDs))
 	failuresMu.Unlock()
+	// Flush acked offsets first so the failed list lands on top of them.
+	stopProgress()
+	if err := saveFailedProgressIDs(progressPath, finalFailedIDs); err != nil {
+		fmt.Fprintf(stderr, "progress flush failed: %v\n", err)
+	}
 	for _, err := range finalFailures {
 		fmt.Fprintf(stderr, "start error: %v\n", err)
 	}
@@ -706,9 +1327,48 @@ func runStartCLI(serverURL string, args []string, stdout io.Writer, stderr io.Wr
 	if elapsedAll > 0 {
 		overallSpeed = float64(totalTransferred) / elapsedAll.Seconds()
 	}
+	code := 0
+	if len(finalFailures) > 0 {
+		code = 1
+	}
+	if !out.text() {
+		summary := &outputSummary{
+			TransferID:       txferID,
+			Requested:        len(manifest.Entries),
+			Downloaded:       completed,
+			Failed:           len(finalFailures),
+			TransferredBytes: totalTransferred,
+			ElapsedMS:        elapsedAll.Milliseconds(),
+			RateBps:          overallSpeed,
+			CompCounts:       compCounts,
+			Retries:          retries,
+			FailedIDs:        finalFailedIDs,
+		}
+		if link.Mbps > 0 {
+			summary.Link = &outputLink{
+				Mbps:         link.Mbps,
+				StreamMbps:   link.StreamMbps,
+				RTTMS:        link.RTT.Milliseconds(),
+				QueueDelayMS: link.QueueDelay.Milliseconds(),
+				Samples:      link.Samples,
+			}
+		}
+		for _, stats := range replicaStats {
+			summary.Replicas = append(summary.Replicas, outputReplica{
+				Addr:       stats.Addr,
+				TransferID: stats.TransferID,
+				Requests:   stats.Requests,
+				Failures:   stats.Failures,
+				Bytes:      stats.Bytes,
+				GoodputBps: stats.Goodput,
+			})
+		}
+		out.update(func(r *outputResult) { r.Summary = summary })
+		return out.finish(code)
+	}
 	fmt.Fprintf(
 		stdout,
-		"start complete: tid=%s requested=%d downloaded=%d failed=%d transferred=%s speed=%s elapsed=%s\n",
+		"start complete: tid=%s requested=%d downloaded=%d failed=%d transferred=%s speed=%s elapsed=%s retries=%d\n",
 		txferID,
 		len(manifest.Entries),
 		completed,
@@ -716,11 +1376,51 @@ func runStartCLI(serverURL string, args []string, stdout io.Writer, stderr io.Wr
 		encoding.HumanBytes(totalTransferred),
 		encoding.HumanRate(overallSpeed),
 		elapsedAll.Round(time.Millisecond),
+		retries,
 	)
-	if len(finalFailures) > 0 {
-		return 1
+	if link.Mbps > 0 {
+		fmt.Fprintf(stdout, "start-link: %s\n", link)
 	}
-	return 0
+	for _, stats := range replicaStats {
+		fmt.Fprintf(stdout, "start-source: addr=%s tid=%s requests=%d failures=%d bytes=%s goodput=%s\n", stats.Addr, stats.TransferID, stats.Requests, stats.Failures, encoding.HumanBytes(stats.Bytes), encoding.HumanRate(stats.Goodput))
+	}
+	return code
+}
+
+// mergeReplicaStats adds one requeue round's per-server counts to the
+// running totals; rounds list servers in the same order.
+func mergeReplicaStats(total []ReplicaStats, round []ReplicaStats) []ReplicaStats {
+	if len(total) == 0 {
+		return round
+	}
+	for i := range total {
+		if i >= len(round) {
+			break
+		}
+		total[i].Bytes += round[i].Bytes
+		total[i].Requests += round[i].Requests
+		total[i].Failures += round[i].Failures
+		if round[i].Goodput > 0 {
+			total[i].Goodput = round[i].Goodput
+		}
+	}
+	return total
+}
+
+// stringListFlag collects every value of a repeatable flag.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return errors.New("empty value")
+	}
+	*f = append(*f, value)
+	return nil
 }
 
 func printStartFileSummary(stdout io.Writer, fileID uint64, path string, meta FileFrameMeta, localFileHash string, windowChecksumPassed, windowChecksumTotal int, elapsed time.Duration) {
@@ -822,7 +1522,7 @@ func resolveDownloadDestinationPath(entry ManifestEntry, outRoot string, outFile
 	return filepath.Clean(filepath.Join(outRoot, filepath.FromSlash(entry.Path)))
 }
 
-func openDownloadOutput(entry ManifestEntry, offset int64, destPath string, stdout io.Writer, noSync bool) (io.WriteCloser, func() error, error) {
+func openDownloadOutput(entry ManifestEntry, offset int64, destPath string, stdout io.Writer, noSync bool, sparse bool) (io.WriteCloser, func() error, error) {
 	if destPath == "-" {
 		if offset > 0 {
 			return nil, nil, errors.New("cannot resume when output is stdout")
@@ -874,6 +1574,19 @@ func openDownloadOutput(entry ManifestEntry, offset int64, destPath string, stdo
 			return nil, nil, fmt.Errorf("create output file: %w", err)
 		}
 	}
+	if sparse {
+		out := newSparseFile(fd, offset)
+		syncOutput := func() error {
+			if err := out.extend(); err != nil {
+				return err
+			}
+			if noSync {
+				return nil
+			}
+			return syscall.Fdatasync(int(fd.Fd()))
+		}
+		return out, syncOutput, nil
+	}
 	if offset > 0 {
 		if _, err := fd.Seek(offset, io.SeekStart); err != nil {
 			_ = fd.Close()
@@ -889,16 +1602,34 @@ func openDownloadOutput(entry ManifestEntry, offset int64, destPath string, stdo
 	return fd, syncOutput, nil
 }
 
-func applyDownloadedTrailerMetadata(destPath string, meta *FileTrailerMetadata) error {
+func applyDownloadedTrailerMetadata(destPath string, meta *FileTrailerMetadata, preserve preserveOptions) error {
 	if meta == nil || isDiscardDestination(destPath) {
 		return nil
 	}
-	if err := applyTrailerMetadataToPath(destPath, meta); err != nil {
+	if err := applyTrailerMetadataToPath(destPath, meta, preserve); err != nil {
 		return fmt.Errorf("apply trailer metadata to %s: %w", destPath, err)
 	}
 	return nil
 }
 
+// updateManifestEntryAfterChange points a changed file's entry at the size
+// and mtime the server re-recorded and clears its progress.
+func updateManifestEntryAfterChange(manifest *Manifest, changed *FileChangedError) {
+	if manifest == nil {
+		return
+	}
+	for i := range manifest.Entries {
+		if manifest.Entries[i].ID != changed.FileID {
+			continue
+		}
+		manifest.Entries[i].Size = changed.Size
+		manifest.Entries[i].Mtime = changed.MtimeNS
+		manifest.Entries[i].HoleBytes = 0
+		manifest.Entries[i].Progress = ManifestProgress{}
+		return
+	}
+}
+
 func applyProgressStateToManifest(manifest *Manifest, state map[uint64]ManifestProgress) {
 	if manifest == nil || len(manifest.Entries) == 0 || len(state) == 0 {
 		return
@@ -977,13 +1708,15 @@ func loadProgressState(progressPath string) (map[uint64]ManifestProgress, error)
 	defer fd.Close()
 
 	scanner := bufio.NewScanner(fd)
+	// Sink tokens list every multipart ETag, so lines can be long.
+	scanner.Buffer(make([]byte, 0, 64*1024), maxProgressLineBytes)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, progressFailedPrefix) {
 			continue
 		}
 		parts := strings.Fields(line)
-		if len(parts) != 2 && len(parts) != 3 {
+		if len(parts) < 2 || len(parts) > 4 {
 			return nil, fmt.Errorf("invalid progress line: %q", line)
 		}
 		fileID, err := strconv.ParseUint(parts[0], 10, 64)
@@ -995,7 +1728,7 @@ func loadProgressState(progressPath string) (map[uint64]ManifestProgress, error)
 			return nil, fmt.Errorf("invalid progress ack %q: %w", parts[1], err)
 		}
 		metadataDone := false
-		if len(parts) == 3 {
+		if len(parts) >= 3 {
 			switch parts[2] {
 			case "0":
 				metadataDone = false
@@ -1005,11 +1738,16 @@ func loadProgressState(progressPath string) (map[uint64]ManifestProgress, error)
 				return nil, fmt.Errorf("invalid progress metadata flag %q", parts[2])
 			}
 		}
+		sinkState := ""
+		if len(parts) == 4 {
+			sinkState = parts[3]
+		}
 		prev, ok := state[fileID]
 		if !ok || ack > prev.AckBytes || (ack == prev.AckBytes && metadataDone && !prev.MetadataDone) {
 			state[fileID] = ManifestProgress{
 				AckBytes:     ack,
 				MetadataDone: metadataDone,
+				Sink:         sinkState,
 			}
 		}
 	}
@@ -1019,11 +1757,115 @@ func loadProgressState(progressPath string) (map[uint64]ManifestProgress, error)
 	return state, nil
 }
 
+// progressFailedPrefix starts a .progress line naming a file the last start
+// run failed, for --retry-failed. Progress snapshots drop these lines, so
+// only the latest run's failures remain.
+const progressFailedPrefix = "failed "
+
+// loadFailedProgressIDs returns the failed file ids recorded in the
+// .progress file.
+func loadFailedProgressIDs(progressPath string) ([]uint64, error) {
+	raw, err := os.ReadFile(progressPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []uint64
+	for line := range strings.Lines(string(raw)) {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(line), progressFailedPrefix)
+		if !ok {
+			continue
+		}
+		fileID, err := strconv.ParseUint(strings.TrimSpace(rest), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid progress failed id %q: %w", rest, err)
+		}
+		ids = append(ids, fileID)
+	}
+	return ids, nil
+}
+
+// saveFailedProgressIDs replaces the failed file ids in the .progress file,
+// keeping every file's progress.
+func saveFailedProgressIDs(progressPath string, failed []uint64) error {
+	prev, err := loadFailedProgressIDs(progressPath)
+	if err != nil {
+		return err
+	}
+	if slices.Equal(prev, failed) {
+		return nil
+	}
+	state, err := loadProgressState(progressPath)
+	if err != nil {
+		return err
+	}
+	return writeProgressFile(progressPath, state, failed)
+}
+
+// writeProgressState atomically replaces the .progress file with state.
+func writeProgressState(progressPath string, state map[uint64]ManifestProgress) error {
+	return writeProgressFile(progressPath, state, nil)
+}
+
+func writeProgressFile(progressPath string, state map[uint64]ManifestProgress, failed []uint64) error {
+	dir := filepath.Dir(progressPath)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmpPath := progressPath + ".tmp"
+	fd, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	ids := make([]uint64, 0, len(state))
+	for fileID := range state {
+		ids = append(ids, fileID)
+	}
+	slices.Sort(ids)
+	for _, fileID := range ids {
+		entry := state[fileID]
+		metaDone := 0
+		if entry.MetadataDone {
+			metaDone = 1
+		}
+		line := fmt.Sprintf("%d %d %d", fileID, entry.AckBytes, metaDone)
+		if entry.Sink != "" {
+			line += " " + entry.Sink
+		}
+		if _, err := fmt.Fprintln(fd, line); err != nil {
+			_ = fd.Close()
+			return err
+		}
+	}
+	for _, fileID := range failed {
+		if _, err := fmt.Fprintf(fd, "%s%d\n", progressFailedPrefix, fileID); err != nil {
+			_ = fd.Close()
+			return err
+		}
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, progressPath)
+}
+
 type metadataProgressUpdate struct {
 	FileID uint64
+	// Reset forgets the file's progress (its source changed) once every
+	// progress update already queued has been applied, then closes done.
+	Reset bool
+	done  chan struct{}
+	// SinkState, when set, replaces the file's sink resume token and
+	// flushes the snapshot at once, sending the result on saved.
+	SinkState *string
+	saved     chan error
 }
 
-func startProgressWriter(progressPath string, initial map[uint64]ManifestProgress, updates <-chan DownloadProgressUpdate, onUpdate func(DownloadProgressUpdate), stderr io.Writer) (func(), func(uint64)) {
+func startProgressWriter(progressPath string, initial map[uint64]ManifestProgress, updates <-chan DownloadProgressUpdate, onUpdate func(DownloadProgressUpdate), stderr io.Writer) (func(), func(uint64), func(uint64), func(uint64, string) error) {
 	state := initial
 	if state == nil {
 		state = make(map[uint64]ManifestProgress)
@@ -1033,37 +1875,7 @@ func startProgressWriter(progressPath string, initial map[uint64]ManifestProgres
 	metadataDoneCh := make(chan metadataProgressUpdate, 1024)
 
 	writeSnapshot := func() error {
-		dir := filepath.Dir(progressPath)
-		if dir != "." && dir != "" {
-			if err := os.MkdirAll(dir, 0o755); err != nil {
-				return err
-			}
-		}
-		tmpPath := progressPath + ".tmp"
-		fd, err := os.Create(tmpPath)
-		if err != nil {
-			return err
-		}
-		ids := make([]uint64, 0, len(state))
-		for fileID := range state {
-			ids = append(ids, fileID)
-		}
-		slices.Sort(ids)
-		for _, fileID := range ids {
-			entry := state[fileID]
-			metaDone := 0
-			if entry.MetadataDone {
-				metaDone = 1
-			}
-			if _, err := fmt.Fprintf(fd, "%d %d %d\n", fileID, entry.AckBytes, metaDone); err != nil {
-				_ = fd.Close()
-				return err
-			}
-		}
-		if err := fd.Close(); err != nil {
-			return err
-		}
-		return os.Rename(tmpPath, progressPath)
+		return writeProgressState(progressPath, state)
 	}
 
 	go func() {
@@ -1082,7 +1894,37 @@ func startProgressWriter(progressPath string, initial map[uint64]ManifestProgres
 				dirty = true
 			}
 		}
+		drainUpdates := func() {
+			for {
+				select {
+				case update, ok := <-updates:
+					if !ok {
+						updates = nil
+						return
+					}
+					applyProgress(update)
+				default:
+					return
+				}
+			}
+		}
 		applyMetadataDone := func(update metadataProgressUpdate) {
+			if update.SinkState != nil {
+				prev := state[update.FileID]
+				prev.Sink = *update.SinkState
+				state[update.FileID] = prev
+				err := writeSnapshot()
+				dirty = err != nil
+				update.saved <- err
+				return
+			}
+			if update.Reset {
+				drainUpdates()
+				delete(state, update.FileID)
+				dirty = true
+				close(update.done)
+				return
+			}
 			prev := state[update.FileID]
 			if !prev.MetadataDone {
 				prev.MetadataDone = true
@@ -1153,10 +1995,42 @@ func startProgressWriter(progressPath string, initial map[uint64]ManifestProgres
 			// Do not block download workers on progress persistence.
 		}
 	}
-	return stop, markMetadataDone
+	resetProgress := func(fileID uint64) {
+		update := metadataProgressUpdate{FileID: fileID, Reset: true, done: make(chan struct{})}
+		select {
+		case <-doneCh:
+			return
+		case metadataDoneCh <- update:
+		}
+		select {
+		case <-doneCh:
+		case <-update.done:
+		}
+	}
+	errStopped := errors.New("progress writer stopped")
+	saveSinkState := func(fileID uint64, sinkState string) error {
+		update := metadataProgressUpdate{FileID: fileID, SinkState: &sinkState, saved: make(chan error, 1)}
+		select {
+		case <-doneCh:
+			return errStopped
+		case metadataDoneCh <- update:
+		}
+		select {
+		case err := <-update.saved:
+			return err
+		case <-doneCh:
+			select {
+			case err := <-update.saved:
+				return err
+			default:
+				return errStopped
+			}
+		}
+	}
+	return stop, markMetadataDone, resetProgress, saveSinkState
 }
 
-func refreshCompletedFileMetadata(ctx context.Context, client *Client, manifest *Manifest, fileID uint64, outRoot string, outFile string, agePublicKey string, ageIdentity string) error {
+func refreshCompletedFileMetadata(ctx context.Context, client *Client, manifest *Manifest, fileID uint64, outRoot string, outFile string, agePublicKey string, ageIdentity string, preserve preserveOptions) error {
 	if manifest == nil {
 		return errors.New("nil manifest")
 	}
@@ -1177,8 +2051,8 @@ func refreshCompletedFileMetadata(ctx context.Context, client *Client, manifest
 	if isDiscardDestination(destPath) {
 		return nil
 	}
-	serverPath := filepath.Clean(filepath.Join(manifest.Root, filepath.FromSlash(entry.Path)))
-	if !filepath.IsAbs(serverPath) {
+	serverPath := manifest.ServerPath(entry)
+	if !source.IsRemote(serverPath) && !filepath.IsAbs(serverPath) {
 		return fmt.Errorf("resolved file path is not absolute: %s", serverPath)
 	}
 	meta, err := fetchTerminalTrailerMetadataFromChecksum(ctx, client, manifest.TransferID, fileID, serverPath, entry.Size, agePublicKey, ageIdentity)
@@ -1188,7 +2062,7 @@ func refreshCompletedFileMetadata(ctx context.Context, client *Client, manifest
 	if meta == nil {
 		return errors.New("checksum response missing terminal trailer metadata")
 	}
-	return applyTrailerMetadataToPath(destPath, meta)
+	return applyTrailerMetadataToPath(destPath, meta, preserve)
 }
 
 func fetchTerminalTrailerMetadataFromChecksum(ctx context.Context, client *Client, transferID string, fileID uint64, serverPath string, fileSize int64, agePublicKey string, ageIdentity string) (*FileTrailerMetadata, error) {
@@ -1299,6 +2173,27 @@ func parseTrailerMetadata(line string) (*FileTrailerMetadata, bool, error) {
 				meta.Size, _ = strconv.ParseInt(val, 10, 64)
 			case "mtime_ns":
 				meta.MtimeNS, _ = strconv.ParseInt(val, 10, 64)
+			case "atime_ns":
+				meta.AtimeNS, _ = strconv.ParseInt(val, 10, 64)
+			case "xattrs_truncated":
+				meta.XattrsTruncated = val == "1"
+			default:
+				rawName, ok := strings.CutPrefix(key, "xattr.")
+				if !ok {
+					continue
+				}
+				name, err := encoding.DecodeXattrName(rawName)
+				if err != nil || name == "" {
+					return nil, false, fmt.Errorf("invalid checksum frame trailer xattr name %q", rawName)
+				}
+				value, err := base64.StdEncoding.DecodeString(val)
+				if err != nil {
+					return nil, false, fmt.Errorf("invalid checksum frame trailer xattr %q value", name)
+				}
+				if meta.Xattrs == nil {
+					meta.Xattrs = make(map[string][]byte)
+				}
+				meta.Xattrs[name] = value
 			}
 		}
 	}
@@ -1308,7 +2203,10 @@ func parseTrailerMetadata(line string) (*FileTrailerMetadata, bool, error) {
 	return meta, isTerminal, nil
 }
 
-func applyTrailerMetadataToPath(path string, meta *FileTrailerMetadata) error {
+// applyTrailerMetadataToPath restores the selected metadata onto path.
+// Ownership goes first since chown may clear setuid/setgid bits, and times go
+// last since every other change bumps ctime and xattr writes may touch mtime.
+func applyTrailerMetadataToPath(path string, meta *FileTrailerMetadata, preserve preserveOptions) error {
 	if meta == nil {
 		return nil
 	}
@@ -1318,8 +2216,26 @@ func applyTrailerMetadataToPath(path string, meta *FileTrailerMetadata) error {
 	}
 	defer fd.Close()
 
+	if preserve.Owner {
+		if err := applyTrailerOwner(fd, meta, preserve.OwnerByName); err != nil {
+			return err
+		}
+	}
+	// Xattrs go before the mode: setting user.* xattrs needs write
+	// permission, which a read-only source mode such as 0444 takes away.
+	if preserve.Xattrs {
+		if meta.XattrsTruncated {
+			return errors.New("trailer xattrs truncated by server, refusing partial restore")
+		}
+		names := slices.Sorted(maps.Keys(meta.Xattrs))
+		for _, name := range names {
+			if err := unix.Fsetxattr(int(fd.Fd()), name, meta.Xattrs[name], 0); err != nil {
+				return fmt.Errorf("set xattr %s: %w", name, err)
+			}
+		}
+	}
 	modeRaw := strings.TrimSpace(meta.Mode)
-	if modeRaw != "" {
+	if preserve.Mode && modeRaw != "" {
 		modeBits, err := strconv.ParseUint(modeRaw, 8, 32)
 		if err != nil || modeBits > 0o7777 {
 			return fmt.Errorf("invalid trailer mode %q", modeRaw)
@@ -1328,8 +2244,37 @@ func applyTrailerMetadataToPath(path string, meta *FileTrailerMetadata) error {
 			return fmt.Errorf("chmod destination to %s: %w", modeRaw, err)
 		}
 	}
+	if preserve.Times && (meta.MtimeNS > 0 || meta.AtimeNS > 0) {
+		var atime, mtime time.Time
+		if meta.AtimeNS > 0 {
+			atime = time.Unix(0, meta.AtimeNS)
+		}
+		if meta.MtimeNS > 0 {
+			mtime = time.Unix(0, meta.MtimeNS)
+		}
+		// A zero time.Time leaves that timestamp unchanged.
+		if err := os.Chtimes(path, atime, mtime); err != nil {
+			return fmt.Errorf("set destination times: %w", err)
+		}
+	}
+	return nil
+}
+
+func applyTrailerOwner(fd *os.File, meta *FileTrailerMetadata, byName bool) error {
 	uidRaw := strings.TrimSpace(meta.UID)
 	gidRaw := strings.TrimSpace(meta.GID)
+	if byName {
+		if name := strings.TrimSpace(meta.User); name != "" {
+			if u, err := user.Lookup(name); err == nil {
+				uidRaw = u.Uid
+			}
+		}
+		if name := strings.TrimSpace(meta.Group); name != "" {
+			if g, err := user.LookupGroup(name); err == nil {
+				gidRaw = g.Gid
+			}
+		}
+	}
 	if uidRaw == "" && gidRaw == "" {
 		return nil
 	}
@@ -3,22 +3,33 @@ package filexfercli
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"filippo.io/age"
 	. "github.com/jolynch/pinch/filexfer"
+	"github.com/jolynch/pinch/internal/filexfer/encoding"
 	intftcp "github.com/jolynch/pinch/internal/filexfer/ftcp"
+	"github.com/jolynch/pinch/internal/filexfer/s3/s3test"
 	"github.com/zeebo/xxh3"
+	"golang.org/x/sys/unix"
 )
 
 type ftcpTestServer struct {
@@ -313,6 +324,83 @@ func TestRunCLIGetResumesFromProgressOffset(t *testing.T) {
 	}
 }
 
+func TestRunCLIGetRefreshRestoresAtimeAndXattrs(t *testing.T) {
+	tmp := t.TempDir()
+	manifestPath := filepath.Join(tmp, "txmeta.fm2")
+	manifestRaw := strings.Join([]string{
+		"FM/2 txmeta 7:/remote mode=fast link-mbps=1000 concurrency=8",
+		"0 5 0:100 0644 0:5:a.txt",
+		"",
+	}, "\n")
+	if err := os.WriteFile(manifestPath, []byte(manifestRaw), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	// The file finished in an earlier run, so only its metadata is left.
+	if err := os.WriteFile(manifestPath+".progress", []byte("0 5 0\n"), 0o644); err != nil {
+		t.Fatalf("write progress: %v", err)
+	}
+	outRoot := filepath.Join(tmp, "out")
+	if err := os.MkdirAll(outRoot, 0o755); err != nil {
+		t.Fatalf("mkdir out root: %v", err)
+	}
+	destPath := filepath.Join(outRoot, "a.txt")
+	if err := os.WriteFile(destPath, []byte("hello"), 0o644); err != nil {
+		t.Fatalf("write destination: %v", err)
+	}
+	xattrsSupported := unix.Setxattr(destPath, "user.pinch.probe", []byte("1"), 0) == nil
+	preserve := "times"
+	if xattrsSupported {
+		preserve = "times,xattrs"
+	}
+
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	atime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	body := []byte("hello")
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		if req.Verb != intftcp.VerbCXSUM {
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+		if got, want := req.Params[0]["meta"], strings.Replace(preserve, "times", "atime", 1); got != want {
+			return fmt.Errorf("expected meta=%s, got %q", want, got)
+		}
+		xsum := xxh128HexCLI(body)
+		header := fmt.Sprintf("FX/1 0 offset=0 size=5 wsize=0 comp=none enc=none hash=xxh128:%s ts=1000\n", xsum)
+		trailer := fmt.Sprintf(
+			"FXT/1 0 status=ok ts=1001 next=0 file-hash=xxh128:%s meta:size=5 meta:mtime_ns=%d meta:mode=644 meta:atime_ns=%d meta:xattr.%s=%s",
+			xsum, mtime.UnixNano(), atime.UnixNano(), encoding.EncodeXattrName("user.pinch.test"), base64.StdEncoding.EncodeToString([]byte("value")),
+		)
+		h := xxh3.New()
+		_, _ = h.Write([]byte(header))
+		_, _ = h.Write([]byte(trailer))
+		_, err := fmt.Fprintf(out, "%s%s hash=xxh64:%016x\n", header, trailer, h.Sum64())
+		return err
+	})
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := RunCLI([]string{srv.URL, "get", "--tid", "txmeta", "--fd", "0", "--manifest", manifestPath, "--out-root", outRoot, "--preserve", preserve}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("get refresh: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+	info, err := os.Stat(destPath)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected mtime: got %v want %v", info.ModTime(), mtime)
+	}
+	if got := time.Unix(info.Sys().(*syscall.Stat_t).Atim.Unix()); !got.Equal(atime) {
+		t.Fatalf("unexpected atime: got %v want %v", got, atime)
+	}
+	if xattrsSupported {
+		buf := make([]byte, 64)
+		n, err := unix.Getxattr(destPath, "user.pinch.test", buf)
+		if err != nil || string(buf[:n]) != "value" {
+			t.Fatalf("unexpected xattr: %q err=%v", buf[:n], err)
+		}
+	}
+}
+
 func TestRunCLIGetRejectsResumeToStdout(t *testing.T) {
 	tmp := t.TempDir()
 	manifestPath := filepath.Join(tmp, "txstdout.fm2")
@@ -512,6 +600,148 @@ func TestRunCLIStartDownloadsAll(t *testing.T) {
 	}
 }
 
+func TestRunCLIStartSavesManifestAfterChangedFile(t *testing.T) {
+	tmp := t.TempDir()
+	manifestPath := filepath.Join(tmp, "txchanged.fm2")
+	manifestRaw := strings.Join([]string{
+		"FM/2 txchanged 7:/remote mode=fast link-mbps=1000 concurrency=1",
+		"0 5 0:100 0644 0:5:a.txt",
+		"",
+	}, "\n")
+	if err := os.WriteFile(manifestPath, []byte(manifestRaw), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+
+	var sends atomic.Int32
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			if sends.Add(1) == 1 {
+				frame := buildCLIFrame(0, []byte("hello"), 0)
+				frame = frame[:strings.Index(frame, "FXT/1")]
+				_, err := io.WriteString(out, frame+"ERR CHANGED fid=0 size=9 mtime=42 changed during send: size 5->9\r\n")
+				return err
+			}
+			if _, err := io.WriteString(out, buildCLIFrame(0, []byte("hello new"), 0)); err != nil {
+				return err
+			}
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbACK:
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer srv.Close()
+
+	outRoot := filepath.Join(tmp, "out")
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code := RunCLI([]string{srv.URL, "start", "--tid", "txchanged", "--manifest", manifestPath, "--out-root", outRoot, "--consistency", "strict"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("start: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+	saved, err := LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("load saved manifest: %v", err)
+	}
+	entry, ok := saved.EntryByID(0)
+	if !ok {
+		t.Fatalf("missing entry 0 in saved manifest")
+	}
+	if entry.Size != 9 || entry.Mtime != 42 {
+		t.Fatalf("expected saved entry size=9 mtime=42, got size=%d mtime=%d", entry.Size, entry.Mtime)
+	}
+	if _, err := os.Stat(manifestPath + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected no leftover temp manifest, stat err=%v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(outRoot, "a.txt"))
+	if err != nil || string(got) != "hello new" {
+		t.Fatalf("unexpected output %q err=%v", got, err)
+	}
+}
+
+func TestRunCLIStartRetryFailedRerunsOnlyFailedFiles(t *testing.T) {
+	tmp := t.TempDir()
+	manifestPath := filepath.Join(tmp, "txretry.fm2")
+	manifestRaw := "FM/2 txretry 7:/remote mode=fast link-mbps=700 concurrency=1\n0 5 0:100 0644 0:5:a.txt\n1 4 0:101 0644 0:5:b.txt\n"
+	if err := os.WriteFile(manifestPath, []byte(manifestRaw), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	bodies := map[string]string{"0": "hello", "1": "test"}
+	var mu sync.Mutex
+	denied := true
+	var sent []string
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			for _, p := range req.Params[1:] {
+				mu.Lock()
+				sent = append(sent, p["fid"])
+				deny := denied
+				mu.Unlock()
+				if p["fid"] == "1" && deny {
+					_, err := io.WriteString(out, "ERR NOT_AUTHORIZED denied\r\n")
+					return err
+				}
+				fileID, _ := strconv.ParseUint(p["fid"], 10, 64)
+				if _, err := io.WriteString(out, buildCLIFrame(fileID, []byte(bodies[p["fid"]]), 0)); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbACK:
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer srv.Close()
+
+	outRoot := filepath.Join(tmp, "out")
+	args := []string{srv.URL, "start", "--manifest", manifestPath, "--out-root", outRoot, "--batch-size", "5", "--retry-backoff", "1ms"}
+	var stdout, stderr bytes.Buffer
+	if code := RunCLI(args, &stdout, &stderr); code != 1 {
+		t.Fatalf("first start: expected 1, got %d stderr=%s", code, stderr.String())
+	}
+	if ids, err := loadFailedProgressIDs(manifestPath + ".progress"); err != nil || fmt.Sprint(ids) != "[1]" {
+		t.Fatalf("expected failed id 1 recorded, got %v err=%v", ids, err)
+	}
+	if strings.Contains(stderr.String(), "start-retry:") {
+		t.Fatalf("NOT_AUTHORIZED must not be retried: %s", stderr.String())
+	}
+
+	mu.Lock()
+	denied = false
+	sent = nil
+	mu.Unlock()
+	stdout.Reset()
+	stderr.Reset()
+	if code := RunCLI(append(args, "--retry-failed"), &stdout, &stderr); code != 0 {
+		t.Fatalf("retry start: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+	mu.Lock()
+	gotSent := fmt.Sprint(sent)
+	mu.Unlock()
+	if gotSent != "[1]" {
+		t.Fatalf("expected only the failed file sent, got %s", gotSent)
+	}
+	if got, err := os.ReadFile(filepath.Join(outRoot, "b.txt")); err != nil || string(got) != "test" {
+		t.Fatalf("unexpected b.txt: %q err=%v", got, err)
+	}
+	if ids, err := loadFailedProgressIDs(manifestPath + ".progress"); err != nil || len(ids) != 0 {
+		t.Fatalf("expected failed ids cleared, got %v err=%v", ids, err)
+	}
+	progress, err := loadProgressState(manifestPath + ".progress")
+	if err != nil || progress[0].AckBytes != 5 || progress[1].AckBytes != 4 {
+		t.Fatalf("unexpected progress after retry: %+v err=%v", progress, err)
+	}
+}
+
 func TestRunCLIStartUsesManifestConcurrencyDefault(t *testing.T) {
 	tmp := t.TempDir()
 	manifestPath := filepath.Join(tmp, "txstartdefault.fm2")
@@ -556,6 +786,59 @@ func TestRunCLIStartUsesManifestConcurrencyDefault(t *testing.T) {
 	}
 }
 
+func TestRunCLIStartExplicitConcurrencyDisablesAdaptive(t *testing.T) {
+	tmp := t.TempDir()
+	manifestPath := filepath.Join(tmp, "txadaptive.fm2")
+	manifestRaw := strings.Join([]string{
+		"FM/2 txadaptive 7:/remote mode=fast link-mbps=1000 concurrency=2",
+		"0 5 0:100 0644 0:5:a.txt",
+		"",
+	}, "\n")
+	if err := os.WriteFile(manifestPath, []byte(manifestRaw), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			if _, err := io.WriteString(out, buildCLIFrame(0, []byte("hello"), 0)); err != nil {
+				return err
+			}
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbACK:
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name  string
+		flags []string
+		want  string
+	}{
+		{name: "default", want: "adaptive=true"},
+		{name: "explicit concurrency", flags: []string{"--concurrency", "4"}, want: "adaptive=false"},
+		{name: "explicit concurrency and adaptive", flags: []string{"--concurrency", "4", "--adaptive"}, want: "adaptive=true"},
+		{name: "explicit concurrency and max", flags: []string{"--concurrency", "4", "--max-concurrency", "8"}, want: "adaptive=true"},
+		{name: "adaptive off", flags: []string{"--adaptive=false"}, want: "adaptive=false"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := []string{srv.URL, "start", "--manifest", manifestPath, "--out-root", filepath.Join(t.TempDir(), "out")}
+			var stdout, stderr bytes.Buffer
+			if code := RunCLI(append(args, tc.flags...), &stdout, &stderr); code != 0 {
+				t.Fatalf("start: expected 0, got %d stderr=%s", code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tc.want+"\n") {
+				t.Fatalf("expected %s in start plan: %s", tc.want, stdout.String())
+			}
+		})
+	}
+}
+
 func TestRunCLIStartOutRootDevNullDiscardsOutput(t *testing.T) {
 	tmp := t.TempDir()
 	manifestPath := filepath.Join(tmp, "txstartdevnull.fm2")
@@ -594,12 +877,147 @@ func TestRunCLIStartOutRootDevNullDiscardsOutput(t *testing.T) {
 	}
 }
 
+func TestRunCLIStartUploadsToS3(t *testing.T) {
+	tmp := t.TempDir()
+	manifestPath := filepath.Join(tmp, "txs3.fm2")
+	const bigSize = 6 * 1024 * 1024
+	manifestRaw := strings.Join([]string{
+		"FM/2 txs3 7:/remote mode=fast link-mbps=700 concurrency=2",
+		fmt.Sprintf("0 %d 0:100 0644 0:7:big.bin", bigSize),
+		"1 5 0:101 0644 0:9:dir/a.txt",
+		"",
+	}, "\n")
+	if err := os.WriteFile(manifestPath, []byte(manifestRaw), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	contents := map[string][]byte{
+		"0": bytes.Repeat([]byte("pinch-s3"), bigSize/8),
+		"1": []byte("hello"),
+	}
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			for _, p := range req.Params[1:] {
+				content, ok := contents[p["fid"]]
+				if !ok {
+					return fmt.Errorf("unexpected fid: %q", p["fid"])
+				}
+				fileID, _ := strconv.ParseUint(p["fid"], 10, 64)
+				offset, _ := strconv.ParseInt(p["offset"], 10, 64)
+				end := int64(len(content))
+				if size, err := strconv.ParseInt(p["size"], 10, 64); err == nil && size > 0 {
+					end = offset + size
+				}
+				if _, err := io.WriteString(out, buildCLIFrame(fileID, content[offset:end], offset)); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbACK, intftcp.VerbPROBE:
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer srv.Close()
+	fake := s3test.New()
+	s3srv := httptest.NewServer(fake)
+	defer s3srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", s3srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code := RunCLI([]string{srv.URL, "start", "--manifest", manifestPath, "--out-root", "s3://bucket/dest", "--batch-size", "5MiB"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("start: expected 0, got %d stderr=%s stdout=%s", code, stderr.String(), stdout.String())
+	}
+	for fid, key := range map[string]string{"0": "dest/big.bin", "1": "dest/dir/a.txt"} {
+		if got, ok := fake.Object("bucket", key); !ok || !bytes.Equal(got, contents[fid]) {
+			t.Fatalf("%s: unexpected object ok=%t len=%d", key, ok, len(got))
+		}
+	}
+	if fake.Requests("UploadPart") != 2 || fake.Requests("PutObject") != 1 || fake.Uploads() != 0 {
+		t.Fatalf("expected two parts and one PutObject, got parts=%d puts=%d open=%d", fake.Requests("UploadPart"), fake.Requests("PutObject"), fake.Uploads())
+	}
+	if !strings.Contains(stdout.String(), "s3://bucket/dest/big.bin") {
+		t.Fatalf("missing s3 location in summary: %s", stdout.String())
+	}
+	progress, err := loadProgressState(manifestPath + ".progress")
+	if err != nil {
+		t.Fatalf("load progress: %v", err)
+	}
+	if p := progress[0]; p.AckBytes != bigSize || !p.MetadataDone || !strings.Contains(p.Sink, "upload=") {
+		t.Fatalf("expected the multipart upload recorded in .progress, got %+v", p)
+	}
+
+	code = RunCLI([]string{srv.URL, "start", "--manifest", manifestPath, "--out-root", "s3://bucket/dest", "--batch-size", "1MiB"}, &stdout, &stderr)
+	if code != 2 || !strings.Contains(stderr.String(), "--batch-size must be at least 5MiB") {
+		t.Fatalf("expected a usage error for small parts, got %d stderr=%s", code, stderr.String())
+	}
+}
+
+func TestRunCLIStartWritesArchive(t *testing.T) {
+	tmp := t.TempDir()
+	manifestPath := filepath.Join(tmp, "txarchive.fm2")
+	manifestRaw := strings.Join([]string{
+		"FM/2 txarchive 7:/remote mode=gentle link-mbps=700 concurrency=3",
+		"0 5 0:100 0644 0:5:a.txt",
+		"",
+	}, "\n")
+	if err := os.WriteFile(manifestPath, []byte(manifestRaw), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	archive := bytes.Repeat([]byte("tarbytes"), 100)
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		if req.Verb != intftcp.VerbARCHIVE {
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+		p := req.Params[0]
+		if p["txferid"] != "txarchive" || p["comp"] != ArchiveCompZstd || p["mode"] != LoadStrategyGentle {
+			return fmt.Errorf("unexpected ARCHIVE params: %v", p)
+		}
+		_, err := fmt.Fprintf(out, "FA/1 %d\n%sFA/1 %d\n%sFA/1 0\nOK\r\n", 300, archive[:300], len(archive)-300, archive[300:])
+		return err
+	})
+	defer srv.Close()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	code := RunCLI([]string{srv.URL, "start", "--manifest", manifestPath, "--format", "tar.zst"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("start: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+	if !bytes.Equal(stdout.Bytes(), archive) {
+		t.Fatalf("expected only archive bytes on stdout, got %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "start-archive: tid=txarchive format=tar.zst files=1") {
+		t.Fatalf("missing archive summary on stderr: %s", stderr.String())
+	}
+
+	outPath := filepath.Join(tmp, "out.tar.zst")
+	stdout.Reset()
+	code = RunCLI([]string{srv.URL, "start", "--manifest", manifestPath, "--format", "tar.zst", "-o", outPath}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("start -o: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+	if got, err := os.ReadFile(outPath); err != nil || !bytes.Equal(got, archive) {
+		t.Fatalf("unexpected archive file: err=%v len=%d", err, len(got))
+	}
+	if !strings.Contains(stdout.String(), "out="+outPath) {
+		t.Fatalf("missing archive summary on stdout: %s", stdout.String())
+	}
+}
+
 func TestRunCLIStatus(t *testing.T) {
 	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
 		if req.Verb != intftcp.VerbSTATUS {
 			return fmt.Errorf("unexpected verb: %v", req.Verb)
 		}
-		_, err := io.WriteString(out, `OK {"transfer_id":"abc","directory":"/r","num_files":10,"total_size":1000,"done":3,"done_size":200,"percent_files":30,"percent_bytes":20,"download_status":{"started":5,"running":2,"done":3,"missing":0}}`+"\r\n")
+		_, err := io.WriteString(out, `OK {"transfer_id":"abc","directory":"/r","num_files":10,"total_size":1000,"done":3,"done_size":200,"percent_files":30,"percent_bytes":20,"download_status":{"started":5,"running":2,"done":3,"missing":0},"link_mbps":950,"link_rtt_ms":12,"link_source":"client","link_age_ms":3000}`+"\r\n")
 		return err
 	})
 	defer srv.Close()
@@ -616,6 +1034,511 @@ func TestRunCLIStatus(t *testing.T) {
 	if !strings.Contains(stdout.String(), "downloads: started=5 running=2 done=3 missing=0") {
 		t.Fatalf("unexpected status downloads output: %s", stdout.String())
 	}
+	if !strings.Contains(stdout.String(), "link: mbps=950 source=client rtt=12ms age=3s") {
+		t.Fatalf("unexpected status link output: %s", stdout.String())
+	}
+}
+
+func TestRunCLIControlCommands(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbCANCEL, intftcp.VerbPAUSE, intftcp.VerbRESUME, intftcp.VerbRENEW:
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+		txferID := req.Params[0]["txferid"]
+		mu.Lock()
+		got = append(got, req.Verb.String()+" "+txferID)
+		mu.Unlock()
+		if txferID == "gone" {
+			_, err := io.WriteString(out, "ERR NOT_FOUND transfer not found\r\n")
+			return err
+		}
+		if req.Verb == intftcp.VerbRENEW {
+			_, err := io.WriteString(out, "OK expires-at-ms=1760000600000 ttl-ms=600000\r\n")
+			return err
+		}
+		_, err := io.WriteString(out, "OK\r\n")
+		return err
+	})
+	defer srv.Close()
+
+	for _, tc := range []struct{ cmd, want string }{
+		{cmd: "pause", want: "transfer=abc paused"},
+		{cmd: "resume", want: "transfer=abc resumed"},
+		{cmd: "renew", want: "transfer=abc renewed expires=2025-10-09T09:03:20Z"},
+		{cmd: "cancel", want: "transfer=abc cancelled"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := RunCLI([]string{srv.URL, tc.cmd, "--tid", "abc"}, &stdout, &stderr); code != 0 {
+			t.Fatalf("%s: expected 0, got %d stderr=%s", tc.cmd, code, stderr.String())
+		}
+		if strings.TrimSpace(stdout.String()) != tc.want {
+			t.Fatalf("%s: unexpected output: %q", tc.cmd, stdout.String())
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	if code := RunCLI([]string{srv.URL, "cancel", "--tid", "gone"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("cancel of unknown transfer: expected 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "NOT_FOUND") {
+		t.Fatalf("expected NOT_FOUND on stderr: %s", stderr.String())
+	}
+	if code := RunCLI([]string{srv.URL, "pause"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("pause without --tid: expected 2, got %d", code)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"PAUSE abc", "RESUME abc", "RENEW abc", "CANCEL abc", "CANCEL gone"}; !slices.Equal(got, want) {
+		t.Fatalf("server saw %v want %v", got, want)
+	}
+}
+
+func TestKeepTransferRenewedRenewsEveryThirdOfTTL(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	var renewals atomic.Int64
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		if req.Verb != intftcp.VerbRENEW || req.Params[0]["txferid"] != "abc" {
+			return fmt.Errorf("unexpected request: %v %v", req.Verb, req.Params)
+		}
+		renewals.Add(1)
+		expiresAt := time.Now().Add(ttl)
+		_, err := fmt.Fprintf(out, "OK expires-at-ms=%d ttl-ms=%d\r\n", expiresAt.UnixMilli(), ttl.Milliseconds())
+		return err
+	})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*ttl)
+	defer cancel()
+	var stderr bytes.Buffer
+	keepTransferRenewed(ctx, NewClient(srv.URL), "abc", &stderr)
+	// One renewal up front, then one every ttl/3 for 3*ttl.
+	if got := renewals.Load(); got < 6 || got > 11 {
+		t.Fatalf("expected about 10 renewals in 3 leases, got %d stderr=%s", got, stderr.String())
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("unexpected renew errors: %s", stderr.String())
+	}
+}
+
+func TestRunCLIOutputJSON(t *testing.T) {
+	t.Chdir(t.TempDir())
+	manifestRaw := "FM/2 txjson 7:/remote mode=fast link-mbps=1000 concurrency=8\n0 5 0:100 0644 0:5:a.txt\n"
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbPROBE:
+			n, _ := strconv.Atoi(req.Params[0]["probe-bytes"])
+			if _, err := io.WriteString(out, fmt.Sprintf("PROBE cpu=24 cts0=%s sts0=10 sts1=11 probe-bytes=%d\n", req.Params[0]["cts0"], n)); err != nil {
+				return err
+			}
+			if _, err := out.Write(make([]byte, n)); err != nil {
+				return err
+			}
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbTXFER:
+			_, err := io.WriteString(out, manifestRaw+"OK\r\n")
+			return err
+		case intftcp.VerbSEND:
+			_, err := io.WriteString(out, buildCLIFrame(0, []byte("hello"), 0))
+			return err
+		case intftcp.VerbACK:
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbSTATUS:
+			_, err := io.WriteString(out, `OK {"transfer_id":"txjson","directory":"/remote","num_files":1,"total_size":5,"done":1,"done_size":5,"percent_files":100,"percent_bytes":100,"download_status":{"started":1,"running":0,"done":1,"missing":0},"link_mbps":1000}`+"\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer srv.Close()
+
+	run := func(args ...string) outputResult {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		if code := RunCLI(args, &stdout, &stderr); code != 0 {
+			t.Fatalf("%v: expected 0, got %d stderr=%s", args, code, stderr.String())
+		}
+		var result outputResult
+		if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+			t.Fatalf("%v: stdout is not one JSON object: %v\n%s", args, err, stdout.String())
+		}
+		if result.Schema != outputSchema || result.Type != "result" || !result.OK {
+			t.Fatalf("%v: unexpected envelope: %+v", args, result)
+		}
+		return result
+	}
+
+	result := run("--output", "json", srv.URL, "transfer", "-s", "/remote")
+	if result.Transfer == nil || result.Transfer.TransferID != "txjson" || result.Transfer.Files != 1 || result.Transfer.Manifest != "txjson.fm2" {
+		t.Fatalf("unexpected transfer result: %+v", result.Transfer)
+	}
+	if result.Probe == nil || result.Probe.ServerCPU != 24 {
+		t.Fatalf("unexpected probe result: %+v", result.Probe)
+	}
+
+	result = run(srv.URL, "get", "--output=json", "--tid", "txjson", "--fd", "0", "--manifest", "txjson.fm2", "--out-root", "out")
+	if len(result.Files) != 1 {
+		t.Fatalf("expected one file, got %+v", result.Files)
+	}
+	if file := result.Files[0]; file.Size != 5 || file.Checksum != "ok" || file.CompCounts == nil {
+		t.Fatalf("unexpected file result: %+v", file)
+	}
+
+	result = run("--output=json", srv.URL, "status", "--tid", "txjson")
+	if result.Status == nil || result.Status.PercentBytes != 100 || result.Status.DownloadStatus.Done != 1 {
+		t.Fatalf("unexpected status result: %+v", result.Status)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := RunCLI([]string{"--output", "json", srv.URL, "list"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("list: expected 2, got %d", code)
+	}
+	if code := RunCLI([]string{srv.URL, "get", "--output", "yaml", "--tid", "txjson", "--fd", "0"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("get --output yaml: expected 2, got %d", code)
+	}
+}
+
+func TestRunCLIStartOutputNDJSON(t *testing.T) {
+	tmp := t.TempDir()
+	manifestPath := filepath.Join(tmp, "txnd.fm2")
+	manifestRaw := "FM/2 txnd 7:/remote mode=fast link-mbps=700 concurrency=2\n0 5 0:100 0644 0:5:a.txt\n1 4 0:101 0644 0:5:b.txt\n"
+	if err := os.WriteFile(manifestPath, []byte(manifestRaw), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	bodies := map[string]string{"0": "hello", "1": "test"}
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		switch req.Verb {
+		case intftcp.VerbSEND:
+			for _, p := range req.Params[1:] {
+				fileID, _ := strconv.ParseUint(p["fid"], 10, 64)
+				if _, err := io.WriteString(out, buildCLIFrame(fileID, []byte(bodies[p["fid"]]), 0)); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		case intftcp.VerbACK:
+			_, err := io.WriteString(out, "OK\r\n")
+			return err
+		default:
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+	})
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := RunCLI([]string{"--output", "ndjson", srv.URL, "start", "--tid", "txnd", "--manifest", manifestPath, "--out-root", filepath.Join(tmp, "out")}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("start: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+	var types []string
+	var files []uint64
+	var result outputResult
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var evt outputEvent
+		if err := json.Unmarshal([]byte(line), &evt); err != nil {
+			t.Fatalf("invalid ndjson line %q: %v", line, err)
+		}
+		if evt.Schema != outputSchema || evt.Command != "start" {
+			t.Fatalf("unexpected envelope: %q", line)
+		}
+		if evt.Type != "progress" {
+			types = append(types, evt.Type)
+		}
+		switch evt.Type {
+		case "file":
+			files = append(files, evt.File.FileID)
+		case "result":
+			if err := json.Unmarshal([]byte(line), &result); err != nil {
+				t.Fatalf("invalid result line %q: %v", line, err)
+			}
+		}
+	}
+	if strings.Join(types, ",") != "plan,file,file,result" {
+		t.Fatalf("unexpected event order %v:\n%s", types, stdout.String())
+	}
+	slices.Sort(files)
+	if !slices.Equal(files, []uint64{0, 1}) {
+		t.Fatalf("unexpected file events: %v", files)
+	}
+	if !result.OK || result.Plan == nil || result.Plan.Pending != 2 || len(result.Files) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if s := result.Summary; s == nil || s.Downloaded != 2 || s.Failed != 0 || s.CompCounts["none"] != 2 {
+		t.Fatalf("unexpected summary: %+v", result.Summary)
+	}
+}
+
+func TestRunCLIKeygenTrustAndList(t *testing.T) {
+	t.Setenv(configDirEnv, t.TempDir())
+	serverID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate server identity: %v", err)
+	}
+	serverKey := serverID.Recipient().String()
+	srv := newFTCPTestServer(t, func(req intftcp.Request, out io.Writer) error {
+		if req.Verb != intftcp.VerbPROBE {
+			return fmt.Errorf("unexpected verb: %v", req.Verb)
+		}
+		n, err := strconv.Atoi(req.Params[0]["probe-bytes"])
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(out, fmt.Sprintf("PROBE cpu=4 cts0=%s sts0=10 sts1=11 probe-bytes=%d key=%s\n", req.Params[0]["cts0"], n, serverKey)); err != nil {
+			return err
+		}
+		if _, err := out.Write(make([]byte, n)); err != nil {
+			return err
+		}
+		_, err = io.WriteString(out, "OK\r\n")
+		return err
+	})
+	defer srv.Close()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	if code := RunCLI([]string{"keygen"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("keygen: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "identity: name=default public-key=age1") {
+		t.Fatalf("unexpected keygen output: %s", stdout.String())
+	}
+	if code := RunCLI([]string{"keygen"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("keygen over existing identity: expected 1, got %d", code)
+	}
+	if code := RunCLI([]string{"keygen", "--name", "ci"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("keygen --name: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := RunCLI([]string{srv.URL, "trust", "--identity", "ci"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("trust: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "key="+serverKey+" identity=ci source=probe") {
+		t.Fatalf("unexpected trust output: %s", stdout.String())
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate other identity: %v", err)
+	}
+	stderr.Reset()
+	if code := RunCLI([]string{srv.URL, "trust", "--key", other.Recipient().String()}, &stdout, &stderr); code != 1 {
+		t.Fatalf("trust with changed key: expected 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "changed") {
+		t.Fatalf("expected changed key error, got: %s", stderr.String())
+	}
+
+	stdout.Reset()
+	if code := RunCLI([]string{"list"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("list: expected 0, got %d stderr=%s", code, stderr.String())
+	}
+	for _, want := range []string{"identity: name=ci ", "identity: name=default ", "server: addr=" + srv.URL + " key=" + serverKey + " identity=ci"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("list output missing %q: %s", want, stdout.String())
+		}
+	}
+}
+
+func TestResolveEncryptionOptionsUsesPinnedServer(t *testing.T) {
+	t.Setenv(configDirEnv, t.TempDir())
+	ks, err := openKeyStore()
+	if err != nil {
+		t.Fatalf("openKeyStore: %v", err)
+	}
+
+	enc, err := resolveEncryptionOptions("127.0.0.1:1", "")
+	if err != nil {
+		t.Fatalf("resolve unpinned: %v", err)
+	}
+	if enc != (encryptionOptions{}) {
+		t.Fatalf("expected no encryption for unpinned server, got %+v", enc)
+	}
+	enc, err = resolveEncryptionOptions("127.0.0.1:1", "age")
+	if err != nil {
+		t.Fatalf("resolve unpinned age: %v", err)
+	}
+	if enc.AgeIdentity == "" || enc.ServerAgePublicKey != "" {
+		t.Fatalf("expected ephemeral identity without server key, got %+v", enc)
+	}
+
+	identity, err := ks.generateIdentity(defaultIdentityName, false)
+	if err != nil {
+		t.Fatalf("generateIdentity: %v", err)
+	}
+	serverID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate server identity: %v", err)
+	}
+	if err := ks.trustServer(knownServer{Addr: "127.0.0.1:1", PublicKey: serverID.Recipient().String()}); err != nil {
+		t.Fatalf("trustServer: %v", err)
+	}
+	for _, mode := range []string{"", "age"} {
+		enc, err = resolveEncryptionOptions("127.0.0.1:1", mode)
+		if err != nil {
+			t.Fatalf("resolve pinned mode=%q: %v", mode, err)
+		}
+		if enc.AgeIdentity != identity.String() || enc.AgePublicKey != identity.Recipient().String() {
+			t.Fatalf("mode=%q: expected stored identity, got %+v", mode, enc)
+		}
+		if enc.ServerAgePublicKey != serverID.Recipient().String() {
+			t.Fatalf("mode=%q: expected pinned server key, got %q", mode, enc.ServerAgePublicKey)
+		}
+	}
+	enc, err = resolveEncryptionOptions("127.0.0.1:1", "none")
+	if err != nil {
+		t.Fatalf("resolve none: %v", err)
+	}
+	if enc != (encryptionOptions{}) {
+		t.Fatalf("expected --encrypt none to disable encryption, got %+v", enc)
+	}
+	enc, err = resolveEncryptionOptions("127.0.0.1:2", "")
+	if err != nil {
+		t.Fatalf("resolve other address: %v", err)
+	}
+	if enc != (encryptionOptions{}) {
+		t.Fatalf("expected pinning to be per address, got %+v", enc)
+	}
+
+	if err := ks.trustServer(knownServer{Addr: "127.0.0.1:1", PublicKey: serverID.Recipient().String(), Identity: "missing"}); err != nil {
+		t.Fatalf("trustServer: %v", err)
+	}
+	if _, err := resolveEncryptionOptions("127.0.0.1:1", ""); err == nil {
+		t.Fatalf("expected error for missing named identity")
+	}
+}
+
+func TestPinServerKeyOnFirstUse(t *testing.T) {
+	t.Setenv(configDirEnv, t.TempDir())
+	first, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate server identity: %v", err)
+	}
+	second, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate server identity: %v", err)
+	}
+	firstKey, secondKey := first.Recipient().String(), second.Recipient().String()
+
+	var stderr bytes.Buffer
+	warnUnencrypted(&stderr, "127.0.0.1:1", "", encryptionOptions{})
+	if !strings.Contains(stderr.String(), "not encrypted") {
+		t.Fatalf("expected a plaintext warning, got %q", stderr.String())
+	}
+	if pin, err := pinServerKeyOnFirstUse("127.0.0.1:1", firstKey, []string{firstKey}, ""); err != nil || pin != serverKeyPinned {
+		t.Fatalf("first use: pin=%v err=%v", pin, err)
+	}
+	enc, err := resolveEncryptionOptions("127.0.0.1:1", "")
+	if err != nil || enc.ServerAgePublicKey != firstKey || enc.AgeIdentity == "" {
+		t.Fatalf("expected encryption to the key pinned on first use, got %+v err=%v", enc, err)
+	}
+	stderr.Reset()
+	warnUnencrypted(&stderr, "127.0.0.1:1", "", enc)
+	if stderr.Len() != 0 {
+		t.Fatalf("unexpected warning for a pinned server: %q", stderr.String())
+	}
+	if pin, err := pinServerKeyOnFirstUse("127.0.0.1:1", firstKey, []string{firstKey}, firstKey); err != nil || pin != serverKeyUnchanged {
+		t.Fatalf("same key again: pin=%v err=%v", pin, err)
+	}
+	// A new key is only followed when the server still accepts the pinned
+	// one and proved it by answering a session encrypted to it.
+	if _, err := pinServerKeyOnFirstUse("127.0.0.1:1", secondKey, []string{secondKey}, firstKey); !errors.Is(err, errServerKeyChanged) {
+		t.Fatalf("expected errServerKeyChanged once the pinned key is retired, got %v", err)
+	}
+	if _, err := pinServerKeyOnFirstUse("127.0.0.1:1", secondKey, []string{secondKey, firstKey}, ""); !errors.Is(err, errServerKeyChanged) {
+		t.Fatalf("expected errServerKeyChanged over an unencrypted session, got %v", err)
+	}
+	enc, err = resolveEncryptionOptions("127.0.0.1:1", "")
+	if err != nil || enc.ServerAgePublicKey != firstKey {
+		t.Fatalf("a changed key must not replace the pin, got %+v err=%v", enc, err)
+	}
+	if pin, err := pinServerKeyOnFirstUse("127.0.0.1:1", secondKey, []string{secondKey, firstKey}, firstKey); err != nil || pin != serverKeyRotated {
+		t.Fatalf("rotation: pin=%v err=%v", pin, err)
+	}
+	enc, err = resolveEncryptionOptions("127.0.0.1:1", "")
+	if err != nil || enc.ServerAgePublicKey != secondKey {
+		t.Fatalf("expected the pin to follow the rotation, got %+v err=%v", enc, err)
+	}
+}
+
+func TestRunCLITransferFollowsServerKeyRotation(t *testing.T) {
+	t.Setenv(configDirEnv, t.TempDir())
+	t.Setenv(serverPublicKeyEnv, "")
+	tmp := t.TempDir()
+	srcDir := filepath.Join(tmp, "src")
+	if err := os.MkdirAll(srcDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var ids []*age.X25519Identity
+	for range 3 {
+		id, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("generate server identity: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	keyring := intftcp.NewKeyring(ids[0])
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen tcp: %v", err)
+	}
+	defer ln.Close()
+	go func() { _ = intftcp.Serve(ln, intftcp.ServerOptions{Keyring: keyring}) }()
+	addr := ln.Addr().String()
+
+	transfer := func(step string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		manifestPath := filepath.Join(tmp, step+".fm2")
+		code := RunCLI([]string{addr, "transfer", "-s", srcDir, "-o", manifestPath}, &stdout, &stderr)
+		return code, stderr.String()
+	}
+	pinnedKey := func() string {
+		enc, err := resolveEncryptionOptions(addr, "")
+		if err != nil {
+			t.Fatalf("resolveEncryptionOptions: %v", err)
+		}
+		return enc.ServerAgePublicKey
+	}
+
+	if code, stderr := transfer("first"); code != 0 || !strings.Contains(stderr, "(first use)") {
+		t.Fatalf("first transfer: code=%d stderr=%s", code, stderr)
+	}
+	if got := pinnedKey(); got != ids[0].Recipient().String() {
+		t.Fatalf("expected the first key pinned, got %q", got)
+	}
+
+	// Rotate: the new primary is announced while the old key stays
+	// accepted, so the client moves its pin without `trust --replace`.
+	keyring.Set(ids[1], ids[0])
+	if code, stderr := transfer("rotated"); code != 0 || !strings.Contains(stderr, "(rotated)") {
+		t.Fatalf("transfer after rotation: code=%d stderr=%s", code, stderr)
+	}
+	if got := pinnedKey(); got != ids[1].Recipient().String() {
+		t.Fatalf("expected the pin to follow the rotation, got %q", got)
+	}
+	keyring.Set(ids[1])
+	if code, stderr := transfer("retired"); code != 0 {
+		t.Fatalf("transfer after retiring the old key: code=%d stderr=%s", code, stderr)
+	}
+
+	// Once the pinned key is gone the server cannot prove it, so the
+	// transfer fails and the pin stays.
+	keyring.Set(ids[2])
+	if code, stderr := transfer("replaced"); code == 0 {
+		t.Fatalf("expected transfer to fail once the pinned key is retired, stderr=%s", stderr)
+	}
+	if got := pinnedKey(); got != ids[1].Recipient().String() {
+		t.Fatalf("a server without the pinned key must not move the pin, got %q", got)
+	}
 }
 
 func TestRunCLIUsageErrors(t *testing.T) {
@@ -652,6 +1575,64 @@ func TestRunCLIUsageErrors(t *testing.T) {
 		t.Fatalf("expected invalid --ack-every message, got: %s", stderr.String())
 	}
 	stderr.Reset()
+	if code := RunCLI([]string{"127.0.0.1:1", "get", "--tid", "t", "--fd", "0", "--preserve", "acls"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected usage exit 2 for invalid --preserve, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "invalid --preserve") {
+		t.Fatalf("expected invalid --preserve message, got: %s", stderr.String())
+	}
+	stderr.Reset()
+	if code := RunCLI([]string{"127.0.0.1:1", "get", "--tid", "t", "--fd", "0", "--consistency", "maybe"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected usage exit 2 for invalid --consistency, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "invalid --consistency") {
+		t.Fatalf("expected invalid --consistency message, got: %s", stderr.String())
+	}
+	stderr.Reset()
+	if code := RunCLI([]string{"127.0.0.1:1", "start", "--tid", "t", "--order", "fastest"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected usage exit 2 for invalid --order, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "invalid --order") {
+		t.Fatalf("expected invalid --order message, got: %s", stderr.String())
+	}
+	stderr.Reset()
+	if code := RunCLI([]string{"127.0.0.1:1", "start", "--tid", "t", "--replica", "127.0.0.1:2", "--replica-stall", "-1s"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected usage exit 2 for negative --replica-stall, got %d", code)
+	}
+	stderr.Reset()
+	if code := RunCLI([]string{"127.0.0.1:1", "start", "--tid", "t", "--format", "zip"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected usage exit 2 for invalid --format, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "invalid --format") {
+		t.Fatalf("expected invalid --format message, got: %s", stderr.String())
+	}
+	for _, flags := range [][]string{
+		{"--out-root", "out"},
+		{"--preserve", "all"},
+		{"--replica", "127.0.0.1:2"},
+		{"--order", "largest-first"},
+		{"--retry-attempts", "5"},
+		{"--retry-backoff", "1s"},
+		{"--concurrency", "4"},
+	} {
+		stderr.Reset()
+		args := append([]string{"127.0.0.1:1", "start", "--tid", "t", "--format", "tar"}, flags...)
+		if code := RunCLI(args, &stdout, &stderr); code != 2 {
+			t.Fatalf("expected usage exit 2 for %s with --format tar, got %d", flags[0], code)
+		}
+		if want := flags[0] + " not supported with --format tar"; !strings.Contains(stderr.String(), want) {
+			t.Fatalf("expected %q, got: %s", want, stderr.String())
+		}
+	}
+	stderr.Reset()
+	if code := RunCLI([]string{"127.0.0.1:1", "mount", "--tid", "t"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected usage exit 2 for mount without a mountpoint, got %d", code)
+	}
+	stderr.Reset()
+	if code := RunCLI([]string{"127.0.0.1:1", "follow", "--tid", "t", "--settle", "-1s"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("expected usage exit 2 for negative --settle, got %d", code)
+	}
+	stderr.Reset()
 	if code := RunCLI([]string{"127.0.0.1:1", "transfer", "--directory", "/tmp"}, &stdout, &stderr); code != 2 {
 		t.Fatalf("expected usage exit 2 for legacy --directory flag, got %d", code)
 	}
@@ -685,6 +1666,116 @@ func TestRunCLIUsageErrors(t *testing.T) {
 	}
 }
 
+func TestResolvePreserve(t *testing.T) {
+	opts, err := resolvePreserve(defaultPreserve, false)
+	if err != nil {
+		t.Fatalf("resolvePreserve default failed: %v", err)
+	}
+	if !opts.Mode || !opts.Owner || !opts.Times || opts.Xattrs {
+		t.Fatalf("unexpected default preserve options: %+v", opts)
+	}
+	opts, err = resolvePreserve("times, XATTRS", true)
+	if err != nil {
+		t.Fatalf("resolvePreserve failed: %v", err)
+	}
+	if opts.Mode || opts.Owner || !opts.Times || !opts.Xattrs || !opts.OwnerByName {
+		t.Fatalf("unexpected preserve options: %+v", opts)
+	}
+	if opts, err = resolvePreserve("none", false); err != nil || opts != (preserveOptions{}) {
+		t.Fatalf("expected empty options for none, got %+v err=%v", opts, err)
+	}
+	if _, err := resolvePreserve("mode,acl", false); err == nil {
+		t.Fatalf("expected error for unknown preserve value")
+	}
+}
+
+func TestApplyTrailerMetadataRestoresTimesAndXattrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.txt")
+	if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	xattrsSupported := unix.Setxattr(path, "user.pinch.probe", []byte("1"), 0) == nil
+
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 800, time.UTC)
+	atime := time.Date(2022, 1, 2, 3, 4, 5, 600, time.UTC)
+	meta := &FileTrailerMetadata{
+		Mode:    "640",
+		MtimeNS: mtime.UnixNano(),
+		AtimeNS: atime.UnixNano(),
+		Xattrs:  map[string][]byte{"user.pinch.test": []byte("value")},
+	}
+	preserve := preserveOptions{Mode: true, Times: true, Xattrs: xattrsSupported}
+	if err := applyTrailerMetadataToPath(path, meta, preserve); err != nil {
+		t.Fatalf("applyTrailerMetadataToPath failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Fatalf("unexpected mode: %v", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected mtime: got %v want %v", info.ModTime(), mtime)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if got := time.Unix(st.Atim.Unix()); !got.Equal(atime) {
+		t.Fatalf("unexpected atime: got %v want %v", got, atime)
+	}
+	if xattrsSupported {
+		buf := make([]byte, 64)
+		n, err := unix.Getxattr(path, "user.pinch.test", buf)
+		if err != nil || string(buf[:n]) != "value" {
+			t.Fatalf("unexpected xattr: %q err=%v", buf[:n], err)
+		}
+		meta.XattrsTruncated = true
+		if err := applyTrailerMetadataToPath(path, meta, preserve); err == nil {
+			t.Fatalf("expected error restoring truncated xattrs")
+		}
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := applyTrailerMetadataToPath(path, meta, preserveOptions{}); err != nil {
+		t.Fatalf("applyTrailerMetadataToPath with nothing preserved failed: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Fatalf("mode changed without --preserve mode: %v", info.Mode().Perm())
+	}
+}
+
+func TestApplyTrailerMetadataSetsXattrsBeforeReadOnlyMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.txt")
+	if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if unix.Setxattr(path, "user.pinch.probe", []byte("1"), 0) != nil {
+		t.Skip("filesystem does not support user xattrs")
+	}
+	// Without write permission a non-root owner cannot set user.* xattrs,
+	// so a 0444 source only restores if the xattrs land first.
+	meta := &FileTrailerMetadata{
+		Mode:   "444",
+		Xattrs: map[string][]byte{"user.pinch.test": []byte("value")},
+	}
+	if err := applyTrailerMetadataToPath(path, meta, preserveOptions{Mode: true, Xattrs: true}); err != nil {
+		t.Fatalf("applyTrailerMetadataToPath failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != 0o444 {
+		t.Fatalf("unexpected mode: %v", info.Mode().Perm())
+	}
+	buf := make([]byte, 64)
+	n, err := unix.Getxattr(path, "user.pinch.test", buf)
+	if err != nil || string(buf[:n]) != "value" {
+		t.Fatalf("unexpected xattr: %q err=%v", buf[:n], err)
+	}
+}
+
 func TestVerboseProgressReporterIncludesAckedBytes(t *testing.T) {
 	var stderr bytes.Buffer
 	reporter := newVerboseProgressReporter(&stderr)
@@ -800,3 +1891,24 @@ func TestVerboseProgressReporterConcurrentUse(t *testing.T) {
 		t.Fatalf("expected fd=2 progress lines, got %q", out)
 	}
 }
+
+func TestProgressWriterResetDropsQueuedProgress(t *testing.T) {
+	progressPath := filepath.Join(t.TempDir(), "tx.fm2.progress")
+	updates := make(chan DownloadProgressUpdate, 4)
+	stop, _, reset, _ := startProgressWriter(progressPath, map[uint64]ManifestProgress{1: {AckBytes: 3}}, updates, nil, io.Discard)
+	updates <- DownloadProgressUpdate{FileID: 0, AckBytes: 7}
+	updates <- DownloadProgressUpdate{FileID: 1, AckBytes: 9}
+	reset(1)
+	stop()
+
+	state, err := loadProgressState(progressPath)
+	if err != nil {
+		t.Fatalf("loadProgressState failed: %v", err)
+	}
+	if _, ok := state[1]; ok {
+		t.Fatalf("expected reset file to be dropped, got %+v", state)
+	}
+	if state[0].AckBytes != 7 {
+		t.Fatalf("expected other progress kept, got %+v", state)
+	}
+}
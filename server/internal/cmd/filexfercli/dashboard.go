@@ -0,0 +1,483 @@
+package filexfercli
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/jolynch/pinch/filexfer"
+	"github.com/jolynch/pinch/internal/filexfer/encoding"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	dashboardAuto  = "auto"
+	dashboardTTY   = "tty"
+	dashboardLines = "lines"
+	dashboardOff   = "off"
+)
+
+const (
+	defaultDashboardRefresh      = 250 * time.Millisecond
+	defaultDashboardLineInterval = 10 * time.Second
+	// dashboardMaxErrors is how many of the latest errors the dashboard
+	// keeps on screen.
+	dashboardMaxErrors = 5
+	// dashboardMaxRows caps the per-file rows; a terminal shorter than the
+	// frame shows fewer.
+	dashboardMaxRows = 16
+	// dashboardRateSmoothing weights the newest sample of a rate.
+	dashboardRateSmoothing = 0.3
+)
+
+// resolveDashboardMode maps --dashboard to tty, lines or off; auto is tty
+// when w is a terminal and lines otherwise.
+func resolveDashboardMode(raw string, w io.Writer) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", dashboardAuto:
+		if isTerminal(w) {
+			return dashboardTTY, nil
+		}
+		return dashboardLines, nil
+	case dashboardTTY:
+		return dashboardTTY, nil
+	case dashboardLines:
+		return dashboardLines, nil
+	case dashboardOff:
+		return dashboardOff, nil
+	default:
+		return "", errors.New("must be auto, tty, lines or off")
+	}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalSize returns w's columns and rows, or 100x30 when unknown.
+func terminalSize(w io.Writer) (int, int) {
+	if f, ok := w.(*os.File); ok {
+		if ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 && ws.Row > 0 {
+			return int(ws.Col), int(ws.Row)
+		}
+	}
+	return 100, 30
+}
+
+type dashboardFile struct {
+	path      string
+	target    int64
+	copied    int64
+	acked     int64
+	startedAt time.Time
+	// lastBytes is copied at the previous sample of rate.
+	lastBytes int64
+	rate      float64
+}
+
+// startDashboard summarizes a running start. In tty mode it redraws a frame
+// at the bottom of the terminal, with every other line the command prints
+// scrolling above it; in lines mode it prints one start-progress line per
+// interval. It is fed by the progress updates and file completions start
+// already consumes.
+type startDashboard struct {
+	mu       sync.Mutex
+	mode     string
+	w        io.Writer
+	now      func() time.Time
+	interval time.Duration
+
+	txferID    string
+	entries    map[uint64]ManifestEntry
+	totalFiles int
+	totalBytes int64
+	doneFiles  int
+	doneBytes  int64
+	// bytes is each file's received bytes, counting what earlier runs acked.
+	bytes      map[uint64]int64
+	active     map[uint64]*dashboardFile
+	compCounts map[string]uint64
+	errors     []string
+	errorCount int
+
+	startedAt  time.Time
+	lastSample time.Time
+	lastBytes  int64
+	rate       float64
+	// drawn is how many lines of the tty frame are on screen.
+	drawn int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newStartDashboard(mode string, w io.Writer) *startDashboard {
+	interval := defaultDashboardLineInterval
+	if mode == dashboardTTY {
+		interval = defaultDashboardRefresh
+	}
+	return &startDashboard{
+		mode:       mode,
+		w:          w,
+		now:        time.Now,
+		interval:   interval,
+		entries:    make(map[uint64]ManifestEntry),
+		bytes:      make(map[uint64]int64),
+		active:     make(map[uint64]*dashboardFile),
+		compCounts: make(map[string]uint64),
+	}
+}
+
+// wrap returns a writer whose lines print above the tty frame; other modes
+// return w unchanged.
+func (d *startDashboard) wrap(w io.Writer) io.Writer {
+	if d.mode != dashboardTTY {
+		return w
+	}
+	return dashboardWriter{d: d, w: w}
+}
+
+type dashboardWriter struct {
+	d *startDashboard
+	w io.Writer
+}
+
+func (dw dashboardWriter) Write(p []byte) (int, error) {
+	dw.d.mu.Lock()
+	defer dw.d.mu.Unlock()
+	dw.d.clearLocked()
+	return dw.w.Write(p)
+}
+
+// begin records what the run covers and starts drawing. completed is how
+// many files were already done before any download.
+func (d *startDashboard) begin(manifest *Manifest, completed int64) {
+	if d.mode == dashboardOff {
+		return
+	}
+	d.mu.Lock()
+	d.txferID = manifest.TransferID
+	d.totalFiles = len(manifest.Entries)
+	d.doneFiles = int(completed)
+	for _, entry := range manifest.Entries {
+		d.entries[entry.ID] = entry
+		d.totalBytes += entry.Size
+		acked := clampInt64(entry.Progress.AckBytes, 0, entry.Size)
+		d.bytes[entry.ID] = acked
+		d.doneBytes += acked
+	}
+	d.startedAt = d.now()
+	d.lastSample = d.startedAt
+	d.lastBytes = d.doneBytes
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	d.mu.Unlock()
+
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.tick()
+			}
+		}
+	}()
+}
+
+// end stops drawing; a tty keeps the last frame on screen.
+func (d *startDashboard) end() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	d.stop = nil
+	if d.mode == dashboardTTY {
+		d.tick()
+		d.mu.Lock()
+		d.drawn = 0
+		d.mu.Unlock()
+	}
+}
+
+func (d *startDashboard) update(update DownloadProgressUpdate) {
+	if d.mode == dashboardOff || update.TargetBytes <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f := d.active[update.FileID]
+	if f == nil {
+		entry, ok := d.entries[update.FileID]
+		if !ok {
+			return
+		}
+		f = &dashboardFile{
+			path:      entry.Path,
+			acked:     clampInt64(entry.Progress.AckBytes, 0, entry.Size),
+			startedAt: d.now(),
+			lastBytes: d.bytes[update.FileID],
+		}
+		d.active[update.FileID] = f
+	}
+	f.target = update.TargetBytes
+	f.copied = max(f.copied, clampInt64(update.CopiedBytes, 0, f.target))
+	f.acked = max(f.acked, clampInt64(update.AckBytes, 0, f.target))
+	d.addBytesLocked(update.FileID, max(f.copied, f.acked))
+}
+
+func (d *startDashboard) addBytesLocked(fileID uint64, n int64) {
+	if prev := d.bytes[fileID]; n > prev {
+		d.doneBytes += n - prev
+		d.bytes[fileID] = n
+	}
+}
+
+func (d *startDashboard) fileDone(file outputFile) {
+	if d.mode == dashboardOff {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.active, file.FileID)
+	d.doneFiles++
+	d.addBytesLocked(file.FileID, d.entries[file.FileID].Size)
+	for comp, count := range file.CompCounts {
+		d.compCounts[comp] += count
+	}
+}
+
+// reset forgets a file's bytes when it is requeued at a new size.
+func (d *startDashboard) reset(fileID uint64, size int64) {
+	if d.mode == dashboardOff {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry := d.entries[fileID]
+	d.doneBytes -= d.bytes[fileID]
+	d.totalBytes += size - entry.Size
+	entry.Size = size
+	d.entries[fileID] = entry
+	d.bytes[fileID] = 0
+	delete(d.active, fileID)
+}
+
+func (d *startDashboard) addError(err error) {
+	if d.mode == dashboardOff {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errorCount++
+	d.errors = append(d.errors, err.Error())
+	if len(d.errors) > dashboardMaxErrors {
+		d.errors = d.errors[len(d.errors)-dashboardMaxErrors:]
+	}
+}
+
+func (d *startDashboard) tick() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sampleLocked(d.now())
+	if d.mode == dashboardLines {
+		fmt.Fprintln(d.w, d.summaryLocked())
+		return
+	}
+	width, height := terminalSize(d.w)
+	lines := d.frameLocked(max(height-10, 1))
+	d.clearLocked()
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(truncateDisplay(line, width-1))
+		b.WriteByte('\n')
+	}
+	_, _ = io.WriteString(d.w, b.String())
+	d.drawn = len(lines)
+}
+
+// clearLocked erases the tty frame so the next write starts where it
+// began.
+func (d *startDashboard) clearLocked() {
+	if d.drawn == 0 {
+		return
+	}
+	fmt.Fprintf(d.w, "\x1b[%dF\x1b[J", d.drawn)
+	d.drawn = 0
+}
+
+// sampleLocked updates the smoothed overall and per-file rates.
+func (d *startDashboard) sampleLocked(now time.Time) {
+	elapsed := now.Sub(d.lastSample).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	d.rate = smoothRate(d.rate, float64(d.doneBytes-d.lastBytes)/elapsed)
+	d.lastBytes = d.doneBytes
+	d.lastSample = now
+	for fileID, f := range d.active {
+		bytes := d.bytes[fileID]
+		f.rate = smoothRate(f.rate, float64(bytes-f.lastBytes)/elapsed)
+		f.lastBytes = bytes
+	}
+}
+
+func smoothRate(prev float64, sample float64) float64 {
+	if prev <= 0 {
+		return sample
+	}
+	return prev + dashboardRateSmoothing*(sample-prev)
+}
+
+func (d *startDashboard) etaLocked() string {
+	if d.rate <= 0 {
+		return "n/a"
+	}
+	return humanETA(time.Duration(float64(d.totalBytes-d.doneBytes) / d.rate * float64(time.Second)))
+}
+
+// ackLagLocked is the bytes received but not yet acked across active
+// files.
+func (d *startDashboard) ackLagLocked() int64 {
+	var lag int64
+	for _, f := range d.active {
+		lag += max(f.copied-f.acked, 0)
+	}
+	return lag
+}
+
+func (d *startDashboard) compMixLocked() string {
+	var total uint64
+	comps := make([]string, 0, len(d.compCounts))
+	for comp, count := range d.compCounts {
+		total += count
+		comps = append(comps, comp)
+	}
+	if total == 0 {
+		return "-"
+	}
+	slices.SortFunc(comps, func(a, b string) int {
+		if c := cmp.Compare(d.compCounts[b], d.compCounts[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	parts := make([]string, 0, len(comps))
+	for _, comp := range comps {
+		parts = append(parts, fmt.Sprintf("%s=%.0f%%", comp, float64(d.compCounts[comp])*100/float64(total)))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (d *startDashboard) percentLocked() float64 {
+	if d.totalBytes <= 0 {
+		return 100
+	}
+	return float64(d.doneBytes) * 100 / float64(d.totalBytes)
+}
+
+func (d *startDashboard) summaryLocked() string {
+	return fmt.Sprintf(
+		"start-progress: tid=%s files=%d/%d bytes=%s/%s (%.1f%%) rate=%s eta=%s active=%d ack-lag=%s comp=%s errors=%d",
+		d.txferID,
+		d.doneFiles,
+		d.totalFiles,
+		encoding.HumanBytes(d.doneBytes),
+		encoding.HumanBytes(d.totalBytes),
+		d.percentLocked(),
+		encoding.HumanRate(d.rate),
+		d.etaLocked(),
+		len(d.active),
+		encoding.HumanBytes(d.ackLagLocked()),
+		d.compMixLocked(),
+		d.errorCount,
+	)
+}
+
+// frameLocked renders the tty frame with at most maxRows per-file rows.
+func (d *startDashboard) frameLocked(maxRows int) []string {
+	lines := []string{
+		fmt.Sprintf("pinch start %s  elapsed %s", d.txferID, d.now().Sub(d.startedAt).Round(time.Second)),
+		fmt.Sprintf(
+			"  total  %s  %s/%s  files %d/%d  rate %s  eta %s",
+			progressBar(d.percentLocked(), 20),
+			encoding.HumanBytes(d.doneBytes),
+			encoding.HumanBytes(d.totalBytes),
+			d.doneFiles,
+			d.totalFiles,
+			encoding.HumanRate(d.rate),
+			d.etaLocked(),
+		),
+		fmt.Sprintf("  comp   %s  ack-lag %s", d.compMixLocked(), encoding.HumanBytes(d.ackLagLocked())),
+		fmt.Sprintf("  active %d", len(d.active)),
+	}
+	fileIDs := make([]uint64, 0, len(d.active))
+	for fileID := range d.active {
+		fileIDs = append(fileIDs, fileID)
+	}
+	slices.SortFunc(fileIDs, func(a, b uint64) int {
+		if c := d.active[a].startedAt.Compare(d.active[b].startedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	rows := min(len(fileIDs), maxRows, dashboardMaxRows)
+	for _, fileID := range fileIDs[:rows] {
+		f := d.active[fileID]
+		pct := 100.0
+		if f.target > 0 {
+			pct = float64(d.bytes[fileID]) * 100 / float64(f.target)
+		}
+		lines = append(lines, fmt.Sprintf(
+			"    fd=%-6d %5.1f%% %10s  lag %9s  %s",
+			fileID,
+			pct,
+			encoding.HumanRate(f.rate),
+			encoding.HumanBytes(max(f.copied-f.acked, 0)),
+			f.path,
+		))
+	}
+	if more := len(fileIDs) - rows; more > 0 {
+		lines = append(lines, fmt.Sprintf("    ... %d more", more))
+	}
+	if d.errorCount > 0 {
+		lines = append(lines, fmt.Sprintf("  errors %d", d.errorCount))
+		for _, msg := range d.errors {
+			lines = append(lines, "    "+msg)
+		}
+	}
+	return lines
+}
+
+func progressBar(pct float64, width int) string {
+	filled := int(pct / 100 * float64(width))
+	filled = min(max(filled, 0), width)
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// truncateDisplay keeps a frame line on one terminal row.
+func truncateDisplay(line string, width int) string {
+	runes := []rune(line)
+	if width <= 0 || len(runes) <= width {
+		return line
+	}
+	if width <= 3 {
+		return string(runes[:width])
+	}
+	return string(runes[:width-3]) + "..."
+}
@@ -0,0 +1,92 @@
+package filexfercli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/jolynch/pinch/filexfer"
+)
+
+func newTestDashboard(t *testing.T, mode string, w *bytes.Buffer) (*startDashboard, *time.Time) {
+	t.Helper()
+	now := time.Unix(1000, 0)
+	d := newStartDashboard(mode, w)
+	d.now = func() time.Time { return now }
+	d.interval = time.Hour
+	manifest := &Manifest{TransferID: "txdash", Entries: []ManifestEntry{
+		{ID: 0, Size: 100, Path: "a.bin"},
+		{ID: 1, Size: 300, Path: "b.bin", Progress: ManifestProgress{AckBytes: 100}},
+		{ID: 2, Size: 600, Path: "c.bin"},
+	}}
+	d.begin(manifest, 0)
+	t.Cleanup(d.end)
+	return d, &now
+}
+
+func TestStartDashboardSummaryLine(t *testing.T) {
+	var buf bytes.Buffer
+	d, now := newTestDashboard(t, dashboardLines, &buf)
+
+	d.update(DownloadProgressUpdate{FileID: 1, CopiedBytes: 200, TargetBytes: 300})
+	d.update(DownloadProgressUpdate{FileID: 2, CopiedBytes: 300, TargetBytes: 600, AckBytes: 100})
+	d.update(DownloadProgressUpdate{FileID: 0, CopiedBytes: 100, TargetBytes: 100})
+	d.fileDone(outputFile{FileID: 0, CompCounts: map[string]uint64{"zstd": 3, "none": 1}})
+	d.addError(errors.New("id=9 boom"))
+	*now = now.Add(2 * time.Second)
+	d.tick()
+
+	want := "start-progress: tid=txdash files=1/3 bytes=600 B/1000 B (60.0%) rate=250 B/s eta=2s active=2 ack-lag=300 B comp=zstd=75%,none=25% errors=1\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected summary line:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestStartDashboardFrameStaysBelowOutput(t *testing.T) {
+	var buf bytes.Buffer
+	d, _ := newTestDashboard(t, dashboardTTY, &buf)
+	d.update(DownloadProgressUpdate{FileID: 2, CopiedBytes: 300, TargetBytes: 600})
+	d.addError(errors.New("id=9 boom"))
+	d.tick()
+
+	frame := buf.String()
+	for _, want := range []string{"pinch start txdash", "files 0/3", "fd=2", "c.bin", "errors 1", "id=9 boom"} {
+		if !strings.Contains(frame, want) {
+			t.Fatalf("frame missing %q:\n%s", want, frame)
+		}
+	}
+	drawn := strings.Count(frame, "\n")
+
+	buf.Reset()
+	stdout := d.wrap(&buf)
+	if _, err := stdout.Write([]byte("start-file: fd=0\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	clear := fmt.Sprintf("\x1b[%dF\x1b[J", drawn)
+	if got := buf.String(); got != clear+"start-file: fd=0\n" {
+		t.Fatalf("expected the frame cleared before output, got %q", got)
+	}
+	buf.Reset()
+	if _, err := stdout.Write([]byte("next\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if got := buf.String(); got != "next\n" {
+		t.Fatalf("expected no clear without a frame, got %q", got)
+	}
+}
+
+func TestResolveDashboardMode(t *testing.T) {
+	var buf bytes.Buffer
+	if mode, err := resolveDashboardMode("auto", &buf); err != nil || mode != dashboardLines {
+		t.Fatalf("auto on a buffer: mode=%q err=%v", mode, err)
+	}
+	if mode, err := resolveDashboardMode("TTY", &buf); err != nil || mode != dashboardTTY {
+		t.Fatalf("tty: mode=%q err=%v", mode, err)
+	}
+	if _, err := resolveDashboardMode("fancy", &buf); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
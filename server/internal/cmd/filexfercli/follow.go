@@ -0,0 +1,203 @@
+package filexfercli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	. "github.com/jolynch/pinch/filexfer"
+)
+
+const (
+	defaultFollowSettle = time.Second
+	// maxFollowWatchFailures is how many WATCH connections in a row may
+	// fail before follow gives up on the server.
+	maxFollowWatchFailures = 5
+)
+
+// applyFollowEvent folds one WATCH event into the local manifest and
+// progress state. Growth keeps acked bytes so the next round appends the new
+// tail; a replaced file starts over.
+func applyFollowEvent(manifest *Manifest, progress map[uint64]ManifestProgress, event WatchEvent) error {
+	idx := -1
+	for i, entry := range manifest.Entries {
+		if entry.ID == event.Entry.ID {
+			idx = i
+			break
+		}
+	}
+	switch event.Kind {
+	case WatchEventAdd:
+		if idx >= 0 {
+			manifest.Entries[idx] = event.Entry
+		} else {
+			manifest.Entries = append(manifest.Entries, event.Entry)
+		}
+		delete(progress, event.Entry.ID)
+		return nil
+	case WatchEventGrow, WatchEventReplace:
+		if idx < 0 {
+			return fmt.Errorf("fd=%d not in manifest", event.Entry.ID)
+		}
+		entry := &manifest.Entries[idx]
+		entry.Size = event.Entry.Size
+		entry.Mtime = event.Entry.Mtime
+		entry.HoleBytes = 0
+		if event.Kind == WatchEventReplace {
+			delete(progress, entry.ID)
+			return nil
+		}
+		if state, ok := progress[entry.ID]; ok {
+			state.MetadataDone = false
+			progress[entry.ID] = state
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown watch event %q", event.Kind)
+	}
+}
+
+func runFollowCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("follow", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var txferID string
+	var manifestPath string
+	var outRoot string
+	var encryptMode string
+	var settle time.Duration
+	fs.StringVar(&txferID, "tid", "", "transfer id")
+	fs.StringVar(&manifestPath, "manifest", "", "path to manifest file (default: <tid>.fm2)")
+	fs.StringVar(&outRoot, "out-root", ".", "output root directory, or s3://bucket/prefix to upload")
+	fs.StringVar(&encryptMode, "encrypt", "", "response encryption mode (supported: age)")
+	fs.DurationVar(&settle, "settle", defaultFollowSettle, "wait this long after a change for more before downloading")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if settle < 0 {
+		fmt.Fprintln(stderr, "--settle must be >= 0")
+		return 2
+	}
+	enc, err := resolveEncryptionOptions(serverURL, encryptMode)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --encrypt: %v\n", err)
+		return 2
+	}
+	manifest, resolvedManifestPath, resolvedTxferID, err := loadManifestWithOptionalTID("follow", txferID, manifestPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "load manifest failed: %v\n", err)
+		return 1
+	}
+	progressPath := resolvedManifestPath + ".progress"
+	// Growing files change between TXFER and SEND by design, so follow
+	// ignores that unless the caller asks otherwise after "--".
+	startArgs := []string{"--manifest", resolvedManifestPath, "--out-root", outRoot, "--consistency", ConsistencyIgnore}
+	if encryptMode != "" {
+		startArgs = append(startArgs, "--encrypt", encryptMode)
+	}
+	startArgs = append(startArgs, fs.Args()...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Watch before catching up so nothing that changes during the first
+	// round is missed.
+	events := make(chan WatchEvent, 1024)
+	watchDone := make(chan error, 1)
+	client := newCLIClient(serverURL, enc)
+	go keepTransferRenewed(ctx, client, resolvedTxferID, stderr)
+	go func() {
+		failures := 0
+		for {
+			err := client.WatchTransfer(ctx, WatchTransferRequest{TransferID: resolvedTxferID, Sparse: true}, func(event WatchEvent) error {
+				failures = 0
+				select {
+				case events <- event:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			if ctx.Err() != nil {
+				watchDone <- nil
+				return
+			}
+			failures++
+			if failures >= maxFollowWatchFailures {
+				watchDone <- err
+				return
+			}
+			fmt.Fprintf(stderr, "follow: watch interrupted: %v; reconnecting\n", err)
+			select {
+			case <-time.After(time.Duration(failures) * time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	fmt.Fprintf(stdout, "follow: tid=%s out-root=%s catching up\n", resolvedTxferID, outRoot)
+	if code := runStartCLI(serverURL, startArgs, stdout, stderr); code == 2 {
+		return code
+	}
+	for {
+		var batch []WatchEvent
+		select {
+		case <-ctx.Done():
+			return 0
+		case err := <-watchDone:
+			if err != nil {
+				fmt.Fprintf(stderr, "follow failed: %v\n", err)
+				return 1
+			}
+			return 0
+		case event := <-events:
+			batch = append(batch, event)
+		}
+		timer := time.NewTimer(settle)
+	collect:
+		for {
+			select {
+			case event := <-events:
+				batch = append(batch, event)
+			case <-timer.C:
+				break collect
+			case <-ctx.Done():
+				timer.Stop()
+				return 0
+			}
+		}
+
+		progressState, err := loadProgressState(progressPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "load progress failed: %v\n", err)
+			return 1
+		}
+		var applyErrs []error
+		for _, event := range batch {
+			if err := applyFollowEvent(manifest, progressState, event); err != nil {
+				applyErrs = append(applyErrs, err)
+				continue
+			}
+			fmt.Fprintf(stdout, "follow-event: %s fd=%d size=%d\n", event.Kind, event.Entry.ID, event.Entry.Size)
+		}
+		if err := errors.Join(applyErrs...); err != nil {
+			fmt.Fprintf(stderr, "follow: ignoring events: %v\n", err)
+		}
+		if err := SaveManifest(resolvedManifestPath, manifest); err != nil {
+			fmt.Fprintf(stderr, "save manifest failed: %v\n", err)
+			return 1
+		}
+		if err := writeProgressState(progressPath, progressState); err != nil {
+			fmt.Fprintf(stderr, "write progress failed: %v\n", err)
+			return 1
+		}
+		// A failed round is retried with the next batch of changes; start
+		// resumes every file from its acked offset.
+		_ = runStartCLI(serverURL, startArgs, stdout, stderr)
+	}
+}
@@ -0,0 +1,47 @@
+package filexfercli
+
+import (
+	"testing"
+
+	. "github.com/jolynch/pinch/filexfer"
+)
+
+func TestApplyFollowEventTracksGrowthAndReplacement(t *testing.T) {
+	manifest := &Manifest{
+		TransferID: "txfollow",
+		Root:       "/remote",
+		Entries: []ManifestEntry{
+			{ID: 0, Size: 5, Mtime: 1, Path: "grow.log"},
+			{ID: 1, Size: 9, Mtime: 1, Path: "rotate.log"},
+		},
+	}
+	progress := map[uint64]ManifestProgress{
+		0: {AckBytes: 5, MetadataDone: true},
+		1: {AckBytes: 9, MetadataDone: true},
+	}
+	events := []WatchEvent{
+		{Kind: WatchEventGrow, Entry: ManifestEntry{ID: 0, Size: 12, Mtime: 2}},
+		{Kind: WatchEventReplace, Entry: ManifestEntry{ID: 1, Size: 3, Mtime: 2}},
+		{Kind: WatchEventAdd, Entry: ManifestEntry{ID: 2, Size: 4, Mtime: 2, Mode: 0o644, Path: "new.log"}},
+	}
+	for _, event := range events {
+		if err := applyFollowEvent(manifest, progress, event); err != nil {
+			t.Fatalf("applyFollowEvent(%s) failed: %v", event.Kind, err)
+		}
+	}
+	if got := manifest.Entries[0]; got.Size != 12 || got.Mtime != 2 {
+		t.Fatalf("expected grown entry, got %+v", got)
+	}
+	if got := progress[0]; got.AckBytes != 5 || got.MetadataDone {
+		t.Fatalf("expected grown file to resume at its acked offset, got %+v", got)
+	}
+	if _, ok := progress[1]; ok {
+		t.Fatalf("expected replaced file progress dropped")
+	}
+	if entry, ok := manifest.EntryByID(2); !ok || entry.Path != "new.log" {
+		t.Fatalf("expected added entry in manifest, got %+v ok=%t", entry, ok)
+	}
+	if err := applyFollowEvent(manifest, progress, WatchEvent{Kind: WatchEventGrow, Entry: ManifestEntry{ID: 7, Size: 1}}); err == nil {
+		t.Fatalf("expected error for growth of an unknown file id")
+	}
+}
@@ -0,0 +1,537 @@
+package filexfercli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	. "github.com/jolynch/pinch/filexfer"
+)
+
+// The client key store lives in a config directory with this layout:
+//
+//	identities/<name>.key  age identity, same format as the server's keys/key
+//	known_servers          one "<file-listener> <age-recipient> identity=<name>" per line
+//
+// Server keys are pinned per file-listener address, either explicitly with
+// `trust --key` or on first use from the key the server announces in PROBE.
+const (
+	configDirEnv        = "PINCH_CONFIG_DIR"
+	serverPublicKeyEnv  = "PINCH_FILE_SERVER_AGE_PUBLIC_KEY"
+	defaultIdentityName = "default"
+	identitiesDirName   = "identities"
+	knownServersName    = "known_servers"
+)
+
+type keyStore struct {
+	dir string
+}
+
+type knownServer struct {
+	Addr      string
+	PublicKey string
+	Identity  string
+}
+
+type encryptionOptions struct {
+	AgePublicKey       string
+	AgeIdentity        string
+	ServerAgePublicKey string
+}
+
+func resolveConfigDir() (string, error) {
+	if dir := strings.TrimSpace(os.Getenv(configDirEnv)); dir != "" {
+		return dir, nil
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve config directory (set %s): %w", configDirEnv, err)
+	}
+	return filepath.Join(base, "pinch"), nil
+}
+
+func openKeyStore() (*keyStore, error) {
+	dir, err := resolveConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return &keyStore{dir: dir}, nil
+}
+
+func validateIdentityName(name string) error {
+	if name == "" {
+		return errors.New("identity name must not be empty")
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return fmt.Errorf("invalid identity name %q (allowed: letters, digits, '-', '_', '.')", name)
+		}
+	}
+	if strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid identity name %q (must not start with '.')", name)
+	}
+	return nil
+}
+
+func (ks *keyStore) identityPath(name string) string {
+	return filepath.Join(ks.dir, identitiesDirName, name+".key")
+}
+
+func (ks *keyStore) loadIdentity(name string) (*age.X25519Identity, error) {
+	if err := validateIdentityName(name); err != nil {
+		return nil, err
+	}
+	keyPath := ks.identityPath(name)
+	raw, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, parseErr := age.ParseX25519Identity(line)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid identity file %s: %w", keyPath, parseErr)
+		}
+		return identity, nil
+	}
+	return nil, fmt.Errorf("identity file %s has no identity", keyPath)
+}
+
+func (ks *keyStore) generateIdentity(name string, force bool) (*age.X25519Identity, error) {
+	if err := validateIdentityName(name); err != nil {
+		return nil, err
+	}
+	keyPath := ks.identityPath(name)
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0o700); err != nil {
+		return nil, fmt.Errorf("create identities directory: %w", err)
+	}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, fmt.Errorf("generate age identity: %w", err)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	out, err := os.OpenFile(keyPath, flags, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("identity %q already exists at %s (use --force to replace)", name, keyPath)
+		}
+		return nil, fmt.Errorf("open identity file %s: %w", keyPath, err)
+	}
+	fmt.Fprintf(out, "# created: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(out, "# public key: %s\n", identity.Recipient())
+	fmt.Fprintf(out, "%s\n", identity)
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("write identity file %s: %w", keyPath, err)
+	}
+	return identity, nil
+}
+
+func (ks *keyStore) identityNames() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(ks.dir, identitiesDirName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".key")
+		if !ok || entry.IsDir() || validateIdentityName(name) != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (ks *keyStore) knownServers() ([]knownServer, error) {
+	knownPath := filepath.Join(ks.dir, knownServersName)
+	fd, err := os.Open(knownPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+
+	var servers []knownServer
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid %s line: %q", knownPath, line)
+		}
+		if _, err := age.ParseX25519Recipient(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid %s key for %s: %w", knownPath, parts[0], err)
+		}
+		server := knownServer{Addr: parts[0], PublicKey: parts[1]}
+		if len(parts) == 3 {
+			name, ok := strings.CutPrefix(parts[2], "identity=")
+			if !ok {
+				return nil, fmt.Errorf("invalid %s line: %q", knownPath, line)
+			}
+			server.Identity = name
+		}
+		servers = append(servers, server)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+func (ks *keyStore) lookupServer(addr string) (knownServer, bool, error) {
+	servers, err := ks.knownServers()
+	if err != nil {
+		return knownServer{}, false, err
+	}
+	for _, server := range servers {
+		if server.Addr == addr {
+			return server, true, nil
+		}
+	}
+	return knownServer{}, false, nil
+}
+
+// trustServer pins the server key for server.Addr, replacing any previous entry.
+func (ks *keyStore) trustServer(server knownServer) error {
+	servers, err := ks.knownServers()
+	if err != nil {
+		return err
+	}
+	servers = slices.DeleteFunc(servers, func(s knownServer) bool { return s.Addr == server.Addr })
+	servers = append(servers, server)
+	slices.SortFunc(servers, func(a, b knownServer) int { return strings.Compare(a.Addr, b.Addr) })
+
+	if err := os.MkdirAll(ks.dir, 0o700); err != nil {
+		return fmt.Errorf("create config directory: %w", err)
+	}
+	knownPath := filepath.Join(ks.dir, knownServersName)
+	tmpPath := knownPath + ".tmp"
+	fd, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	for _, s := range servers {
+		line := s.Addr + " " + s.PublicKey
+		if s.Identity != "" {
+			line += " identity=" + s.Identity
+		}
+		if _, err := fmt.Fprintln(fd, line); err != nil {
+			_ = fd.Close()
+			return err
+		}
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, knownPath)
+}
+
+// resolveEncryptionOptions picks the client identity and server key for
+// serverURL. An empty mode encrypts only once the server key is pinned,
+// which `trust` or the first `transfer` against the address does; until
+// then it returns no options and warnUnencrypted tells the user. "age"
+// always encrypts responses, falling back to a fresh identity when none
+// has been generated with keygen.
+func resolveEncryptionOptions(serverURL string, mode string) (encryptionOptions, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "", "none", "age":
+	default:
+		return encryptionOptions{}, fmt.Errorf("unsupported --encrypt value %q (only \"age\" is supported)", mode)
+	}
+	if mode == "none" {
+		return encryptionOptions{}, nil
+	}
+	ks, err := openKeyStore()
+	if err != nil {
+		if mode == "" {
+			return encryptionOptions{}, nil
+		}
+		return ephemeralEncryptionOptions()
+	}
+	server, pinned, err := ks.lookupServer(serverURL)
+	if err != nil {
+		return encryptionOptions{}, err
+	}
+	if !pinned && mode == "" {
+		return encryptionOptions{}, nil
+	}
+	identityName := server.Identity
+	if identityName == "" {
+		identityName = defaultIdentityName
+	}
+	identity, err := ks.loadIdentity(identityName)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || server.Identity != "" {
+			return encryptionOptions{}, fmt.Errorf("load identity %q: %w", identityName, err)
+		}
+		opts, genErr := ephemeralEncryptionOptions()
+		if genErr != nil {
+			return encryptionOptions{}, genErr
+		}
+		opts.ServerAgePublicKey = server.PublicKey
+		return opts, nil
+	}
+	return encryptionOptions{
+		AgePublicKey:       identity.Recipient().String(),
+		AgeIdentity:        identity.String(),
+		ServerAgePublicKey: server.PublicKey,
+	}, nil
+}
+
+// warnUnencrypted notes on stderr that the default mode found no pinned key
+// for serverURL, so responses travel in plaintext.
+func warnUnencrypted(stderr io.Writer, serverURL string, mode string, enc encryptionOptions) {
+	if strings.TrimSpace(mode) != "" || enc.AgeIdentity != "" {
+		return
+	}
+	fmt.Fprintf(stderr, "warning: no key pinned for %s, responses are not encrypted (run \"pinch cli %s trust\" or pass --encrypt none)\n", serverURL, serverURL)
+}
+
+// errServerKeyChanged is returned when a server announces a key other than
+// the one pinned for its address and no longer accepts the pinned one.
+var errServerKeyChanged = errors.New("server key changed")
+
+// serverKeyPin is what pinServerKeyOnFirstUse did with an announced key.
+type serverKeyPin int
+
+const (
+	serverKeyUnchanged serverKeyPin = iota
+	// serverKeyPinned: the address had no key, so the announced one was pinned.
+	serverKeyPinned
+	// serverKeyRotated: the server still accepts the pinned key but announces
+	// a new primary, so the pin moved to it.
+	serverKeyRotated
+)
+
+// pinServerKeyOnFirstUse pins announced for serverURL when the address has
+// no key yet. accepted are every key the server takes, and sessionKey is the
+// key the announcing session was encrypted to: when that is the pinned key
+// and the server still accepts it, the server has proven it holds the pinned
+// identity, so the pin follows a rotation to announced. Otherwise a pinned
+// key that differs fails with errServerKeyChanged; only `trust --replace`
+// moves it.
+func pinServerKeyOnFirstUse(serverURL string, announced string, accepted []string, sessionKey string) (serverKeyPin, error) {
+	if announced == "" {
+		return serverKeyUnchanged, nil
+	}
+	if _, err := age.ParseX25519Recipient(announced); err != nil {
+		return serverKeyUnchanged, fmt.Errorf("server announced an invalid key: %w", err)
+	}
+	ks, err := openKeyStore()
+	if err != nil {
+		return serverKeyUnchanged, err
+	}
+	existing, pinned, err := ks.lookupServer(serverURL)
+	if err != nil {
+		return serverKeyUnchanged, err
+	}
+	if pinned {
+		if existing.PublicKey == announced {
+			return serverKeyUnchanged, nil
+		}
+		if sessionKey != existing.PublicKey || !slices.Contains(accepted, existing.PublicKey) {
+			return serverKeyUnchanged, fmt.Errorf("%w for %s: pinned=%s announced=%s (run \"pinch cli %s trust --replace\" to pin it, or pass --encrypt none)", errServerKeyChanged, serverURL, existing.PublicKey, announced, serverURL)
+		}
+		existing.PublicKey = announced
+		if err := ks.trustServer(existing); err != nil {
+			return serverKeyUnchanged, err
+		}
+		return serverKeyRotated, nil
+	}
+	if err := ks.trustServer(knownServer{Addr: serverURL, PublicKey: announced}); err != nil {
+		return serverKeyUnchanged, err
+	}
+	return serverKeyPinned, nil
+}
+
+func ephemeralEncryptionOptions() (encryptionOptions, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return encryptionOptions{}, fmt.Errorf("generate age identity: %w", err)
+	}
+	return encryptionOptions{
+		AgePublicKey: identity.Recipient().String(),
+		AgeIdentity:  identity.String(),
+	}, nil
+}
+
+// newCLIClient builds a client for serverURL that encrypts commands to the
+// pinned server key. An explicit PINCH_FILE_SERVER_AGE_PUBLIC_KEY still wins.
+func newCLIClient(serverURL string, enc encryptionOptions, opts ...ClientOption) *Client {
+	if enc.ServerAgePublicKey != "" && strings.TrimSpace(os.Getenv(serverPublicKeyEnv)) == "" {
+		opts = append(opts, WithServerAgePublicKey(enc.ServerAgePublicKey))
+	}
+	return NewClient(serverURL, opts...)
+}
+
+func runKeygenCLI(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var name string
+	var force bool
+	fs.StringVar(&name, "name", defaultIdentityName, "identity name")
+	fs.BoolVar(&force, "force", false, "replace an existing identity")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := validateIdentityName(name); err != nil {
+		fmt.Fprintf(stderr, "invalid --name: %v\n", err)
+		return 2
+	}
+	ks, err := openKeyStore()
+	if err != nil {
+		fmt.Fprintf(stderr, "keygen failed: %v\n", err)
+		return 1
+	}
+	identity, err := ks.generateIdentity(name, force)
+	if err != nil {
+		fmt.Fprintf(stderr, "keygen failed: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "identity: name=%s public-key=%s path=%s\n", name, identity.Recipient(), ks.identityPath(name))
+	return 0
+}
+
+func runListCLI(args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	ks, err := openKeyStore()
+	if err != nil {
+		fmt.Fprintf(stderr, "list failed: %v\n", err)
+		return 1
+	}
+	names, err := ks.identityNames()
+	if err != nil {
+		fmt.Fprintf(stderr, "list failed: %v\n", err)
+		return 1
+	}
+	servers, err := ks.knownServers()
+	if err != nil {
+		fmt.Fprintf(stderr, "list failed: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "config: dir=%s\n", ks.dir)
+	for _, name := range names {
+		identity, err := ks.loadIdentity(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "list: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(stdout, "identity: name=%s public-key=%s\n", name, identity.Recipient())
+	}
+	for _, server := range servers {
+		identityName := server.Identity
+		if identityName == "" {
+			identityName = defaultIdentityName
+		}
+		fmt.Fprintf(stdout, "server: addr=%s key=%s identity=%s\n", server.Addr, server.PublicKey, identityName)
+	}
+	return 0
+}
+
+func runTrustCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("trust", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var key string
+	var identityName string
+	var replace bool
+	fs.StringVar(&key, "key", "", "server age public key (default: key announced by the server)")
+	fs.StringVar(&identityName, "identity", "", "client identity to use for this server (default: "+defaultIdentityName+")")
+	fs.BoolVar(&replace, "replace", false, "replace a previously pinned key that differs")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	key = strings.TrimSpace(key)
+	if key != "" {
+		if _, err := age.ParseX25519Recipient(key); err != nil {
+			fmt.Fprintf(stderr, "invalid --key: %v\n", err)
+			return 2
+		}
+	}
+	if identityName != "" {
+		if err := validateIdentityName(identityName); err != nil {
+			fmt.Fprintf(stderr, "invalid --identity: %v\n", err)
+			return 2
+		}
+	}
+	ks, err := openKeyStore()
+	if err != nil {
+		fmt.Fprintf(stderr, "trust failed: %v\n", err)
+		return 1
+	}
+	if identityName != "" {
+		if _, err := ks.loadIdentity(identityName); err != nil {
+			fmt.Fprintf(stderr, "trust failed: load identity %q: %v\n", identityName, err)
+			return 1
+		}
+	}
+	existing, pinned, err := ks.lookupServer(serverURL)
+	if err != nil {
+		fmt.Fprintf(stderr, "trust failed: %v\n", err)
+		return 1
+	}
+	source := "flag"
+	if key == "" {
+		probe, err := NewClient(serverURL).ProbeLink(context.Background(), ProbeRequest{Samples: 1, ProbeBytes: 1})
+		if err != nil {
+			fmt.Fprintf(stderr, "trust failed: probe server key: %v (pass --key to pin it explicitly)\n", err)
+			return 1
+		}
+		if probe.ServerAgePublicKey == "" {
+			fmt.Fprintln(stderr, "trust failed: server did not announce a key (pass --key to pin it explicitly)")
+			return 1
+		}
+		if _, err := age.ParseX25519Recipient(probe.ServerAgePublicKey); err != nil {
+			fmt.Fprintf(stderr, "trust failed: server announced an invalid key: %v\n", err)
+			return 1
+		}
+		key = probe.ServerAgePublicKey
+		source = "probe"
+	}
+	if pinned && existing.PublicKey != key && !replace {
+		fmt.Fprintf(stderr, "trust failed: key for %s changed: pinned=%s new=%s (use --replace to accept)\n", serverURL, existing.PublicKey, key)
+		return 1
+	}
+	if identityName == "" {
+		identityName = existing.Identity
+	}
+	if err := ks.trustServer(knownServer{Addr: serverURL, PublicKey: key, Identity: identityName}); err != nil {
+		fmt.Fprintf(stderr, "trust failed: %v\n", err)
+		return 1
+	}
+	if identityName == "" {
+		identityName = defaultIdentityName
+	}
+	fmt.Fprintf(stdout, "trusted: addr=%s key=%s identity=%s source=%s\n", serverURL, key, identityName, source)
+	return 0
+}
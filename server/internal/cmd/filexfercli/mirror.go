@@ -0,0 +1,396 @@
+package filexfercli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	. "github.com/jolynch/pinch/filexfer"
+	"github.com/jolynch/pinch/internal/filexfer/encoding"
+	"github.com/jolynch/pinch/internal/filexfer/s3"
+	"github.com/zeebo/xxh3"
+)
+
+// mirrorFetch is a manifest entry whose local copy is missing or differs.
+type mirrorFetch struct {
+	Entry  ManifestEntry
+	Reason string // missing|type|size|mtime|checksum
+}
+
+// mirrorPlan is what mirror will do to make --out-root match the manifest.
+type mirrorPlan struct {
+	Fetch []mirrorFetch
+	Skip  []ManifestEntry
+	// Extra holds slash-separated paths under the out root that are not in
+	// the manifest (or are in the way of one), in walk order.
+	Extra []string
+}
+
+// mirrorCompare reports whether a local regular file with a matching size
+// holds the same content as entry. The default compares mtimes; --checksum
+// compares a CXSUM xxh128 hash with the local file instead.
+type mirrorCompare func(entry ManifestEntry, localPath string, info fs.FileInfo) (match bool, reason string, err error)
+
+func compareMirrorMtime(entry ManifestEntry, _ string, info fs.FileInfo) (bool, string, error) {
+	if info.ModTime().UnixNano() != entry.Mtime {
+		return false, "mtime", nil
+	}
+	return true, "", nil
+}
+
+// planMirror walks outRoot and sorts every manifest entry into fetch or skip,
+// collecting local paths that do not belong. Paths in keep (the manifest and
+// its progress files, the quarantine directory) are never treated as extras.
+func planMirror(manifest *Manifest, outRoot string, compare mirrorCompare, keep []string) (mirrorPlan, error) {
+	var plan mirrorPlan
+	wanted := make(map[string]bool, len(manifest.Entries))
+	for _, entry := range manifest.Entries {
+		wanted[entry.Path] = true
+		for dir := path.Dir(entry.Path); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if wanted[dir+"/"] {
+				break
+			}
+			wanted[dir+"/"] = true
+		}
+	}
+
+	for _, entry := range manifest.Entries {
+		localPath := resolveDownloadDestinationPath(entry, outRoot, "")
+		info, err := os.Lstat(localPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			plan.Fetch = append(plan.Fetch, mirrorFetch{Entry: entry, Reason: "missing"})
+			continue
+		case err != nil:
+			return mirrorPlan{}, err
+		case !info.Mode().IsRegular():
+			plan.Fetch = append(plan.Fetch, mirrorFetch{Entry: entry, Reason: "type"})
+			continue
+		case info.Size() != entry.Size:
+			plan.Fetch = append(plan.Fetch, mirrorFetch{Entry: entry, Reason: "size"})
+			continue
+		}
+		match, reason, err := compare(entry, localPath, info)
+		if err != nil {
+			return mirrorPlan{}, fmt.Errorf("compare %s: %w", entry.Path, err)
+		}
+		if match {
+			plan.Skip = append(plan.Skip, entry)
+		} else {
+			plan.Fetch = append(plan.Fetch, mirrorFetch{Entry: entry, Reason: reason})
+		}
+	}
+
+	keepSet := make(map[string]bool, len(keep))
+	for _, keepPath := range keep {
+		if abs, err := filepath.Abs(keepPath); err == nil {
+			keepSet[abs] = true
+		}
+	}
+	root, err := filepath.Abs(outRoot)
+	if err != nil {
+		return mirrorPlan{}, err
+	}
+	err = filepath.WalkDir(root, func(localPath string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if localPath == root && errors.Is(walkErr, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return walkErr
+		}
+		if localPath == root {
+			return nil
+		}
+		if keepSet[localPath] {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, localPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if wanted[rel+"/"] {
+				return nil
+			}
+			// A directory nobody wants, possibly sitting where a file
+			// belongs: remove it whole.
+			plan.Extra = append(plan.Extra, rel)
+			return fs.SkipDir
+		}
+		if !wanted[rel] || !d.Type().IsRegular() {
+			plan.Extra = append(plan.Extra, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return mirrorPlan{}, err
+	}
+	return plan, nil
+}
+
+// removeMirrorExtras deletes extras, or moves them under quarantineDir with
+// their relative layout, then prunes directories the removal left empty. An
+// extra whose parent resolves outside outRoot (through a symlinked directory
+// swapped in after planning, say) is refused rather than removed.
+func removeMirrorExtras(outRoot string, extras []string, quarantineDir string) error {
+	if len(extras) == 0 {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(outRoot)
+	if err != nil {
+		return fmt.Errorf("resolve out root: %w", err)
+	}
+	for _, rel := range extras {
+		localPath := filepath.Join(outRoot, filepath.FromSlash(rel))
+		if err := checkWithinMirrorRoot(root, localPath); err != nil {
+			return err
+		}
+		if quarantineDir != "" {
+			dest := filepath.Join(quarantineDir, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+				return err
+			}
+			if err := os.Rename(localPath, dest); err != nil {
+				return fmt.Errorf("quarantine %s: %w", rel, err)
+			}
+		} else if err := os.RemoveAll(localPath); err != nil {
+			return fmt.Errorf("delete %s: %w", rel, err)
+		}
+		for dir := filepath.Dir(localPath); dir != filepath.Clean(outRoot) && dir != "."; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// checkWithinMirrorRoot reports an error unless the parent directory of
+// localPath, with symlinks resolved, is root or lies below it. The leaf itself
+// is not resolved: a symlink extra is removed as a link, never followed.
+func checkWithinMirrorRoot(root string, localPath string) error {
+	parent, err := filepath.EvalSymlinks(filepath.Dir(localPath))
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, parent)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to touch %s: resolves outside --out-root %s", localPath, root)
+	}
+	return nil
+}
+
+// mirrorProgressState rewrites progress so start skips matching files and
+// re-downloads files whose recorded progress no longer describes the local
+// copy. Partial progress on a file that is still short is kept for resume.
+func mirrorProgressState(state map[uint64]ManifestProgress, plan mirrorPlan) map[uint64]ManifestProgress {
+	out := make(map[uint64]ManifestProgress, len(state)+len(plan.Skip))
+	for _, entry := range plan.Skip {
+		out[entry.ID] = ManifestProgress{AckBytes: entry.Size, MetadataDone: true}
+	}
+	for _, fetch := range plan.Fetch {
+		progress, ok := state[fetch.Entry.ID]
+		if ok && fetch.Reason == "size" && progress.AckBytes < fetch.Entry.Size {
+			out[fetch.Entry.ID] = progress
+		}
+	}
+	return out
+}
+
+func printMirrorPlan(w io.Writer, plan mirrorPlan, quarantineDir string) {
+	for _, fetch := range plan.Fetch {
+		fmt.Fprintf(w, "mirror-plan: fetch fd=%d path=%s reason=%s size=%d\n", fetch.Entry.ID, fetch.Entry.Path, fetch.Reason, fetch.Entry.Size)
+	}
+	action := "delete"
+	if quarantineDir != "" {
+		action = "quarantine"
+	}
+	for _, rel := range plan.Extra {
+		fmt.Fprintf(w, "mirror-plan: %s path=%s\n", action, rel)
+	}
+}
+
+func runMirrorCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mirror", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var txferID string
+	var manifestPath string
+	var outRoot string
+	var encryptMode string
+	var checksum bool
+	var quarantineDir string
+	var dryRun bool
+	fs.StringVar(&txferID, "tid", "", "transfer id")
+	fs.StringVar(&manifestPath, "manifest", "", "path to manifest file (default: <tid>.fm2)")
+	fs.StringVar(&outRoot, "out-root", "", "output root to make identical to the manifest (required)")
+	fs.StringVar(&encryptMode, "encrypt", "", "response encryption mode (supported: age)")
+	fs.BoolVar(&checksum, "checksum", false, "compare same-size files by CXSUM content hash instead of mtime")
+	fs.StringVar(&quarantineDir, "quarantine", "", "move extraneous local files here instead of deleting them")
+	fs.BoolVar(&dryRun, "dry-run", false, "print the plan without changing anything")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if outRoot == "" {
+		// mirror deletes whatever the manifest does not list, so never
+		// default to the working directory.
+		fmt.Fprintln(stderr, "mirror needs an explicit --out-root")
+		return 2
+	}
+	if s3.IsURL(outRoot) {
+		fmt.Fprintln(stderr, "mirror needs a local --out-root; use start for s3://")
+		return 2
+	}
+	enc, err := resolveEncryptionOptions(serverURL, encryptMode)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --encrypt: %v\n", err)
+		return 2
+	}
+	manifest, resolvedManifestPath, resolvedTxferID, err := loadManifestWithOptionalTID("mirror", txferID, manifestPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "load manifest failed: %v\n", err)
+		return 1
+	}
+	progressPath := resolvedManifestPath + ".progress"
+
+	compare := compareMirrorMtime
+	if checksum {
+		client := newCLIClient(serverURL, enc)
+		compare = func(entry ManifestEntry, localPath string, _ os.FileInfo) (bool, string, error) {
+			remote, err := fetchRemoteFileHash(context.Background(), client, manifest, entry, enc.AgePublicKey, enc.AgeIdentity)
+			if err != nil {
+				return false, "", err
+			}
+			local, err := localFileHash(localPath)
+			if err != nil {
+				return false, "", err
+			}
+			if !strings.EqualFold(remote, local) {
+				return false, "checksum", nil
+			}
+			return true, "", nil
+		}
+	}
+	keep := []string{resolvedManifestPath, progressPath, progressPath + ".tmp"}
+	if quarantineDir != "" {
+		keep = append(keep, quarantineDir)
+	}
+	plan, err := planMirror(manifest, outRoot, compare, keep)
+	if err != nil {
+		fmt.Fprintf(stderr, "mirror plan failed: %v\n", err)
+		return 1
+	}
+	var fetchBytes int64
+	for _, fetch := range plan.Fetch {
+		fetchBytes += fetch.Entry.Size
+	}
+	if dryRun {
+		printMirrorPlan(stdout, plan, quarantineDir)
+	}
+	fmt.Fprintf(
+		stdout,
+		"mirror plan: tid=%s fetch=%d (%s) skip=%d extra=%d dry-run=%t\n",
+		resolvedTxferID,
+		len(plan.Fetch),
+		encoding.HumanBytes(fetchBytes),
+		len(plan.Skip),
+		len(plan.Extra),
+		dryRun,
+	)
+	if dryRun {
+		return 0
+	}
+
+	if err := removeMirrorExtras(outRoot, plan.Extra, quarantineDir); err != nil {
+		fmt.Fprintf(stderr, "mirror failed: %v\n", err)
+		return 1
+	}
+	progressState, err := loadProgressState(progressPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "load progress failed: %v\n", err)
+		return 1
+	}
+	if err := writeProgressState(progressPath, mirrorProgressState(progressState, plan)); err != nil {
+		fmt.Fprintf(stderr, "write progress failed: %v\n", err)
+		return 1
+	}
+	if len(plan.Fetch) == 0 {
+		return 0
+	}
+	startArgs := []string{"--manifest", resolvedManifestPath, "--out-root", outRoot}
+	if encryptMode != "" {
+		startArgs = append(startArgs, "--encrypt", encryptMode)
+	}
+	return runStartCLI(serverURL, append(startArgs, fs.Args()...), stdout, stderr)
+}
+
+// fetchRemoteFileHash asks the server for the whole-file xxh128 hash of entry
+// with a single-window CXSUM.
+func fetchRemoteFileHash(ctx context.Context, client *Client, manifest *Manifest, entry ManifestEntry, agePublicKey string, ageIdentity string) (string, error) {
+	resp, err := client.FetchChecksumStream(ctx, FetchChecksumStreamRequest{
+		TransferID:   manifest.TransferID,
+		FileID:       entry.ID,
+		FullPath:     manifest.ServerPath(entry),
+		WindowSize:   max(entry.Size, 1),
+		ChecksumsCSV: "xxh128",
+		AgePublicKey: agePublicKey,
+		AgeIdentity:  ageIdentity,
+	})
+	if err != nil {
+		return "", fmt.Errorf("checksum request failed: %w", err)
+	}
+	defer resp.Reader.Close()
+	br := bufio.NewReader(resp.Reader)
+	for {
+		headerLine, err := br.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("read checksum frame header: %w", err)
+		}
+		wsize, err := parseFrameWireSize(strings.TrimRight(headerLine, "\r\n"))
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.CopyN(io.Discard, br, wsize); err != nil {
+			return "", fmt.Errorf("discard checksum frame payload: %w", err)
+		}
+		trailerLine, err := br.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("read checksum frame trailer: %w", err)
+		}
+		fields := strings.Fields(trailerLine)
+		if !slices.Contains(fields, "next=0") {
+			continue
+		}
+		for _, field := range fields {
+			if hash, ok := strings.CutPrefix(field, "file-hash="); ok {
+				return hash, nil
+			}
+		}
+		return "", errors.New("checksum trailer missing file-hash")
+	}
+}
+
+func localFileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := xxh3.New128()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return encoding.FormatXXH128HashToken(h.Sum128()), nil
+}
@@ -0,0 +1,202 @@
+package filexfercli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/jolynch/pinch/filexfer"
+)
+
+func writeMirrorFile(t *testing.T, root string, rel string, data string, mtime time.Time) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write %s: %v", rel, err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("chtimes %s: %v", rel, err)
+	}
+}
+
+func TestPlanMirrorSortsEntriesAndFindsExtras(t *testing.T) {
+	root := t.TempDir()
+	mtime := time.Unix(1700000000, 123)
+	manifest := &Manifest{
+		TransferID: "txmirror",
+		Root:       "/remote",
+		Entries: []ManifestEntry{
+			{ID: 0, Size: 5, Mtime: mtime.UnixNano(), Path: "same.txt"},
+			{ID: 1, Size: 5, Mtime: mtime.UnixNano(), Path: "dir/grown.txt"},
+			{ID: 2, Size: 5, Mtime: mtime.UnixNano(), Path: "dir/touched.txt"},
+			{ID: 3, Size: 5, Mtime: mtime.UnixNano(), Path: "missing.txt"},
+			{ID: 4, Size: 5, Mtime: mtime.UnixNano(), Path: "blocked"},
+		},
+	}
+	writeMirrorFile(t, root, "same.txt", "hello", mtime)
+	writeMirrorFile(t, root, "dir/grown.txt", "hello!", mtime)
+	writeMirrorFile(t, root, "dir/touched.txt", "hello", mtime.Add(time.Second))
+	writeMirrorFile(t, root, "dir/stale.txt", "old", mtime)
+	writeMirrorFile(t, root, "gone/deep/file.txt", "old", mtime)
+	writeMirrorFile(t, root, "blocked/inner.txt", "dir in the way", mtime)
+	writeMirrorFile(t, root, "tx.fm2", "manifest", mtime)
+
+	plan, err := planMirror(manifest, root, compareMirrorMtime, []string{filepath.Join(root, "tx.fm2")})
+	if err != nil {
+		t.Fatalf("planMirror failed: %v", err)
+	}
+	if len(plan.Skip) != 1 || plan.Skip[0].ID != 0 {
+		t.Fatalf("expected only same.txt skipped, got %+v", plan.Skip)
+	}
+	reasons := map[uint64]string{}
+	for _, fetch := range plan.Fetch {
+		reasons[fetch.Entry.ID] = fetch.Reason
+	}
+	want := map[uint64]string{1: "size", 2: "mtime", 3: "missing", 4: "type"}
+	for id, reason := range want {
+		if reasons[id] != reason {
+			t.Fatalf("fd=%d: expected reason %q, got %q (all: %v)", id, reason, reasons[id], reasons)
+		}
+	}
+	slices.Sort(plan.Extra)
+	if wantExtra := []string{"blocked", "dir/stale.txt", "gone"}; !slices.Equal(plan.Extra, wantExtra) {
+		t.Fatalf("expected extras %v, got %v", wantExtra, plan.Extra)
+	}
+
+	progress := mirrorProgressState(map[uint64]ManifestProgress{
+		1: {AckBytes: 5, MetadataDone: true},
+		2: {AckBytes: 5, MetadataDone: true},
+	}, plan)
+	if got := progress[0]; got.AckBytes != 5 || !got.MetadataDone {
+		t.Fatalf("expected skipped file marked done, got %+v", got)
+	}
+	if _, ok := progress[1]; ok {
+		t.Fatalf("expected completed progress dropped for a mismatched file")
+	}
+	if _, ok := progress[2]; ok {
+		t.Fatalf("expected completed progress dropped for a touched file")
+	}
+}
+
+func TestRemoveMirrorExtrasQuarantinesAndPrunes(t *testing.T) {
+	root := t.TempDir()
+	quarantine := filepath.Join(t.TempDir(), "q")
+	mtime := time.Unix(1700000000, 0)
+	writeMirrorFile(t, root, "keep.txt", "keep", mtime)
+	writeMirrorFile(t, root, "gone/deep/file.txt", "old", mtime)
+	writeMirrorFile(t, root, "extra.txt", "old", mtime)
+
+	if err := removeMirrorExtras(root, []string{"gone/deep/file.txt"}, quarantine); err != nil {
+		t.Fatalf("quarantine failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(quarantine, "gone", "deep", "file.txt")); err != nil || string(data) != "old" {
+		t.Fatalf("expected quarantined file, got %q err=%v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "gone")); !os.IsNotExist(err) {
+		t.Fatalf("expected emptied directories pruned, stat err=%v", err)
+	}
+
+	if err := removeMirrorExtras(root, []string{"extra.txt"}, ""); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "extra.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected extra deleted, stat err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "keep.txt")); err != nil {
+		t.Fatalf("expected keep.txt untouched: %v", err)
+	}
+}
+
+func TestRemoveMirrorExtrasRefusesPathsThroughSymlinkedDirectory(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	writeMirrorFile(t, outside, "victim.txt", "precious", mtime)
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	err := removeMirrorExtras(root, []string{"link/victim.txt"}, "")
+	if err == nil || !strings.Contains(err.Error(), "outside --out-root") {
+		t.Fatalf("expected outside --out-root error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "victim.txt")); err != nil {
+		t.Fatalf("expected victim.txt untouched: %v", err)
+	}
+
+	// The link itself is an extra inside the root: it goes, its target stays.
+	if err := removeMirrorExtras(root, []string{"link"}, ""); err != nil {
+		t.Fatalf("delete link failed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "link")); !os.IsNotExist(err) {
+		t.Fatalf("expected link removed, stat err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "victim.txt")); err != nil {
+		t.Fatalf("expected victim.txt untouched after removing link: %v", err)
+	}
+}
+
+func TestRunMirrorCLIRequiresOutRoot(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := RunCLI([]string{"127.0.0.1:1", "mirror", "--manifest", filepath.Join(t.TempDir(), "tx.fm2")}, &stdout, &stderr)
+	if code != 2 {
+		t.Fatalf("expected exit 2, got %d stderr=%s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "explicit --out-root") {
+		t.Fatalf("unexpected stderr: %s", stderr.String())
+	}
+}
+
+func TestRunMirrorCLIDryRunChangesNothing(t *testing.T) {
+	root := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	writeMirrorFile(t, root, "a.txt", "hello", mtime)
+	writeMirrorFile(t, root, "extra.txt", "old", mtime)
+	manifestPath := filepath.Join(t.TempDir(), "txdry.fm2")
+	manifest := &Manifest{
+		TransferID:  "txdry",
+		Root:        "/remote",
+		Mode:        "fast",
+		Concurrency: 1,
+		Entries: []ManifestEntry{
+			{ID: 0, Size: 5, Mtime: mtime.UnixNano(), Mode: 0o644, Path: "a.txt"},
+			{ID: 1, Size: 3, Mtime: mtime.UnixNano(), Mode: 0o644, Path: "b.txt"},
+		},
+	}
+	raw, err := MarshalManifest(manifest)
+	if err != nil {
+		t.Fatalf("MarshalManifest failed: %v", err)
+	}
+	if err := os.WriteFile(manifestPath, raw, 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := RunCLI([]string{"127.0.0.1:1", "mirror", "--manifest", manifestPath, "--out-root", root, "--dry-run"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("expected exit 0, got %d stderr=%s", code, stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"mirror-plan: fetch fd=1 path=b.txt reason=missing size=3",
+		"mirror-plan: delete path=extra.txt",
+		"mirror plan: tid=txdry fetch=1 (3 B) skip=1 extra=1 dry-run=true",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "extra.txt")); err != nil {
+		t.Fatalf("dry run removed extra.txt: %v", err)
+	}
+	if _, err := os.Stat(manifestPath + ".progress"); !os.IsNotExist(err) {
+		t.Fatalf("dry run wrote progress, stat err=%v", err)
+	}
+}
@@ -0,0 +1,396 @@
+package filexfercli
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/jolynch/pinch/filexfer"
+	"github.com/jolynch/pinch/internal/filexfer/encoding"
+)
+
+const (
+	defaultMountBlockSize  = 1024 * 1024
+	defaultMountCacheBytes = 256 * 1024 * 1024
+	// mountAttrTimeout is how long the kernel may cache attributes and
+	// lookups; the tree is a fixed manifest snapshot.
+	mountAttrTimeout = time.Minute
+)
+
+func runMountCLI(serverURL string, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mount", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var txferID string
+	var manifestPath string
+	var encryptMode string
+	var blockSizeRaw string
+	var cacheRaw string
+	fs.StringVar(&txferID, "tid", "", "transfer id")
+	fs.StringVar(&manifestPath, "manifest", "", "path to manifest file (default: <tid>.fm2)")
+	fs.StringVar(&encryptMode, "encrypt", "", "response encryption mode (supported: age)")
+	fs.StringVar(&blockSizeRaw, "block-size", encoding.HumanBytes(defaultMountBlockSize), "bytes fetched and cached per read")
+	fs.StringVar(&cacheRaw, "cache", encoding.HumanBytes(defaultMountCacheBytes), "local block cache size")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	switch {
+	case fs.NArg() == 2 && manifestPath == "":
+		// mount <manifest> <mountpoint>
+		manifestPath = fs.Arg(0)
+	case fs.NArg() == 2:
+		fmt.Fprintln(stderr, "mount takes the manifest as --manifest or as the first argument, not both")
+		return 2
+	case fs.NArg() != 1:
+		fmt.Fprintln(stderr, "mount requires [<manifest>] <mountpoint>")
+		return 2
+	}
+	mountpoint := fs.Arg(fs.NArg() - 1)
+	blockSize, err := encoding.ParseByteSize(blockSizeRaw)
+	if err != nil || blockSize <= 0 {
+		fmt.Fprintf(stderr, "invalid --block-size: must be a size > 0\n")
+		return 2
+	}
+	cacheBytes, err := encoding.ParseByteSize(cacheRaw)
+	if err != nil || cacheBytes < blockSize {
+		fmt.Fprintf(stderr, "invalid --cache: must be a size >= --block-size\n")
+		return 2
+	}
+	enc, err := resolveEncryptionOptions(serverURL, encryptMode)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --encrypt: %v\n", err)
+		return 2
+	}
+	warnUnencrypted(stderr, serverURL, encryptMode, enc)
+	manifest, _, resolvedTxferID, err := loadManifestWithOptionalTID("mount", txferID, manifestPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "load manifest failed: %v\n", err)
+		return 1
+	}
+	loadStrategy, err := resolveLoadStrategy(manifest.Mode)
+	if err != nil {
+		fmt.Fprintf(stderr, "load manifest failed: invalid manifest mode %q\n", manifest.Mode)
+		return 1
+	}
+	client := newCLIClient(serverURL, enc, WithLoadStrategy(loadStrategy), WithRemoteReadAhead(blockSize))
+	defer client.CloseIdleConnections()
+	cache := newBlockCache(blockSize, int(cacheBytes/blockSize))
+	root := newMountRoot(manifest, func(entry ManifestEntry) (io.ReaderAt, error) {
+		return client.OpenRemoteFile(manifest, entry.ID)
+	}, cache)
+
+	attrTimeout := mountAttrTimeout
+	server, err := fusefs.Mount(mountpoint, root, &fusefs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "pinch:" + resolvedTxferID,
+			Name:        "pinch",
+			DirectMount: true,
+		},
+		AttrTimeout:  &attrTimeout,
+		EntryTimeout: &attrTimeout,
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "mount failed: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "mount: tid=%s files=%d at=%s cache=%s block-size=%s\n", resolvedTxferID, len(manifest.Entries), mountpoint, encoding.HumanBytes(cacheBytes), encoding.HumanBytes(blockSize))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Reads are SENDs, but an idle mount must not lose its transfer.
+	go keepTransferRenewed(ctx, client, resolvedTxferID, stderr)
+	go func() {
+		<-ctx.Done()
+		if err := server.Unmount(); err != nil {
+			fmt.Fprintf(stderr, "unmount failed: %v\n", err)
+		}
+	}()
+	server.Wait()
+	hits, misses := cache.stats()
+	fmt.Fprintf(stdout, "mount: unmounted tid=%s cache-hits=%d cache-misses=%d\n", resolvedTxferID, hits, misses)
+	return 0
+}
+
+// mountOpener opens a manifest file for random access reads. Readers that
+// are also io.Closers are closed when the file handle is released.
+type mountOpener func(entry ManifestEntry) (io.ReaderAt, error)
+
+// mountRoot is the root directory of a read-only tree built from a
+// manifest. Directories are implied by the entry paths.
+type mountRoot struct {
+	fusefs.Inode
+	manifest *Manifest
+	open     mountOpener
+	cache    *blockCache
+}
+
+var _ fusefs.NodeOnAdder = (*mountRoot)(nil)
+
+func newMountRoot(manifest *Manifest, open mountOpener, cache *blockCache) *mountRoot {
+	return &mountRoot{manifest: manifest, open: open, cache: cache}
+}
+
+func (r *mountRoot) OnAdd(ctx context.Context) {
+	for _, entry := range r.manifest.Entries {
+		parts := strings.Split(path.Clean("/"+entry.Path), "/")[1:]
+		if len(parts) == 0 || parts[0] == "" {
+			continue
+		}
+		parent := r.EmbeddedInode()
+		for _, dir := range parts[:len(parts)-1] {
+			child := parent.GetChild(dir)
+			if child == nil {
+				child = parent.NewPersistentInode(ctx, &mountDir{}, fusefs.StableAttr{Mode: fuse.S_IFDIR})
+				parent.AddChild(dir, child, false)
+			}
+			if _, ok := child.Operations().(*mountDir); !ok {
+				// A file already holds this name; the manifest cannot
+				// also place something beneath it.
+				parent = nil
+				break
+			}
+			child.Operations().(*mountDir).raiseMtime(entry.Mtime)
+			parent = child
+		}
+		if parent == nil {
+			continue
+		}
+		file := &mountFile{root: r, entry: entry}
+		parent.AddChild(parts[len(parts)-1], parent.NewPersistentInode(ctx, file, fusefs.StableAttr{Mode: fuse.S_IFREG, Ino: entry.ID + 2}), false)
+	}
+}
+
+func (r *mountRoot) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = 0o555
+	return 0
+}
+
+type mountDir struct {
+	fusefs.Inode
+	mtime int64
+}
+
+// raiseMtime keeps the newest mtime of anything below the directory.
+func (d *mountDir) raiseMtime(mtime int64) {
+	d.mtime = max(d.mtime, mtime)
+}
+
+func (d *mountDir) Getattr(ctx context.Context, f fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = 0o555
+	mtime := time.Unix(0, d.mtime)
+	out.SetTimes(nil, &mtime, &mtime)
+	return 0
+}
+
+// mountFile is one manifest entry. It holds no server state itself; each
+// open gets a mountHandle.
+type mountFile struct {
+	fusefs.Inode
+	root  *mountRoot
+	entry ManifestEntry
+}
+
+var (
+	_ fusefs.NodeGetattrer = (*mountFile)(nil)
+	_ fusefs.NodeOpener    = (*mountFile)(nil)
+	_ fusefs.NodeReader    = (*mountFile)(nil)
+)
+
+func (f *mountFile) Getattr(ctx context.Context, fh fusefs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = uint32(f.entry.Mode.Perm() &^ 0o222)
+	out.Size = uint64(f.entry.Size)
+	out.Blocks = uint64(f.entry.AllocatedSize()+511) / 512
+	mtime := time.Unix(0, f.entry.Mtime)
+	out.SetTimes(nil, &mtime, &mtime)
+	return 0
+}
+
+func (f *mountFile) Open(ctx context.Context, flags uint32) (fusefs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	// The snapshot never changes under the mount, so page cache contents
+	// stay valid across opens.
+	return &mountHandle{file: f}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (f *mountFile) Read(ctx context.Context, fh fusefs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h, ok := fh.(*mountHandle)
+	if !ok {
+		h = &mountHandle{file: f}
+		defer h.Release(ctx)
+	}
+	n, err := f.root.cache.readAt(f.entry.ID, h, f.entry.Size, dest, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, mountErrno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// mountHandle is one open of a mountFile. Reads go through the mount's
+// shared block cache; the server reader behind it is opened on the first
+// miss and closed on Release, so only open files hold one.
+type mountHandle struct {
+	file *mountFile
+
+	mu     sync.Mutex
+	reader io.ReaderAt
+}
+
+var (
+	_ io.ReaderAt         = (*mountHandle)(nil)
+	_ fusefs.FileReleaser = (*mountHandle)(nil)
+)
+
+func (h *mountHandle) ReadAt(p []byte, off int64) (int, error) {
+	h.mu.Lock()
+	if h.reader == nil {
+		reader, err := h.file.root.open(h.file.entry)
+		if err != nil {
+			h.mu.Unlock()
+			return 0, err
+		}
+		h.reader = reader
+	}
+	reader := h.reader
+	h.mu.Unlock()
+	return reader.ReadAt(p, off)
+}
+
+func (h *mountHandle) Release(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if closer, ok := h.reader.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	h.reader = nil
+	return 0
+}
+
+func mountErrno(err error) syscall.Errno {
+	if errors.Is(err, ErrFileMissing) {
+		return syscall.ENOENT
+	}
+	return syscall.EIO
+}
+
+// blockCache is a bounded LRU of fixed-size file blocks shared by every file
+// of a mount. Concurrent misses on one block share a single fetch.
+type blockCache struct {
+	blockSize int64
+	maxBlocks int
+
+	mu       sync.Mutex
+	lru      *list.List // of *cachedBlock, most recently used first
+	blocks   map[blockKey]*list.Element
+	inflight map[blockKey]*blockFetch
+	hits     int64
+	misses   int64
+}
+
+type blockKey struct {
+	fileID uint64
+	index  int64
+}
+
+type cachedBlock struct {
+	key  blockKey
+	data []byte
+}
+
+type blockFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newBlockCache(blockSize int64, maxBlocks int) *blockCache {
+	return &blockCache{
+		blockSize: blockSize,
+		maxBlocks: max(1, maxBlocks),
+		lru:       list.New(),
+		blocks:    make(map[blockKey]*list.Element),
+		inflight:  make(map[blockKey]*blockFetch),
+	}
+}
+
+// readAt fills p from file fileID at off, fetching missing blocks from r.
+func (c *blockCache) readAt(fileID uint64, r io.ReaderAt, size int64, p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) && off+int64(n) < size {
+		at := off + int64(n)
+		index := at / c.blockSize
+		data, err := c.block(blockKey{fileID: fileID, index: index}, r, size)
+		if err != nil {
+			return n, err
+		}
+		within := at - index*c.blockSize
+		if within >= int64(len(data)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(p[n:], data[within:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (c *blockCache) block(key blockKey, r io.ReaderAt, size int64) ([]byte, error) {
+	c.mu.Lock()
+	if elem, ok := c.blocks[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.hits++
+		c.mu.Unlock()
+		return elem.Value.(*cachedBlock).data, nil
+	}
+	if fetch, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-fetch.done
+		return fetch.data, fetch.err
+	}
+	c.misses++
+	fetch := &blockFetch{done: make(chan struct{})}
+	c.inflight[key] = fetch
+	c.mu.Unlock()
+
+	start := key.index * c.blockSize
+	buf := make([]byte, min(c.blockSize, size-start))
+	read, err := r.ReadAt(buf, start)
+	if err != nil && !(errors.Is(err, io.EOF) && read == len(buf)) {
+		fetch.err = err
+	} else {
+		fetch.data = buf[:read]
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if fetch.err == nil {
+		c.blocks[key] = c.lru.PushFront(&cachedBlock{key: key, data: fetch.data})
+		for c.lru.Len() > c.maxBlocks {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.blocks, oldest.Value.(*cachedBlock).key)
+		}
+	}
+	c.mu.Unlock()
+	close(fetch.done)
+	return fetch.data, fetch.err
+}
+
+func (c *blockCache) stats() (hits int64, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
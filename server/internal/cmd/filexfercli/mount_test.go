@@ -0,0 +1,203 @@
+package filexfercli
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	. "github.com/jolynch/pinch/filexfer"
+)
+
+type countingReaderAt struct {
+	r     *bytes.Reader
+	calls atomic.Int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.calls.Add(1)
+	return c.r.ReadAt(p, off)
+}
+
+func TestBlockCacheReadsThroughAndEvicts(t *testing.T) {
+	content := []byte("0123456789abcdef")
+	r := &countingReaderAt{r: bytes.NewReader(content)}
+	cache := newBlockCache(4, 2)
+
+	buf := make([]byte, 6)
+	if n, err := cache.readAt(1, r, int64(len(content)), buf, 2); err != nil || string(buf[:n]) != "234567" {
+		t.Fatalf("unexpected read: n=%d err=%v data=%q", n, err, buf[:n])
+	}
+	if got := r.calls.Load(); got != 2 {
+		t.Fatalf("expected blocks 0 and 1 fetched, got %d fetches", got)
+	}
+	if n, err := cache.readAt(1, r, int64(len(content)), buf[:3], 4); err != nil || string(buf[:n]) != "456" {
+		t.Fatalf("unexpected cached read: n=%d err=%v data=%q", n, err, buf[:n])
+	}
+	if got := r.calls.Load(); got != 2 {
+		t.Fatalf("expected cached read to fetch nothing, got %d fetches", got)
+	}
+	// Reading block 3 evicts block 0, the least recently used.
+	if n, err := cache.readAt(1, r, int64(len(content)), buf, 12); !errors.Is(err, io.EOF) || string(buf[:n]) != "cdef" {
+		t.Fatalf("unexpected tail read: n=%d err=%v data=%q", n, err, buf[:n])
+	}
+	if _, err := cache.readAt(1, r, int64(len(content)), buf[:1], 0); err != nil {
+		t.Fatalf("unexpected read: %v", err)
+	}
+	if got := r.calls.Load(); got != 4 {
+		t.Fatalf("expected evicted block to be fetched again, got %d fetches", got)
+	}
+	if hits, misses := cache.stats(); hits != 1 || misses != 4 {
+		t.Fatalf("unexpected stats: hits=%d misses=%d", hits, misses)
+	}
+}
+
+func TestBlockCacheSharesConcurrentMiss(t *testing.T) {
+	release := make(chan struct{})
+	r := &blockingReaderAt{release: release, data: []byte("abcd")}
+	cache := newBlockCache(4, 4)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 4)
+			if n, err := cache.readAt(7, r, 4, buf, 0); err != nil || string(buf[:n]) != "abcd" {
+				t.Errorf("unexpected read: n=%d err=%v", n, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := r.calls.Load(); got != 1 {
+		t.Fatalf("expected one shared fetch, got %d", got)
+	}
+}
+
+type blockingReaderAt struct {
+	release chan struct{}
+	data    []byte
+	calls   atomic.Int64
+}
+
+func (b *blockingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	b.calls.Add(1)
+	<-b.release
+	return copy(p, b.data[off:]), nil
+}
+
+func TestMountServesManifestTree(t *testing.T) {
+	mtime := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	files := map[uint64][]byte{
+		0: []byte("top level"),
+		1: bytes.Repeat([]byte("0123456789"), 1000),
+		2: []byte("deep"),
+	}
+	manifest := &Manifest{
+		TransferID: "txmount",
+		Root:       "/remote",
+		Entries: []ManifestEntry{
+			{ID: 0, Size: int64(len(files[0])), Mtime: mtime.UnixNano(), Mode: 0o644, Path: "a.txt"},
+			{ID: 1, Size: int64(len(files[1])), Mtime: mtime.UnixNano(), Mode: 0o600, Path: "dir/b.bin"},
+			{ID: 2, Size: int64(len(files[2])), Mtime: mtime.Add(time.Hour).UnixNano(), Mode: 0o644, Path: "dir/sub/c.txt"},
+		},
+	}
+	var opens, closes atomic.Int64
+	root := newMountRoot(manifest, func(entry ManifestEntry) (io.ReaderAt, error) {
+		opens.Add(1)
+		return &closingReaderAt{Reader: bytes.NewReader(files[entry.ID]), closes: &closes}, nil
+	}, newBlockCache(4096, 8))
+
+	mountpoint := t.TempDir()
+	server, err := fusefs.Mount(mountpoint, root, &fusefs.Options{
+		MountOptions: fuse.MountOptions{DirectMountStrict: true, FsName: "pinch:txmount"},
+	})
+	if err != nil {
+		t.Skipf("FUSE mount unavailable: %v", err)
+	}
+	defer func() {
+		if err := server.Unmount(); err != nil {
+			t.Errorf("unmount: %v", err)
+		}
+	}()
+
+	got, err := os.ReadFile(filepath.Join(mountpoint, "dir", "b.bin"))
+	if err != nil || !bytes.Equal(got, files[1]) {
+		t.Fatalf("unexpected dir/b.bin: err=%v len=%d", err, len(got))
+	}
+	got, err = os.ReadFile(filepath.Join(mountpoint, "dir", "sub", "c.txt"))
+	if err != nil || string(got) != "deep" {
+		t.Fatalf("unexpected dir/sub/c.txt: err=%v data=%q", err, got)
+	}
+	info, err := os.Stat(filepath.Join(mountpoint, "a.txt"))
+	if err != nil {
+		t.Fatalf("stat a.txt: %v", err)
+	}
+	if info.Size() != int64(len(files[0])) || info.Mode() != 0o444 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected a.txt attrs: size=%d mode=%v mtime=%v", info.Size(), info.Mode(), info.ModTime())
+	}
+	dirInfo, err := os.Stat(filepath.Join(mountpoint, "dir"))
+	if err != nil || !dirInfo.IsDir() || !dirInfo.ModTime().Equal(mtime.Add(time.Hour)) {
+		t.Fatalf("unexpected dir attrs: err=%v info=%v", err, dirInfo)
+	}
+	entries, err := os.ReadDir(filepath.Join(mountpoint, "dir"))
+	if err != nil || len(entries) != 2 || entries[0].Name() != "b.bin" || entries[1].Name() != "sub" {
+		t.Fatalf("unexpected dir listing: err=%v entries=%v", err, entries)
+	}
+	if _, err := os.OpenFile(filepath.Join(mountpoint, "a.txt"), os.O_WRONLY, 0); err == nil {
+		t.Fatalf("expected write open to fail on a read-only mount")
+	}
+	if got := opens.Load(); got != 2 {
+		t.Fatalf("expected only the two read files opened remotely, got %d", got)
+	}
+	// The kernel releases handles asynchronously after close.
+	deadline := time.Now().Add(5 * time.Second)
+	for closes.Load() != opens.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if closes.Load() != opens.Load() {
+		t.Fatalf("expected every remote reader closed on release, opens=%d closes=%d", opens.Load(), closes.Load())
+	}
+}
+
+type closingReaderAt struct {
+	*bytes.Reader
+	closes *atomic.Int64
+}
+
+func (c *closingReaderAt) Close() error {
+	c.closes.Add(1)
+	return nil
+}
+
+func TestRunMountCLIArguments(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.fm2")
+	for _, tc := range []struct {
+		name string
+		args []string
+		code int
+		want string
+	}{
+		{name: "no mountpoint", args: []string{"mount"}, code: 2, want: "requires [<manifest>] <mountpoint>"},
+		{name: "too many", args: []string{"mount", "a", "b", "c"}, code: 2, want: "requires [<manifest>] <mountpoint>"},
+		{name: "manifest twice", args: []string{"mount", "--manifest", missing, missing, "mnt"}, code: 2, want: "not both"},
+		{name: "positional manifest", args: []string{"mount", missing, "mnt"}, code: 1, want: "missing.fm2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := RunCLI(append([]string{"127.0.0.1:1"}, tc.args...), &stdout, &stderr)
+			if code != tc.code || !strings.Contains(stderr.String(), tc.want) {
+				t.Fatalf("expected exit %d with %q, got %d stderr=%s", tc.code, tc.want, code, stderr.String())
+			}
+		})
+	}
+}
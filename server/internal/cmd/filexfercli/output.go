@@ -0,0 +1,326 @@
+package filexfercli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/jolynch/pinch/filexfer"
+)
+
+// outputSchema versions every JSON object the CLI prints. Adding fields is
+// compatible; renaming, removing or retyping one bumps the version. See
+// filexfer/docs/CLI_OUTPUT.md.
+const outputSchema = "pinch-cli/1"
+
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+func resolveOutputFormat(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", outputText:
+		return outputText, nil
+	case outputJSON:
+		return outputJSON, nil
+	case outputNDJSON:
+		return outputNDJSON, nil
+	default:
+		return "", errors.New("must be text, json or ndjson")
+	}
+}
+
+// splitGlobalOutput removes a leading --output flag, which may come before
+// the file listener, and returns its value.
+func splitGlobalOutput(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", args, nil
+	}
+	name, value, hasValue := strings.Cut(args[0], "=")
+	if name != "--output" && name != "-output" {
+		return "", args, nil
+	}
+	if hasValue {
+		return value, args[1:], nil
+	}
+	if len(args) < 2 {
+		return "", nil, errors.New("--output needs a value")
+	}
+	return args[1], args[2:], nil
+}
+
+type outputProbe struct {
+	Strategy    string `json:"strategy"`
+	ServerCPU   int    `json:"server_cpu"`
+	AvgMS       int64  `json:"avg_ms"`
+	LinkMbps    int64  `json:"link_mbps"`
+	Concurrency int    `json:"concurrency"`
+}
+
+type outputTransfer struct {
+	TransferID    string `json:"tid"`
+	Root          string `json:"root"`
+	Files         int    `json:"files"`
+	TotalSize     int64  `json:"total_size"`
+	AllocatedSize int64  `json:"allocated_size"`
+	Manifest      string `json:"manifest"`
+	ElapsedMS     int64  `json:"elapsed_ms"`
+}
+
+type outputPlan struct {
+	TransferID          string `json:"tid"`
+	Strategy            string `json:"strategy"`
+	LinkMbps            int64  `json:"link_mbps"`
+	Concurrency         int    `json:"concurrency"`
+	ManifestConcurrency int    `json:"manifest_concurrency"`
+	Order               string `json:"order"`
+	Adaptive            bool   `json:"adaptive"`
+	Files               int    `json:"files"`
+	Pending             int    `json:"pending"`
+}
+
+// outputFile is the per-file metrics of one completed download.
+type outputFile struct {
+	TransferID string            `json:"tid"`
+	FileID     uint64            `json:"fd"`
+	Path       string            `json:"path"`
+	Size       int64             `json:"size"`
+	WireSize   int64             `json:"wire_size"`
+	Ratio      float64           `json:"ratio"`
+	Comp       string            `json:"comp"`
+	CompCounts map[string]uint64 `json:"comp_counts"`
+	ElapsedMS  int64             `json:"elapsed_ms"`
+	RateBps    float64           `json:"rate_bps"`
+	// Checksum is ok, mismatch or unverified for whole-file hashes, or
+	// windows when split windows were checked one by one.
+	Checksum      string `json:"checksum"`
+	WindowsPassed int    `json:"windows_passed,omitempty"`
+	WindowsTotal  int    `json:"windows_total,omitempty"`
+	ServerHash    string `json:"server_hash,omitempty"`
+	ClientHash    string `json:"client_hash,omitempty"`
+	ServerTS0     int64  `json:"server_ts0"`
+	ServerTS1     int64  `json:"server_ts1"`
+}
+
+func newOutputFile(txferID string, fileID uint64, path string, meta FileFrameMeta, localFileHash string, windowChecksumPassed, windowChecksumTotal int, elapsed time.Duration) outputFile {
+	file := outputFile{
+		TransferID:    txferID,
+		FileID:        fileID,
+		Path:          path,
+		Size:          meta.Size,
+		WireSize:      meta.WireSize,
+		Comp:          meta.Comp,
+		CompCounts:    maps.Clone(meta.CompCounts),
+		ElapsedMS:     elapsed.Milliseconds(),
+		WindowsPassed: windowChecksumPassed,
+		WindowsTotal:  windowChecksumTotal,
+		ServerHash:    meta.FileHashToken,
+		ClientHash:    localFileHash,
+		ServerTS0:     meta.HeaderTS,
+		ServerTS1:     meta.TrailerTS,
+	}
+	if file.CompCounts == nil {
+		// Only files split into windows carry counts; a single frame is one
+		// of its own compression.
+		file.CompCounts = map[string]uint64{}
+		if meta.Comp != "" {
+			file.CompCounts[meta.Comp] = 1
+		}
+	}
+	if meta.WireSize > 0 {
+		file.Ratio = float64(meta.Size) / float64(meta.WireSize)
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		file.RateBps = float64(meta.Size) / seconds
+	}
+	switch {
+	case windowChecksumTotal > 0:
+		file.Checksum = "windows"
+	case meta.FileHashToken != "" && localFileHash != "" && strings.EqualFold(meta.FileHashToken, localFileHash):
+		file.Checksum = "ok"
+	case meta.FileHashToken != "" && localFileHash != "":
+		file.Checksum = "mismatch"
+	default:
+		file.Checksum = "unverified"
+	}
+	return file
+}
+
+type outputProgress struct {
+	TransferID  string `json:"tid"`
+	FileID      uint64 `json:"fd"`
+	CopiedBytes int64  `json:"copied"`
+	TargetBytes int64  `json:"target"`
+	AckBytes    int64  `json:"acked"`
+}
+
+type outputLink struct {
+	Mbps         int64 `json:"mbps"`
+	StreamMbps   int64 `json:"stream_mbps"`
+	RTTMS        int64 `json:"rtt_ms"`
+	QueueDelayMS int64 `json:"queue_delay_ms"`
+	Samples      int   `json:"samples"`
+}
+
+type outputReplica struct {
+	Addr       string  `json:"addr"`
+	TransferID string  `json:"tid"`
+	Requests   int     `json:"requests"`
+	Failures   int     `json:"failures"`
+	Bytes      int64   `json:"bytes"`
+	GoodputBps float64 `json:"goodput_bps"`
+}
+
+type outputSummary struct {
+	TransferID       string            `json:"tid"`
+	Requested        int               `json:"requested"`
+	Downloaded       int64             `json:"downloaded"`
+	Failed           int               `json:"failed"`
+	TransferredBytes int64             `json:"transferred_bytes"`
+	ElapsedMS        int64             `json:"elapsed_ms"`
+	RateBps          float64           `json:"rate_bps"`
+	CompCounts       map[string]uint64 `json:"comp_counts"`
+	Retries          int               `json:"retries"`
+	// FailedIDs are the files a --retry-failed run would download.
+	FailedIDs []uint64        `json:"failed_ids,omitempty"`
+	Link      *outputLink     `json:"link,omitempty"`
+	Replicas  []outputReplica `json:"replicas,omitempty"`
+}
+
+// outputResult is the one object json prints and the last line ndjson
+// prints.
+type outputResult struct {
+	Schema   string          `json:"schema"`
+	Type     string          `json:"type"`
+	Command  string          `json:"command"`
+	OK       bool            `json:"ok"`
+	Errors   []string        `json:"errors"`
+	Probe    *outputProbe    `json:"probe,omitempty"`
+	Transfer *outputTransfer `json:"transfer,omitempty"`
+	Status   *TransferStatus `json:"status,omitempty"`
+	Plan     *outputPlan     `json:"plan,omitempty"`
+	// Files holds every completed file under json; ndjson streams them as
+	// file events instead.
+	Files []outputFile `json:"files,omitempty"`
+	// Skipped lists file ids that were already complete.
+	Skipped []uint64       `json:"skipped,omitempty"`
+	Summary *outputSummary `json:"summary,omitempty"`
+}
+
+// outputEvent is one ndjson line before the result.
+type outputEvent struct {
+	Schema   string          `json:"schema"`
+	Type     string          `json:"type"`
+	Command  string          `json:"command"`
+	TimeMS   int64           `json:"ts"`
+	Plan     *outputPlan     `json:"plan,omitempty"`
+	Progress *outputProgress `json:"progress,omitempty"`
+	File     *outputFile     `json:"file,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// cliOutput collects a command's machine-readable result. Under text it
+// does nothing and callers print their usual lines; under json it prints
+// the result once at the end; under ndjson it also streams events as they
+// happen.
+type cliOutput struct {
+	format string
+	w      io.Writer
+	mu     sync.Mutex
+	result outputResult
+}
+
+func newCLIOutput(format string, command string, w io.Writer) *cliOutput {
+	return &cliOutput{
+		format: format,
+		w:      w,
+		result: outputResult{Schema: outputSchema, Type: "result", Command: command, Errors: []string{}},
+	}
+}
+
+// text reports whether the command should print its human-readable lines.
+func (o *cliOutput) text() bool {
+	return o.format == outputText
+}
+
+func (o *cliOutput) update(fn func(*outputResult)) {
+	if o.text() {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fn(&o.result)
+}
+
+func (o *cliOutput) emit(evt outputEvent) {
+	if o.format != outputNDJSON {
+		return
+	}
+	evt.Schema, evt.Command, evt.TimeMS = outputSchema, o.result.Command, time.Now().UnixMilli()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.writeLocked(evt)
+}
+
+func (o *cliOutput) writeLocked(v any) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		raw = []byte(fmt.Sprintf(`{"schema":%q,"type":"error","error":%q}`, outputSchema, err.Error()))
+	}
+	_, _ = o.w.Write(append(raw, '\n'))
+}
+
+func (o *cliOutput) plan(plan outputPlan) {
+	o.update(func(r *outputResult) { r.Plan = &plan })
+	o.emit(outputEvent{Type: "plan", Plan: &plan})
+}
+
+func (o *cliOutput) progress(update DownloadProgressUpdate) {
+	o.emit(outputEvent{Type: "progress", Progress: &outputProgress{
+		TransferID:  update.TransferID,
+		FileID:      update.FileID,
+		CopiedBytes: update.CopiedBytes,
+		TargetBytes: update.TargetBytes,
+		AckBytes:    update.AckBytes,
+	}})
+}
+
+func (o *cliOutput) file(file outputFile) {
+	if o.format == outputJSON {
+		o.update(func(r *outputResult) { r.Files = append(r.Files, file) })
+	}
+	o.emit(outputEvent{Type: "file", File: &file})
+}
+
+func (o *cliOutput) addError(err error) {
+	o.update(func(r *outputResult) { r.Errors = append(r.Errors, err.Error()) })
+	o.emit(outputEvent{Type: "error", Error: err.Error()})
+}
+
+// fail reports a command failure on stderr, as text mode always has, and
+// as the result's error, then returns exit code 1.
+func (o *cliOutput) fail(stderr io.Writer, format string, args ...any) int {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintln(stderr, msg)
+	o.update(func(r *outputResult) { r.Errors = append(r.Errors, msg) })
+	return o.finish(1)
+}
+
+// finish prints the result and passes code through.
+func (o *cliOutput) finish(code int) int {
+	if o.text() {
+		return code
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.result.OK = code == 0 && len(o.result.Errors) == 0
+	o.writeLocked(o.result)
+	return code
+}
@@ -0,0 +1,65 @@
+package filexfercli
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	. "github.com/jolynch/pinch/filexfer"
+	"github.com/jolynch/pinch/internal/filexfer/s3"
+)
+
+// startSink is where start puts the tree: a local --out-root directory or,
+// for an s3:// --out-root, a bucket.
+type startSink interface {
+	Sink
+	// Location is where entry ends up, for per-file summaries.
+	Location(entry ManifestEntry) string
+}
+
+// fileSink writes files under a local output root.
+type fileSink struct {
+	outRoot string
+	noSync  bool
+	sparse  bool
+}
+
+func (s fileSink) OpenWindow(entry ManifestEntry, offset int64) (io.WriteCloser, func() error, error) {
+	return openDownloadOutput(entry, offset, s.Location(entry), nil, s.noSync, s.sparse)
+}
+
+// FinishFile has nothing to do; OnFileDone applies trailer metadata.
+func (s fileSink) FinishFile(context.Context, ManifestEntry) error {
+	return nil
+}
+
+func (s fileSink) Location(entry ManifestEntry) string {
+	return resolveDownloadDestinationPath(entry, s.outRoot, "")
+}
+
+// newStartSink picks the sink for outRoot. S3 credentials, region and
+// endpoint come from the standard AWS_* environment variables; the batch
+// size doubles as the multipart part size so each split window is a part.
+func newStartSink(outRoot string, batchSize int64, noSync bool, sparse bool, saveState func(uint64, string) error) (startSink, error) {
+	if !s3.IsURL(outRoot) {
+		return fileSink{outRoot: outRoot, noSync: noSync, sparse: sparse}, nil
+	}
+	if batchSize < s3.MinPartSize {
+		return nil, errors.New("--batch-size must be at least 5MiB with an s3:// --out-root")
+	}
+	cfg := s3.ConfigFromEnv()
+	sink, err := NewS3Sink(S3SinkConfig{
+		URL:             outRoot,
+		Endpoint:        cfg.Endpoint,
+		Region:          cfg.Region,
+		AccessKeyID:     cfg.AccessKeyID,
+		SecretAccessKey: cfg.SecretAccessKey,
+		SessionToken:    cfg.SessionToken,
+		PartSize:        batchSize,
+		SaveState:       saveState,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
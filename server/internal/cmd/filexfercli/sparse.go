@@ -0,0 +1,137 @@
+package filexfercli
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// sparseMinHoleBytes is the smallest all-zero write turned into a hole.
+const sparseMinHoleBytes = 64 * 1024
+
+var sparseZeroBlock [sparseMinHoleBytes]byte
+
+// sparseFile writes downloaded bytes at a fixed position and turns hole
+// frames, which the client hands to WriteHole, and large all-zero writes
+// into holes: it punches the range, which also clears stale data in a
+// reused file, and skips ahead. Several
+// windows of one file may write through separate sparseFiles concurrently,
+// so it only ever grows the file.
+type sparseFile struct {
+	f       *os.File
+	pos     int64
+	holeEnd int64 // end of a trailing hole not yet reflected in the file size
+	noPunch bool
+}
+
+func newSparseFile(f *os.File, offset int64) *sparseFile {
+	return &sparseFile{f: f, pos: offset}
+}
+
+func (s *sparseFile) Write(p []byte) (int, error) {
+	if len(p) >= sparseMinHoleBytes && isZeroBlock(p) {
+		if err := s.skipHole(int64(len(p))); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	n, err := s.f.WriteAt(p, s.pos)
+	s.pos += int64(n)
+	if s.pos >= s.holeEnd {
+		s.holeEnd = 0
+	}
+	return n, err
+}
+
+// WriteHole skips n zero bytes without their being materialized; see
+// filexfer.HoleWriter.
+func (s *sparseFile) WriteHole(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	return s.skipHole(n)
+}
+
+func (s *sparseFile) skipHole(n int64) error {
+	if !s.noPunch {
+		err := unix.Fallocate(int(s.f.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, s.pos, n)
+		if err == nil {
+			s.pos += n
+			s.holeEnd = s.pos
+			return nil
+		}
+		if !errors.Is(err, unix.EOPNOTSUPP) && !errors.Is(err, unix.ENOSYS) {
+			return err
+		}
+		s.noPunch = true
+	}
+	for n > 0 {
+		step := min(n, int64(len(sparseZeroBlock)))
+		written, err := s.f.WriteAt(sparseZeroBlock[:step], s.pos)
+		s.pos += int64(written)
+		if err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// Seek moves the write position so a failed window can be rewritten from
+// where it started. Holes already skipped stay recorded until Close.
+func (s *sparseFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	default:
+		return 0, errors.New("sparse file: unsupported whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("sparse file: negative position")
+	}
+	s.pos = offset
+	return s.pos, nil
+}
+
+// extend makes sure a trailing hole counts toward the file size. Writing the
+// last byte, unlike Truncate, cannot shrink a file another window extended.
+func (s *sparseFile) extend() error {
+	if s.holeEnd == 0 {
+		return nil
+	}
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < s.holeEnd {
+		if _, err := s.f.WriteAt([]byte{0}, s.holeEnd-1); err != nil {
+			return err
+		}
+	}
+	s.holeEnd = 0
+	return nil
+}
+
+func (s *sparseFile) Close() error {
+	extendErr := s.extend()
+	closeErr := s.f.Close()
+	if extendErr != nil {
+		return extendErr
+	}
+	return closeErr
+}
+
+func isZeroBlock(p []byte) bool {
+	for len(p) > 0 {
+		n := min(len(p), len(sparseZeroBlock))
+		if !bytes.Equal(p[:n], sparseZeroBlock[:n]) {
+			return false
+		}
+		p = p[n:]
+	}
+	return true
+}
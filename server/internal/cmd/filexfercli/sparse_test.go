@@ -0,0 +1,97 @@
+package filexfercli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/jolynch/pinch/filexfer"
+)
+
+func TestSparseFileKeepsHolesAndContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.img")
+	// Stale data from an earlier download must be cleared by punched holes.
+	if err := os.WriteFile(path, bytes.Repeat([]byte{0xff}, 256*1024), 0o644); err != nil {
+		t.Fatalf("seed output: %v", err)
+	}
+	entry := ManifestEntry{ID: 1, Size: 1 << 20}
+	w, syncOutput, err := openDownloadOutput(entry, 0, path, nil, true, true)
+	if err != nil {
+		t.Fatalf("openDownloadOutput failed: %v", err)
+	}
+	head := bytes.Repeat([]byte("data"), 1024)
+	zeros := make([]byte, 512*1024)
+	chunks := [][]byte{head, zeros, head, make([]byte, (1<<20)-2*len(head)-len(zeros))}
+	var want []byte
+	for _, chunk := range chunks {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		want = append(want, chunk...)
+	}
+	if err := syncOutput(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("sparse output differs from written bytes (len=%d want %d)", len(got), len(want))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat output: %v", err)
+	}
+	if allocated := info.Sys().(*syscall.Stat_t).Blocks * 512; allocated >= info.Size()/2 {
+		t.Fatalf("expected holes in output, allocated=%d size=%d", allocated, info.Size())
+	}
+}
+
+func TestSparseFileWriteHolePunchesWithoutData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.img")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{0xff}, 1<<20), 0o644); err != nil {
+		t.Fatalf("seed output: %v", err)
+	}
+	entry := ManifestEntry{ID: 1, Size: 2 << 20}
+	w, syncOutput, err := openDownloadOutput(entry, 0, path, nil, true, true)
+	if err != nil {
+		t.Fatalf("openDownloadOutput failed: %v", err)
+	}
+	holes, ok := w.(HoleWriter)
+	if !ok {
+		t.Fatalf("sparse output %T is not a HoleWriter", w)
+	}
+	head := bytes.Repeat([]byte("data"), 1024)
+	if _, err := w.Write(head); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := holes.WriteHole(0); err != nil {
+		t.Fatalf("empty hole: %v", err)
+	}
+	// A trailing hole still counts toward the file size once synced.
+	if err := holes.WriteHole(entry.Size - int64(len(head))); err != nil {
+		t.Fatalf("WriteHole: %v", err)
+	}
+	if err := syncOutput(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	want := make([]byte, entry.Size)
+	copy(want, head)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("sparse output differs (len=%d want %d)", len(got), len(want))
+	}
+}
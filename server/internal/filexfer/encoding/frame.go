@@ -1,11 +1,14 @@
 package encoding
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"os/user"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,6 +16,7 @@ import (
 	"time"
 
 	"github.com/zeebo/xxh3"
+	"golang.org/x/sys/unix"
 )
 
 var userNameCache sync.Map  // map[string]string  uid  → username
@@ -50,9 +54,31 @@ type FileFrameMetadata struct {
 	GID     string
 	User    string
 	Group   string
+	// AtimeNS is only collected when MetadataOptions.Atime is set; 0 means
+	// unknown.
+	AtimeNS int64
+	// Xattrs holds extended attributes, including POSIX ACLs stored as
+	// system.posix_acl_access/system.posix_acl_default, when
+	// MetadataOptions.Xattrs is set.
+	Xattrs          map[string][]byte
+	XattrsTruncated bool
 }
 
+// MetadataOptions selects optional trailer metadata that costs extra
+// syscalls or trailer bytes.
+type MetadataOptions struct {
+	Atime  bool
+	Xattrs bool
+}
+
+// MaxTrailerXattrBytes bounds the encoded xattr values carried in one trailer.
+const MaxTrailerXattrBytes = 1 << 20
+
 func CollectFileFrameMetadata(path string, info os.FileInfo) FileFrameMetadata {
+	return CollectFileFrameMetadataWithOptions(path, info, MetadataOptions{})
+}
+
+func CollectFileFrameMetadataWithOptions(path string, info os.FileInfo, opts MetadataOptions) FileFrameMetadata {
 	meta := FileFrameMetadata{
 		Size:    info.Size(),
 		MtimeNS: info.ModTime().UnixNano(),
@@ -67,13 +93,62 @@ func CollectFileFrameMetadata(path string, info os.FileInfo) FileFrameMetadata {
 		meta.GID = strconv.FormatUint(uint64(st.Gid), 10)
 		meta.User = lookupUserName(meta.UID)
 		meta.Group = lookupGroupName(meta.GID)
+		if opts.Atime {
+			meta.AtimeNS = st.Atim.Nano()
+		}
+	}
+	if opts.Xattrs {
+		meta.Xattrs, meta.XattrsTruncated = collectXattrs(path)
 	}
-	_ = path
 	return meta
 }
 
-func (m FileFrameMetadata) trailerTokens() []string {
-	return []string{
+// collectXattrs reads every extended attribute on path. Errors (including
+// filesystems without xattr support) yield an empty set.
+func collectXattrs(path string) (map[string][]byte, bool) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size <= 0 {
+		return nil, false
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil || size <= 0 {
+		return nil, false
+	}
+	names := strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00")
+	sort.Strings(names)
+	out := make(map[string][]byte, len(names))
+	total := 0
+	truncated := false
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		vsize, err := unix.Getxattr(path, name, nil)
+		if err != nil || vsize < 0 {
+			continue
+		}
+		val := make([]byte, vsize)
+		if vsize > 0 {
+			vsize, err = unix.Getxattr(path, name, val)
+			if err != nil {
+				continue
+			}
+			val = val[:vsize]
+		}
+		if total+len(name)+base64.StdEncoding.EncodedLen(len(val)) > MaxTrailerXattrBytes {
+			truncated = true
+			continue
+		}
+		total += len(name) + base64.StdEncoding.EncodedLen(len(val))
+		out[name] = val
+	}
+	return out, truncated
+}
+
+// TrailerTokens renders the metadata as FXT/1 meta:* tokens.
+func (m FileFrameMetadata) TrailerTokens() []string {
+	tokens := []string{
 		fmt.Sprintf("meta:size=%d", m.Size),
 		fmt.Sprintf("meta:mtime_ns=%d", m.MtimeNS),
 		fmt.Sprintf("meta:mode=%s", m.Mode),
@@ -82,6 +157,31 @@ func (m FileFrameMetadata) trailerTokens() []string {
 		fmt.Sprintf("meta:user=%s", strings.ReplaceAll(m.User, " ", "_")),
 		fmt.Sprintf("meta:group=%s", strings.ReplaceAll(m.Group, " ", "_")),
 	}
+	if m.AtimeNS > 0 {
+		tokens = append(tokens, fmt.Sprintf("meta:atime_ns=%d", m.AtimeNS))
+	}
+	names := make([]string, 0, len(m.Xattrs))
+	for name := range m.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tokens = append(tokens, "meta:xattr."+EncodeXattrName(name)+"="+base64.StdEncoding.EncodeToString(m.Xattrs[name]))
+	}
+	if m.XattrsTruncated {
+		tokens = append(tokens, "meta:xattrs_truncated=1")
+	}
+	return tokens
+}
+
+// EncodeXattrName escapes an xattr name so it survives as part of a
+// space-delimited key=value token.
+func EncodeXattrName(name string) string {
+	return url.QueryEscape(name)
+}
+
+func DecodeXattrName(raw string) (string, error) {
+	return url.QueryUnescape(raw)
 }
 
 type WriteArgs struct {
@@ -142,7 +242,7 @@ func WriteFrame(w io.Writer, args WriteArgs) (WriteStats, error) {
 	b.WriteString(" next=")
 	b.WriteString(strconv.FormatInt(args.Next, 10))
 	if args.Metadata != nil {
-		for _, token := range args.Metadata.trailerTokens() {
+		for _, token := range args.Metadata.TrailerTokens() {
 			b.WriteString(" ")
 			b.WriteString(token)
 		}
@@ -183,6 +283,8 @@ type FileFrameMeta struct {
 	WireSize        int64
 	MaxWireSizeHint int64
 	HeaderTS        int64
+	// Hole marks a zero-filled extent sent without payload.
+	Hole bool
 }
 
 type FrameTrailer struct {
@@ -246,6 +348,10 @@ func ParseFXHeader(line string) (FileFrameMeta, error) {
 	if comp == "" || enc == "" {
 		return FileFrameMeta{}, errors.New("missing required frame properties")
 	}
+	hole := props["hole"] == "1"
+	if hole && wsize != 0 {
+		return FileFrameMeta{}, errors.New("hole frame must have wsize=0")
+	}
 	return FileFrameMeta{
 		FileID:          fileID,
 		Comp:            comp,
@@ -255,6 +361,7 @@ func ParseFXHeader(line string) (FileFrameMeta, error) {
 		WireSize:        wsize,
 		MaxWireSizeHint: maxWSizeHint,
 		HeaderTS:        ts,
+		Hole:            hole,
 	}, nil
 }
 
@@ -4,13 +4,14 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"runtime/trace"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jolynch/pinch/internal/filexfer/encoding"
+	"github.com/jolynch/pinch/tracing"
 )
 
 type ackItem struct {
@@ -120,13 +121,27 @@ func handleACK(ctx context.Context, req Request, out io.Writer, deps Deps) error
 		})
 	}
 
+	logger := slog.With(peerFromContext(ctx).logAttrs()...)
+	renewed := ""
 	for _, v := range validated {
-		_, ackTask := trace.NewTask(ctx, "ack")
+		if v.item.TransferID != renewed {
+			deps.RenewTransfer(v.item.TransferID, 0)
+			renewed = v.item.TransferID
+		}
+		ackCtx, span := tracing.Start(ctx, "ftcp.ack.file", "txfer", v.item.TransferID, "fid", v.item.FileID, "ack_bytes", v.ackBytes, "delta_bytes", v.item.DeltaBytes)
+		_, ackTask := trace.NewTask(ackCtx, "ack")
 		if ok := deps.AcknowledgeTransferFile(v.item.TransferID, v.item.FileID, v.ackBytes); !ok {
 			ackTask.End()
-			return protocolErr{code: "INTERNAL", message: "failed to acknowledge file progress"}
+			err := protocolErr{code: "INTERNAL", message: "failed to acknowledge file progress"}
+			span.SetError(err)
+			span.End()
+			return err
 		}
 		if v.ackBytes >= 0 {
+			if v.item.DeltaBytes > 0 {
+				ackRecvSeconds.With().Observe(float64(v.item.RecvMS) / 1000.0)
+				ackSyncSeconds.With().Observe(float64(v.item.SyncMS) / 1000.0)
+			}
 			nowTS := time.Now().UnixMilli()
 			lagMS := nowTS - v.ackTS
 			receiverMS := v.item.RecvMS + v.item.SyncMS
@@ -144,25 +159,26 @@ func handleACK(ctx context.Context, req Request, out io.Writer, deps Deps) error
 					syncBps = float64(v.item.DeltaBytes) / (float64(v.item.SyncMS) / 1000.0)
 				}
 			}
-			log.Printf(
-				"filexfer ack tid=%s fid=%d ack_bytes=%d ack_hash=%s acked_server_ts=%d ack_recv_ts=%d lag_ms=%d delta_bytes=%d recv_ms=%d sync_ms=%d receiver_ms=%d receiver_throughput=%s recv_throughput=%s sync_throughput=%s",
-				v.item.TransferID,
-				v.item.FileID,
-				v.ackBytes,
-				encoding.AbbrevHashToken(v.ackHashToken),
-				v.ackTS,
-				nowTS,
-				lagMS,
-				v.item.DeltaBytes,
-				v.item.RecvMS,
-				v.item.SyncMS,
-				receiverMS,
-				encoding.HumanRate(receiverBps),
-				encoding.HumanRate(recvBps),
-				encoding.HumanRate(syncBps),
+			logger.Info(
+				"ack.accepted",
+				"txfer", v.item.TransferID,
+				"fid", v.item.FileID,
+				"ack_bytes", v.ackBytes,
+				"ack_hash", encoding.AbbrevHashToken(v.ackHashToken),
+				"acked_server_ts", v.ackTS,
+				"ack_recv_ts", nowTS,
+				"lag_ms", lagMS,
+				"delta_bytes", v.item.DeltaBytes,
+				"recv_ms", v.item.RecvMS,
+				"sync_ms", v.item.SyncMS,
+				"receiver_ms", receiverMS,
+				"receiver_throughput", encoding.HumanRate(receiverBps),
+				"recv_throughput", encoding.HumanRate(recvBps),
+				"sync_throughput", encoding.HumanRate(syncBps),
 			)
 		}
 		ackTask.End()
+		span.End()
 	}
 	return writeOKLine(out, "")
 }
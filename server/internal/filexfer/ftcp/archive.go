@@ -0,0 +1,321 @@
+package ftcp
+
+import (
+	"archive/tar"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jolynch/pinch/internal/filexfer/encoding"
+	"github.com/jolynch/pinch/internal/filexfer/limit"
+	"github.com/jolynch/pinch/internal/filexfer/source"
+	"github.com/jolynch/pinch/tracing"
+	"github.com/zeebo/xxh3"
+)
+
+// archiveChunkBytes is the largest FA/1 chunk the server emits.
+const archiveChunkBytes = 256 * 1024
+
+type archiveRequest struct {
+	TransferID string
+	Comp       string
+	Mode       string
+}
+
+type archiveEntry struct {
+	FileID   uint64
+	Path     string
+	FullPath string
+}
+
+func parseARCHIVERequest(req Request) (archiveRequest, error) {
+	if req.Verb != VerbARCHIVE {
+		return archiveRequest{}, protocolErr{code: "BAD_COMMAND", message: "not ARCHIVE"}
+	}
+	if len(req.Params) != 1 {
+		return archiveRequest{}, protocolErr{code: "BAD_REQUEST", message: "invalid ARCHIVE arguments"}
+	}
+	p := req.Params[0]
+	parsed := archiveRequest{TransferID: p["txferid"], Comp: encoding.EncodingIdentity, Mode: loadStrategyFast}
+	if parsed.TransferID == "" {
+		return archiveRequest{}, protocolErr{code: "BAD_REQUEST", message: "missing transfer id"}
+	}
+	if raw := strings.ToLower(strings.TrimSpace(p["comp"])); raw != "" {
+		switch raw {
+		case "none", encoding.EncodingIdentity:
+		case encoding.EncodingZstd:
+			parsed.Comp = encoding.EncodingZstd
+		default:
+			return archiveRequest{}, protocolErr{code: "UNSUPPORTED_COMP", message: "ARCHIVE comp must be none or zstd"}
+		}
+	}
+	if raw := strings.ToLower(strings.TrimSpace(p["mode"])); raw != "" {
+		if raw != loadStrategyFast && raw != loadStrategyGentle {
+			return archiveRequest{}, protocolErr{code: "BAD_REQUEST", message: "invalid ARCHIVE mode"}
+		}
+		parsed.Mode = raw
+	}
+	return parsed, nil
+}
+
+func handleARCHIVE(ctx context.Context, req Request, out io.Writer, deps Deps) error {
+	return handleARCHIVEWithOptions(ctx, req, out, deps, nil)
+}
+
+// handleARCHIVEWithOptions streams every file of a transfer as one POSIX tar
+// archive, optionally zstd compressed, framed as FA/1 chunks.
+func handleARCHIVEWithOptions(ctx context.Context, req Request, out io.Writer, deps Deps, limiter *limit.Limiter) error {
+	parsed, err := parseARCHIVERequest(req)
+	if err != nil {
+		return err
+	}
+	transfer, ok := deps.GetTransfer(parsed.TransferID)
+	if !ok {
+		return protocolErr{code: "NOT_FOUND", message: "transfer not found"}
+	}
+	if source.IsRemote(transfer.Directory) {
+		return protocolErr{code: "UNPROCESSABLE", message: "object-store roots only support SEND"}
+	}
+	entries, missing, err := archiveEntries(transfer)
+	if err != nil {
+		return err
+	}
+	if limiter != nil && parsed.Mode == loadStrategyGentle {
+		out = limiter.WrapRateLimitedWriter(out, ctx)
+	}
+
+	chunks := &archiveChunkWriter{w: out, buf: make([]byte, 0, archiveChunkBytes)}
+	compWriter, closeCompWriter, _, err := encoding.WrapCompressedWriter(chunks, parsed.Comp, parsed.Mode)
+	if err != nil {
+		return protocolErr{code: "INTERNAL", message: "failed to initialize compression"}
+	}
+	tw := tar.NewWriter(compWriter)
+	peer := peerFromContext(ctx)
+	var totalBytes int64
+	for _, entry := range entries {
+		n, err := writeArchiveEntry(ctx, tw, deps, parsed.TransferID, entry)
+		if errors.Is(err, errArchiveEntryGone) {
+			missing = append(missing, entry.FileID)
+			continue
+		}
+		if err != nil {
+			if isBrokenPipe(err) {
+				return nil
+			}
+			return err
+		}
+		totalBytes += n
+		fileID := entry.FileID
+		peer.audit(AuditEvent{
+			Event:      "window.sent",
+			TransferID: parsed.TransferID,
+			FileID:     &fileID,
+			Path:       entry.FullPath,
+			Bytes:      n,
+		})
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := closeCompWriter(); err != nil {
+		return err
+	}
+	if err := chunks.Close(); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		slog.Warn("archive.files_missing", "txfer", parsed.TransferID, "files", len(missing), "fids", missing)
+	}
+	attrs := []any{
+		"txfer", parsed.TransferID,
+		"files", len(entries) - len(missing),
+		"missing", len(missing),
+		"bytes", totalBytes,
+		"wire_bytes", chunks.written,
+		"comp", parsed.Comp,
+	}
+	slog.Info("archive.sent", append(attrs, peer.logAttrs()...)...)
+	tracing.SpanFromContext(ctx).SetAttributes(attrs...)
+	return nil
+}
+
+// errArchiveEntryGone marks a registered file that disappeared between the
+// walk and its turn in the archive; it is skipped like one the walk missed.
+var errArchiveEntryGone = errors.New("archive entry gone")
+
+// archiveEntries lists the files registered on the transfer (by TXFER and
+// any WATCH since) in file id order. The store only keeps path digests, so
+// the root is walked to recover each registered file's path; files nobody
+// registered are left out, and registered files the walk no longer finds
+// (removed, renamed, or under an unreadable directory) are returned as
+// missing rather than failing the archive.
+func archiveEntries(transfer Transfer) ([]archiveEntry, []uint64, error) {
+	root := filepath.Clean(transfer.Directory)
+	registered := make(map[xxh3.Uint128]uint64, transfer.NumFiles)
+	for i := 0; i < transfer.NumFiles && i < len(transfer.PathHash); i++ {
+		registered[transfer.PathHash[i]] = uint64(i)
+	}
+	entries := make([]archiveEntry, 0, len(registered))
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if path == root {
+				return walkErr
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fullPath := filepath.Clean(path)
+		hash := xxh3.Hash128([]byte(fullPath))
+		fileID, ok := registered[hash]
+		if !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		delete(registered, hash)
+		entries = append(entries, archiveEntry{
+			FileID:   fileID,
+			Path:     filepath.ToSlash(rel),
+			FullPath: fullPath,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, protocolErr{code: "UNPROCESSABLE", message: "failed to walk transfer root"}
+	}
+	slices.SortFunc(entries, func(a, b archiveEntry) int {
+		return cmp.Compare(a.FileID, b.FileID)
+	})
+	missing := slices.Sorted(maps.Values(registered))
+	return entries, missing, nil
+}
+
+func writeArchiveEntry(ctx context.Context, tw *tar.Writer, deps Deps, txferID string, entry archiveEntry) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	fd, fileRef, err := deps.GetFile(txferID, entry.FileID, entry.FullPath)
+	var lookupErr *FileLookupError
+	if errors.As(err, &lookupErr) && lookupErr.Code == http.StatusNotFound {
+		return 0, errArchiveEntryGone
+	}
+	if err != nil {
+		return 0, mapLookupError(err)
+	}
+	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		return 0, protocolErr{code: "INTERNAL", message: "failed to stat file"}
+	}
+	meta := encoding.CollectFileFrameMetadata(fileRef.Path, info)
+	if err := tw.WriteHeader(archiveHeader(entry.Path, meta)); err != nil {
+		return 0, err
+	}
+	n, err := io.CopyN(tw, fd, meta.Size)
+	if err == io.EOF {
+		return n, protocolErr{code: "CHANGED", message: fmt.Sprintf("fid=%d shrank while archiving", entry.FileID)}
+	}
+	return n, err
+}
+
+// archiveHeader builds a PAX tar header from the same metadata SEND puts in
+// its trailer. Unknown owners are left unset.
+func archiveHeader(name string, meta encoding.FileFrameMetadata) *tar.Header {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     meta.Size,
+		ModTime:  time.Unix(0, meta.MtimeNS),
+		Format:   tar.FormatPAX,
+	}
+	// meta.Mode carries os.FileMode bits; tar wants the POSIX ones.
+	if raw, err := strconv.ParseUint(meta.Mode, 8, 32); err == nil {
+		mode := os.FileMode(raw)
+		hdr.Mode = int64(mode.Perm())
+		if mode&os.ModeSetuid != 0 {
+			hdr.Mode |= 0o4000
+		}
+		if mode&os.ModeSetgid != 0 {
+			hdr.Mode |= 0o2000
+		}
+		if mode&os.ModeSticky != 0 {
+			hdr.Mode |= 0o1000
+		}
+	}
+	if uid, err := strconv.Atoi(meta.UID); err == nil {
+		hdr.Uid = uid
+	}
+	if gid, err := strconv.Atoi(meta.GID); err == nil {
+		hdr.Gid = gid
+	}
+	if meta.User != "unknown" {
+		hdr.Uname = meta.User
+	}
+	if meta.Group != "unknown" {
+		hdr.Gname = meta.Group
+	}
+	return hdr
+}
+
+// archiveChunkWriter frames the archive stream as "FA/1 <n>\n" chunks so the
+// client can find its end before the terminal status line. Close writes the
+// empty chunk that ends the stream.
+type archiveChunkWriter struct {
+	w       io.Writer
+	buf     []byte
+	written int64
+}
+
+func (c *archiveChunkWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		n := min(len(p), cap(c.buf)-len(c.buf))
+		c.buf = append(c.buf, p[:n]...)
+		p = p[n:]
+		if len(c.buf) == cap(c.buf) {
+			if err := c.flush(); err != nil {
+				return total - len(p), err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (c *archiveChunkWriter) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(c.w, "FA/1 %d\n", len(c.buf)); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(c.buf); err != nil {
+		return err
+	}
+	c.written += int64(len(c.buf))
+	c.buf = c.buf[:0]
+	return nil
+}
+
+func (c *archiveChunkWriter) Close() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.w, "FA/1 0\n")
+	return err
+}
@@ -0,0 +1,198 @@
+package ftcp
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/zeebo/xxh3"
+)
+
+func newArchiveTestTransfer(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o640); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	req, err := ParseRequest([]byte(fmt.Sprintf(`TXFER %q mode=fast link-mbps=0 concurrency=1`, root)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var manifest bytes.Buffer
+	if err := handleTXFER(context.Background(), req, &manifest, NewRuntimeDeps()); err != nil {
+		t.Fatalf("handleTXFER failed: %v", err)
+	}
+	return strings.Fields(manifest.String())[1], root
+}
+
+// readArchiveChunks reassembles FA/1 chunks and returns the bytes after the
+// final empty chunk.
+func readArchiveChunks(t *testing.T, raw []byte) ([]byte, string) {
+	t.Helper()
+	br := bufio.NewReader(bytes.NewReader(raw))
+	var archive bytes.Buffer
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read chunk header: %v (archive so far %d bytes)", err, archive.Len())
+		}
+		n, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(line), "FA/1 "), 10, 64)
+		if err != nil {
+			t.Fatalf("bad chunk header %q", line)
+		}
+		if n == 0 {
+			break
+		}
+		if _, err := io.CopyN(&archive, br, n); err != nil {
+			t.Fatalf("read chunk: %v", err)
+		}
+	}
+	rest, _ := io.ReadAll(br)
+	return archive.Bytes(), string(rest)
+}
+
+func TestHandleARCHIVEStreamsCompressedTar(t *testing.T) {
+	files := map[string]string{
+		"a.txt":         "alpha",
+		"dir/b.txt":     strings.Repeat("bravo ", 1000),
+		"dir/sub/c.txt": "",
+	}
+	txferID, root := newArchiveTestTransfer(t, files)
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	if err := os.Chtimes(filepath.Join(root, "a.txt"), mtime, mtime); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	req, err := ParseRequest([]byte("ARCHIVE " + txferID + " comp=zstd"))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := handleARCHIVE(context.Background(), req, &out, NewRuntimeDeps()); err != nil {
+		t.Fatalf("handleARCHIVE failed: %v", err)
+	}
+	compressed, rest := readArchiveChunks(t, out.Bytes())
+	if rest != "" {
+		t.Fatalf("unexpected bytes after final chunk: %q", rest)
+	}
+	dec, err := zstd.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("zstd reader: %v", err)
+	}
+	defer dec.Close()
+
+	tr := tar.NewReader(dec)
+	seen := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar next: %v", err)
+		}
+		want, ok := files[hdr.Name]
+		if !ok {
+			t.Fatalf("unexpected archive entry %q", hdr.Name)
+		}
+		got, _ := io.ReadAll(tr)
+		if string(got) != want {
+			t.Fatalf("%s: content mismatch", hdr.Name)
+		}
+		if hdr.Mode != 0o640 || hdr.Uid != os.Getuid() || hdr.Gid != os.Getgid() {
+			t.Fatalf("%s: unexpected mode/owner: mode=%o uid=%d gid=%d", hdr.Name, hdr.Mode, hdr.Uid, hdr.Gid)
+		}
+		if hdr.Name == "a.txt" && !hdr.ModTime.Equal(mtime) {
+			t.Fatalf("a.txt: expected mtime %v, got %v", mtime, hdr.ModTime)
+		}
+		seen[hdr.Name] = true
+	}
+	if len(seen) != len(files) {
+		t.Fatalf("expected %d entries, got %v", len(files), seen)
+	}
+}
+
+func TestHandleARCHIVEUsesRegisteredFilesAndSkipsMissing(t *testing.T) {
+	txferID, root := newArchiveTestTransfer(t, map[string]string{"b.txt": "b", "c.txt": "c"})
+	deps := NewRuntimeDeps()
+	// Unregistered: not archived. Removed: skipped. Registered by WATCH:
+	// archived under the id the store gave it.
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("new"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.Remove(filepath.Join(root, "c.txt")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	watched := filepath.Join(root, "sub", "watched.txt")
+	if err := os.MkdirAll(filepath.Dir(watched), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(watched, []byte("watched"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, added, ok := deps.AppendTransferFile(txferID, TransferFileStateUpdate{PathHash: xxh3.Hash128([]byte(watched)), FileSize: 7}); !ok || !added {
+		t.Fatalf("register watched file: added=%t ok=%t", added, ok)
+	}
+
+	req, err := ParseRequest([]byte("ARCHIVE " + txferID))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := handleARCHIVE(context.Background(), req, &out, deps); err != nil {
+		t.Fatalf("handleARCHIVE failed: %v", err)
+	}
+	archive, _ := readArchiveChunks(t, out.Bytes())
+	tr := tar.NewReader(bytes.NewReader(archive))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar next: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != "b.txt,sub/watched.txt" {
+		t.Fatalf("unexpected archive entries: %v", names)
+	}
+}
+
+func TestParseARCHIVERequestValidatesOptions(t *testing.T) {
+	for _, raw := range []string{"ARCHIVE tx1 comp=lz4", "ARCHIVE tx1 mode=slow", "ARCHIVE"} {
+		req, err := ParseRequest([]byte(raw))
+		if err == nil {
+			_, err = parseARCHIVERequest(req)
+		}
+		if err == nil {
+			t.Fatalf("%q: expected error", raw)
+		}
+	}
+	req, err := ParseRequest([]byte("ARCHIVE tx1 comp=none mode=gentle"))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	parsed, err := parseARCHIVERequest(req)
+	if err != nil || parsed.TransferID != "tx1" || parsed.Comp != "identity" || parsed.Mode != "gentle" {
+		t.Fatalf("unexpected parse: %+v err=%v", parsed, err)
+	}
+}
@@ -0,0 +1,137 @@
+package ftcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+// AuditLog appends one JSON line per transfer event to
+// <dir>/<txferid>.audit.jsonl so operators can tell who pulled which files.
+type AuditLog struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// AuditEvent is a single line of a transfer audit file.
+type AuditEvent struct {
+	Time       time.Time `json:"ts"`
+	Event      string    `json:"event"`
+	TransferID string    `json:"txfer"`
+	Recipient  string    `json:"recipient,omitempty"`
+	RemoteAddr string    `json:"remote,omitempty"`
+	Directory  string    `json:"directory,omitempty"`
+	FileID     *uint64   `json:"fid,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Offset     int64     `json:"offset,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	WireBytes  int64     `json:"wire_bytes,omitempty"`
+}
+
+func NewAuditLog(dir string) (*AuditLog, error) {
+	dir = strings.TrimSpace(dir)
+	if dir == "" {
+		return nil, errors.New("audit directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create audit directory %s: %w", dir, err)
+	}
+	return &AuditLog{dir: dir}, nil
+}
+
+// Path returns the audit file used for txferID.
+func (a *AuditLog) Path(txferID string) string {
+	return filepath.Join(a.dir, txferID+".audit.jsonl")
+}
+
+// Record appends ev to the transfer's audit file. A nil AuditLog discards
+// events.
+func (a *AuditLog) Record(ev AuditEvent) error {
+	if a == nil {
+		return nil
+	}
+	if ev.TransferID == "" || strings.ContainsAny(ev.TransferID, `/\`) || strings.HasPrefix(ev.TransferID, ".") {
+		return fmt.Errorf("invalid audit transfer id %q", ev.TransferID)
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.Path(ev.TransferID), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// peerInfo identifies the client behind a command for logs and audit records.
+type peerInfo struct {
+	RemoteAddr string
+	Recipient  string
+	auditLog   *AuditLog
+}
+
+type peerContextKey struct{}
+
+func withPeer(ctx context.Context, p peerInfo) context.Context {
+	return context.WithValue(ctx, peerContextKey{}, p)
+}
+
+func peerFromContext(ctx context.Context) peerInfo {
+	if ctx == nil {
+		return peerInfo{}
+	}
+	p, _ := ctx.Value(peerContextKey{}).(peerInfo)
+	return p
+}
+
+func recipientString(r age.Recipient) string {
+	if x, ok := r.(*age.X25519Recipient); ok && x != nil {
+		return x.String()
+	}
+	return ""
+}
+
+// audit records ev with the peer identity attached. Failures are logged but
+// never fail the transfer itself.
+func (p peerInfo) audit(ev AuditEvent) {
+	if p.auditLog == nil {
+		return
+	}
+	ev.Recipient = p.Recipient
+	ev.RemoteAddr = p.RemoteAddr
+	if err := p.auditLog.Record(ev); err != nil {
+		slog.Warn("audit.failed", "txfer", ev.TransferID, "event", ev.Event, "err", err)
+	}
+}
+
+func (p peerInfo) logAttrs() []any {
+	attrs := make([]any, 0, 4)
+	if p.RemoteAddr != "" {
+		attrs = append(attrs, "remote", p.RemoteAddr)
+	}
+	if p.Recipient != "" {
+		attrs = append(attrs, "recipient", p.Recipient)
+	}
+	return attrs
+}
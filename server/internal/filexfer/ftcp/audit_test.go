@@ -0,0 +1,89 @@
+package ftcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func readAuditEvents(t *testing.T, path string) []AuditEvent {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open audit file: %v", err)
+	}
+	defer f.Close()
+	var events []AuditEvent
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var ev AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("decode audit line %q: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("scan audit file: %v", err)
+	}
+	return events
+}
+
+func TestAuditLogRejectsPathLikeTransferIDs(t *testing.T) {
+	audit, err := NewAuditLog(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewAuditLog failed: %v", err)
+	}
+	for _, id := range []string{"", "../x", "a/b", ".hidden"} {
+		if err := audit.Record(AuditEvent{Event: "window.sent", TransferID: id}); err == nil {
+			t.Fatalf("expected error for transfer id %q", id)
+		}
+	}
+	var nilAudit *AuditLog
+	if err := nilAudit.Record(AuditEvent{Event: "window.sent", TransferID: "tx"}); err != nil {
+		t.Fatalf("nil audit log should discard events, got %v", err)
+	}
+}
+
+func TestStreamSendItemWritesAuditRecord(t *testing.T) {
+	audit, err := NewAuditLog(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewAuditLog failed: %v", err)
+	}
+	data := bytes.Repeat([]byte("audit"), 1024)
+	tmp := writeTempSendFile(t, data)
+	deps := &sendTestDeps{filePath: tmp}
+	ctx := withPeer(context.Background(), peerInfo{
+		RemoteAddr: "192.0.2.10:5555",
+		Recipient:  "age1example",
+		auditLog:   audit,
+	})
+
+	var out bytes.Buffer
+	if err := streamSendItem(ctx, &out, deps, "tx-audit", sendItem{FileID: 3, Comp: "none", Path: tmp}); err != nil {
+		t.Fatalf("streamSendItem failed: %v", err)
+	}
+
+	events := readAuditEvents(t, audit.Path("tx-audit"))
+	if len(events) != 1 {
+		t.Fatalf("expected one audit event, got %d", len(events))
+	}
+	ev := events[0]
+	if ev.Event != "window.sent" || ev.TransferID != "tx-audit" {
+		t.Fatalf("unexpected audit event: %+v", ev)
+	}
+	if ev.FileID == nil || *ev.FileID != 3 || ev.Path != tmp {
+		t.Fatalf("unexpected audit file identity: %+v", ev)
+	}
+	if ev.Bytes != int64(len(data)) || ev.WireBytes != int64(len(data)) {
+		t.Fatalf("unexpected audit byte counts: %+v", ev)
+	}
+	if ev.Recipient != "age1example" || ev.RemoteAddr != "192.0.2.10:5555" {
+		t.Fatalf("unexpected audit peer: %+v", ev)
+	}
+	if ev.Time.IsZero() {
+		t.Fatalf("expected audit timestamp")
+	}
+}
@@ -18,7 +18,9 @@ type authResult struct {
 	encryptedRequests bool
 }
 
-func processAUTHRequest(req Request, requireAuth bool, serverID *age.X25519Identity) (authResult, error) {
+// processAUTHRequest validates an AUTH line. Encrypted blobs may be sealed to
+// any of serverIDs so that clients pinned to a rotated-out key still work.
+func processAUTHRequest(req Request, requireAuth bool, serverIDs []age.Identity) (authResult, error) {
 	if req.Verb != VerbAUTH {
 		return authResult{}, protocolErr{code: "BAD_COMMAND", message: "invalid auth command"}
 	}
@@ -32,15 +34,18 @@ func processAUTHRequest(req Request, requireAuth bool, serverID *age.X25519Ident
 		}
 		return authResult{}, nil
 	}
-	if requireAuth {
-		if serverID == nil {
+	// Clients holding a pinned server key always send an encrypted blob, so
+	// accept it even when auth is optional as long as we can decrypt it.
+	encryptedBlob := strings.HasPrefix(strings.TrimSpace(blob), "b64:")
+	if requireAuth || (encryptedBlob && len(serverIDs) > 0) {
+		if len(serverIDs) == 0 {
 			return authResult{}, errNotAuthorized
 		}
 		cipherBlob, err := decodeAUTHBlob(blob)
 		if err != nil {
 			return authResult{}, errNotAuthorized
 		}
-		dec, err := age.Decrypt(bytes.NewReader(cipherBlob), serverID)
+		dec, err := age.Decrypt(bytes.NewReader(cipherBlob), serverIDs...)
 		if err != nil {
 			return authResult{}, errNotAuthorized
 		}
@@ -0,0 +1,77 @@
+package ftcp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func encryptedAUTHRequest(t *testing.T, serverID *age.X25519Identity, plaintext string) Request {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, serverID.Recipient())
+	if err != nil {
+		t.Fatalf("age.Encrypt: %v", err)
+	}
+	if _, err := w.Write([]byte(plaintext)); err != nil {
+		t.Fatalf("write auth plaintext: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close auth writer: %v", err)
+	}
+	return Request{Verb: VerbAUTH, Params: []map[string]string{{"blob": "b64:" + base64.StdEncoding.EncodeToString(buf.Bytes())}}}
+}
+
+func TestProcessAUTHRequestEncryptedBlob(t *testing.T) {
+	serverID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate server identity: %v", err)
+	}
+	clientID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate client identity: %v", err)
+	}
+	req := encryptedAUTHRequest(t, serverID, clientID.Recipient().String())
+
+	for _, requireAuth := range []bool{true, false} {
+		res, err := processAUTHRequest(req, requireAuth, []age.Identity{serverID})
+		if err != nil {
+			t.Fatalf("requireAuth=%v: processAUTHRequest failed: %v", requireAuth, err)
+		}
+		if !res.encryptedRequests {
+			t.Fatalf("requireAuth=%v: expected encrypted requests", requireAuth)
+		}
+		if got := res.recipient.(*age.X25519Recipient).String(); got != clientID.Recipient().String() {
+			t.Fatalf("requireAuth=%v: unexpected recipient %s", requireAuth, got)
+		}
+	}
+
+	otherID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate other identity: %v", err)
+	}
+	if _, err := processAUTHRequest(req, false, []age.Identity{otherID}); !errors.Is(err, errNotAuthorized) {
+		t.Fatalf("expected not authorized for wrong server key, got %v", err)
+	}
+}
+
+func TestProcessAUTHRequestPlainRecipient(t *testing.T) {
+	clientID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate client identity: %v", err)
+	}
+	req := Request{Verb: VerbAUTH, Params: []map[string]string{{"blob": clientID.Recipient().String()}}}
+	res, err := processAUTHRequest(req, false, nil)
+	if err != nil {
+		t.Fatalf("processAUTHRequest failed: %v", err)
+	}
+	if res.encryptedRequests || res.recipient == nil {
+		t.Fatalf("expected plaintext requests with response recipient, got %+v", res)
+	}
+	if _, err := processAUTHRequest(req, true, []age.Identity{clientID}); !errors.Is(err, errNotAuthorized) {
+		t.Fatalf("expected not authorized for plaintext blob with require-auth, got %v", err)
+	}
+}
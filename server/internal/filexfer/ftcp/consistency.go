@@ -0,0 +1,91 @@
+package ftcp
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+)
+
+const (
+	consistencyStrict = "strict"
+	consistencyWarn   = "warn"
+	consistencyIgnore = "ignore"
+)
+
+// fileIdentity is the subset of stat that tells whether a file changed
+// between TXFER and SEND, or while a window was being sent.
+type fileIdentity struct {
+	Size    int64
+	MtimeNS int64
+	Inode   uint64
+}
+
+func identityFromInfo(info fs.FileInfo) fileIdentity {
+	return fileIdentity{
+		Size:    info.Size(),
+		MtimeNS: info.ModTime().UnixNano(),
+		Inode:   statInode(info),
+	}
+}
+
+func identityFromRef(ref FileRef) fileIdentity {
+	return fileIdentity{Size: ref.FileSize, MtimeNS: ref.MtimeNS, Inode: ref.Inode}
+}
+
+// diff describes how got differs from want. Zero fields in want were never
+// recorded and are not compared.
+func (want fileIdentity) diff(got fileIdentity) string {
+	var changes []string
+	if want.Size != got.Size {
+		changes = append(changes, fmt.Sprintf("size %d->%d", want.Size, got.Size))
+	}
+	if want.MtimeNS != 0 && want.MtimeNS != got.MtimeNS {
+		changes = append(changes, fmt.Sprintf("mtime %d->%d", want.MtimeNS, got.MtimeNS))
+	}
+	if want.Inode != 0 && want.Inode != got.Inode {
+		changes = append(changes, fmt.Sprintf("inode %d->%d", want.Inode, got.Inode))
+	}
+	return strings.Join(changes, ", ")
+}
+
+func parseConsistencyOption(raw string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(raw))
+	switch mode {
+	case "":
+		return consistencyWarn, nil
+	case consistencyStrict, consistencyWarn, consistencyIgnore:
+		return mode, nil
+	}
+	return "", protocolErr{code: "BAD_REQUEST", message: "consistency must be strict, warn, or ignore"}
+}
+
+// checkConsistency compares a fresh identity against the expected one. In
+// strict mode a change fails the window with ERR CHANGED and re-records the
+// file as it is now, so the client can re-queue it against the new size; in
+// warn mode it is only logged and the client receives the bytes present at
+// read time.
+func checkConsistency(deps Deps, mode string, txferID string, fid uint64, stage string, want fileIdentity, got fileIdentity) error {
+	return reportChange(deps, mode, txferID, fid, stage, got, want.diff(got))
+}
+
+func reportChange(deps Deps, mode string, txferID string, fid uint64, stage string, current fileIdentity, changes string) error {
+	if mode == consistencyIgnore || changes == "" {
+		return nil
+	}
+	fileChanged.With(mode).Inc()
+	slog.Warn("file.changed", "txfer", txferID, "fid", fid, "stage", stage, "consistency", mode, "changes", changes)
+	if mode != consistencyStrict {
+		return nil
+	}
+	deps.RefreshTransferFile(txferID, TransferFileStateUpdate{
+		FileID:   fid,
+		FileSize: current.Size,
+		MtimeNS:  current.MtimeNS,
+		Inode:    current.Inode,
+	})
+	return protocolErr{
+		code:    "CHANGED",
+		message: fmt.Sprintf("fid=%d size=%d mtime=%d changed during %s: %s", fid, current.Size, current.MtimeNS, stage, changes),
+	}
+}
@@ -0,0 +1,89 @@
+package ftcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	intstore "github.com/jolynch/pinch/internal/filexfer/store"
+)
+
+// errTransferCancelled ends a SEND whose transfer was cancelled; it is
+// written in place of the next frame header.
+var errTransferCancelled = protocolErr{code: "CANCELLED", message: "transfer cancelled"}
+
+type controlRequest struct {
+	TransferID string
+}
+
+// parseControlRequest parses CANCEL, PAUSE, RESUME and RENEW, which all
+// take just a transfer id.
+func parseControlRequest(req Request, verb Verb) (controlRequest, error) {
+	if req.Verb != verb {
+		return controlRequest{}, protocolErr{code: "BAD_COMMAND", message: "not " + verb.String()}
+	}
+	if len(req.Params) != 1 {
+		return controlRequest{}, protocolErr{code: "BAD_REQUEST", message: "invalid " + verb.String() + " arguments"}
+	}
+	txferID := req.Params[0]["txferid"]
+	if txferID == "" {
+		return controlRequest{}, protocolErr{code: "BAD_REQUEST", message: "missing transfer id"}
+	}
+	return controlRequest{TransferID: txferID}, nil
+}
+
+// handleCANCEL aborts the transfer's in-flight SENDs and releases its
+// store state; later commands for it see NOT_FOUND.
+func handleCANCEL(ctx context.Context, req Request, out io.Writer, deps Deps) error {
+	return handleControl(ctx, req, out, VerbCANCEL, "transfer.cancelled", deps.CancelTransfer)
+}
+
+// handlePAUSE holds the transfer's SENDs between frames until RESUME.
+func handlePAUSE(ctx context.Context, req Request, out io.Writer, deps Deps) error {
+	return handleControl(ctx, req, out, VerbPAUSE, "transfer.paused", deps.PauseTransfer)
+}
+
+func handleRESUME(ctx context.Context, req Request, out io.Writer, deps Deps) error {
+	return handleControl(ctx, req, out, VerbRESUME, "transfer.resumed", deps.ResumeTransfer)
+}
+
+func handleControl(ctx context.Context, req Request, out io.Writer, verb Verb, event string, apply func(string) bool) error {
+	parsed, err := parseControlRequest(req, verb)
+	if err != nil {
+		return err
+	}
+	if !apply(parsed.TransferID) {
+		return protocolErr{code: "NOT_FOUND", message: "transfer not found"}
+	}
+	peer := peerFromContext(ctx)
+	slog.Info(event, append([]any{"txfer", parsed.TransferID}, peer.logAttrs()...)...)
+	peer.audit(AuditEvent{Event: event, TransferID: parsed.TransferID})
+	return writeOKLine(out, "")
+}
+
+// handleRENEW extends the transfer's lease for clients that are alive but
+// not sending or acking, and reports the new expiry.
+func handleRENEW(_ context.Context, req Request, out io.Writer, deps Deps) error {
+	parsed, err := parseControlRequest(req, VerbRENEW)
+	if err != nil {
+		return err
+	}
+	expiresAt, ok := deps.RenewTransfer(parsed.TransferID, 0)
+	if !ok {
+		return protocolErr{code: "NOT_FOUND", message: "transfer not found"}
+	}
+	return writeOKLine(out, fmt.Sprintf("expires-at-ms=%d ttl-ms=%d", expiresAt.UnixMilli(), time.Until(expiresAt).Round(time.Millisecond).Milliseconds()))
+}
+
+// waitTransferGate holds a SEND between frames while its transfer is
+// paused, and ends it once the transfer is cancelled.
+func waitTransferGate(ctx context.Context, gate *TransferGate) error {
+	err := gate.Wait(ctx)
+	if errors.Is(err, intstore.ErrTransferCancelled) {
+		return errTransferCancelled
+	}
+	return err
+}
@@ -0,0 +1,168 @@
+package ftcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newControlTestTransfer registers a root holding one file that SEND
+// streams as two frames.
+func newControlTestTransfer(t *testing.T, deps Deps) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	path := filepath.Join(root, "big.bin")
+	payload := bytes.Repeat([]byte("pinch"), int(defaultFileFrameLogicalSize/5)+16)
+	if err := os.WriteFile(path, payload, 0o640); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	req, err := ParseRequest([]byte(fmt.Sprintf(`TXFER %q mode=fast link-mbps=0 concurrency=1`, root)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var manifest bytes.Buffer
+	if err := handleTXFER(context.Background(), req, &manifest, deps); err != nil {
+		t.Fatalf("handleTXFER failed: %v", err)
+	}
+	return strings.Fields(manifest.String())[1], path
+}
+
+// hookWriter runs onWrite before the first write it sees.
+type hookWriter struct {
+	once    sync.Once
+	onWrite func()
+	n       atomic.Int64
+}
+
+func (w *hookWriter) Write(p []byte) (int, error) {
+	w.once.Do(w.onWrite)
+	w.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func TestParseRequestControlVerbs(t *testing.T) {
+	for _, verb := range []Verb{VerbCANCEL, VerbPAUSE, VerbRESUME, VerbRENEW} {
+		req, err := ParseRequest([]byte(verb.String() + " tx1"))
+		if err != nil {
+			t.Fatalf("ParseRequest(%s) failed: %v", verb, err)
+		}
+		parsed, err := parseControlRequest(req, verb)
+		if err != nil || parsed.TransferID != "tx1" {
+			t.Fatalf("parseControlRequest(%s)=%+v,%v", verb, parsed, err)
+		}
+		if _, err := ParseRequest([]byte(verb.String() + " tx1 extra")); err == nil {
+			t.Fatalf("expected %s with extra arguments to fail", verb)
+		}
+	}
+	var pe protocolErr
+	err := handleCANCEL(context.Background(), Request{Verb: VerbCANCEL, Params: []map[string]string{{"txferid": "missing"}}}, &bytes.Buffer{}, NewRuntimeDeps())
+	if !errors.As(err, &pe) || pe.code != "NOT_FOUND" {
+		t.Fatalf("expected NOT_FOUND for unknown transfer, got %v", err)
+	}
+}
+
+func TestHandleCANCELEndsInFlightSENDBetweenFrames(t *testing.T) {
+	deps := NewRuntimeDeps()
+	txferID, path := newControlTestTransfer(t, deps)
+	send, err := ParseRequest([]byte(fmt.Sprintf(`SEND %s fd=0 %q comp=none`, txferID, path)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	out := &hookWriter{onWrite: func() {
+		var ok bytes.Buffer
+		if err := handleCANCEL(context.Background(), Request{Verb: VerbCANCEL, Params: []map[string]string{{"txferid": txferID}}}, &ok, deps); err != nil {
+			t.Errorf("handleCANCEL failed: %v", err)
+		}
+		if got := ok.String(); got != "OK\r\n" {
+			t.Errorf("CANCEL response=%q", got)
+		}
+	}}
+	var pe protocolErr
+	if err := handleSEND(context.Background(), send, out, deps); !errors.As(err, &pe) || pe.code != "CANCELLED" {
+		t.Fatalf("expected CANCELLED, got %v", err)
+	}
+	if got := out.n.Load(); got <= defaultFileFrameLogicalSize || got > defaultFileFrameLogicalSize+4096 {
+		t.Fatalf("expected exactly the first frame before cancelling, wrote %d bytes", got)
+	}
+	if _, ok := deps.GetTransfer(txferID); ok {
+		t.Fatalf("transfer %q still present after CANCEL", txferID)
+	}
+}
+
+func TestHandlePAUSEHoldsSENDUntilRESUME(t *testing.T) {
+	deps := NewRuntimeDeps()
+	txferID, path := newControlTestTransfer(t, deps)
+	control := func(verb Verb, handler HandlerFunc) {
+		t.Helper()
+		req := Request{Verb: verb, Params: []map[string]string{{"txferid": txferID}}}
+		if err := handler(context.Background(), req, &bytes.Buffer{}, deps); err != nil {
+			t.Fatalf("%s failed: %v", verb, err)
+		}
+	}
+	control(VerbPAUSE, handlePAUSE)
+	status, err := transferStatus(deps, txferID)
+	if err != nil || !status.Paused {
+		t.Fatalf("expected paused status, got %+v err=%v", status, err)
+	}
+
+	send, err := ParseRequest([]byte(fmt.Sprintf(`SEND %s fd=0 %q comp=none`, txferID, path)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	out := &hookWriter{onWrite: func() {}}
+	done := make(chan error, 1)
+	go func() { done <- handleSEND(context.Background(), send, out, deps) }()
+	select {
+	case err := <-done:
+		t.Fatalf("SEND finished while paused: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := out.n.Load(); got != 0 {
+		t.Fatalf("paused SEND wrote %d bytes", got)
+	}
+
+	control(VerbRESUME, handleRESUME)
+	if err := <-done; err != nil {
+		t.Fatalf("SEND after RESUME failed: %v", err)
+	}
+	if got := out.n.Load(); got <= defaultFileFrameLogicalSize {
+		t.Fatalf("expected the whole file after RESUME, wrote %d bytes", got)
+	}
+}
+
+func TestHandleRENEWExtendsLease(t *testing.T) {
+	deps := NewRuntimeDeps()
+	txferID, _ := newControlTestTransfer(t, deps)
+	before, ok := deps.GetTransfer(txferID)
+	if !ok {
+		t.Fatalf("transfer %q not found", txferID)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var out bytes.Buffer
+	if err := handleRENEW(context.Background(), Request{Verb: VerbRENEW, Params: []map[string]string{{"txferid": txferID}}}, &out, deps); err != nil {
+		t.Fatalf("handleRENEW failed: %v", err)
+	}
+	after, _ := deps.GetTransfer(txferID)
+	if !after.ExpiresAt.After(before.ExpiresAt) {
+		t.Fatalf("expected RENEW to extend expiry: before=%s after=%s", before.ExpiresAt, after.ExpiresAt)
+	}
+	want := fmt.Sprintf("OK expires-at-ms=%d ttl-ms=%d\r\n", after.ExpiresAt.UnixMilli(), after.TTL.Milliseconds())
+	if got := out.String(); got != want {
+		t.Fatalf("RENEW response=%q want %q", got, want)
+	}
+
+	var pe protocolErr
+	err := handleRENEW(context.Background(), Request{Verb: VerbRENEW, Params: []map[string]string{{"txferid": "missing"}}}, &out, deps)
+	if !errors.As(err, &pe) || pe.code != "NOT_FOUND" {
+		t.Fatalf("expected NOT_FOUND for unknown transfer, got %v", err)
+	}
+}
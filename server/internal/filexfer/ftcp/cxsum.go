@@ -21,6 +21,9 @@ type cxsumRequest struct {
 	WindowSize   int64
 	ChecksumsCSV string
 	Path         string
+	// Meta selects extra terminal trailer metadata, as SEND's meta= does, so
+	// a client refreshing a finished file sees the same fields.
+	Meta encoding.MetadataOptions
 }
 
 func parseCXSUMRequest(req Request) (cxsumRequest, error) {
@@ -53,6 +56,7 @@ func parseCXSUMRequest(req Request) (cxsumRequest, error) {
 		WindowSize:   windowSize,
 		ChecksumsCSV: p["checksums-csv"],
 		Path:         path,
+		Meta:         parseSENDMetaOption(p["meta"]),
 	}, nil
 }
 
@@ -84,7 +88,7 @@ func handleCXSUM(_ context.Context, req Request, out io.Writer, deps Deps) error
 		return protocolErr{code: "BAD_REQUEST", message: "invalid checksum parameter"}
 	}
 
-	metadata := encoding.CollectFileFrameMetadata(fileRef.Path, fileInfo)
+	metadata := encoding.CollectFileFrameMetadataWithOptions(fileRef.Path, fileInfo, parsed.Meta)
 	full128 := xxh3.New128()
 	full64 := xxh3.New()
 
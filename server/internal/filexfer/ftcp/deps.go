@@ -4,7 +4,9 @@ import (
 	"errors"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/jolynch/pinch/internal/filexfer/source"
 	intstore "github.com/jolynch/pinch/internal/filexfer/store"
 )
 
@@ -13,6 +15,7 @@ type TransferFileState = intstore.TransferFileState
 type TransferFileStateUpdate = intstore.TransferFileStateUpdate
 type FileRef = intstore.FileRef
 type FileLookupError = intstore.FileLookupError
+type TransferGate = intstore.TransferGate
 
 const (
 	TransferStateStarted = intstore.TransferStateStarted
@@ -25,10 +28,19 @@ type Deps interface {
 	NewTransfer(directory string, numFiles int, totalSize int64) (Transfer, error)
 	DeleteTransfer(txferID string) bool
 	RegisterTransferFileState(txferID string, updatesCh <-chan TransferFileStateUpdate, state uint8) <-chan struct{}
+	// AppendTransferFile registers a file found after TXFER under the next
+	// free file id, or returns the id its path already has.
+	AppendTransferFile(txferID string, update TransferFileStateUpdate) (fileID uint64, added bool, ok bool)
 	ClipTransfer(txferID string) bool
+	// SetTransferSource caches the source TXFER opened the root with, so
+	// SENDs on object-store roots do not build a client per file.
+	SetTransferSource(txferID string, src source.Source) bool
 
 	GetTransfer(txferID string) (Transfer, bool)
 	SetTransferHints(txferID string, mode string, linkMbps int64, concurrency int) bool
+	// UpdateTransferLink records a client link estimate when linkMbps > 0
+	// and returns the transfer's current link hint.
+	UpdateTransferLink(txferID string, linkMbps int64, rttMS int64) (int64, bool)
 	GetFile(txferID string, fileID uint64, fullPathRaw string) (*os.File, FileRef, error)
 	GetFileRef(txferID string, fileID uint64, fullPathRaw string) (FileRef, error)
 
@@ -36,6 +48,17 @@ type Deps interface {
 	SetTransferFileWindowHash(txferID string, fileID uint64, endBytes int64, hashToken string) bool
 	VerifyTransferFileWindowHash(txferID string, fileID uint64, endBytes int64, hashToken string) bool
 	AcknowledgeTransferFile(txferID string, fileID uint64, ackBytes int64) bool
+	RefreshTransferFile(txferID string, update TransferFileStateUpdate) bool
+
+	CancelTransfer(txferID string) bool
+	PauseTransfer(txferID string) bool
+	ResumeTransfer(txferID string) bool
+	// GetTransferGate returns the gate SENDs wait on between frames; nil
+	// never blocks.
+	GetTransferGate(txferID string) *TransferGate
+	// RenewTransfer extends the transfer's lease from now, first setting the
+	// lease to ttl when ttl > 0, and returns the new expiry.
+	RenewTransfer(txferID string, ttl time.Duration) (time.Time, bool)
 }
 
 type runtimeDeps struct{}
@@ -56,6 +79,14 @@ func (runtimeDeps) RegisterTransferFileState(txferID string, updatesCh <-chan Tr
 	return intstore.RegisterTransferFileState(txferID, updatesCh, state)
 }
 
+func (runtimeDeps) AppendTransferFile(txferID string, update TransferFileStateUpdate) (uint64, bool, bool) {
+	return intstore.AppendTransferFile(txferID, update)
+}
+
+func (runtimeDeps) SetTransferSource(txferID string, src source.Source) bool {
+	return intstore.SetTransferSource(txferID, src)
+}
+
 func (runtimeDeps) ClipTransfer(txferID string) bool {
 	return intstore.ClipTransfer(txferID)
 }
@@ -68,6 +99,10 @@ func (runtimeDeps) SetTransferHints(txferID string, mode string, linkMbps int64,
 	return intstore.SetTransferHints(txferID, mode, linkMbps, concurrency)
 }
 
+func (runtimeDeps) UpdateTransferLink(txferID string, linkMbps int64, rttMS int64) (int64, bool) {
+	return intstore.UpdateTransferLink(txferID, linkMbps, rttMS)
+}
+
 func (runtimeDeps) GetFile(txferID string, fileID uint64, fullPathRaw string) (*os.File, FileRef, error) {
 	return intstore.GetFile(txferID, fileID, fullPathRaw)
 }
@@ -88,10 +123,34 @@ func (runtimeDeps) VerifyTransferFileWindowHash(txferID string, fileID uint64, e
 	return intstore.VerifyTransferFileWindowHash(txferID, fileID, endBytes, hashToken)
 }
 
+func (runtimeDeps) RefreshTransferFile(txferID string, update TransferFileStateUpdate) bool {
+	return intstore.RefreshTransferFile(txferID, update)
+}
+
 func (runtimeDeps) AcknowledgeTransferFile(txferID string, fileID uint64, ackBytes int64) bool {
 	return intstore.AcknowledgeTransferFile(txferID, fileID, ackBytes)
 }
 
+func (runtimeDeps) CancelTransfer(txferID string) bool {
+	return intstore.CancelTransfer(txferID)
+}
+
+func (runtimeDeps) PauseTransfer(txferID string) bool {
+	return intstore.PauseTransfer(txferID)
+}
+
+func (runtimeDeps) ResumeTransfer(txferID string) bool {
+	return intstore.ResumeTransfer(txferID)
+}
+
+func (runtimeDeps) GetTransferGate(txferID string) *TransferGate {
+	return intstore.GetTransferGate(txferID)
+}
+
+func (runtimeDeps) RenewTransfer(txferID string, ttl time.Duration) (time.Time, bool) {
+	return intstore.RenewTransfer(txferID, ttl)
+}
+
 func mapLookupError(err error) error {
 	if err == nil {
 		return nil
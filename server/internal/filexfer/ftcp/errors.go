@@ -43,6 +43,32 @@ func mapHTTPErrorCode(status int) string {
 	}
 }
 
+// httpStatusForCode is the inverse of mapHTTPErrorCode for the HTTP gateway.
+func httpStatusForCode(code string) int {
+	switch code {
+	case "BAD_REQUEST", "BAD_COMMAND", "UNSUPPORTED_COMP":
+		return http.StatusBadRequest
+	case "NOT_FOUND":
+		return http.StatusNotFound
+	case "CONFLICT", "CHANGED":
+		return http.StatusConflict
+	case "UNPROCESSABLE":
+		return http.StatusUnprocessableEntity
+	case "RANGE":
+		return http.StatusRequestedRangeNotSatisfiable
+	case "TIMEOUT":
+		return http.StatusGatewayTimeout
+	case "NOT_AUTHORIZED":
+		return http.StatusForbidden
+	}
+	if raw, ok := strings.CutPrefix(code, "HTTP_"); ok {
+		if status, err := strconv.Atoi(raw); err == nil && status >= 400 && status < 600 {
+			return status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
 func writeErrFrame(w io.Writer, err error) error {
 	if w == nil || err == nil {
 		return nil
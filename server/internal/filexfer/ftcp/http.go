@@ -0,0 +1,259 @@
+package ftcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jolynch/pinch/internal/filexfer/encoding"
+	"github.com/jolynch/pinch/internal/filexfer/limit"
+	"github.com/jolynch/pinch/internal/filexfer/source"
+)
+
+// HTTPOptions configures the HTTP gateway to the file-transfer commands.
+type HTTPOptions struct {
+	// AuditLog, when set, records which peer pulled which files.
+	AuditLog *AuditLog
+	Deps     Deps
+	Limiter  *limit.Limiter
+}
+
+// NewHTTPHandler serves TXFER, SEND and STATUS over plain HTTP so curl and
+// browsers can pull files:
+//
+//	GET /fs/manifest?dir=<abs-dir>[&mode=&link-mbps=&concurrency=&verbose=]
+//	GET /fs/file/<txferid>/<fid>?path=<manifest-path>
+//	GET /fs/status/<txferid>
+//
+// Files support Range requests and, for whole-file reads, Accept-Encoding
+// zstd or lz4. The gateway has no AUTH, so callers should only mount it when
+// the TCP listener does not require one.
+func NewHTTPHandler(opts HTTPOptions) http.Handler {
+	if opts.Deps == nil {
+		opts.Deps = NewRuntimeDeps()
+	}
+	g := &httpGateway{opts: opts}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fs/manifest", g.manifest)
+	mux.HandleFunc("GET /fs/file/{txferid}/{fid}", g.file)
+	mux.HandleFunc("GET /fs/status/{txferid}", g.status)
+	return mux
+}
+
+type httpGateway struct {
+	opts HTTPOptions
+}
+
+func (g *httpGateway) peer(r *http.Request) peerInfo {
+	return peerInfo{RemoteAddr: r.RemoteAddr, auditLog: g.opts.AuditLog}
+}
+
+func (g *httpGateway) manifest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	params := map[string]string{
+		"directory":   query.Get("dir"),
+		"mode":        loadStrategyFast,
+		"link-mbps":   "0",
+		"concurrency": "1",
+	}
+	for _, key := range []string{"mode", "link-mbps", "concurrency", "verbose", "max-manifest-chunk-size", "ttl", "sparse"} {
+		if v := query.Get(key); v != "" {
+			params[key] = v
+		}
+	}
+	if source.IsRemote(params["directory"]) {
+		// The gateway has no AUTH; never let it reach object stores with
+		// the server's credentials.
+		writeHTTPError(w, protocolErr{code: "UNPROCESSABLE", message: "object-store roots are not served over HTTP"})
+		return
+	}
+	req := Request{Verb: VerbTXFER, Params: []map[string]string{params}}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	out := &httpBodyWriter{w: w}
+	if err := handleTXFER(withPeer(r.Context(), g.peer(r)), req, out, g.opts.Deps); err != nil {
+		if out.written > 0 {
+			// The manifest is already partly sent; cut the response short
+			// so the client sees a truncated body instead of a bogus one.
+			slog.Warn("http.manifest_failed", "directory", params["directory"], "err", err)
+			panic(http.ErrAbortHandler)
+		}
+		writeHTTPError(w, err)
+	}
+}
+
+func (g *httpGateway) status(w http.ResponseWriter, r *http.Request) {
+	status, err := transferStatus(g.opts.Deps, r.PathValue("txferid"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	payload, err := json.Marshal(status)
+	if err != nil {
+		writeHTTPError(w, protocolErr{code: "INTERNAL", message: "failed to encode status"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(payload, '\n'))
+}
+
+func (g *httpGateway) file(w http.ResponseWriter, r *http.Request) {
+	txferID := r.PathValue("txferid")
+	fileID, err := strconv.ParseUint(r.PathValue("fid"), 10, 64)
+	if err != nil {
+		writeHTTPError(w, protocolErr{code: "BAD_REQUEST", message: "invalid file id"})
+		return
+	}
+	transfer, ok := g.opts.Deps.GetTransfer(txferID)
+	if !ok {
+		writeHTTPError(w, protocolErr{code: "NOT_FOUND", message: "transfer not found"})
+		return
+	}
+	rawPath := r.URL.Query().Get("path")
+	if rawPath == "" {
+		writeHTTPError(w, protocolErr{code: "BAD_REQUEST", message: "missing path"})
+		return
+	}
+	fullPath := rawPath
+	if !filepath.IsAbs(fullPath) {
+		fullPath = filepath.Join(transfer.Directory, rawPath)
+	}
+	fd, fileRef, err := g.opts.Deps.GetFile(txferID, fileID, fullPath)
+	if err != nil {
+		writeHTTPError(w, mapLookupError(err))
+		return
+	}
+	defer fd.Close()
+	// A file download is the gateway's SEND, so it extends the lease too.
+	g.opts.Deps.RenewTransfer(txferID, 0)
+	info, err := fd.Stat()
+	if err != nil {
+		writeHTTPError(w, protocolErr{code: "INTERNAL", message: "failed to stat file"})
+		return
+	}
+
+	ctx := r.Context()
+	body := &httpBodyWriter{w: w}
+	if g.opts.Limiter != nil && strings.EqualFold(transfer.Mode, loadStrategyGentle) {
+		body.limited = g.opts.Limiter.WrapRateLimitedWriter(w, ctx)
+	}
+	etag := fmt.Sprintf("%x-%x", info.Size(), info.ModTime().UnixNano())
+	header := w.Header()
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Accept-Ranges", "bytes")
+	header.Add("Vary", "Accept-Encoding")
+
+	var offset, logical int64
+	selected := encoding.SelectEncoding(r.Header.Get("Accept-Encoding"))
+	if r.Method == http.MethodHead || r.Header.Get("Range") != "" || selected == encoding.EncodingIdentity {
+		// ServeContent handles Range, If-Range and conditional requests
+		// against the identity bytes.
+		header.Set("ETag", `"`+etag+`"`)
+		http.ServeContent(&httpResponseWriter{ResponseWriter: w, body: body}, r, "", info.ModTime(), fd)
+		logical = body.written
+		if start, ok := parseRangeStart(header.Get("Content-Range")); ok {
+			offset = start
+		}
+	} else {
+		header.Set("ETag", `"`+etag+"-"+selected+`"`)
+		header.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		header.Set("Content-Encoding", selected)
+		compWriter, closeCompWriter, _, err := encoding.WrapCompressedWriter(body, selected, transfer.Mode)
+		if err != nil {
+			writeHTTPError(w, protocolErr{code: "INTERNAL", message: "failed to initialize compression"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		logical, err = io.Copy(compWriter, fd)
+		if closeErr := closeCompWriter(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			if !isBrokenPipe(err) && !errors.Is(err, ctx.Err()) {
+				slog.Warn("http.file_failed", "txfer", txferID, "fid", fileID, "err", err)
+			}
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	peer := g.peer(r)
+	slog.Info("http.file.sent", append([]any{
+		"txfer", txferID,
+		"fid", fileID,
+		"offset", offset,
+		"size", logical,
+		"wsize", body.written,
+		"comp", header.Get("Content-Encoding"),
+	}, peer.logAttrs()...)...)
+	peer.audit(AuditEvent{
+		Event:      "window.sent",
+		TransferID: txferID,
+		FileID:     &fileID,
+		Path:       fileRef.Path,
+		Offset:     offset,
+		Bytes:      logical,
+		WireBytes:  body.written,
+	})
+}
+
+// parseRangeStart returns the first byte of a single-range Content-Range.
+func parseRangeStart(contentRange string) (int64, bool) {
+	raw, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	raw, _, ok = strings.Cut(raw, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(raw, 10, 64)
+	return start, err == nil
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	var pe protocolErr
+	if !errors.As(err, &pe) {
+		pe = protocolErr{code: "INTERNAL", message: "internal server error"}
+	}
+	msg := pe.code
+	if pe.message != "" {
+		msg += " " + sanitizeStatusMessage(pe.message)
+	}
+	http.Error(w, msg, httpStatusForCode(pe.code))
+}
+
+// httpBodyWriter counts response body bytes, optionally through the file
+// stream rate limiter.
+type httpBodyWriter struct {
+	w       io.Writer
+	limited *limit.RateLimitedWriter
+	written int64
+}
+
+func (b *httpBodyWriter) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	if b.limited != nil {
+		n, err = b.limited.Write(p)
+	} else {
+		n, err = b.w.Write(p)
+	}
+	b.written += int64(n)
+	return n, err
+}
+
+// httpResponseWriter routes ServeContent's body writes through body while
+// keeping the underlying headers and status.
+type httpResponseWriter struct {
+	http.ResponseWriter
+	body *httpBodyWriter
+}
+
+func (w *httpResponseWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
@@ -0,0 +1,117 @@
+package ftcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestHTTPHandlerServesManifestFileAndStatus(t *testing.T) {
+	root := t.TempDir()
+	content := bytes.Repeat([]byte("pinch http gateway "), 512)
+	if err := os.WriteFile(filepath.Join(root, "a.bin"), content, 0o644); err != nil {
+		t.Fatalf("write test file: %v", err)
+	}
+	srv := httptest.NewServer(NewHTTPHandler(HTTPOptions{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/fs/manifest?dir=" + url.QueryEscape(root))
+	if err != nil {
+		t.Fatalf("GET manifest: %v", err)
+	}
+	manifest, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(manifest), "FM/2 ") {
+		t.Fatalf("unexpected manifest response: status=%d body=%q", resp.StatusCode, manifest)
+	}
+	txferID := strings.Fields(string(manifest))[1]
+	fileURL := srv.URL + "/fs/file/" + txferID + "/0?path=a.bin"
+
+	req, _ := http.NewRequest(http.MethodGet, fileURL, nil)
+	req.Header.Set("Range", "bytes=10-29")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET range: %v", err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(got, content[10:30]) {
+		t.Fatalf("unexpected range response: status=%d body=%q", resp.StatusCode, got)
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("range response must not be compressed, got %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, fileURL, nil)
+	req.Header.Set("Accept-Encoding", "zstd, lz4;q=0.5")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET zstd: %v", err)
+	}
+	wire, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "zstd" || len(wire) >= len(content) {
+		t.Fatalf("unexpected zstd response: status=%d encoding=%q wire=%d", resp.StatusCode, resp.Header.Get("Content-Encoding"), len(wire))
+	}
+	dec, err := zstd.NewReader(bytes.NewReader(wire))
+	if err != nil {
+		t.Fatalf("zstd reader: %v", err)
+	}
+	got, err = io.ReadAll(dec)
+	dec.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("zstd body mismatch: err=%v len=%d", err, len(got))
+	}
+
+	resp, err = http.Get(srv.URL + "/fs/file/" + txferID + "/0?path=../a.bin")
+	if err != nil {
+		t.Fatalf("GET escaped path: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for a path outside the root, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/fs/status/" + txferID)
+	if err != nil {
+		t.Fatalf("GET status: %v", err)
+	}
+	var status TransferStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil || status.TransferID != txferID || status.NumFiles != 1 || status.TotalSize != int64(len(content)) {
+		t.Fatalf("unexpected status: err=%v status=%+v", err, status)
+	}
+}
+
+func TestHTTPHandlerMapsProtocolErrors(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(HTTPOptions{}))
+	defer srv.Close()
+	cases := map[string]int{
+		"/fs/manifest":                       http.StatusBadRequest,
+		"/fs/manifest?dir=relative":          http.StatusUnprocessableEntity,
+		"/fs/status/missing":                 http.StatusNotFound,
+		"/fs/file/missing/0?path=a":          http.StatusNotFound,
+		"/fs/file/missing/notanumber?path=a": http.StatusBadRequest,
+	}
+	for path, want := range cases {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("GET %s: expected %d, got %d (%q)", path, want, resp.StatusCode, body)
+		}
+	}
+}
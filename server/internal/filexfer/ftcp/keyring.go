@@ -0,0 +1,78 @@
+package ftcp
+
+import (
+	"sync/atomic"
+
+	"filippo.io/age"
+)
+
+// Keyring holds the identities the server accepts when decrypting AUTH blobs
+// and encrypted command lines. The primary identity is the one announced to
+// clients; the others stay valid so clients pinned to a previous key keep
+// working while a rotation rolls out. Keyring is safe for concurrent use and
+// can be swapped at runtime with Set.
+type Keyring struct {
+	state atomic.Pointer[keyringState]
+}
+
+type keyringState struct {
+	primary    *age.X25519Identity
+	identities []age.Identity
+	recipients []string
+}
+
+func NewKeyring(primary *age.X25519Identity, others ...*age.X25519Identity) *Keyring {
+	k := &Keyring{}
+	k.Set(primary, others...)
+	return k
+}
+
+// Set replaces the active identities. Duplicates of the primary are dropped.
+func (k *Keyring) Set(primary *age.X25519Identity, others ...*age.X25519Identity) {
+	st := &keyringState{primary: primary}
+	seen := make(map[string]bool, len(others)+1)
+	for _, id := range append([]*age.X25519Identity{primary}, others...) {
+		if id == nil {
+			continue
+		}
+		recipient := id.Recipient().String()
+		if seen[recipient] {
+			continue
+		}
+		seen[recipient] = true
+		st.identities = append(st.identities, id)
+		st.recipients = append(st.recipients, recipient)
+	}
+	k.state.Store(st)
+}
+
+func (k *Keyring) load() *keyringState {
+	if k == nil {
+		return nil
+	}
+	return k.state.Load()
+}
+
+// Primary returns the identity announced to clients, or nil.
+func (k *Keyring) Primary() *age.X25519Identity {
+	if st := k.load(); st != nil {
+		return st.primary
+	}
+	return nil
+}
+
+// Identities returns every accepted identity, primary first.
+func (k *Keyring) Identities() []age.Identity {
+	if st := k.load(); st != nil {
+		return st.identities
+	}
+	return nil
+}
+
+// Recipients returns the public keys of every accepted identity, primary first.
+func (k *Keyring) Recipients() []string {
+	if st := k.load(); st != nil {
+		return st.recipients
+	}
+	return nil
+}
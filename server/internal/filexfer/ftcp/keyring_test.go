@@ -0,0 +1,48 @@
+package ftcp
+
+import (
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestKeyringRotationAcceptsPreviousKey(t *testing.T) {
+	oldID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate old identity: %v", err)
+	}
+	newID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate new identity: %v", err)
+	}
+	clientID, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate client identity: %v", err)
+	}
+	keys := NewKeyring(oldID)
+	req := encryptedAUTHRequest(t, oldID, clientID.Recipient().String())
+
+	keys.Set(newID, oldID, newID)
+	if got := keys.Primary(); got != newID {
+		t.Fatalf("expected rotated primary")
+	}
+	recipients := keys.Recipients()
+	if len(recipients) != 2 || recipients[0] != newID.Recipient().String() || recipients[1] != oldID.Recipient().String() {
+		t.Fatalf("unexpected recipients after rotation: %v", recipients)
+	}
+	if _, err := processAUTHRequest(req, true, keys.Identities()); err != nil {
+		t.Fatalf("expected previous key to stay accepted: %v", err)
+	}
+
+	keys.Set(newID)
+	if _, err := processAUTHRequest(req, true, keys.Identities()); err == nil {
+		t.Fatalf("expected retired key to be rejected")
+	}
+}
+
+func TestKeyringNilSafe(t *testing.T) {
+	var keys *Keyring
+	if keys.Primary() != nil || keys.Identities() != nil || keys.Recipients() != nil {
+		t.Fatalf("expected nil keyring to report no identities")
+	}
+}
@@ -0,0 +1,41 @@
+package ftcp
+
+import "github.com/jolynch/pinch/metrics"
+
+var (
+	activeCommands = metrics.Default.NewGaugeVec(
+		"pinch_ftcp_active_connections",
+		"FTCP connections currently executing a command, by verb.",
+		"verb",
+	)
+	sentLogicalBytes = metrics.Default.NewCounterVec(
+		"pinch_ftcp_sent_logical_bytes_total",
+		"Uncompressed file bytes sent in FX/1 frames, by frame codec.",
+		"comp",
+	)
+	sentWireBytes = metrics.Default.NewCounterVec(
+		"pinch_ftcp_sent_wire_bytes_total",
+		"On-the-wire FX/1 payload bytes sent, by frame codec.",
+		"comp",
+	)
+	compSwitches = metrics.Default.NewCounterVec(
+		"pinch_ftcp_comp_switches_total",
+		"Adaptive compression mode changes between frames.",
+		"from", "to",
+	)
+	fileChanged = metrics.Default.NewCounterVec(
+		"pinch_ftcp_file_changed_total",
+		"Files found changed since TXFER or during SEND, by consistency mode.",
+		"consistency",
+	)
+	ackRecvSeconds = metrics.Default.NewHistogramVec(
+		"pinch_ftcp_ack_recv_seconds",
+		"Client-reported time spent receiving each acknowledged window (ACK recv-ms).",
+		metrics.DefaultLatencyBuckets,
+	)
+	ackSyncSeconds = metrics.Default.NewHistogramVec(
+		"pinch_ftcp_ack_sync_seconds",
+		"Client-reported time spent syncing each acknowledged window to disk (ACK sync-ms).",
+		metrics.DefaultLatencyBuckets,
+	)
+)
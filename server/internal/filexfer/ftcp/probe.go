@@ -52,7 +52,11 @@ func parsePROBERequest(req Request) (probeRequest, error) {
 	return probeRequest{ClientCPU: clientCPU, ProbeBytes: probeBytes, ClientTS0: clientTS0}, nil
 }
 
-func handlePROBEWithInput(_ context.Context, req Request, in io.Reader, out io.Writer, _ Deps) error {
+// handlePROBEWithInput answers a PROBE. serverKeys, primary first, are the
+// age recipients the server accepts: the primary is announced as key= so
+// clients can pin it on first use, and all of them as keys= so a client
+// pinned to a key that is being rotated out can move to the primary.
+func handlePROBEWithInput(_ context.Context, req Request, in io.Reader, out io.Writer, _ Deps, serverKeys []string) error {
 	parsed, err := parsePROBERequest(req)
 	if err != nil {
 		return err
@@ -76,6 +80,9 @@ func handlePROBEWithInput(_ context.Context, req Request, in io.Reader, out io.W
 		parsed.ProbeBytes,
 		utils.MaxSocketWriteBufferBytes(),
 	)
+	if len(serverKeys) > 0 {
+		respLine = strings.TrimSuffix(respLine, "\n") + " key=" + serverKeys[0] + " keys=" + strings.Join(serverKeys, ",") + "\n"
+	}
 	if _, err := io.WriteString(out, respLine); err != nil {
 		return err
 	}
@@ -18,7 +18,7 @@ func TestHandlePROBERoundTrip(t *testing.T) {
 	payload := bytes.Repeat([]byte{0x5a}, 1024)
 	in := bytes.NewReader(payload)
 	var out bytes.Buffer
-	if err := handlePROBEWithInput(context.Background(), req, in, &out, nil); err != nil {
+	if err := handlePROBEWithInput(context.Background(), req, in, &out, nil, nil); err != nil {
 		t.Fatalf("handlePROBEWithInput failed: %v", err)
 	}
 
@@ -49,6 +49,27 @@ func TestHandlePROBERoundTrip(t *testing.T) {
 	}
 }
 
+func TestHandlePROBEAnnouncesServerKey(t *testing.T) {
+	req, err := ParseRequest([]byte(`PROBE cpu=8 probe-bytes=0 cts0=100`))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := handlePROBEWithInput(context.Background(), req, bytes.NewReader(nil), &out, nil, []string{"age1new", "age1old"}); err != nil {
+		t.Fatalf("handlePROBEWithInput failed: %v", err)
+	}
+	respReq, err := ParseRequest(bytes.TrimRight(out.Bytes(), "\r\n"))
+	if err != nil {
+		t.Fatalf("parse response line: %v", err)
+	}
+	if got := respReq.Params[0]["key"]; got != "age1new" {
+		t.Fatalf("expected key=age1new, got %q", got)
+	}
+	if got := respReq.Params[0]["keys"]; got != "age1new,age1old" {
+		t.Fatalf("expected keys=age1new,age1old, got %q", got)
+	}
+}
+
 func TestHandlePROBERejectsShortPayload(t *testing.T) {
 	req, err := ParseRequest([]byte(`PROBE cpu=8 probe-bytes=10 cts0=100`))
 	if err != nil {
@@ -56,7 +77,7 @@ func TestHandlePROBERejectsShortPayload(t *testing.T) {
 	}
 	in := bytes.NewReader([]byte{1, 2, 3})
 	var out bytes.Buffer
-	err = handlePROBEWithInput(context.Background(), req, in, &out, nil)
+	err = handlePROBEWithInput(context.Background(), req, in, &out, nil, nil)
 	if err == nil {
 		t.Fatalf("expected payload validation error")
 	}
@@ -10,6 +10,12 @@ import (
 type Request struct {
 	Verb   Verb
 	Params []map[string]string
+	// TraceParent is the optional W3C traceparent= key, accepted anywhere
+	// a key=value option may appear on TXFER, SEND, ACK and PROBE.
+	TraceParent string
+	// KeepAlive is the SEND keepalive=1 option: on a plaintext session the
+	// connection stays open for another command after the response.
+	KeepAlive bool
 }
 
 func ParseRequest(payload []byte) (Request, error) {
@@ -55,6 +61,10 @@ func ParseRequest(payload []byte) (Request, error) {
 			if !ok {
 				return Request{}, protocolErr{code: "BAD_REQUEST", message: "invalid TXFER option"}
 			}
+			if key == "traceparent" {
+				req.TraceParent = val
+				continue
+			}
 			param[key] = val
 		}
 		req.Params = append(req.Params, param)
@@ -99,8 +109,12 @@ func ParseRequest(payload []byte) (Request, error) {
 					return Request{}, protocolErr{code: "BAD_REQUEST", message: "invalid SEND item option"}
 				}
 				switch key {
-				case "offset", "size", "comp", "mode":
+				case "offset", "size", "comp", "mode", "meta", "sparse", "consistency", "link-mbps", "link-rtt-ms":
 					item[key] = val
+				case "traceparent":
+					req.TraceParent = val
+				case "keepalive":
+					req.KeepAlive = val == "1"
 				default:
 					// Unknown keys are ignored for forward compatibility.
 				}
@@ -152,6 +166,8 @@ func ParseRequest(payload []byte) (Request, error) {
 				switch key {
 				case "ack-token", "delta-bytes", "recv-ms", "sync-ms":
 					item[key] = val
+				case "traceparent":
+					req.TraceParent = val
 				default:
 					// Unknown keys are ignored for forward compatibility.
 				}
@@ -174,27 +190,82 @@ func ParseRequest(payload []byte) (Request, error) {
 		if pathErr != nil {
 			return Request{}, protocolErr{code: "BAD_REQUEST", message: "invalid CXSUM path"}
 		}
-		if !c.eof() {
-			return Request{}, protocolErr{code: "BAD_REQUEST", message: "unexpected CXSUM arguments"}
-		}
-		req.Params = append(req.Params, map[string]string{
+		param := map[string]string{
 			"txferid":       txferID,
 			"fid":           fid,
 			"window-size":   windowSize,
 			"checksums-csv": checksums,
 			"path":          string(path),
-		})
+		}
+		for !c.eof() {
+			tok, tokErr := c.readToken()
+			if tokErr != nil {
+				return Request{}, protocolErr{code: "BAD_REQUEST", message: "unexpected CXSUM arguments"}
+			}
+			val, ok := strings.CutPrefix(tok, "meta=")
+			if !ok {
+				return Request{}, protocolErr{code: "BAD_REQUEST", message: "unexpected CXSUM arguments"}
+			}
+			param["meta"] = val
+		}
+		req.Params = append(req.Params, param)
 		return req, nil
-	case VerbSTATUS:
+	case VerbSTATUS, VerbCANCEL, VerbPAUSE, VerbRESUME, VerbRENEW:
 		txferID, txErr := c.readToken()
 		if txErr != nil || txferID == "" {
 			return Request{}, protocolErr{code: "BAD_REQUEST", message: "missing transfer id"}
 		}
 		if !c.eof() {
-			return Request{}, protocolErr{code: "BAD_REQUEST", message: "unexpected STATUS arguments"}
+			return Request{}, protocolErr{code: "BAD_REQUEST", message: "unexpected " + verb.String() + " arguments"}
 		}
 		req.Params = append(req.Params, map[string]string{"txferid": txferID})
 		return req, nil
+	case VerbWATCH:
+		txferID, txErr := c.readToken()
+		if txErr != nil || txferID == "" {
+			return Request{}, protocolErr{code: "BAD_REQUEST", message: "missing transfer id"}
+		}
+		param := map[string]string{"txferid": txferID}
+		for !c.eof() {
+			tok, tokErr := c.readToken()
+			if tokErr != nil {
+				return Request{}, protocolErr{code: "BAD_REQUEST", message: "invalid WATCH option"}
+			}
+			key, val, ok := strings.Cut(tok, "=")
+			if !ok {
+				return Request{}, protocolErr{code: "BAD_REQUEST", message: "invalid WATCH option"}
+			}
+			if key == "traceparent" {
+				req.TraceParent = val
+				continue
+			}
+			param[key] = val
+		}
+		req.Params = append(req.Params, param)
+		return req, nil
+	case VerbARCHIVE:
+		txferID, txErr := c.readToken()
+		if txErr != nil || txferID == "" {
+			return Request{}, protocolErr{code: "BAD_REQUEST", message: "missing transfer id"}
+		}
+		param := map[string]string{"txferid": txferID}
+		for !c.eof() {
+			tok, tokErr := c.readToken()
+			if tokErr != nil {
+				return Request{}, protocolErr{code: "BAD_REQUEST", message: "invalid ARCHIVE option"}
+			}
+			key, val, ok := strings.Cut(tok, "=")
+			if !ok {
+				return Request{}, protocolErr{code: "BAD_REQUEST", message: "invalid ARCHIVE option"}
+			}
+			if key == "traceparent" {
+				req.TraceParent = val
+				continue
+			}
+			param[key] = val
+		}
+		req.Params = append(req.Params, param)
+		return req, nil
 	case VerbPROBE:
 		param := map[string]string{}
 		for !c.eof() {
@@ -207,8 +278,10 @@ func ParseRequest(payload []byte) (Request, error) {
 				return Request{}, protocolErr{code: "BAD_REQUEST", message: "invalid PROBE arguments"}
 			}
 			switch key {
-			case "cpu", "probe-bytes", "cts0", "sts0", "sts1":
+			case "cpu", "probe-bytes", "cts0", "sts0", "sts1", "key", "keys":
 				param[key] = val
+			case "traceparent":
+				req.TraceParent = val
 			default:
 				// Unknown keys are ignored for forward compatibility.
 			}
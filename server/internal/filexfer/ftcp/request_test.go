@@ -222,3 +222,39 @@ func TestHandleCommandBadVerb(t *testing.T) {
 		t.Fatalf("code=%s", pe.code)
 	}
 }
+
+func TestParseRequestTraceParent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	cases := []string{
+		`TXFER 4:/tmp mode=fast link-mbps=0 concurrency=1 traceparent=` + tp,
+		`SEND tx1 fd=42 "/tmp/a.txt" size=20 traceparent=` + tp,
+		`ACK tx1 fd=1 "/tmp/a.txt" ack-token=-1 traceparent=` + tp,
+		`PROBE cpu=1 probe-bytes=0 cts0=1 traceparent=` + tp,
+	}
+	for _, payload := range cases {
+		req, err := ParseRequest([]byte(payload))
+		if err != nil {
+			t.Fatalf("ParseRequest(%q) err: %v", payload, err)
+		}
+		if req.TraceParent != tp {
+			t.Fatalf("ParseRequest(%q) traceparent=%q", payload, req.TraceParent)
+		}
+		for _, p := range req.Params {
+			if _, ok := p["traceparent"]; ok {
+				t.Fatalf("traceparent leaked into params for %q", payload)
+			}
+		}
+	}
+	if _, err := parseTXFERRequest(mustParseRequest(t, cases[0])); err != nil {
+		t.Fatalf("TXFER with traceparent rejected: %v", err)
+	}
+}
+
+func mustParseRequest(t *testing.T, payload string) Request {
+	t.Helper()
+	req, err := ParseRequest([]byte(payload))
+	if err != nil {
+		t.Fatalf("ParseRequest(%q) err: %v", payload, err)
+	}
+	return req
+}
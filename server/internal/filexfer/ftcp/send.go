@@ -5,7 +5,9 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"net/http"
 	"os"
 	"runtime"
 	"runtime/trace"
@@ -18,6 +20,8 @@ import (
 	"github.com/jolynch/pinch/internal/filexfer/encoding"
 	"github.com/jolynch/pinch/internal/filexfer/limit"
 	"github.com/jolynch/pinch/internal/filexfer/policy"
+	"github.com/jolynch/pinch/internal/filexfer/source"
+	"github.com/jolynch/pinch/tracing"
 	"github.com/zeebo/xxh3"
 	"golang.org/x/sys/unix"
 )
@@ -42,6 +46,14 @@ type sendItem struct {
 	Comp   string
 	Path   string
 	Mode   string
+	Meta   encoding.MetadataOptions
+	Sparse bool
+	// Consistency is strict, warn, or ignore; see checkConsistency.
+	Consistency string
+	// LinkMbps is the link hint for the adaptive compression policy: the
+	// client's link-mbps estimate when sent, else the transfer's stored hint.
+	LinkMbps  int64
+	LinkRTTMS int64
 }
 
 type sendRequest struct {
@@ -65,6 +77,10 @@ type frameStreamArgs struct {
 	Output        io.Writer
 	PipeSizeBytes int
 	DirectIO      bool
+	Hole          bool
+	// Verify, when set, runs before the terminal trailer is written; an
+	// error replaces the trailer with an ERR status line.
+	Verify func() error
 }
 
 type frameStreamStats struct {
@@ -134,11 +150,66 @@ func parseSENDRequest(req Request) (sendRequest, error) {
 		default:
 			return sendRequest{}, protocolErr{code: "BAD_REQUEST", message: "unsupported SEND mode"}
 		}
-		items = append(items, sendItem{FileID: fid, Offset: offset, Size: size, Comp: comp, Path: path, Mode: mode})
+		consistency, err := parseConsistencyOption(p["consistency"])
+		if err != nil {
+			return sendRequest{}, err
+		}
+		linkMbps, linkRTTMS, err := parseSENDLinkOptions(p["link-mbps"], p["link-rtt-ms"])
+		if err != nil {
+			return sendRequest{}, err
+		}
+		items = append(items, sendItem{
+			FileID:      fid,
+			Offset:      offset,
+			Size:        size,
+			Comp:        comp,
+			Path:        path,
+			Mode:        mode,
+			Meta:        parseSENDMetaOption(p["meta"]),
+			Sparse:      p["sparse"] == "1",
+			Consistency: consistency,
+			LinkMbps:    linkMbps,
+			LinkRTTMS:   linkRTTMS,
+		})
 	}
 	return sendRequest{TransferID: txferID, Items: items}, nil
 }
 
+// parseSENDLinkOptions reads the client's passive link estimate carried on
+// SEND items; empty values mean no estimate.
+func parseSENDLinkOptions(rawMbps string, rawRTT string) (int64, int64, error) {
+	var mbps, rttMS int64
+	var err error
+	if raw := strings.TrimSpace(rawMbps); raw != "" {
+		mbps, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || mbps < 0 {
+			return 0, 0, protocolErr{code: "BAD_REQUEST", message: "invalid SEND link-mbps"}
+		}
+	}
+	if raw := strings.TrimSpace(rawRTT); raw != "" {
+		rttMS, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || rttMS < 0 {
+			return 0, 0, protocolErr{code: "BAD_REQUEST", message: "invalid SEND link-rtt-ms"}
+		}
+	}
+	return mbps, rttMS, nil
+}
+
+// parseSENDMetaOption reads the meta=<csv> item option that opts into extra
+// terminal trailer metadata. Unknown names are ignored.
+func parseSENDMetaOption(raw string) encoding.MetadataOptions {
+	var opts encoding.MetadataOptions
+	for _, name := range strings.Split(raw, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "atime":
+			opts.Atime = true
+		case "xattrs":
+			opts.Xattrs = true
+		}
+	}
+	return opts
+}
+
 func handleSEND(ctx context.Context, req Request, out io.Writer, deps Deps) error {
 	return handleSENDWithOptions(ctx, req, out, deps, nil)
 }
@@ -149,6 +220,9 @@ func handleSENDWithOptions(ctx context.Context, req Request, out io.Writer, deps
 		return err
 	}
 	for _, item := range parsed.Items {
+		if linkMbps, ok := deps.UpdateTransferLink(parsed.TransferID, item.LinkMbps, item.LinkRTTMS); ok {
+			item.LinkMbps = linkMbps
+		}
 		itemOut := out
 		if limiter != nil && item.Mode == loadStrategyGentle {
 			itemOut = limiter.WrapRateLimitedWriter(out, ctx)
@@ -160,10 +234,18 @@ func handleSENDWithOptions(ctx context.Context, req Request, out io.Writer, deps
 	return nil
 }
 
-func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID string, item sendItem) error {
+func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID string, item sendItem) (err error) {
+	ctx, span := tracing.Start(ctx, "ftcp.send.window", "txfer", txferID, "fid", item.FileID, "offset", item.Offset)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
 	ctx, windowTask := trace.NewTask(ctx, "send-window")
 	defer windowTask.End()
-	fd, fileRef, usedDirectOpen, err := openSendFile(deps, txferID, item)
+	// Taken before opening so a CANCEL that removes the transfer mid-window
+	// is still observed.
+	gate := deps.GetTransferGate(txferID)
+	fd, fileRef, usedDirectOpen, err := openSendFile(ctx, deps, txferID, item)
 	if err != nil {
 		return mapLookupError(err)
 	}
@@ -179,6 +261,27 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 	if err != nil {
 		return protocolErr{code: "INTERNAL", message: "failed to stat file"}
 	}
+	openIdentity := identityFromInfo(fileInfo)
+	if err := checkConsistency(deps, item.Consistency, txferID, item.FileID, "window open", identityFromRef(fileRef), openIdentity); err != nil {
+		return err
+	}
+	verifyUnchanged := func() error {
+		// Stat both the open descriptor (in-place writes) and the path
+		// (replaced files) against what the window started from.
+		got, err := fd.Stat()
+		if err != nil {
+			return protocolErr{code: "INTERNAL", message: "failed to stat file"}
+		}
+		current := identityFromInfo(got)
+		if err := checkConsistency(deps, item.Consistency, txferID, item.FileID, "send", openIdentity, current); err != nil {
+			return err
+		}
+		byPath, err := statSendPath(ctx, fileRef)
+		if err != nil {
+			return reportChange(deps, item.Consistency, txferID, item.FileID, "send", current, "path no longer exists")
+		}
+		return checkConsistency(deps, item.Consistency, txferID, item.FileID, "send", openIdentity, identityFromInfo(byPath))
+	}
 	windowLen := fileInfo.Size()
 	if windowLen < 0 {
 		return protocolErr{code: "INTERNAL", message: "invalid file size"}
@@ -199,6 +302,7 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 	windowWireTotal := int64(0)
 	windowLogicalTotal := int64(0)
 	windowFrames := 0
+	windowHoleBytes := int64(0)
 	windowTS0 := time.Now().UnixMilli()
 
 	firstFrameLogical := min(windowLen, defaultFileFrameLogicalSize)
@@ -207,16 +311,36 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 		return protocolErr{code: "INTERNAL", message: "failed to compute max frame size hint"}
 	}
 	pipeSizeBytes := desiredPipeSizeBytes(windowLen, firstFrameLogical)
-	useLinuxSplice := runtime.GOOS == "linux" && item.Mode == loadStrategyFast
+	// Object-store files are plain readers: no splice, fadvise or sparse
+	// detection, which all need a local descriptor.
+	localFD, isLocal := fd.(*os.File)
+	useLinuxSplice := runtime.GOOS == "linux" && item.Mode == loadStrategyFast && isLocal
 	firstFrame := true
 
 	adaptive := item.Comp == "adapt"
-	currentMode := initialCompressionMode(item.Comp)
+	currentMode := initialCompressionMode(item.Comp, item.LinkMbps)
 	compressPolicy := policy.NewCompressionPolicy()
 	windowHasher := xxh3.New128()
 
 	for remaining := windowLen; remaining > 0; {
+		if err := waitTransferGate(ctx, gate); err != nil {
+			return err
+		}
+		// Each frame extends the lease, so a window slower than the TTL
+		// is not reaped under the client.
+		deps.RenewTransfer(txferID, 0)
 		frameSize := min(remaining, defaultFileFrameLogicalSize)
+		hole := false
+		if item.Sparse && isLocal {
+			extent, isHole := nextSparseExtent(localFD, cursor, cursor+remaining)
+			if isHole {
+				// Hole frames carry no payload, so they are not bound by
+				// the logical frame size.
+				frameSize, hole = extent, true
+			} else {
+				frameSize = min(frameSize, extent)
+			}
+		}
 		nextOffset := cursor + frameSize
 		isTerminal := nextOffset == item.Offset+windowLen
 		nextValue := nextOffset
@@ -231,7 +355,7 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 		frameComp := policy.FrameCompTokenForMode(currentMode)
 		var terminalMD *encoding.FileFrameMetadata
 		if isTerminal {
-			md := encoding.CollectFileFrameMetadata(fileRef.Path, fileInfo)
+			md := encoding.CollectFileFrameMetadataWithOptions(fileRef.Path, fileInfo, item.Meta)
 			terminalMD = &md
 		}
 
@@ -251,12 +375,19 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 			Output:        out,
 			PipeSizeBytes: pipeSizeBytes,
 			DirectIO:      usedDirectOpen,
+			Hole:          hole,
+		}
+		if isTerminal && item.Consistency != consistencyIgnore {
+			frameArgs.Verify = verifyUnchanged
 		}
 
 		frameOffset := cursor
 		var stats frameStreamStats
-		if useLinuxSplice {
-			stats, err = streamFramePayloadLinuxSplice(fd, &frameOffset, frameArgs)
+		if hole {
+			frameArgs.Comp = "none"
+			stats, err = streamHoleFrame(&frameOffset, frameArgs)
+		} else if useLinuxSplice {
+			stats, err = streamFramePayloadLinuxSplice(localFD, &frameOffset, frameArgs)
 		} else {
 			stats, err = streamFramePayloadBuffered(fd, &frameOffset, frameArgs)
 		}
@@ -268,10 +399,11 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 				}
 				_ = fd.Close()
 				fd = nil
-				fd, fileRef, err = deps.GetFile(txferID, item.FileID, item.Path)
+				localFD, fileRef, err = deps.GetFile(txferID, item.FileID, item.Path)
 				if err != nil {
 					return mapLookupError(err)
 				}
+				fd = localFD
 				usedDirectOpen = false
 				useLinuxSplice = runtime.GOOS == "linux" && item.Mode == loadStrategyFast
 				remaining = item.Offset + windowLen - cursor
@@ -287,7 +419,7 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 					windowFrames = 0
 					windowTS0 = time.Now().UnixMilli()
 					firstFrame = true
-					currentMode = initialCompressionMode(item.Comp)
+					currentMode = initialCompressionMode(item.Comp, item.LinkMbps)
 					compressPolicy = policy.NewCompressionPolicy()
 					windowHasher = xxh3.New128()
 				} else {
@@ -306,6 +438,11 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 		windowFrames++
 		windowLogicalTotal += stats.LogicalSize
 		windowWireTotal += stats.WireSize
+		sentLogicalBytes.With(frameArgs.Comp).Add(float64(stats.LogicalSize))
+		sentWireBytes.With(frameArgs.Comp).Add(float64(stats.WireSize))
+		if hole {
+			windowHoleBytes += stats.LogicalSize
+		}
 
 		if isTerminal {
 			if stats.WindowHashToken == "" {
@@ -316,26 +453,28 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 			}
 		}
 
-		if adaptive {
+		if adaptive && !hole {
 			decision := compressPolicy.Decide(currentMode, policy.CompressionMetrics{
 				LogicalSize:    stats.LogicalSize,
 				WireSize:       stats.WireSize,
 				PrepareLatency: stats.PrepareLatency,
 				WriteLatency:   stats.WriteLatency,
+				LinkMbps:       item.LinkMbps,
 			})
 			if decision.Next != currentMode {
 				prevComp := policy.FrameCompTokenForMode(currentMode)
 				nextComp := policy.FrameCompTokenForMode(decision.Next)
-				log.Printf(
-					"filexfer frame tid=%s fid=%d switching compression %s->%s reason=%s ratio=%.3f read_over_write=%.3f",
-					txferID,
-					item.FileID,
-					prevComp,
-					nextComp,
-					decision.Reason,
-					decision.Ratio,
-					decision.ReadOverWrite,
+				slog.Info(
+					"comp.switch",
+					"txfer", txferID,
+					"fid", item.FileID,
+					"from", prevComp,
+					"to", nextComp,
+					"reason", decision.Reason,
+					"ratio", decision.Ratio,
+					"read_over_write", decision.ReadOverWrite,
 				)
+				compSwitches.With(prevComp, nextComp).Inc()
 				currentMode = decision.Next
 			}
 		}
@@ -350,24 +489,41 @@ func streamSendItem(ctx context.Context, out io.Writer, deps Deps, txferID strin
 		logicalBps = float64(windowLogicalTotal) / seconds
 		wireBps = float64(windowWireTotal) / seconds
 	}
-	log.Printf(
-		"filexfer window tid=%s fid=%d frames=%d offset=%d size=%d wsize=%d ts0=%d ts1=%d window_ms=%d logical=%s wire=%s",
-		txferID,
-		item.FileID,
-		windowFrames,
-		windowStart,
-		windowLogicalTotal,
-		windowWireTotal,
-		windowTS0,
-		windowTS1,
-		windowMS,
-		encoding.HumanRate(logicalBps),
-		encoding.HumanRate(wireBps),
-	)
+	peer := peerFromContext(ctx)
+	attrs := []any{
+		"txfer", txferID,
+		"fid", item.FileID,
+		"frames", windowFrames,
+		"offset", windowStart,
+		"size", windowLogicalTotal,
+		"wsize", windowWireTotal,
+		"hole_bytes", windowHoleBytes,
+		"ts0", windowTS0,
+		"ts1", windowTS1,
+		"window_ms", windowMS,
+		"logical_rate", encoding.HumanRate(logicalBps),
+		"wire_rate", encoding.HumanRate(wireBps),
+	}
+	slog.Info("window.sent", append(attrs, peer.logAttrs()...)...)
+	span.SetAttributes("frames", windowFrames, "size", windowLogicalTotal, "wsize", windowWireTotal)
+	fileID := item.FileID
+	peer.audit(AuditEvent{
+		Event:      "window.sent",
+		TransferID: txferID,
+		FileID:     &fileID,
+		Path:       fileRef.Path,
+		Offset:     windowStart,
+		Bytes:      windowLogicalTotal,
+		WireBytes:  windowWireTotal,
+	})
 	return nil
 }
 
-func openSendFile(deps Deps, txferID string, item sendItem) (*os.File, FileRef, bool, error) {
+func openSendFile(ctx context.Context, deps Deps, txferID string, item sendItem) (source.File, FileRef, bool, error) {
+	if source.IsRemote(item.Path) {
+		fd, fileRef, err := openSourceFile(ctx, deps, txferID, item)
+		return fd, fileRef, false, err
+	}
 	if item.Mode != loadStrategyGentle {
 		fd, fileRef, err := deps.GetFile(txferID, item.FileID, item.Path)
 		return fd, fileRef, false, err
@@ -384,6 +540,50 @@ func openSendFile(deps Deps, txferID string, item sendItem) (*os.File, FileRef,
 	return fd, fileRef, true, nil
 }
 
+// openSourceFile opens a file of an object-store root through its source,
+// mapping failures onto the same lookup errors GetFile returns.
+func openSourceFile(ctx context.Context, deps Deps, txferID string, item sendItem) (source.File, FileRef, error) {
+	fileRef, err := deps.GetFileRef(txferID, item.FileID, item.Path)
+	if err != nil {
+		return nil, FileRef{}, err
+	}
+	src, err := fileRefSource(fileRef)
+	if err != nil {
+		return nil, FileRef{}, &FileLookupError{Code: http.StatusInternalServerError, Msg: "failed to open transfer root"}
+	}
+	fd, err := src.Open(ctx, fileRef.Path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, FileRef{}, &FileLookupError{Code: http.StatusNotFound, Msg: "file not found"}
+		}
+		slog.Warn("send.open_failed", "txfer", txferID, "fid", item.FileID, "err", err)
+		return nil, FileRef{}, &FileLookupError{Code: http.StatusInternalServerError, Msg: "failed to open file"}
+	}
+	return fd, fileRef, nil
+}
+
+// statSendPath stats whatever is at fileRef's path now, which differs from
+// the open file when it has been replaced.
+func statSendPath(ctx context.Context, fileRef FileRef) (fs.FileInfo, error) {
+	if !source.IsRemote(fileRef.Path) {
+		return os.Stat(fileRef.Path)
+	}
+	src, err := fileRefSource(fileRef)
+	if err != nil {
+		return nil, err
+	}
+	return src.Stat(ctx, fileRef.Path)
+}
+
+// fileRefSource returns the source TXFER cached on the transfer, building
+// one only for transfers created without it.
+func fileRefSource(fileRef FileRef) (source.Source, error) {
+	if fileRef.Source != nil {
+		return fileRef.Source, nil
+	}
+	return source.New(fileRef.Directory)
+}
+
 func isDirectIOReadError(err error) bool {
 	if err == nil {
 		return false
@@ -391,8 +591,10 @@ func isDirectIOReadError(err error) bool {
 	return errors.Is(err, syscall.EINVAL) || errors.Is(err, unix.EINVAL)
 }
 
-func initialCompressionMode(comp string) policy.CompressionMode {
+func initialCompressionMode(comp string, linkMbps int64) policy.CompressionMode {
 	switch comp {
+	case "adapt":
+		return policy.InitialCompressionMode(linkMbps)
 	case encoding.EncodingLz4:
 		return policy.CompressionModeLz4
 	case encoding.EncodingZstd:
@@ -458,21 +660,25 @@ func growPipeBestEffort(pipeFD *os.File, sizeBytes int) {
 	_, _ = unix.FcntlInt(pipeFD.Fd(), unix.F_SETPIPE_SZ, sizeBytes)
 }
 
-func buildFrameHeaderLine(fileID uint64, offset int64, size int64, wireSize int64, comp string, maxWSizeHint *int64, ts int64) string {
+func buildFrameHeaderLine(fileID uint64, offset int64, size int64, wireSize int64, comp string, maxWSizeHint *int64, ts int64, hole bool) string {
+	holeToken := ""
+	if hole {
+		holeToken = " hole=1"
+	}
 	if maxWSizeHint != nil {
 		return "FX/1 " + strconv.FormatUint(fileID, 10) +
 			" offset=" + strconv.FormatInt(offset, 10) +
 			" size=" + strconv.FormatInt(size, 10) +
 			" wsize=" + strconv.FormatInt(wireSize, 10) +
 			" comp=" + comp + " enc=none hash=" + placeholderHeaderHashToken +
-			" max-wsize=" + strconv.FormatInt(*maxWSizeHint, 10) +
+			" max-wsize=" + strconv.FormatInt(*maxWSizeHint, 10) + holeToken +
 			" ts=" + strconv.FormatInt(ts, 10) + "\n"
 	}
 	return "FX/1 " + strconv.FormatUint(fileID, 10) +
 		" offset=" + strconv.FormatInt(offset, 10) +
 		" size=" + strconv.FormatInt(size, 10) +
 		" wsize=" + strconv.FormatInt(wireSize, 10) +
-		" comp=" + comp + " enc=none hash=" + placeholderHeaderHashToken +
+		" comp=" + comp + " enc=none hash=" + placeholderHeaderHashToken + holeToken +
 		" ts=" + strconv.FormatInt(ts, 10) + "\n"
 }
 
@@ -502,18 +708,10 @@ func metadataTrailerTokens(metadata *encoding.FileFrameMetadata) []string {
 	if metadata == nil {
 		return nil
 	}
-	return []string{
-		"meta:size=" + strconv.FormatInt(metadata.Size, 10),
-		"meta:mtime_ns=" + strconv.FormatInt(metadata.MtimeNS, 10),
-		"meta:mode=" + metadata.Mode,
-		"meta:uid=" + metadata.UID,
-		"meta:gid=" + metadata.GID,
-		"meta:user=" + strings.ReplaceAll(metadata.User, " ", "_"),
-		"meta:group=" + strings.ReplaceAll(metadata.Group, " ", "_"),
-	}
+	return metadata.TrailerTokens()
 }
 
-func streamFramePayloadBuffered(fd *os.File, fileOffset *int64, args frameStreamArgs) (frameStreamStats, error) {
+func streamFramePayloadBuffered(fd io.ReaderAt, fileOffset *int64, args frameStreamArgs) (frameStreamStats, error) {
 	readBuf, releaseRead, err := acquireLogicalBuffer(logicalBufferBucketSize(args.FrameSize))
 	if err != nil {
 		return frameStreamStats{}, err
@@ -766,7 +964,7 @@ func streamFramePayloadLinuxSplice(fd *os.File, fileOffset *int64, args frameStr
 }
 
 func writeFrameHeader(out io.Writer, args frameStreamArgs, wireSize int64, writeLatency *time.Duration) error {
-	headerLine := buildFrameHeaderLine(args.FileID, args.Offset, args.FrameSize, wireSize, args.Comp, args.MaxWSizeHint, args.HeaderTS)
+	headerLine := buildFrameHeaderLine(args.FileID, args.Offset, args.FrameSize, wireSize, args.Comp, args.MaxWSizeHint, args.HeaderTS, args.Hole)
 	writeStart := time.Now()
 	if _, err := io.WriteString(out, headerLine); err != nil {
 		return err
@@ -778,6 +976,11 @@ func writeFrameHeader(out io.Writer, args frameStreamArgs, wireSize int64, write
 }
 
 func writeFrameTrailer(out io.Writer, args frameStreamArgs, writeLatency *time.Duration) (string, error) {
+	if args.IsTerminal && args.Verify != nil {
+		if err := args.Verify(); err != nil {
+			return "", err
+		}
+	}
 	windowHashToken := ""
 	if args.IsTerminal {
 		windowHashToken = encoding.FormatXXH128HashToken(args.WindowHasher.Sum128())
@@ -794,7 +997,7 @@ func writeFrameTrailer(out io.Writer, args frameStreamArgs, writeLatency *time.D
 }
 
 func streamBufferedRead(
-	fd *os.File,
+	fd io.ReaderAt,
 	fileOffset *int64,
 	frameSize int64,
 	buf []byte,
@@ -920,8 +1123,9 @@ func logicalBufferBucketSize(maxChunk int64) int {
 	return bucket8MiB
 }
 
-func tryReadAheadWindow(fd *os.File, offset int64, length int64) {
-	if fd == nil || offset < 0 || length <= 0 {
+func tryReadAheadWindow(f source.File, offset int64, length int64) {
+	fd, ok := f.(*os.File)
+	if !ok || fd == nil || offset < 0 || length <= 0 {
 		return
 	}
 	_ = unix.Fadvise(int(fd.Fd()), offset, length, unix.FADV_SEQUENTIAL)
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -11,11 +12,14 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/jolynch/pinch/internal/filexfer/encoding"
+	"github.com/jolynch/pinch/internal/filexfer/source"
 	"github.com/zeebo/xxh3"
+	"golang.org/x/sys/unix"
 )
 
 type sendTestDeps struct {
@@ -24,6 +28,11 @@ type sendTestDeps struct {
 	windowHash     string
 	setStateCalls  int
 	setWindowCalls int
+	// recordedMtimeNS, when set, stands in for the mtime TXFER recorded.
+	recordedMtimeNS int64
+	refreshed       []TransferFileStateUpdate
+	// linkMbps is the stored link hint UpdateTransferLink reports.
+	linkMbps int64
 }
 
 func (d *sendTestDeps) NewTransfer(string, int, int64) (Transfer, error) {
@@ -38,12 +47,25 @@ func (d *sendTestDeps) RegisterTransferFileState(string, <-chan TransferFileStat
 	return ch
 }
 
+func (d *sendTestDeps) AppendTransferFile(string, TransferFileStateUpdate) (uint64, bool, bool) {
+	return 0, false, false
+}
+
 func (d *sendTestDeps) ClipTransfer(string) bool { return false }
 
+func (d *sendTestDeps) SetTransferSource(string, source.Source) bool { return false }
+
 func (d *sendTestDeps) GetTransfer(string) (Transfer, bool) { return Transfer{}, false }
 
 func (d *sendTestDeps) SetTransferHints(string, string, int64, int) bool { return true }
 
+func (d *sendTestDeps) UpdateTransferLink(_ string, linkMbps int64, _ int64) (int64, bool) {
+	if linkMbps > 0 {
+		d.linkMbps = linkMbps
+	}
+	return d.linkMbps, true
+}
+
 func (d *sendTestDeps) GetFile(txferID string, fileID uint64, fullPathRaw string) (*os.File, FileRef, error) {
 	fd, err := os.Open(d.filePath)
 	if err != nil {
@@ -60,6 +82,7 @@ func (d *sendTestDeps) GetFile(txferID string, fileID uint64, fullPathRaw string
 		Path:       d.filePath,
 		Directory:  filepath.Dir(d.filePath),
 		FileSize:   info.Size(),
+		MtimeNS:    d.recordedMtimeNS,
 	}, nil
 }
 
@@ -74,6 +97,7 @@ func (d *sendTestDeps) GetFileRef(txferID string, fileID uint64, fullPathRaw str
 		Path:       d.filePath,
 		Directory:  filepath.Dir(d.filePath),
 		FileSize:   info.Size(),
+		MtimeNS:    d.recordedMtimeNS,
 	}, nil
 }
 
@@ -93,6 +117,19 @@ func (d *sendTestDeps) VerifyTransferFileWindowHash(string, uint64, int64, strin
 
 func (d *sendTestDeps) AcknowledgeTransferFile(string, uint64, int64) bool { return false }
 
+func (d *sendTestDeps) RefreshTransferFile(_ string, update TransferFileStateUpdate) bool {
+	d.refreshed = append(d.refreshed, update)
+	return true
+}
+
+func (d *sendTestDeps) CancelTransfer(string) bool           { return false }
+func (d *sendTestDeps) PauseTransfer(string) bool            { return false }
+func (d *sendTestDeps) ResumeTransfer(string) bool           { return false }
+func (d *sendTestDeps) GetTransferGate(string) *TransferGate { return nil }
+func (d *sendTestDeps) RenewTransfer(string, time.Duration) (time.Time, bool) {
+	return time.Now().Add(time.Minute), true
+}
+
 func TestParseSENDRequestCompDefaultsAndModes(t *testing.T) {
 	req, err := ParseRequest([]byte(`SEND tx1 fd=1 "/tmp/a.txt"`))
 	if err != nil {
@@ -233,6 +270,12 @@ func TestStreamSendItemAdaptiveUpgradesFromNone(t *testing.T) {
 	tmp := writeTempSendFile(t, data)
 	deps := &sendTestDeps{filePath: tmp}
 
+	switchesBefore := compSwitches.With("none", encoding.EncodingLz4).Value() + compSwitches.With("none", encoding.EncodingZstd).Value()
+	logicalBefore := 0.0
+	for _, comp := range []string{"none", encoding.EncodingLz4, encoding.EncodingZstd} {
+		logicalBefore += sentLogicalBytes.With(comp).Value()
+	}
+
 	var rawOut bytes.Buffer
 	slowOut := delayedWriter{w: &rawOut, delay: 10 * time.Millisecond}
 	err := streamSendItem(context.Background(), &slowOut, deps, "tx-adapt", sendItem{FileID: 9, Offset: 0, Size: 0, Comp: "adapt", Path: tmp})
@@ -260,6 +303,17 @@ func TestStreamSendItemAdaptiveUpgradesFromNone(t *testing.T) {
 	if !sawCompressed {
 		t.Fatalf("expected adaptive mode to upgrade to a compressed frame, comps=%v", comps)
 	}
+	switchesAfter := compSwitches.With("none", encoding.EncodingLz4).Value() + compSwitches.With("none", encoding.EncodingZstd).Value()
+	if switchesAfter <= switchesBefore {
+		t.Fatalf("expected comp switch metric to increase, before=%v after=%v", switchesBefore, switchesAfter)
+	}
+	logicalAfter := 0.0
+	for _, comp := range []string{"none", encoding.EncodingLz4, encoding.EncodingZstd} {
+		logicalAfter += sentLogicalBytes.With(comp).Value()
+	}
+	if got := logicalAfter - logicalBefore; got != float64(size) {
+		t.Fatalf("expected %d logical bytes recorded, got %v", size, got)
+	}
 }
 
 type decodedFrame struct {
@@ -287,21 +341,27 @@ func decodeFrameStream(raw []byte) ([]decodedFrame, error) {
 		if header.WireSize < 0 {
 			return nil, errors.New("negative wire size")
 		}
-		payload := make([]byte, header.WireSize)
-		if _, err := io.ReadFull(br, payload); err != nil {
-			return nil, fmt.Errorf("read payload: %w", err)
-		}
-		decodedReader, err := encoding.DecodePayloadReaderByComp(bytes.NewReader(payload), header.Comp)
-		if err != nil {
-			return nil, fmt.Errorf("decode payload: %w", err)
-		}
-		logical, readErr := io.ReadAll(decodedReader)
-		closeErr := decodedReader.Close()
-		if readErr != nil {
-			return nil, fmt.Errorf("read decoded payload: %w", readErr)
-		}
-		if closeErr != nil {
-			return nil, fmt.Errorf("close decoded payload: %w", closeErr)
+		var logical []byte
+		if header.Hole {
+			logical = make([]byte, header.Size)
+		} else {
+			payload := make([]byte, header.WireSize)
+			if _, err := io.ReadFull(br, payload); err != nil {
+				return nil, fmt.Errorf("read payload: %w", err)
+			}
+			decodedReader, err := encoding.DecodePayloadReaderByComp(bytes.NewReader(payload), header.Comp)
+			if err != nil {
+				return nil, fmt.Errorf("decode payload: %w", err)
+			}
+			var readErr error
+			logical, readErr = io.ReadAll(decodedReader)
+			closeErr := decodedReader.Close()
+			if readErr != nil {
+				return nil, fmt.Errorf("read decoded payload: %w", readErr)
+			}
+			if closeErr != nil {
+				return nil, fmt.Errorf("close decoded payload: %w", closeErr)
+			}
 		}
 
 		trailerLine, err := br.ReadString('\n')
@@ -389,3 +449,254 @@ func TestHandleSENDBasic(t *testing.T) {
 		t.Fatalf("unexpected logical bytes")
 	}
 }
+
+func TestParseSENDRequestConsistency(t *testing.T) {
+	req, err := ParseRequest([]byte(`SEND tx1 fd=1 "/tmp/a" consistency=strict fd=2 "/tmp/b"`))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	parsed, err := parseSENDRequest(req)
+	if err != nil {
+		t.Fatalf("parseSENDRequest failed: %v", err)
+	}
+	if got := parsed.Items[0].Consistency; got != consistencyStrict {
+		t.Fatalf("expected strict consistency, got %q", got)
+	}
+	if got := parsed.Items[1].Consistency; got != consistencyWarn {
+		t.Fatalf("expected default warn consistency, got %q", got)
+	}
+
+	req, err = ParseRequest([]byte(`SEND tx1 fd=1 "/tmp/a" consistency=maybe`))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	if _, err := parseSENDRequest(req); err == nil || !strings.Contains(err.Error(), "BAD_REQUEST") {
+		t.Fatalf("expected BAD_REQUEST for invalid consistency, got %v", err)
+	}
+}
+
+func TestHandleSENDLinkHintStartsAdaptiveAtZstd(t *testing.T) {
+	tmp := writeTempSendFile(t, bytes.Repeat([]byte("link hint "), 1024))
+	deps := &sendTestDeps{filePath: tmp}
+	req, err := ParseRequest([]byte(`SEND tx1 fd=1 ` + strconv.Quote(tmp) + ` comp=adapt link-mbps=100 link-rtt-ms=40`))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := handleSEND(context.Background(), req, &out, deps); err != nil {
+		t.Fatalf("handleSEND failed: %v", err)
+	}
+	if deps.linkMbps != 100 {
+		t.Fatalf("expected link hint to be recorded, got %d", deps.linkMbps)
+	}
+	comps, err := frameComps(out.Bytes())
+	if err != nil {
+		t.Fatalf("frameComps failed: %v", err)
+	}
+	if len(comps) != 1 || comps[0] != encoding.EncodingZstd {
+		t.Fatalf("expected a slow link to start adaptive at zstd, comps=%v", comps)
+	}
+
+	req, err = ParseRequest([]byte(`SEND tx1 fd=1 "/tmp/a" link-mbps=-1`))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	if _, err := parseSENDRequest(req); err == nil || !strings.Contains(err.Error(), "BAD_REQUEST") {
+		t.Fatalf("expected BAD_REQUEST for negative link-mbps, got %v", err)
+	}
+}
+
+func TestStreamSendItemConsistencyDetectsChangeSinceTXFER(t *testing.T) {
+	tmp := writeTempSendFile(t, []byte("changed after txfer"))
+	deps := &sendTestDeps{filePath: tmp, recordedMtimeNS: 1}
+
+	var out bytes.Buffer
+	err := streamSendItem(context.Background(), &out, deps, "tx-changed", sendItem{FileID: 1, Comp: "none", Path: tmp, Consistency: consistencyStrict})
+	var pe protocolErr
+	if !errors.As(err, &pe) || pe.code != "CHANGED" {
+		t.Fatalf("expected CHANGED error, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected no frames before ERR CHANGED, got %q", out.String())
+	}
+	if len(deps.refreshed) != 1 || deps.refreshed[0].FileID != 1 || deps.refreshed[0].MtimeNS <= 1 {
+		t.Fatalf("expected changed file to be re-recorded, got %+v", deps.refreshed)
+	}
+	if !strings.Contains(pe.message, "fid=1 size=19 ") {
+		t.Fatalf("expected current identity in CHANGED message, got %q", pe.message)
+	}
+
+	for _, mode := range []string{consistencyWarn, consistencyIgnore} {
+		out.Reset()
+		if err := streamSendItem(context.Background(), &out, deps, "tx-changed", sendItem{FileID: 1, Comp: "none", Path: tmp, Consistency: mode}); err != nil {
+			t.Fatalf("%s: streamSendItem failed: %v", mode, err)
+		}
+	}
+}
+
+// appendOnWrite grows the file being sent as soon as the first frame goes
+// out, simulating a writer racing the transfer.
+type appendOnWrite struct {
+	bytes.Buffer
+	path     string
+	appended bool
+}
+
+func (w *appendOnWrite) Write(p []byte) (int, error) {
+	if !w.appended {
+		w.appended = true
+		f, err := os.OpenFile(w.path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return 0, err
+		}
+		_, _ = f.WriteString("more")
+		_ = f.Close()
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestStreamSendItemStrictConsistencyFailsTerminalFrame(t *testing.T) {
+	tmp := writeTempSendFile(t, []byte("racing writer"))
+	deps := &sendTestDeps{filePath: tmp}
+
+	out := &appendOnWrite{path: tmp}
+	err := streamSendItem(context.Background(), out, deps, "tx-race", sendItem{FileID: 3, Comp: "none", Path: tmp, Consistency: consistencyStrict})
+	var pe protocolErr
+	if !errors.As(err, &pe) || pe.code != "CHANGED" {
+		t.Fatalf("expected CHANGED error, got %v", err)
+	}
+	if strings.Contains(out.String(), "FXT/1") {
+		t.Fatalf("terminal trailer written for a changed file: %q", out.String())
+	}
+	if deps.setWindowCalls != 0 {
+		t.Fatalf("window hash recorded for a changed file")
+	}
+}
+
+func TestStreamSendItemIncludesRequestedExtendedMetadata(t *testing.T) {
+	data := []byte("xattr payload")
+	tmp := writeTempSendFile(t, data)
+	if err := unix.Setxattr(tmp, "user.pinch.test", []byte("v=1 x"), 0); err != nil {
+		t.Skipf("filesystem does not support user xattrs: %v", err)
+	}
+	deps := &sendTestDeps{filePath: tmp}
+
+	var out bytes.Buffer
+	item := sendItem{FileID: 4, Comp: "none", Path: tmp, Meta: parseSENDMetaOption("atime,xattrs,unknown")}
+	if err := streamSendItem(context.Background(), &out, deps, "tx-meta", item); err != nil {
+		t.Fatalf("streamSendItem failed: %v", err)
+	}
+	frames, err := decodeFrameStream(out.Bytes())
+	if err != nil {
+		t.Fatalf("decodeFrameStream failed: %v", err)
+	}
+	prefix := frames[len(frames)-1].Trailer.ChecksumPrefix
+	wantXattr := "meta:xattr." + encoding.EncodeXattrName("user.pinch.test") + "=" + base64.StdEncoding.EncodeToString([]byte("v=1 x"))
+	if !strings.Contains(prefix, wantXattr) {
+		t.Fatalf("expected %q in trailer %q", wantXattr, prefix)
+	}
+	if !strings.Contains(prefix, "meta:atime_ns=") {
+		t.Fatalf("expected meta:atime_ns in trailer %q", prefix)
+	}
+
+	out.Reset()
+	if err := streamSendItem(context.Background(), &out, deps, "tx-meta", sendItem{FileID: 4, Comp: "none", Path: tmp}); err != nil {
+		t.Fatalf("streamSendItem failed: %v", err)
+	}
+	if strings.Contains(out.String(), "meta:xattr.") || strings.Contains(out.String(), "meta:atime_ns=") {
+		t.Fatalf("extended metadata sent without meta= option: %q", out.String())
+	}
+}
+
+func TestHandleCXSUMIncludesRequestedExtendedMetadata(t *testing.T) {
+	tmp := writeTempSendFile(t, []byte("cxsum payload"))
+	if err := unix.Setxattr(tmp, "user.pinch.test", []byte("v"), 0); err != nil {
+		t.Skipf("filesystem does not support user xattrs: %v", err)
+	}
+	deps := &sendTestDeps{filePath: tmp}
+	req, err := ParseRequest([]byte(fmt.Sprintf(`CXSUM tx-meta 4 64 xxh128 %q meta=atime,xattrs`, tmp)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := handleCXSUM(context.Background(), req, &out, deps); err != nil {
+		t.Fatalf("handleCXSUM failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "meta:atime_ns=") || !strings.Contains(out.String(), "meta:xattr."+encoding.EncodeXattrName("user.pinch.test")+"=") {
+		t.Fatalf("expected atime and xattrs in CXSUM trailer: %q", out.String())
+	}
+	if _, err := ParseRequest([]byte(fmt.Sprintf(`CXSUM tx-meta 4 64 xxh128 %q extra=1`, tmp))); err == nil {
+		t.Fatalf("expected unknown CXSUM option to fail")
+	}
+}
+
+func TestStreamSendItemSendsHoleFramesForSparseFiles(t *testing.T) {
+	const size = 4 << 20
+	path := filepath.Join(t.TempDir(), "sparse.img")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create sparse file: %v", err)
+	}
+	head := bytes.Repeat([]byte("head"), 1024)
+	tail := bytes.Repeat([]byte("tail"), 1024)
+	if _, err := f.WriteAt(head, 0); err != nil {
+		t.Fatalf("write head: %v", err)
+	}
+	if _, err := f.WriteAt(tail, 2<<20); err != nil {
+		t.Fatalf("write tail: %v", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	_ = f.Close()
+	if info, err := os.Stat(path); err != nil || info.Sys().(*syscall.Stat_t).Blocks*512 >= size {
+		t.Skip("filesystem does not keep the test file sparse")
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read sparse file: %v", err)
+	}
+
+	var out bytes.Buffer
+	deps := &sendTestDeps{filePath: path}
+	if err := streamSendItem(context.Background(), &out, deps, "tx-sparse", sendItem{FileID: 2, Comp: "none", Path: path, Sparse: true}); err != nil {
+		t.Fatalf("streamSendItem failed: %v", err)
+	}
+	frames, err := decodeFrameStream(out.Bytes())
+	if err != nil {
+		t.Fatalf("decodeFrameStream failed: %v", err)
+	}
+	var got []byte
+	holeBytes, wireBytes := int64(0), int64(0)
+	for _, frame := range frames {
+		if frame.Header.Offset != int64(len(got)) {
+			t.Fatalf("non-contiguous frame offset %d after %d bytes", frame.Header.Offset, len(got))
+		}
+		if frame.Header.Hole {
+			holeBytes += frame.Header.Size
+		}
+		wireBytes += frame.Header.WireSize
+		got = append(got, frame.Logical...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("reassembled sparse file differs from source")
+	}
+	if holeBytes < size/2 {
+		t.Fatalf("expected most of the file sent as holes, got %d hole bytes", holeBytes)
+	}
+	if wireBytes >= size/2 {
+		t.Fatalf("expected hole frames to skip payload, wire bytes=%d", wireBytes)
+	}
+	wantHash := encoding.FormatXXH128HashToken(xxh3.Hash128(want))
+	if gotHash := frames[len(frames)-1].Trailer.FileHashToken; gotHash != wantHash {
+		t.Fatalf("window hash=%s want %s", gotHash, wantHash)
+	}
+
+	out.Reset()
+	if err := streamSendItem(context.Background(), &out, deps, "tx-sparse", sendItem{FileID: 2, Comp: "none", Path: path}); err != nil {
+		t.Fatalf("streamSendItem failed: %v", err)
+	}
+	if strings.Contains(out.String(), "hole=1") {
+		t.Fatalf("hole frames sent without sparse=1")
+	}
+}
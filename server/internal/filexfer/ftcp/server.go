@@ -7,19 +7,30 @@ import (
 	"io"
 	"net"
 	"runtime/trace"
+	"strings"
 	"time"
 
 	"filippo.io/age"
 	"github.com/jolynch/pinch/internal/filexfer/limit"
+	"github.com/jolynch/pinch/tracing"
 )
 
 const (
 	maxCommandLineBytes = 4 * 1024 * 1024
+	// keepAliveIdleTimeout closes a keepalive session that sends no next
+	// command for this long.
+	keepAliveIdleTimeout = time.Minute
 )
 
 type ServerOptions struct {
-	RequireAuth            bool
-	ServerIdentity         *age.X25519Identity
+	RequireAuth bool
+	// ServerIdentity is used when Keyring is nil.
+	ServerIdentity *age.X25519Identity
+	// Keyring, when set, supplies the accepted identities and may be
+	// updated while the server runs to rotate keys.
+	Keyring *Keyring
+	// AuditLog, when set, records which peer pulled which files.
+	AuditLog               *AuditLog
 	Deps                   Deps
 	Limiter                *limit.Limiter
 	SocketWriteBufferBytes int
@@ -28,13 +39,19 @@ type ServerOptions struct {
 type HandlerFunc func(context.Context, Request, io.Writer, Deps) error
 
 var handlers = map[Verb]HandlerFunc{
-	VerbAUTH:   handleAUTHCommand,
-	VerbTXFER:  handleTXFER,
-	VerbSEND:   handleSEND,
-	VerbACK:    handleACK,
-	VerbCXSUM:  handleCXSUM,
-	VerbSTATUS: handleSTATUS,
-	VerbPROBE:  handlePROBECommand,
+	VerbAUTH:    handleAUTHCommand,
+	VerbTXFER:   handleTXFER,
+	VerbSEND:    handleSEND,
+	VerbACK:     handleACK,
+	VerbCXSUM:   handleCXSUM,
+	VerbSTATUS:  handleSTATUS,
+	VerbPROBE:   handlePROBECommand,
+	VerbWATCH:   handleWATCHCommand,
+	VerbARCHIVE: handleARCHIVE,
+	VerbCANCEL:  handleCANCEL,
+	VerbPAUSE:   handlePAUSE,
+	VerbRESUME:  handleRESUME,
+	VerbRENEW:   handleRENEW,
 }
 
 func Serve(listener net.Listener, opts ServerOptions) error {
@@ -45,9 +62,15 @@ func Serve(listener net.Listener, opts ServerOptions) error {
 	if deps == nil {
 		deps = NewRuntimeDeps()
 	}
+	if opts.Keyring == nil && opts.ServerIdentity != nil {
+		opts.Keyring = NewKeyring(opts.ServerIdentity)
+	}
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
 			if _, ok := err.(net.Error); ok {
 				time.Sleep(50 * time.Millisecond)
 				continue
@@ -61,11 +84,15 @@ func Serve(listener net.Listener, opts ServerOptions) error {
 type connSession struct {
 	conn                   net.Conn
 	requireAuth            bool
-	serverID               *age.X25519Identity
+	keys                   *Keyring
+	audit                  *AuditLog
 	deps                   Deps
 	limiter                *limit.Limiter
 	socketWriteBufferBytes int
 	respOut                io.Writer
+	encryptedResp          bool
+	encryptedReq           bool
+	connIn                 io.Reader
 	closeResp              func() error
 	wroteBytes             bool
 }
@@ -75,7 +102,8 @@ func handleConn(conn net.Conn, opts ServerOptions, deps Deps) {
 	s := &connSession{
 		conn:                   conn,
 		requireAuth:            opts.RequireAuth,
-		serverID:               opts.ServerIdentity,
+		keys:                   opts.Keyring,
+		audit:                  opts.AuditLog,
 		deps:                   deps,
 		limiter:                opts.Limiter,
 		socketWriteBufferBytes: opts.SocketWriteBufferBytes,
@@ -96,6 +124,7 @@ func handleConn(conn net.Conn, opts ServerOptions, deps Deps) {
 
 func (s *connSession) run() error {
 	br := bufio.NewReader(s.conn)
+	s.connIn = br
 	firstPayload, err := readCommandLine(br, maxCommandLineBytes)
 	if err != nil {
 		return err
@@ -107,31 +136,41 @@ func (s *connSession) run() error {
 
 	cmdReq := firstReq
 	cmdReader := br
+	peer := peerInfo{auditLog: s.audit}
+	if addr := s.conn.RemoteAddr(); addr != nil {
+		peer.RemoteAddr = addr.String()
+	}
 	if firstReq.Verb == VerbAUTH {
-		authRes, authErr := processAUTHRequest(firstReq, s.requireAuth, s.serverID)
+		// Snapshot the keyring so a concurrent reload cannot split AUTH and
+		// command decryption across different key sets.
+		serverIDs := s.keys.Identities()
+		authRes, authErr := processAUTHRequest(firstReq, s.requireAuth, serverIDs)
 		if authErr != nil {
 			if errors.Is(authErr, errNotAuthorized) {
 				return protocolErr{code: "NOT_AUTHORIZED", message: "authorization failed"}
 			}
 			return authErr
 		}
+		peer.Recipient = recipientString(authRes.recipient)
 		if authRes.recipient != nil {
 			encOut, encErr := age.Encrypt(s.conn, authRes.recipient)
 			if encErr != nil {
 				return encErr
 			}
 			s.respOut = encOut
+			s.encryptedResp = true
 			s.closeResp = encOut.Close
 		}
 		if authRes.encryptedRequests {
-			if s.serverID == nil {
+			if len(serverIDs) == 0 {
 				return protocolErr{code: "NOT_AUTHORIZED", message: "server auth key unavailable"}
 			}
-			decIn, decErr := age.Decrypt(br, s.serverID)
+			decIn, decErr := age.Decrypt(br, serverIDs...)
 			if decErr != nil {
 				return protocolErr{code: "NOT_AUTHORIZED", message: "request decryption failed"}
 			}
 			cmdReader = bufio.NewReader(decIn)
+			s.encryptedReq = true
 		}
 
 		cmdPayload, cmdErr := readCommandLine(cmdReader, maxCommandLineBytes)
@@ -146,29 +185,89 @@ func (s *connSession) run() error {
 		return protocolErr{code: "NOT_AUTHORIZED", message: "missing AUTH"}
 	}
 
-	cmdCtx, connTask := trace.NewTask(context.Background(), "tcp-connection")
+	for {
+		if err := s.runCommand(cmdReq, cmdReader, peer); err != nil {
+			return err
+		}
+		// keepalive=1 leaves a plaintext session open for the next command.
+		// Encrypted streams end with their response, so those still close.
+		if !cmdReq.KeepAlive || s.encryptedResp || cmdReader != br {
+			break
+		}
+		_ = s.conn.SetReadDeadline(time.Now().Add(keepAliveIdleTimeout))
+		payload, err := readCommandLine(br, maxCommandLineBytes)
+		if err != nil {
+			var netErr net.Error
+			if errors.Is(err, io.EOF) || (errors.As(err, &netErr) && netErr.Timeout()) {
+				return nil
+			}
+			return err
+		}
+		_ = s.conn.SetReadDeadline(time.Time{})
+		cmdReq, err = ParseRequest(payload)
+		if err != nil {
+			return err
+		}
+		if cmdReq.Verb == VerbAUTH {
+			return protocolErr{code: "BAD_COMMAND", message: "AUTH must be the first command"}
+		}
+	}
+	return s.closeResp()
+}
+
+func (s *connSession) runCommand(cmdReq Request, cmdReader io.Reader, peer peerInfo) error {
+	active := activeCommands.With(cmdReq.Verb.String())
+	active.Inc()
+	defer active.Dec()
+	ctx := withPeer(context.Background(), peer)
+	if cmdReq.TraceParent != "" {
+		if sc, err := tracing.ParseTraceParent(cmdReq.TraceParent); err == nil {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+	}
+	ctx, span := tracing.Start(ctx, "ftcp."+strings.ToLower(cmdReq.Verb.String()), "net.peer", peer.RemoteAddr, "auth.recipient", peer.Recipient)
+	defer span.End()
+	cmdCtx, connTask := trace.NewTask(ctx, "tcp-connection")
 	defer connTask.End()
 	countingOut := &countingWriter{w: s.respOut}
 	if err := s.handleCommand(cmdCtx, cmdReq, cmdReader, countingOut); err != nil {
 		s.wroteBytes = countingOut.n > 0
+		span.SetError(err)
 		return err
 	}
-	if cmdReq.Verb == VerbTXFER || cmdReq.Verb == VerbSEND || cmdReq.Verb == VerbCXSUM || cmdReq.Verb == VerbPROBE {
+	if cmdReq.Verb == VerbTXFER || cmdReq.Verb == VerbSEND || cmdReq.Verb == VerbCXSUM || cmdReq.Verb == VerbPROBE || cmdReq.Verb == VerbARCHIVE {
 		if err := writeOKLine(countingOut, ""); err != nil {
 			s.wroteBytes = countingOut.n > 0
 			return err
 		}
 	}
 	s.wroteBytes = countingOut.n > 0
-	return s.closeResp()
+	return nil
 }
 
 func (s *connSession) handleCommand(ctx context.Context, req Request, in io.Reader, out io.Writer) error {
 	if req.Verb == VerbSEND {
 		return handleSENDWithOptions(ctx, req, out, s.deps, s.limiter)
 	}
+	if req.Verb == VerbARCHIVE {
+		return handleARCHIVEWithOptions(ctx, req, out, s.deps, s.limiter)
+	}
 	if req.Verb == VerbPROBE {
-		return handlePROBEWithInput(ctx, req, in, out, s.deps)
+		var serverKeys []string
+		if s.keys.Primary() != nil {
+			serverKeys = s.keys.Recipients()
+		}
+		return handlePROBEWithInput(ctx, req, in, out, s.deps, serverKeys)
+	}
+	if req.Verb == VerbWATCH {
+		// A plaintext client holds its side open, so EOF means it hung up.
+		// An encrypted one half-closes after the command to end the age
+		// stream, so its hang-up shows as a failed heartbeat write instead.
+		in = s.connIn
+		if s.encryptedReq {
+			in = nil
+		}
+		return handleWATCHWithInput(ctx, req, in, out, s.deps, s.encryptedResp)
 	}
 	handler, ok := handlers[req.Verb]
 	if !ok || req.Verb == VerbUnknown {
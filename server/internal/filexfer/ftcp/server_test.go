@@ -0,0 +1,57 @@
+package ftcp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleConnKeepAliveServesNextCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleConn(serverConn, ServerOptions{}, &sendTestDeps{filePath: path})
+	}()
+	_ = clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(clientConn)
+	readUntilOK := func() {
+		t.Helper()
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			if strings.HasPrefix(line, "ERR") {
+				t.Fatalf("unexpected error response: %s", line)
+			}
+			if strings.TrimSpace(line) == "OK" {
+				return
+			}
+		}
+	}
+
+	if _, err := io.WriteString(clientConn, `SEND tx1 fd=0 "`+path+`" offset=0 size=5 keepalive=1`+"\r\n"); err != nil {
+		t.Fatalf("write SEND: %v", err)
+	}
+	readUntilOK()
+	// Without keepalive the session ends after this response.
+	if _, err := io.WriteString(clientConn, `SEND tx1 fd=0 "`+path+`" offset=6 size=5`+"\r\n"); err != nil {
+		t.Fatalf("write second SEND: %v", err)
+	}
+	readUntilOK()
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("expected connection close after non-keepalive SEND, got %v", err)
+	}
+	<-done
+}
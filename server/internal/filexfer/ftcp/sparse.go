@@ -0,0 +1,126 @@
+package ftcp
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/zeebo/xxh3"
+	"golang.org/x/sys/unix"
+)
+
+// minSparseHoleBytes is the smallest hole worth its own frame; shorter holes
+// are folded into the surrounding data extent.
+const minSparseHoleBytes int64 = 64 * 1024
+
+var zeroHashBlock [64 * 1024]byte
+
+// nextSparseExtent reports the length of the extent at off (bounded by end)
+// and whether it is a hole, using SEEK_DATA/SEEK_HOLE. Filesystems without
+// hole reporting look like a single data extent.
+func nextSparseExtent(fd *os.File, off int64, end int64) (int64, bool) {
+	if off >= end {
+		return 0, false
+	}
+	data, err := unix.Seek(int(fd.Fd()), off, unix.SEEK_DATA)
+	if err != nil {
+		if errors.Is(err, unix.ENXIO) {
+			// No data past off: the rest of the file is a hole.
+			return end - off, end-off >= minSparseHoleBytes
+		}
+		return end - off, false
+	}
+	if data > off && min(data, end)-off >= minSparseHoleBytes {
+		return min(data, end) - off, true
+	}
+
+	// Data extent: extend it across holes too small to be worth a frame.
+	cursor := off
+	for cursor < end && cursor-off < defaultFileFrameLogicalSize {
+		hole, err := unix.Seek(int(fd.Fd()), cursor, unix.SEEK_HOLE)
+		if err != nil || hole >= end {
+			return end - off, false
+		}
+		next, err := unix.Seek(int(fd.Fd()), hole, unix.SEEK_DATA)
+		if err != nil || next >= end {
+			if end-hole >= minSparseHoleBytes {
+				return hole - off, false
+			}
+			return end - off, false
+		}
+		if next-hole >= minSparseHoleBytes {
+			return hole - off, false
+		}
+		cursor = next
+	}
+	return min(cursor, end) - off, false
+}
+
+// sparseDataBytes returns how many bytes of path hold data and whether the
+// file has any hole, walking its extents with SEEK_DATA/SEEK_HOLE. Files that
+// only use fewer blocks than their size, as on compressing or deduplicating
+// filesystems (zfs, btrfs), have no holes and report size.
+func sparseDataBytes(path string, size int64) (int64, bool) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return size, false
+	}
+	defer fd.Close()
+	var data int64
+	for off := int64(0); off < size; {
+		start, err := unix.Seek(int(fd.Fd()), off, unix.SEEK_DATA)
+		if err != nil {
+			if errors.Is(err, unix.ENXIO) {
+				// No data past off: the rest of the file is a hole.
+				break
+			}
+			return size, false
+		}
+		if start >= size {
+			break
+		}
+		end, err := unix.Seek(int(fd.Fd()), start, unix.SEEK_HOLE)
+		if err != nil {
+			return size, false
+		}
+		end = min(end, size)
+		data += end - start
+		off = end
+	}
+	return data, data < size
+}
+
+// streamHoleFrame writes a payload-less frame for a zero extent. The window
+// hash still covers the logical zeros so clients verify the same bytes they
+// reproduce.
+func streamHoleFrame(fileOffset *int64, args frameStreamArgs) (frameStreamStats, error) {
+	writeLatency := time.Duration(0)
+	if err := writeFrameHeader(args.Output, args, 0, &writeLatency); err != nil {
+		return frameStreamStats{}, err
+	}
+	hashStart := time.Now()
+	hashZeros(args.WindowHasher, args.FrameSize)
+	prepareLatency := time.Since(hashStart)
+	*fileOffset += args.FrameSize
+
+	windowHashToken, err := writeFrameTrailer(args.Output, args, &writeLatency)
+	if err != nil {
+		return frameStreamStats{}, err
+	}
+	return frameStreamStats{
+		LogicalSize:     args.FrameSize,
+		WireSize:        0,
+		PrepareLatency:  prepareLatency,
+		WriteLatency:    writeLatency,
+		NextOffset:      *fileOffset,
+		WindowHashToken: windowHashToken,
+	}, nil
+}
+
+func hashZeros(h *xxh3.Hasher128, n int64) {
+	for n > 0 {
+		step := min(n, int64(len(zeroHashBlock)))
+		_, _ = h.Write(zeroHashBlock[:step])
+		n -= step
+	}
+}
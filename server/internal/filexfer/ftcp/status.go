@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"time"
 )
 
 type DownloadStatus struct {
@@ -23,6 +24,20 @@ type TransferStatus struct {
 	PercentFiles   float64        `json:"percent_files"`
 	PercentBytes   float64        `json:"percent_bytes"`
 	DownloadStatus DownloadStatus `json:"download_status"`
+	// LinkMbps is the transfer's current link hint; LinkSource says whether
+	// it came from the TXFER probe or a client's SEND estimate, and LinkAgeMS
+	// is how long ago it was last updated.
+	LinkMbps   int64  `json:"link_mbps"`
+	LinkRTTMS  int64  `json:"link_rtt_ms,omitempty"`
+	LinkSource string `json:"link_source,omitempty"`
+	LinkAgeMS  int64  `json:"link_age_ms,omitempty"`
+	// Paused is set between PAUSE and RESUME.
+	Paused bool `json:"paused,omitempty"`
+	// ExpiresAtMS is when the transfer is reaped unless SEND, ACK or RENEW
+	// extend it by TTLMS first; 0 while paused, since a paused transfer
+	// does not expire.
+	ExpiresAtMS int64 `json:"expires_at_ms"`
+	TTLMS       int64 `json:"ttl_ms"`
 }
 
 type statusRequest struct {
@@ -48,18 +63,43 @@ func handleSTATUS(_ context.Context, req Request, out io.Writer, deps Deps) erro
 	if err != nil {
 		return err
 	}
-	transfer, ok := deps.GetTransfer(parsed.TransferID)
+	status, err := transferStatus(deps, parsed.TransferID)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(status)
+	if err != nil {
+		return protocolErr{code: "INTERNAL", message: "failed to encode status"}
+	}
+	return writeOKLine(out, string(payload))
+}
+
+// transferStatus summarizes a transfer for STATUS and the HTTP gateway.
+func transferStatus(deps Deps, txferID string) (TransferStatus, error) {
+	transfer, ok := deps.GetTransfer(txferID)
 	if !ok {
-		return protocolErr{code: "NOT_FOUND", message: "transfer not found"}
+		return TransferStatus{}, protocolErr{code: "NOT_FOUND", message: "transfer not found"}
 	}
 
 	status := TransferStatus{
-		TransferID: parsed.TransferID,
+		TransferID: txferID,
 		Directory:  transfer.Directory,
 		NumFiles:   transfer.NumFiles,
 		TotalSize:  transfer.TotalSize,
 		Done:       transfer.Done,
 		DoneSize:   transfer.DoneSize,
+		LinkMbps:   transfer.LinkMbps,
+		LinkRTTMS:  transfer.LinkRTTMS,
+		LinkSource: transfer.LinkSource,
+		Paused:     transfer.Paused,
+	}
+	// A paused transfer does not expire, so it reports no deadline.
+	if !transfer.ExpiresAt.IsZero() && !transfer.Paused {
+		status.ExpiresAtMS = transfer.ExpiresAt.UnixMilli()
+	}
+	status.TTLMS = transfer.TTL.Milliseconds()
+	if !transfer.LinkUpdatedAt.IsZero() {
+		status.LinkAgeMS = max(1, time.Since(transfer.LinkUpdatedAt).Milliseconds())
 	}
 	if transfer.NumFiles > 0 {
 		status.PercentFiles = float64(transfer.Done) * 100.0 / float64(transfer.NumFiles)
@@ -80,9 +120,5 @@ func handleSTATUS(_ context.Context, req Request, out io.Writer, deps Deps) erro
 		}
 	}
 
-	payload, err := json.Marshal(status)
-	if err != nil {
-		return protocolErr{code: "INTERNAL", message: "failed to encode status"}
-	}
-	return writeOKLine(out, string(payload))
+	return status, nil
 }
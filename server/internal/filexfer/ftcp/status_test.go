@@ -6,6 +6,9 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/jolynch/pinch/internal/filexfer/source"
 )
 
 type fakeDeps struct {
@@ -20,8 +23,15 @@ func (f fakeDeps) RegisterTransferFileState(string, <-chan TransferFileStateUpda
 	close(done)
 	return done
 }
+func (f fakeDeps) AppendTransferFile(string, TransferFileStateUpdate) (uint64, bool, bool) {
+	return 0, false, false
+}
 func (f fakeDeps) ClipTransfer(string) bool                         { return true }
+func (f fakeDeps) SetTransferSource(string, source.Source) bool     { return true }
 func (f fakeDeps) SetTransferHints(string, string, int64, int) bool { return true }
+func (f fakeDeps) UpdateTransferLink(string, int64, int64) (int64, bool) {
+	return f.transfer.LinkMbps, f.transferOK
+}
 func (f fakeDeps) GetTransfer(string) (Transfer, bool) {
 	return f.transfer, f.transferOK
 }
@@ -40,18 +50,34 @@ func (f fakeDeps) VerifyTransferFileWindowHash(string, uint64, int64, string) bo
 }
 func (f fakeDeps) AcknowledgeTransferFile(string, uint64, int64) bool { return true }
 
+func (f fakeDeps) RefreshTransferFile(string, TransferFileStateUpdate) bool { return true }
+
+func (f fakeDeps) CancelTransfer(string) bool           { return true }
+func (f fakeDeps) PauseTransfer(string) bool            { return true }
+func (f fakeDeps) ResumeTransfer(string) bool           { return true }
+func (f fakeDeps) GetTransferGate(string) *TransferGate { return nil }
+func (f fakeDeps) RenewTransfer(string, time.Duration) (time.Time, bool) {
+	return time.Now().Add(time.Minute), true
+}
+
 func TestHandleSTATUSWritesStatusLine(t *testing.T) {
 	req := Request{Verb: VerbSTATUS, Params: []map[string]string{{"txferid": "tx1"}}}
 	deps := fakeDeps{
 		transferOK: true,
 		transfer: Transfer{
-			ID:        "tx1",
-			Directory: "/tmp",
-			NumFiles:  1,
-			TotalSize: 100,
-			Done:      1,
-			DoneSize:  100,
-			State:     []uint8{TransferStateDone},
+			ID:            "tx1",
+			Directory:     "/tmp",
+			NumFiles:      1,
+			TotalSize:     100,
+			Done:          1,
+			DoneSize:      100,
+			State:         []uint8{TransferStateDone},
+			LinkMbps:      950,
+			LinkRTTMS:     12,
+			LinkSource:    "client",
+			LinkUpdatedAt: time.Now().Add(-time.Second),
+			ExpiresAt:     time.UnixMilli(1760000600000),
+			TTL:           10 * time.Minute,
 		},
 	}
 
@@ -66,4 +92,10 @@ func TestHandleSTATUSWritesStatusLine(t *testing.T) {
 	if !strings.Contains(line, `"transfer_id":"tx1"`) {
 		t.Fatalf("unexpected payload: %s", line)
 	}
+	if !strings.Contains(line, `"link_mbps":950,"link_rtt_ms":12,"link_source":"client","link_age_ms":`) {
+		t.Fatalf("expected link estimate in payload: %s", line)
+	}
+	if !strings.Contains(line, `"expires_at_ms":1760000600000,"ttl_ms":600000`) {
+		t.Fatalf("expected lease in payload: %s", line)
+	}
 }
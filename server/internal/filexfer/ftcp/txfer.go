@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
+	"math"
 	"net"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/jolynch/pinch/internal/filexfer/source"
+	"github.com/jolynch/pinch/tracing"
 	"github.com/jolynch/pinch/utils"
 	"github.com/zeebo/xxh3"
 )
@@ -24,6 +28,12 @@ type txferRequest struct {
 	Mode         string
 	LinkMbps     int64
 	Concurrency  int
+	// TTL, when > 0, replaces the server's default transfer lease; the
+	// store caps it.
+	TTL time.Duration
+	// Sparse opts into <size>/<allocated> tokens for files with holes;
+	// older clients only parse a plain size.
+	Sparse bool
 }
 
 func parseTXFERRequest(req Request) (txferRequest, error) {
@@ -36,7 +46,7 @@ func parseTXFERRequest(req Request) (txferRequest, error) {
 	p := req.Params[0]
 	for key := range p {
 		switch key {
-		case "directory", "verbose", "max-manifest-chunk-size", "mode", "link-mbps", "concurrency":
+		case "directory", "verbose", "max-manifest-chunk-size", "mode", "link-mbps", "concurrency", "ttl", "sparse":
 		default:
 			return txferRequest{}, protocolErr{code: "BAD_REQUEST", message: "unknown TXFER option"}
 		}
@@ -71,6 +81,14 @@ func parseTXFERRequest(req Request) (txferRequest, error) {
 	if err != nil || concurrency <= 0 {
 		return txferRequest{}, protocolErr{code: "BAD_REQUEST", message: "concurrency must be > 0"}
 	}
+	var ttl time.Duration
+	if raw := strings.TrimSpace(p["ttl"]); raw != "" {
+		seconds, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seconds <= 0 || seconds > int64(math.MaxInt64/time.Second) {
+			return txferRequest{}, protocolErr{code: "BAD_REQUEST", message: "ttl must be a positive number of seconds"}
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
 	return txferRequest{
 		Directory:    directory,
 		Verbose:      verbose,
@@ -78,19 +96,22 @@ func parseTXFERRequest(req Request) (txferRequest, error) {
 		Mode:         mode,
 		LinkMbps:     linkMbps,
 		Concurrency:  concurrency,
+		TTL:          ttl,
+		Sparse:       p["sparse"] == "1" || strings.EqualFold(p["sparse"], "true"),
 	}, nil
 }
 
-func handleTXFER(_ context.Context, req Request, out io.Writer, deps Deps) error {
+func handleTXFER(ctx context.Context, req Request, out io.Writer, deps Deps) error {
 	parsed, err := parseTXFERRequest(req)
 	if err != nil {
 		return err
 	}
-	if err := validateDirectory(parsed.Directory); err != nil {
+	src, err := source.New(parsed.Directory)
+	if err != nil {
 		return protocolErr{code: "UNPROCESSABLE", message: err.Error()}
 	}
 
-	root := filepath.Clean(parsed.Directory)
+	root := src.Root()
 	transfer, err := deps.NewTransfer(root, 0, 0)
 	if err != nil {
 		return protocolErr{code: "INTERNAL", message: "failed to initialize transfer"}
@@ -98,6 +119,14 @@ func handleTXFER(_ context.Context, req Request, out io.Writer, deps Deps) error
 	if ok := deps.SetTransferHints(transfer.ID, parsed.Mode, parsed.LinkMbps, parsed.Concurrency); !ok {
 		return protocolErr{code: "INTERNAL", message: "failed to persist transfer hints"}
 	}
+	if source.IsRemote(root) && !deps.SetTransferSource(transfer.ID, src) {
+		return protocolErr{code: "INTERNAL", message: "failed to persist transfer source"}
+	}
+	if parsed.TTL > 0 {
+		if _, ok := deps.RenewTransfer(transfer.ID, parsed.TTL); !ok {
+			return protocolErr{code: "INTERNAL", message: "failed to set transfer ttl"}
+		}
+	}
 	manifestMode := parsed.Mode
 	manifestLinkMbps := parsed.LinkMbps
 	manifestConcurrency := parsed.Concurrency
@@ -119,47 +148,83 @@ func handleTXFER(_ context.Context, req Request, out io.Writer, deps Deps) error
 		}
 	}()
 
-	if err := encodeManifest(out, transfer.ID, root, manifestMode, manifestLinkMbps, manifestConcurrency, parsed.MaxChunkSize, parsed.Verbose, deps); err != nil {
+	if err := encodeManifest(ctx, out, transfer.ID, src, manifestMode, manifestLinkMbps, manifestConcurrency, parsed.MaxChunkSize, parsed.Verbose, parsed.Sparse, deps); err != nil {
 		if isBrokenPipe(err) {
 			return nil
 		}
 		return protocolErr{code: "BAD_REQUEST", message: err.Error()}
 	}
 	cleanupTransfer = false
+
+	numFiles, totalSize, ttl := 0, int64(0), time.Duration(0)
+	if stored, ok := deps.GetTransfer(transfer.ID); ok {
+		numFiles, totalSize, ttl = stored.NumFiles, stored.TotalSize, stored.TTL
+	}
+	peer := peerFromContext(ctx)
+	attrs := []any{
+		"txfer", transfer.ID,
+		"directory", root,
+		"mode", manifestMode,
+		"link_mbps", manifestLinkMbps,
+		"concurrency", manifestConcurrency,
+		"files", numFiles,
+		"bytes", totalSize,
+		"ttl", ttl,
+	}
+	slog.Info("transfer.created", append(attrs, peer.logAttrs()...)...)
+	tracing.SpanFromContext(ctx).SetAttributes(attrs...)
+	peer.audit(AuditEvent{
+		Event:      "transfer.created",
+		TransferID: transfer.ID,
+		Directory:  root,
+		Bytes:      totalSize,
+	})
 	return nil
 }
 
-func validateDirectory(directory string) error {
-	if !filepath.IsAbs(directory) {
-		return errors.New("directory must be an absolute path")
+// manifestSizeToken renders the entry size. With sparse set it appends
+// "/<allocated>" for a regular file with holes, where allocated counts the
+// bytes in its data extents. A block count below the size only prompts the
+// SEEK_HOLE check, since compressed files use few blocks without holes.
+func manifestSizeToken(path string, info fs.FileInfo, sparse bool) string {
+	size := strconv.FormatInt(info.Size(), 10)
+	if !sparse || !info.Mode().IsRegular() {
+		return size
 	}
-	stat, err := os.Stat(directory)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return errors.New("directory does not exist")
-		}
-		return errors.New("directory is not usable")
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Blocks*512 >= info.Size() {
+		return size
 	}
-	if !stat.IsDir() {
-		return errors.New("directory is not a directory")
+	allocated, holes := sparseDataBytes(path, info.Size())
+	if !holes {
+		return size
 	}
-	if _, err := os.ReadDir(directory); err != nil {
-		return errors.New("directory is not readable")
+	return size + "/" + strconv.FormatInt(allocated, 10)
+}
+
+// statInode returns the inode number of info, or 0 when the platform does
+// not expose one.
+func statInode(info fs.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
 	}
-	return nil
+	return 0
 }
 
 func encodeManifest(
+	ctx context.Context,
 	w io.Writer,
 	transferID string,
-	root string,
+	src source.Source,
 	mode string,
 	linkMbps int64,
 	concurrency int,
 	maxChunkSize int,
 	verbose bool,
+	sparse bool,
 	deps Deps,
 ) error {
+	root := src.Root()
 	rootToken := fmt.Sprintf("%d:%s", len(root), root)
 	header := fmt.Sprintf(
 		"FM/2 %s %s mode=%s link-mbps=%d concurrency=%d\n",
@@ -197,28 +262,14 @@ func encodeManifest(
 		return err
 	}
 
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil {
-			return walkErr
-		}
-		if path == root || d.IsDir() {
-			return nil
-		}
-		info, err := d.Info()
-		if err != nil {
-			return err
-		}
-		rel, err := filepath.Rel(root, path)
-		if err != nil {
-			return err
-		}
-		entryPath := filepath.ToSlash(rel)
+	err := src.Walk(ctx, func(entryPath string, info fs.FileInfo) error {
 		entryMtime := strconv.FormatInt(info.ModTime().UnixNano(), 10)
 		entryMode := formatManifestMode(info.Mode())
+		sizeToken := manifestSizeToken(src.Path(entryPath), info, sparse)
 
 		pathToken := frontToken(prevPath, entryPath, verbose)
 		mtimeToken := mtimeFrontToken(prevMtime, entryMtime, verbose)
-		line := fmt.Sprintf("%d %d %s %s %s\n", fileID, info.Size(), mtimeToken, entryMode, pathToken)
+		line := fmt.Sprintf("%d %s %s %s %s\n", fileID, sizeToken, mtimeToken, entryMode, pathToken)
 
 		if maxChunkSize > 0 && chunkBytes+len(line) > maxChunkSize {
 			if chunkBytes == len(header) {
@@ -232,17 +283,19 @@ func encodeManifest(
 			}
 			pathToken = frontToken("", entryPath, verbose)
 			mtimeToken = mtimeFrontToken("", entryMtime, verbose)
-			line = fmt.Sprintf("%d %d %s %s %s\n", fileID, info.Size(), mtimeToken, entryMode, pathToken)
+			line = fmt.Sprintf("%d %s %s %s %s\n", fileID, sizeToken, mtimeToken, entryMode, pathToken)
 			if chunkBytes+len(line) > maxChunkSize {
 				return errors.New("max-manifest-chunk-size is too small for manifest entry")
 			}
 		}
 
-		fullPath := filepath.Clean(filepath.Join(root, entryPath))
+		fullPath := src.Path(entryPath)
 		updatesCh <- TransferFileStateUpdate{
 			FileID:   uint64(fileID),
 			PathHash: xxh3.Hash128([]byte(fullPath)),
 			FileSize: info.Size(),
+			MtimeNS:  info.ModTime().UnixNano(),
+			Inode:    statInode(info),
 		}
 		if _, err := io.WriteString(w, line); err != nil {
 			return err
@@ -3,12 +3,19 @@ package ftcp
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/jolynch/pinch/internal/filexfer/s3/s3test"
+	"github.com/jolynch/pinch/internal/filexfer/source"
 )
 
 type txferTestDeps struct {
@@ -31,8 +38,14 @@ func (d *txferTestDeps) RegisterTransferFileState(string, <-chan TransferFileSta
 	return done
 }
 
+func (d *txferTestDeps) AppendTransferFile(string, TransferFileStateUpdate) (uint64, bool, bool) {
+	return 0, false, false
+}
+
 func (d *txferTestDeps) ClipTransfer(string) bool { return true }
 
+func (d *txferTestDeps) SetTransferSource(string, source.Source) bool { return true }
+
 func (d *txferTestDeps) GetTransfer(string) (Transfer, bool) { return Transfer{}, false }
 
 func (d *txferTestDeps) SetTransferHints(txferID string, mode string, linkMbps int64, concurrency int) bool {
@@ -44,6 +57,8 @@ func (d *txferTestDeps) SetTransferHints(txferID string, mode string, linkMbps i
 	return true
 }
 
+func (d *txferTestDeps) UpdateTransferLink(string, int64, int64) (int64, bool) { return 0, true }
+
 func (d *txferTestDeps) GetFile(string, uint64, string) (*os.File, FileRef, error) {
 	return nil, FileRef{}, nil
 }
@@ -60,6 +75,16 @@ func (d *txferTestDeps) VerifyTransferFileWindowHash(string, uint64, int64, stri
 
 func (d *txferTestDeps) AcknowledgeTransferFile(string, uint64, int64) bool { return true }
 
+func (d *txferTestDeps) RefreshTransferFile(string, TransferFileStateUpdate) bool { return true }
+
+func (d *txferTestDeps) CancelTransfer(string) bool           { return true }
+func (d *txferTestDeps) PauseTransfer(string) bool            { return true }
+func (d *txferTestDeps) ResumeTransfer(string) bool           { return true }
+func (d *txferTestDeps) GetTransferGate(string) *TransferGate { return nil }
+func (d *txferTestDeps) RenewTransfer(string, time.Duration) (time.Time, bool) {
+	return time.Now().Add(time.Minute), true
+}
+
 func TestParseTXFERRequestRequiresHints(t *testing.T) {
 	req, err := ParseRequest([]byte(`TXFER "/tmp" mode=fast link-mbps=900 concurrency=12`))
 	if err != nil {
@@ -69,9 +94,16 @@ func TestParseTXFERRequestRequiresHints(t *testing.T) {
 	if err != nil {
 		t.Fatalf("parseTXFERRequest failed: %v", err)
 	}
-	if parsed.Mode != "fast" || parsed.LinkMbps != 900 || parsed.Concurrency != 12 {
+	if parsed.Mode != "fast" || parsed.LinkMbps != 900 || parsed.Concurrency != 12 || parsed.TTL != 0 {
 		t.Fatalf("unexpected parsed request: %+v", parsed)
 	}
+	req, err = ParseRequest([]byte(`TXFER "/tmp" mode=fast link-mbps=900 concurrency=12 ttl=7200`))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	if parsed, err = parseTXFERRequest(req); err != nil || parsed.TTL != 2*time.Hour {
+		t.Fatalf("expected ttl=2h, got %+v err=%v", parsed, err)
+	}
 
 	bad := []string{
 		`TXFER "/tmp" link-mbps=900 concurrency=12`,
@@ -80,6 +112,8 @@ func TestParseTXFERRequestRequiresHints(t *testing.T) {
 		`TXFER "/tmp" mode=slow link-mbps=900 concurrency=12`,
 		`TXFER "/tmp" mode=fast link-mbps=-1 concurrency=12`,
 		`TXFER "/tmp" mode=fast link-mbps=900 concurrency=0`,
+		`TXFER "/tmp" mode=fast link-mbps=900 concurrency=12 ttl=0`,
+		`TXFER "/tmp" mode=fast link-mbps=900 concurrency=12 ttl=1h`,
 	}
 	for _, raw := range bad {
 		t.Run(raw, func(t *testing.T) {
@@ -126,3 +160,130 @@ func TestHandleTXFERStoresHintsAndEmitsFM2(t *testing.T) {
 		t.Fatalf("unexpected write error: %v", err)
 	}
 }
+
+func TestManifestSizeTokenReportsAllocatedSizeForSparseFiles(t *testing.T) {
+	dir := t.TempDir()
+	dense := filepath.Join(dir, "dense")
+	if err := os.WriteFile(dense, []byte("hello"), 0o644); err != nil {
+		t.Fatalf("write dense file: %v", err)
+	}
+	info, err := os.Stat(dense)
+	if err != nil {
+		t.Fatalf("stat dense file: %v", err)
+	}
+	if got := manifestSizeToken(dense, info, true); got != "5" {
+		t.Fatalf("dense size token=%q want 5", got)
+	}
+
+	sparse := filepath.Join(dir, "sparse")
+	if err := os.WriteFile(sparse, nil, 0o644); err != nil {
+		t.Fatalf("write sparse file: %v", err)
+	}
+	if err := os.Truncate(sparse, 1<<20); err != nil {
+		t.Fatalf("truncate sparse file: %v", err)
+	}
+	info, err = os.Stat(sparse)
+	if err != nil {
+		t.Fatalf("stat sparse file: %v", err)
+	}
+	if got := manifestSizeToken(sparse, info, true); got != "1048576/0" {
+		t.Fatalf("sparse size token=%q want 1048576/0", got)
+	}
+	// Without TXFER sparse=1 older clients get a plain size.
+	if got := manifestSizeToken(sparse, info, false); got != "1048576" {
+		t.Fatalf("sparse size token without opt-in=%q want 1048576", got)
+	}
+}
+
+func TestHandleTXFERAndSENDFromObjectStoreRoot(t *testing.T) {
+	fake := s3test.New()
+	fake.ListPageSize = 2
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+
+	mtime := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	big := bytes.Repeat([]byte("object storage compresses well "), 4096)
+	fake.Put("bucket", "data/a.txt", []byte("alpha"), mtime)
+	fake.Put("bucket", "data/sub/", nil, mtime)
+	fake.Put("bucket", "data/sub/b.bin", big, mtime)
+	fake.Put("bucket", "data/sub/c.txt", []byte("charlie"), mtime)
+	fake.Put("bucket", "other/d.txt", []byte("delta"), mtime)
+
+	deps := NewRuntimeDeps()
+	req, err := ParseRequest([]byte(`TXFER "s3://bucket/data/" mode=fast link-mbps=0 concurrency=1`))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var manifest bytes.Buffer
+	var pe protocolErr
+	if err := handleTXFER(context.Background(), req, &manifest, deps); !errors.As(err, &pe) || pe.code != "UNPROCESSABLE" {
+		t.Fatalf("expected object-store roots off by default, got %v", err)
+	}
+	t.Cleanup(func() { _ = source.SetAllowedRemoteRoots(nil) })
+	if err := source.SetAllowedRemoteRoots([]string{"s3://bucket/other"}); err != nil {
+		t.Fatalf("SetAllowedRemoteRoots: %v", err)
+	}
+	if err := handleTXFER(context.Background(), req, &manifest, deps); !errors.As(err, &pe) || pe.code != "UNPROCESSABLE" {
+		t.Fatalf("expected a root outside the allowlist rejected, got %v", err)
+	}
+	if err := source.SetAllowedRemoteRoots([]string{"s3://bucket/data"}); err != nil {
+		t.Fatalf("SetAllowedRemoteRoots: %v", err)
+	}
+	if err := handleTXFER(context.Background(), req, &manifest, deps); err != nil {
+		t.Fatalf("handleTXFER failed: %v", err)
+	}
+	fields := strings.Fields(manifest.String())
+	txferID := fields[1]
+	if stored, ok := deps.GetTransfer(txferID); !ok || stored.Source == nil {
+		t.Fatalf("expected TXFER to cache the root's source on the transfer")
+	}
+	if fields[2] != "16:s3://bucket/data" {
+		t.Fatalf("unexpected manifest root %q", fields[2])
+	}
+	// The directory marker and the key outside the prefix are skipped.
+	lines := strings.Split(strings.TrimSpace(manifest.String()), "\n")[1:]
+	want := []string{"0 5 0:5:a.txt", fmt.Sprintf("1 %d 0:9:sub/b.bin", len(big)), "2 7 4:5:c.txt"}
+	var got []string
+	for _, line := range lines {
+		f := strings.Fields(line)
+		got = append(got, f[0]+" "+f[1]+" "+f[4])
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected manifest entries:\n%s", strings.Join(lines, "\n"))
+	}
+	if mtimeToken := strings.Fields(lines[0])[2]; mtimeToken != fmt.Sprintf("0:%d", mtime.UnixNano()) {
+		t.Fatalf("unexpected mtime token %q", mtimeToken)
+	}
+
+	send, err := ParseRequest([]byte(fmt.Sprintf(`SEND %s fd=1 "s3://bucket/data/sub/b.bin" comp=zstd`, txferID)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := handleSEND(context.Background(), send, &out, deps); err != nil {
+		t.Fatalf("handleSEND failed: %v", err)
+	}
+	frames, err := decodeFrameStream(out.Bytes())
+	if err != nil {
+		t.Fatalf("decodeFrameStream failed: %v", err)
+	}
+	if len(frames) != 1 || frames[0].Header.Comp != "zstd" || !bytes.Equal(frames[0].Logical, big) {
+		t.Fatalf("unexpected frames: %d", len(frames))
+	}
+	if frames[0].Header.WireSize >= int64(len(big)) {
+		t.Fatalf("expected compressed frame, wsize=%d size=%d", frames[0].Header.WireSize, len(big))
+	}
+
+	// Paths outside the manifest and replaced objects are refused.
+	outside, _ := ParseRequest([]byte(fmt.Sprintf(`SEND %s fd=1 "s3://bucket/other/d.txt"`, txferID)))
+	if err := handleSEND(context.Background(), outside, io.Discard, deps); !errors.As(err, &pe) || pe.code != "NOT_AUTHORIZED" {
+		t.Fatalf("expected NOT_AUTHORIZED for a path outside the root, got %v", err)
+	}
+	fake.Put("bucket", "data/a.txt", []byte("replaced"), mtime.Add(time.Hour))
+	changed, _ := ParseRequest([]byte(fmt.Sprintf(`SEND %s fd=0 "s3://bucket/data/a.txt" consistency=strict`, txferID)))
+	if err := handleSEND(context.Background(), changed, io.Discard, deps); !errors.As(err, &pe) || pe.code != "CHANGED" {
+		t.Fatalf("expected CHANGED for a replaced object, got %v", err)
+	}
+}
@@ -16,6 +16,12 @@ const (
 	VerbCXSUM
 	VerbSTATUS
 	VerbPROBE
+	VerbWATCH
+	VerbARCHIVE
+	VerbCANCEL
+	VerbPAUSE
+	VerbRESUME
+	VerbRENEW
 )
 
 func ParseVerb(token string) (Verb, error) {
@@ -34,7 +40,52 @@ func ParseVerb(token string) (Verb, error) {
 		return VerbSTATUS, nil
 	case "PROBE":
 		return VerbPROBE, nil
+	case "WATCH":
+		return VerbWATCH, nil
+	case "ARCHIVE":
+		return VerbARCHIVE, nil
+	case "CANCEL":
+		return VerbCANCEL, nil
+	case "PAUSE":
+		return VerbPAUSE, nil
+	case "RESUME":
+		return VerbRESUME, nil
+	case "RENEW":
+		return VerbRENEW, nil
 	default:
 		return VerbUnknown, fmt.Errorf("unknown verb: %s", token)
 	}
 }
+
+func (v Verb) String() string {
+	switch v {
+	case VerbAUTH:
+		return "AUTH"
+	case VerbTXFER:
+		return "TXFER"
+	case VerbSEND:
+		return "SEND"
+	case VerbACK:
+		return "ACK"
+	case VerbCXSUM:
+		return "CXSUM"
+	case VerbSTATUS:
+		return "STATUS"
+	case VerbPROBE:
+		return "PROBE"
+	case VerbWATCH:
+		return "WATCH"
+	case VerbARCHIVE:
+		return "ARCHIVE"
+	case VerbCANCEL:
+		return "CANCEL"
+	case VerbPAUSE:
+		return "PAUSE"
+	case VerbRESUME:
+		return "RESUME"
+	case VerbRENEW:
+		return "RENEW"
+	default:
+		return "UNKNOWN"
+	}
+}
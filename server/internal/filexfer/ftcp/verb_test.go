@@ -14,6 +14,12 @@ func TestParseVerb(t *testing.T) {
 		{token: "CXSUM", want: VerbCXSUM},
 		{token: "STATUS", want: VerbSTATUS},
 		{token: "PROBE", want: VerbPROBE},
+		{token: "WATCH", want: VerbWATCH},
+		{token: "ARCHIVE", want: VerbARCHIVE},
+		{token: "CANCEL", want: VerbCANCEL},
+		{token: "PAUSE", want: VerbPAUSE},
+		{token: "RESUME", want: VerbRESUME},
+		{token: "RENEW", want: VerbRENEW},
 		{token: "status", want: VerbSTATUS},
 	}
 	for _, tc := range cases {
@@ -27,6 +33,19 @@ func TestParseVerb(t *testing.T) {
 	}
 }
 
+func TestVerbStringRoundTrip(t *testing.T) {
+	verbs := []Verb{VerbAUTH, VerbTXFER, VerbSEND, VerbACK, VerbCXSUM, VerbSTATUS, VerbPROBE, VerbWATCH, VerbARCHIVE, VerbCANCEL, VerbPAUSE, VerbRESUME, VerbRENEW}
+	for _, v := range verbs {
+		got, err := ParseVerb(v.String())
+		if err != nil || got != v {
+			t.Fatalf("ParseVerb(%q)=%v,%v want %v", v.String(), got, err, v)
+		}
+	}
+	if got := VerbUnknown.String(); got != "UNKNOWN" {
+		t.Fatalf("VerbUnknown.String()=%q", got)
+	}
+}
+
 func TestParseVerbUnknown(t *testing.T) {
 	got, err := ParseVerb("BOGUS")
 	if err == nil {
@@ -38,7 +57,7 @@ func TestParseVerbUnknown(t *testing.T) {
 }
 
 func TestDispatchMapContainsVerbs(t *testing.T) {
-	verbs := []Verb{VerbAUTH, VerbTXFER, VerbSEND, VerbACK, VerbCXSUM, VerbSTATUS, VerbPROBE}
+	verbs := []Verb{VerbAUTH, VerbTXFER, VerbSEND, VerbACK, VerbCXSUM, VerbSTATUS, VerbPROBE, VerbWATCH, VerbARCHIVE, VerbCANCEL, VerbPAUSE, VerbRESUME, VerbRENEW}
 	for _, v := range verbs {
 		if _, ok := handlers[v]; !ok {
 			t.Fatalf("handlers missing verb %v", v)
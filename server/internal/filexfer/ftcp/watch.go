@@ -0,0 +1,540 @@
+package ftcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jolynch/pinch/internal/filexfer/source"
+	"github.com/zeebo/xxh3"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultWatchHeartbeat = 10 * time.Second
+	// watchPollInterval rescans the whole root when inotify is unavailable
+	// or could not watch every directory, which covers filesystems that do
+	// not report events (NFS, FUSE) and exhausted watch limits.
+	watchPollInterval = 2 * time.Second
+	// watchFullRescanInterval is the backstop full rescan while inotify
+	// covers the tree; events only trigger rescans of their directories.
+	watchFullRescanInterval = time.Minute
+	// watchSettleDelay batches the burst of events a single write produces.
+	watchSettleDelay = 100 * time.Millisecond
+	// ageStreamChunkSize is age's STREAM chunk size. An age writer only
+	// emits a chunk once the byte after it is written, so encrypted WATCH
+	// streams pad each batch of events past a chunk boundary.
+	ageStreamChunkSize = 64 * 1024
+
+	inotifyWatchMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+		unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_DELETE | unix.IN_ATTRIB
+)
+
+type watchRequest struct {
+	TransferID string
+	Heartbeat  time.Duration
+	// Sparse reports <size>/<allocated> for files with holes, as TXFER
+	// sparse=1 does.
+	Sparse bool
+}
+
+// watchedFile is what WATCH last reported for one manifest entry.
+type watchedFile struct {
+	FileID  uint64
+	Size    int64
+	MtimeNS int64
+	Inode   uint64
+}
+
+func handleWATCHCommand(context.Context, Request, io.Writer, Deps) error {
+	return protocolErr{code: "BAD_COMMAND", message: "invalid WATCH invocation"}
+}
+
+func parseWATCHRequest(req Request) (watchRequest, error) {
+	if req.Verb != VerbWATCH {
+		return watchRequest{}, protocolErr{code: "BAD_COMMAND", message: "not WATCH"}
+	}
+	if len(req.Params) != 1 {
+		return watchRequest{}, protocolErr{code: "BAD_REQUEST", message: "invalid WATCH arguments"}
+	}
+	p := req.Params[0]
+	parsed := watchRequest{TransferID: p["txferid"], Heartbeat: defaultWatchHeartbeat}
+	for key, raw := range p {
+		switch key {
+		case "txferid":
+		case "heartbeat-ms":
+			v, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || v <= 0 {
+				return watchRequest{}, protocolErr{code: "BAD_REQUEST", message: "heartbeat-ms must be a positive integer"}
+			}
+			parsed.Heartbeat = time.Duration(v) * time.Millisecond
+		case "sparse":
+			parsed.Sparse = raw == "1" || strings.EqualFold(raw, "true")
+		default:
+			return watchRequest{}, protocolErr{code: "BAD_REQUEST", message: "unknown WATCH option"}
+		}
+	}
+	if parsed.TransferID == "" {
+		return watchRequest{}, protocolErr{code: "BAD_REQUEST", message: "missing transfer id"}
+	}
+	return parsed, nil
+}
+
+// handleWATCHWithInput streams FW/1 events for files added to or changed
+// under the transfer root until the client hangs up. New files get the next
+// file id and are registered like TXFER entries, so SEND and ACK accept them.
+func handleWATCHWithInput(ctx context.Context, req Request, in io.Reader, out io.Writer, deps Deps, encrypted bool) error {
+	parsed, err := parseWATCHRequest(req)
+	if err != nil {
+		return err
+	}
+	transfer, ok := deps.GetTransfer(parsed.TransferID)
+	if !ok {
+		return protocolErr{code: "NOT_FOUND", message: "transfer not found"}
+	}
+	if source.IsRemote(transfer.Directory) {
+		return protocolErr{code: "UNPROCESSABLE", message: "object-store roots only support SEND"}
+	}
+	root := filepath.Clean(transfer.Directory)
+	known := make(map[xxh3.Uint128]watchedFile, transfer.NumFiles)
+	for i := 0; i < transfer.NumFiles && i < len(transfer.PathHash); i++ {
+		known[transfer.PathHash[i]] = watchedFile{
+			FileID:  uint64(i),
+			Size:    transfer.FileSize[i],
+			MtimeNS: transfer.FileMtime[i],
+			Inode:   transfer.FileInode[i],
+		}
+	}
+	w := &watchStream{
+		txferID:   parsed.TransferID,
+		root:      root,
+		deps:      deps,
+		out:       out,
+		encrypted: encrypted,
+		sparse:    parsed.Sparse,
+		known:     known,
+	}
+
+	// The client sends nothing after WATCH; EOF on the request side means
+	// it hung up.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if in != nil {
+		go func() {
+			_, _ = io.Copy(io.Discard, in)
+			cancel()
+		}()
+	}
+
+	dirs, err := newDirWatcher()
+	if err != nil {
+		slog.Warn("watch.inotify_unavailable", "txfer", parsed.TransferID, "error", err)
+	} else {
+		defer dirs.Close()
+		w.dirs = dirs
+	}
+	slog.Info("watch.started", append([]any{"txfer", parsed.TransferID, "directory", root}, peerFromContext(ctx).logAttrs()...)...)
+	defer func() {
+		slog.Info("watch.stopped", "txfer", parsed.TransferID, "added", w.added)
+	}()
+
+	if err := w.scan(nil); err != nil {
+		return w.finish(err)
+	}
+	poll := time.NewTimer(w.pollInterval())
+	defer poll.Stop()
+	heartbeat := time.NewTicker(parsed.Heartbeat)
+	defer heartbeat.Stop()
+	settle := time.NewTimer(watchSettleDelay)
+	settle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-w.dirs.Wake():
+			settle.Reset(watchSettleDelay)
+		case <-settle.C:
+			dirty, overflow := w.dirs.Drain()
+			if overflow {
+				err = w.scan(nil)
+				poll.Reset(w.pollInterval())
+			} else {
+				err = w.scan(dirty)
+			}
+		case <-poll.C:
+			w.dirs.Drain()
+			err = w.scan(nil)
+			poll.Reset(w.pollInterval())
+		case <-heartbeat.C:
+			err = w.renew()
+			if err == nil {
+				err = w.emit(fmt.Sprintf("FW/1 heartbeat %d\n", time.Now().UnixMilli()))
+			}
+		}
+		if err != nil {
+			return w.finish(err)
+		}
+	}
+}
+
+type watchStream struct {
+	txferID   string
+	root      string
+	deps      Deps
+	out       io.Writer
+	encrypted bool
+	sparse    bool
+	written   int64
+	known     map[xxh3.Uint128]watchedFile
+	dirs      *dirWatcher
+	added     int
+	// denied holds subdirectories already logged as unreadable, so each
+	// rescan does not log them again.
+	denied map[string]bool
+}
+
+// finish maps a failed write to a hung-up client onto a clean exit.
+func (w *watchStream) finish(err error) error {
+	if isBrokenPipe(err) {
+		return nil
+	}
+	return err
+}
+
+// renew extends the transfer's lease while the stream is open, since a
+// watching client may send and ack nothing for a long time.
+func (w *watchStream) renew() error {
+	if _, ok := w.deps.RenewTransfer(w.txferID, 0); !ok {
+		return protocolErr{code: "NOT_FOUND", message: "transfer not found"}
+	}
+	return nil
+}
+
+// pollInterval is how long to wait before the next full rescan.
+func (w *watchStream) pollInterval() time.Duration {
+	if w.dirs == nil || w.dirs.Missed() {
+		return watchPollInterval
+	}
+	return watchFullRescanInterval
+}
+
+// scan walks dirs, registering new files and size or identity changes with
+// the store before reporting them. dirs maps each directory to whether its
+// subdirectories are walked too; nil walks the whole root. Only regular
+// files are watched; FIFOs, sockets, devices and symlinks are skipped, as
+// are subdirectories the server cannot read.
+func (w *watchStream) scan(dirs map[string]bool) error {
+	if err := w.renew(); err != nil {
+		return err
+	}
+	if dirs == nil {
+		dirs = map[string]bool{w.root: true}
+	}
+	var lines []string
+	var updates []TransferFileStateUpdate
+	for _, start := range slices.Sorted(maps.Keys(dirs)) {
+		recursive := dirs[start]
+		err := filepath.WalkDir(start, func(path string, d fs.DirEntry, walkErr error) error {
+			return w.visit(path, d, walkErr, start != path && !recursive, &lines, &updates)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(updates) > 0 {
+		updatesCh := make(chan TransferFileStateUpdate, len(updates))
+		for _, update := range updates {
+			updatesCh <- update
+		}
+		close(updatesCh)
+		<-w.deps.RegisterTransferFileState(w.txferID, updatesCh, TransferStateStarted)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return w.emit(strings.Join(lines, ""))
+}
+
+// visit handles one WalkDir entry for scan; skipDirs stops at
+// subdirectories, for directories rescanned on their own inotify events.
+func (w *watchStream) visit(path string, d fs.DirEntry, walkErr error, skipDirs bool, lines *[]string, updates *[]TransferFileStateUpdate) error {
+	if walkErr != nil {
+		if path != w.root && errors.Is(walkErr, fs.ErrNotExist) {
+			return nil
+		}
+		if path != w.root && errors.Is(walkErr, fs.ErrPermission) {
+			if d != nil && d.IsDir() && !w.denied[path] {
+				if w.denied == nil {
+					w.denied = make(map[string]bool)
+				}
+				w.denied[path] = true
+				slog.Warn("watch.dir_skipped", "txfer", w.txferID, "directory", path, "error", walkErr)
+			}
+			return fs.SkipDir
+		}
+		return walkErr
+	}
+	if d.IsDir() {
+		if skipDirs {
+			return fs.SkipDir
+		}
+		w.dirs.Add(path)
+		return nil
+	}
+	if !d.Type().IsRegular() {
+		return nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	hash := xxh3.Hash128([]byte(path))
+	current := watchedFile{Size: info.Size(), MtimeNS: info.ModTime().UnixNano(), Inode: statInode(info)}
+	prev, ok := w.known[hash]
+	switch {
+	case !ok:
+		rel, err := filepath.Rel(w.root, path)
+		if err != nil {
+			return err
+		}
+		update := TransferFileStateUpdate{
+			PathHash: hash,
+			FileSize: current.Size,
+			MtimeNS:  current.MtimeNS,
+			Inode:    current.Inode,
+		}
+		fileID, added, ok := w.deps.AppendTransferFile(w.txferID, update)
+		if !ok {
+			return protocolErr{code: "NOT_FOUND", message: "transfer not found"}
+		}
+		current.FileID = fileID
+		if added {
+			w.added++
+		} else {
+			// Another WATCH registered this path first; keep its id
+			// and record what this stream sees now.
+			update.FileID = fileID
+			*updates = append(*updates, update)
+		}
+		// The payload is a verbose (not front-coded) manifest entry.
+		mtime := strconv.FormatInt(current.MtimeNS, 10)
+		*lines = append(*lines, fmt.Sprintf(
+			"FW/1 add %d %s %s %s %s\n",
+			current.FileID,
+			manifestSizeToken(path, info, w.sparse),
+			mtimeFrontToken("", mtime, true),
+			formatManifestMode(info.Mode()),
+			frontToken("", filepath.ToSlash(rel), true),
+		))
+	case current.Size < prev.Size || (prev.Inode != 0 && current.Inode != prev.Inode):
+		// Truncated or replaced (log rotation): earlier bytes are gone.
+		current.FileID = prev.FileID
+		w.deps.RefreshTransferFile(w.txferID, TransferFileStateUpdate{
+			FileID:   current.FileID,
+			FileSize: current.Size,
+			MtimeNS:  current.MtimeNS,
+			Inode:    current.Inode,
+		})
+		*lines = append(*lines, fmt.Sprintf("FW/1 replace %d %d %d\n", current.FileID, current.Size, current.MtimeNS))
+	case current.Size > prev.Size:
+		current.FileID = prev.FileID
+		*updates = append(*updates, TransferFileStateUpdate{
+			FileID:   current.FileID,
+			PathHash: hash,
+			FileSize: current.Size,
+			MtimeNS:  current.MtimeNS,
+			Inode:    current.Inode,
+		})
+		*lines = append(*lines, fmt.Sprintf("FW/1 grow %d %d %d\n", current.FileID, current.Size, current.MtimeNS))
+	default:
+		return nil
+	}
+	w.known[hash] = current
+	return nil
+}
+
+// emit writes event lines, padding encrypted streams so the age writer
+// releases them now instead of when its chunk fills.
+func (w *watchStream) emit(lines string) error {
+	if w.encrypted {
+		const minPad = len("FW/1 pad \n")
+		// Land the pad's newline just past a chunk boundary.
+		end := w.written + int64(len(lines)) + int64(minPad)
+		target := (end+ageStreamChunkSize-2)/ageStreamChunkSize*ageStreamChunkSize + 1
+		lines += "FW/1 pad " + strings.Repeat(".", int(target-end)) + "\n"
+	}
+	n, err := io.WriteString(w.out, lines)
+	w.written += int64(n)
+	return err
+}
+
+// dirWatcher records which watched directories inotify reported events in,
+// so the caller rescans only those. A nil *dirWatcher never wakes, leaving
+// polling.
+type dirWatcher struct {
+	f    *os.File
+	fd   int
+	wake chan struct{}
+
+	mu      sync.Mutex
+	watched map[string]bool
+	wds     map[int32]string
+	// dirty maps each directory with pending events to whether its
+	// subtree needs walking (a directory created or moved in).
+	dirty map[string]bool
+	// overflow is set when the kernel dropped events; the caller then
+	// rescans everything.
+	overflow bool
+	// missed is set once a directory could not be watched (watch limit),
+	// so events alone no longer cover the tree.
+	missed bool
+}
+
+func newDirWatcher() (*dirWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	w := &dirWatcher{
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		wake:    make(chan struct{}, 1),
+		watched: make(map[string]bool),
+		wds:     make(map[int32]string),
+		dirty:   make(map[string]bool),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *dirWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		w.record(buf[:n])
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// record parses a buffer of inotify events into dirty directories.
+func (w *dirWatcher) record(buf []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(buf) >= unix.SizeofInotifyEvent {
+		wd := int32(binary.NativeEndian.Uint32(buf[0:4]))
+		mask := binary.NativeEndian.Uint32(buf[4:8])
+		nameLen := int(binary.NativeEndian.Uint32(buf[12:16]))
+		if len(buf) < unix.SizeofInotifyEvent+nameLen {
+			return
+		}
+		name := strings.TrimRight(string(buf[unix.SizeofInotifyEvent:unix.SizeofInotifyEvent+nameLen]), "\x00")
+		buf = buf[unix.SizeofInotifyEvent+nameLen:]
+
+		if mask&unix.IN_Q_OVERFLOW != 0 {
+			w.overflow = true
+			continue
+		}
+		dir, ok := w.wds[wd]
+		if !ok {
+			continue
+		}
+		if mask&unix.IN_IGNORED != 0 {
+			// The directory was removed; a new one at the same path
+			// needs a fresh watch.
+			delete(w.wds, wd)
+			delete(w.watched, dir)
+			continue
+		}
+		if mask&unix.IN_ISDIR != 0 && name != "" {
+			child := filepath.Join(dir, name)
+			switch {
+			case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+				// Files may land before the watch does, so walk it all.
+				w.dirty[child] = true
+			case mask&unix.IN_MOVED_FROM != 0:
+				for path := range w.watched {
+					if path == child || strings.HasPrefix(path, child+string(filepath.Separator)) {
+						delete(w.watched, path)
+					}
+				}
+			}
+			continue
+		}
+		if _, ok := w.dirty[dir]; !ok {
+			w.dirty[dir] = false
+		}
+	}
+}
+
+func (w *dirWatcher) Add(dir string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watched[dir] {
+		return
+	}
+	wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyWatchMask)
+	if err != nil {
+		if errors.Is(err, unix.ENOSPC) || errors.Is(err, unix.ENOMEM) {
+			w.missed = true
+		}
+		return
+	}
+	w.watched[dir] = true
+	w.wds[int32(wd)] = dir
+}
+
+// Drain returns and clears the directories with pending events, and
+// whether events were dropped since the last call.
+func (w *dirWatcher) Drain() (map[string]bool, bool) {
+	if w == nil {
+		return nil, false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dirty, overflow := w.dirty, w.overflow
+	w.dirty = make(map[string]bool)
+	w.overflow = false
+	return dirty, overflow
+}
+
+// Missed reports whether some directory could not be watched.
+func (w *dirWatcher) Missed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.missed
+}
+
+func (w *dirWatcher) Wake() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.wake
+}
+
+func (w *dirWatcher) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,350 @@
+package ftcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zeebo/xxh3"
+	"golang.org/x/sys/unix"
+)
+
+func TestHandleWATCHStreamsAddAndGrowEvents(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("write test file: %v", err)
+	}
+	deps := NewRuntimeDeps()
+	req, err := ParseRequest([]byte(fmt.Sprintf(`TXFER %q mode=fast link-mbps=0 concurrency=1`, root)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var manifest bytes.Buffer
+	if err := handleTXFER(context.Background(), req, &manifest, deps); err != nil {
+		t.Fatalf("handleTXFER failed: %v", err)
+	}
+	txferID := strings.Fields(manifest.String())[1]
+	t.Cleanup(func() { deps.DeleteTransfer(txferID) })
+
+	watchReq, err := ParseRequest([]byte("WATCH " + txferID + " heartbeat-ms=60000"))
+	if err != nil {
+		t.Fatalf("ParseRequest WATCH failed: %v", err)
+	}
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := handleWATCHWithInput(context.Background(), watchReq, inR, outW, deps, false)
+		_ = outW.Close()
+		done <- err
+	}()
+
+	lines := make(chan string, 16)
+	go func() {
+		br := bufio.NewReader(outR)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("WATCH stream ended early")
+			}
+			return line
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for WATCH event")
+		}
+		return ""
+	}
+
+	f, err := os.OpenFile(filepath.Join(root, "a.txt"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open for append: %v", err)
+	}
+	if _, err := f.WriteString(" world"); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	f.Close()
+	if line := next(); !strings.HasPrefix(line, "FW/1 grow 0 11 ") {
+		t.Fatalf("expected grow event, got %q", line)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("new"), 0o644); err != nil {
+		t.Fatalf("write new file: %v", err)
+	}
+	line := next()
+	if !strings.HasPrefix(line, "FW/1 add 1 3 ") || !strings.HasSuffix(line, " 0:9:sub/b.txt\n") {
+		t.Fatalf("expected add event, got %q", line)
+	}
+	transfer, ok := deps.GetTransfer(txferID)
+	if !ok {
+		t.Fatalf("transfer %s missing", txferID)
+	}
+	if transfer.NumFiles != 2 || transfer.FileSize[0] != 11 || transfer.FileSize[1] != 3 {
+		t.Fatalf("expected store to track watched files, got files=%d sizes=%v", transfer.NumFiles, transfer.FileSize)
+	}
+
+	_ = inW.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WATCH returned error after hang-up: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("WATCH did not stop after the client hung up")
+	}
+}
+
+func TestWatchScanSkipsSpecialFilesAndUnreadableDirs(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatalf("write test file: %v", err)
+	}
+	deps := NewRuntimeDeps()
+	req, err := ParseRequest([]byte(fmt.Sprintf(`TXFER %q mode=fast link-mbps=0 concurrency=1`, root)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var manifest bytes.Buffer
+	if err := handleTXFER(context.Background(), req, &manifest, deps); err != nil {
+		t.Fatalf("handleTXFER failed: %v", err)
+	}
+	txferID := strings.Fields(manifest.String())[1]
+	t.Cleanup(func() { deps.DeleteTransfer(txferID) })
+	transfer, _ := deps.GetTransfer(txferID)
+
+	if err := unix.Mkfifo(filepath.Join(root, "pipe"), 0o644); err != nil {
+		t.Fatalf("mkfifo: %v", err)
+	}
+	locked := filepath.Join(root, "locked")
+	if err := os.MkdirAll(locked, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(locked, "secret.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write locked file: %v", err)
+	}
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(locked, 0o755) })
+	if err := os.WriteFile(filepath.Join(root, "b.txt"), []byte("new"), 0o644); err != nil {
+		t.Fatalf("write new file: %v", err)
+	}
+
+	// Two streams over one transfer, as two concurrent WATCH clients would
+	// have: each reports b.txt, under the one id the store allocated.
+	var out1, out2 bytes.Buffer
+	for _, out := range []*bytes.Buffer{&out1, &out2} {
+		w := &watchStream{
+			txferID: txferID,
+			root:    filepath.Clean(root),
+			deps:    deps,
+			out:     out,
+			known:   map[xxh3.Uint128]watchedFile{transfer.PathHash[0]: {FileID: 0, Size: 5, MtimeNS: transfer.FileMtime[0], Inode: transfer.FileInode[0]}},
+		}
+		if err := w.scan(nil); err != nil {
+			t.Fatalf("scan failed: %v", err)
+		}
+	}
+	for _, out := range []string{out1.String(), out2.String()} {
+		if strings.Contains(out, "pipe") {
+			t.Fatalf("expected FIFO skipped, got %q", out)
+		}
+		if !strings.Contains(out, "FW/1 add 1 3 ") || !strings.Contains(out, ":b.txt\n") {
+			t.Fatalf("expected b.txt added as fd 1, got %q", out)
+		}
+	}
+	stored, _ := deps.GetTransfer(txferID)
+	if os.Geteuid() == 0 {
+		// root reads the locked directory anyway; it must still be a
+		// separate id from b.txt.
+		if stored.NumFiles != 3 {
+			t.Fatalf("expected 3 files as root, got %d", stored.NumFiles)
+		}
+		return
+	}
+	if strings.Contains(out1.String(), "secret.txt") || stored.NumFiles != 2 {
+		t.Fatalf("expected unreadable directory skipped, files=%d out=%q", stored.NumFiles, out1.String())
+	}
+}
+
+func TestWatchStreamPadsEncryptedEventsPastChunkBoundary(t *testing.T) {
+	var out bytes.Buffer
+	w := &watchStream{out: &out, encrypted: true}
+	for _, line := range []string{"FW/1 grow 0 1 1\n", "FW/1 heartbeat 1\n"} {
+		if err := w.emit(line); err != nil {
+			t.Fatalf("emit failed: %v", err)
+		}
+		if w.written%ageStreamChunkSize != 1 {
+			t.Fatalf("expected writes to end one byte past a chunk, wrote %d", w.written)
+		}
+		if !strings.HasSuffix(out.String(), "\n") {
+			t.Fatalf("expected padded output to end with a newline")
+		}
+	}
+	if int64(out.Len()) != w.written || w.written != ageStreamChunkSize*2+1 {
+		t.Fatalf("unexpected padded length %d", out.Len())
+	}
+}
+
+func TestDirWatcherFlagsOnlyDirectoriesWithEvents(t *testing.T) {
+	root := t.TempDir()
+	quiet := filepath.Join(root, "quiet")
+	if err := os.MkdirAll(quiet, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	dirs, err := newDirWatcher()
+	if err != nil {
+		t.Skipf("inotify unavailable: %v", err)
+	}
+	defer dirs.Close()
+	dirs.Add(root)
+	dirs.Add(quiet)
+
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	want := map[string]bool{root: false, filepath.Join(root, "sub"): true}
+	got := make(map[string]bool)
+	deadline := time.After(10 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case <-dirs.Wake():
+		case <-deadline:
+			t.Fatalf("timed out waiting for events, got %v", got)
+		}
+		dirty, overflow := dirs.Drain()
+		if overflow {
+			t.Fatalf("unexpected overflow")
+		}
+		maps.Copy(got, dirty)
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("expected dirty directories %v, got %v", want, got)
+	}
+
+	var overflow [unix.SizeofInotifyEvent]byte
+	binary.NativeEndian.PutUint32(overflow[0:4], ^uint32(0))
+	binary.NativeEndian.PutUint32(overflow[4:8], unix.IN_Q_OVERFLOW)
+	dirs.record(overflow[:])
+	if _, full := dirs.Drain(); !full {
+		t.Fatalf("expected IN_Q_OVERFLOW to request a full rescan")
+	}
+}
+
+func TestWatchScanOfFlaggedDirectoryStopsAtSubdirectories(t *testing.T) {
+	root := t.TempDir()
+	deps := NewRuntimeDeps()
+	req, err := ParseRequest([]byte(fmt.Sprintf(`TXFER %q mode=fast link-mbps=0 concurrency=1`, root)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var manifest bytes.Buffer
+	if err := handleTXFER(context.Background(), req, &manifest, deps); err != nil {
+		t.Fatalf("handleTXFER failed: %v", err)
+	}
+	txferID := strings.Fields(manifest.String())[1]
+	t.Cleanup(func() { deps.DeleteTransfer(txferID) })
+
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	var out bytes.Buffer
+	w := &watchStream{txferID: txferID, root: filepath.Clean(root), deps: deps, out: &out, known: map[xxh3.Uint128]watchedFile{}}
+	if err := w.scan(map[string]bool{w.root: false}); err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+	if !strings.Contains(out.String(), ":a.txt\n") || strings.Contains(out.String(), "b.txt") {
+		t.Fatalf("expected only a.txt from the flagged directory, got %q", out.String())
+	}
+	out.Reset()
+	if err := w.scan(map[string]bool{filepath.Join(w.root, "sub"): true, filepath.Join(w.root, "gone"): false}); err != nil {
+		t.Fatalf("scan of a new subtree failed: %v", err)
+	}
+	if !strings.Contains(out.String(), ":sub/b.txt\n") || strings.Contains(out.String(), "a.txt") {
+		t.Fatalf("expected only sub/b.txt from the new subtree, got %q", out.String())
+	}
+}
+
+func TestHandleWATCHRenewsLeasePastShortTTL(t *testing.T) {
+	root := t.TempDir()
+	deps := NewRuntimeDeps()
+	req, err := ParseRequest([]byte(fmt.Sprintf(`TXFER %q mode=fast link-mbps=0 concurrency=1`, root)))
+	if err != nil {
+		t.Fatalf("ParseRequest failed: %v", err)
+	}
+	var manifest bytes.Buffer
+	if err := handleTXFER(context.Background(), req, &manifest, deps); err != nil {
+		t.Fatalf("handleTXFER failed: %v", err)
+	}
+	txferID := strings.Fields(manifest.String())[1]
+	t.Cleanup(func() { deps.DeleteTransfer(txferID) })
+	const ttl = 300 * time.Millisecond
+	if _, ok := deps.RenewTransfer(txferID, ttl); !ok {
+		t.Fatalf("transfer %s missing", txferID)
+	}
+
+	watchReq, err := ParseRequest([]byte("WATCH " + txferID + " heartbeat-ms=50"))
+	if err != nil {
+		t.Fatalf("ParseRequest WATCH failed: %v", err)
+	}
+	inR, inW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- handleWATCHWithInput(context.Background(), watchReq, inR, io.Discard, deps, false)
+	}()
+
+	time.Sleep(4 * ttl)
+	if _, ok := deps.GetTransfer(txferID); !ok {
+		t.Fatalf("transfer expired while WATCH was open")
+	}
+	_ = inW.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WATCH returned error after hang-up: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("WATCH did not stop after the client hung up")
+	}
+
+	// Once the stream closes nothing renews it, and the short lease runs out.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, ok := deps.GetTransfer(txferID); !ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("transfer outlived its lease after WATCH closed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
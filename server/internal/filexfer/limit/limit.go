@@ -10,9 +10,15 @@ import (
 	"time"
 
 	intencoding "github.com/jolynch/pinch/internal/filexfer/encoding"
+	"github.com/jolynch/pinch/metrics"
 	"golang.org/x/time/rate"
 )
 
+var rateLimitWaitSeconds = metrics.Default.NewCounterVec(
+	"pinch_ftcp_rate_limit_wait_seconds_total",
+	"Time file-listener responses spent blocked on the rate limiter.",
+)
+
 var (
 	errFileStreamTimeLimitExceeded = errors.New("file stream time limit exceeded")
 )
@@ -155,6 +161,10 @@ func waitRateLimited(ctx context.Context, limiter *rate.Limiter, n int) error {
 	if limiter == nil {
 		return nil
 	}
+	start := time.Now()
+	defer func() {
+		rateLimitWaitSeconds.With().Add(time.Since(start).Seconds())
+	}()
 	remaining := n
 	chunkMax := limiter.Burst()
 	if chunkMax <= 0 {
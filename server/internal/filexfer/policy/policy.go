@@ -21,6 +21,11 @@ const (
 	downRatioCut         = 0.90
 	downReadOverWriteCut = 1.10
 	upReadOverWriteCut   = 0.10
+	// Links at or above fastLinkMbps outrun zstd, so adaptive compression
+	// stops at lz4. At or below slowLinkMbps the wire is the bottleneck:
+	// adaptive streams start at zstd and only a poor ratio downgrades them.
+	fastLinkMbps = 10_000
+	slowLinkMbps = 1_000
 )
 
 type CompressionMetrics struct {
@@ -28,6 +33,8 @@ type CompressionMetrics struct {
 	WireSize       int64
 	PrepareLatency time.Duration
 	WriteLatency   time.Duration
+	// LinkMbps is the current link estimate; zero means unknown.
+	LinkMbps int64
 }
 
 type CompressionDecision struct {
@@ -56,8 +63,9 @@ func (p *CompressionPolicy) Decide(current CompressionMode, m CompressionMetrics
 	avgRatio := p.emaRatio
 	avgReadOverWrite := p.emaReadOverWrite
 
+	slowLink := m.LinkMbps > 0 && m.LinkMbps <= slowLinkMbps
 	upgrade := shouldUpgrade(avgReadOverWrite)
-	downgrade := !upgrade && (avgRatio < downRatioCut || avgReadOverWrite > downReadOverWriteCut)
+	downgrade := !upgrade && (avgRatio < downRatioCut || (!slowLink && avgReadOverWrite > downReadOverWriteCut))
 
 	if downgrade {
 		p.downgradeStreak++
@@ -86,6 +94,9 @@ func (p *CompressionPolicy) Decide(current CompressionMode, m CompressionMetrics
 	}
 	if p.upgradeStreak >= hystStreak {
 		next := upgradeMode(current)
+		if m.LinkMbps >= fastLinkMbps && next != CompressionModeNone && next != CompressionModeLz4 {
+			next = current
+		}
 		if next != current {
 			decision.Next = next
 			decision.Reason = "upgrade"
@@ -154,6 +165,16 @@ func upgradeMode(current CompressionMode) CompressionMode {
 	}
 }
 
+// InitialCompressionMode is where an adaptive stream starts given the link
+// estimate in Mbps: zstd on slow links, otherwise no compression until the
+// latency signal asks for more.
+func InitialCompressionMode(linkMbps int64) CompressionMode {
+	if linkMbps > 0 && linkMbps <= slowLinkMbps {
+		return CompressionModeZstdLevel1
+	}
+	return CompressionModeNone
+}
+
 func CompressionModeFromStored(raw uint8) CompressionMode {
 	mode := CompressionMode(raw)
 	switch mode {
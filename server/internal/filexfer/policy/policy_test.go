@@ -151,3 +151,64 @@ func quantizedRatioFromInput(input float64) float64 {
 	}
 	return compressionRatio(logical, wire)
 }
+
+func TestCompressionPolicyFastLinkStopsAtLz4(t *testing.T) {
+	p := NewCompressionPolicy()
+	mode := CompressionModeNone
+
+	for i := 0; i < 10; i++ {
+		d := p.Decide(mode, CompressionMetrics{
+			LogicalSize:    1000,
+			WireSize:       800,
+			PrepareLatency: 5 * time.Millisecond,
+			WriteLatency:   100 * time.Millisecond,
+			LinkMbps:       25_000,
+		})
+		mode = d.Next
+	}
+	if mode != CompressionModeLz4 {
+		t.Fatalf("expected fast link to cap upgrades at lz4, got %v", mode)
+	}
+}
+
+func TestCompressionPolicySlowLinkIgnoresLatencyDowngrade(t *testing.T) {
+	p := NewCompressionPolicy()
+	mode := CompressionModeZstdLevel1
+	slow := CompressionMetrics{
+		LogicalSize:    1000,
+		WireSize:       500,
+		PrepareLatency: 120 * time.Millisecond,
+		WriteLatency:   100 * time.Millisecond,
+		LinkMbps:       100,
+	}
+	for i := 0; i < 8; i++ {
+		mode = p.Decide(mode, slow).Next
+	}
+	if mode != CompressionModeZstdLevel1 {
+		t.Fatalf("expected slow link to hold zstd while ratio is good, got %v", mode)
+	}
+
+	slow.WireSize = 1200
+	for i := 0; i < 8; i++ {
+		mode = p.Decide(mode, slow).Next
+	}
+	if mode != CompressionModeNone {
+		t.Fatalf("expected poor ratio to still downgrade on a slow link, got %v", mode)
+	}
+}
+
+func TestInitialCompressionModeFollowsLink(t *testing.T) {
+	for _, tc := range []struct {
+		mbps int64
+		want CompressionMode
+	}{
+		{0, CompressionModeNone},
+		{100, CompressionModeZstdLevel1},
+		{1_000, CompressionModeZstdLevel1},
+		{10_000, CompressionModeNone},
+	} {
+		if got := InitialCompressionMode(tc.mbps); got != tc.want {
+			t.Fatalf("InitialCompressionMode(%d)=%v want %v", tc.mbps, got, tc.want)
+		}
+	}
+}
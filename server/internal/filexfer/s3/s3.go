@@ -0,0 +1,410 @@
+// Package s3 is a minimal client for S3-compatible object stores: the
+// handful of object and multipart calls pinch needs, signed with SigV4 and
+// addressed path-style so it works against AWS, MinIO and similar servers.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinPartSize is the smallest size S3 accepts for any multipart part but
+// the last.
+const MinPartSize = 5 * 1024 * 1024
+
+// MaxParts is the most parts one multipart upload may have.
+const MaxParts = 10000
+
+const defaultRegion = "us-east-1"
+
+// Config locates an S3-compatible endpoint and the credentials to sign with.
+// Requests are sent unsigned when AccessKeyID is empty.
+type Config struct {
+	// Endpoint is the service base URL, e.g. http://127.0.0.1:9000. Empty
+	// means AWS's regional endpoint.
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	HTTPClient      *http.Client
+}
+
+// ConfigFromEnv reads the standard AWS_* environment variables.
+func ConfigFromEnv() Config {
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	return Config{
+		Endpoint:        endpoint,
+		Region:          region,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+type Client struct {
+	cfg      Config
+	endpoint *url.URL
+	http     *http.Client
+	now      func() time.Time
+}
+
+func New(cfg Config) (*Client, error) {
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse s3 endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{cfg: cfg, endpoint: endpoint, http: httpClient, now: time.Now}, nil
+}
+
+// ParseURL splits an s3://bucket/prefix URL. The prefix has no leading or
+// trailing slash and may be empty.
+func ParseURL(raw string) (bucket string, prefix string, err error) {
+	rest, ok := strings.CutPrefix(raw, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("not an s3:// url: %q", raw)
+	}
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("missing bucket in %q", raw)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// IsURL reports whether raw names an s3:// location.
+func IsURL(raw string) bool {
+	return strings.HasPrefix(raw, "s3://")
+}
+
+// Error is a non-2xx S3 response, or an error document in a 200 response.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: status %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsCode reports whether err is an S3 error with the given code.
+func IsCode(err error, code string) bool {
+	var s3Err *Error
+	return errors.As(err, &s3Err) && s3Err.Code == code
+}
+
+type errorDocument struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func readError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	s3Err := &Error{StatusCode: resp.StatusCode}
+	var doc errorDocument
+	if xml.Unmarshal(body, &doc) == nil {
+		s3Err.Code, s3Err.Message = doc.Code, doc.Message
+	}
+	if s3Err.Code == "" && resp.StatusCode == http.StatusNotFound {
+		s3Err.Code = "NoSuchKey"
+	}
+	return s3Err
+}
+
+// do sends one signed request. body is sent with the given length; a nil
+// body sends nothing. Non-2xx responses become *Error.
+func (c *Client) do(ctx context.Context, method string, bucket string, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *c.endpoint
+	escapedPath := strings.TrimRight(c.endpoint.EscapedPath(), "/") + "/" + uriEncode(bucket, true)
+	if key != "" {
+		escapedPath += "/" + uriEncode(key, false)
+	}
+	decodedPath, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return nil, err
+	}
+	u.Path, u.RawPath = decodedPath, escapedPath
+	u.RawQuery = canonicalQuery(query)
+
+	if body == nil || size == 0 {
+		body = http.NoBody
+		size = 0
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if payloadHash == "" {
+		payloadHash = emptyPayloadHash
+	}
+	if c.cfg.AccessKeyID != "" {
+		signV4(req, c.cfg.AccessKeyID, c.cfg.SecretAccessKey, c.cfg.SessionToken, c.cfg.Region, payloadHash, c.now())
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, readError(resp)
+	}
+	return resp, nil
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := slices.Clone(query[key])
+		slices.Sort(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// PutObject uploads size bytes from body as one object and returns its ETag.
+func (c *Client) PutObject(ctx context.Context, bucket string, key string, body io.Reader, size int64) (string, error) {
+	resp, err := c.do(ctx, http.MethodPut, bucket, key, nil, nil, body, size, UnsignedPayload)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Header.Get("ETag"), nil
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// CreateMultipartUpload starts a multipart upload and returns its id.
+func (c *Client) CreateMultipartUpload(ctx context.Context, bucket string, key string) (string, error) {
+	resp, err := c.do(ctx, http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil, nil, 0, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result initiateMultipartUploadResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode CreateMultipartUpload response: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("CreateMultipartUpload returned no upload id")
+	}
+	return result.UploadID, nil
+}
+
+// UploadPart uploads size bytes from body as part number part (1-based) and
+// returns the part's ETag.
+func (c *Client) UploadPart(ctx context.Context, bucket string, key string, uploadID string, part int, body io.Reader, size int64) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(part)}, "uploadId": {uploadID}}
+	resp, err := c.do(ctx, http.MethodPut, bucket, key, query, nil, body, size, UnsignedPayload)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("UploadPart %d returned no ETag", part)
+	}
+	return etag, nil
+}
+
+// Part is one uploaded part of a multipart upload.
+type Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []Part   `xml:"Part"`
+}
+
+// CompleteMultipartUpload assembles parts, which must be in ascending part
+// order, into the final object.
+func (c *Client) CompleteMultipartUpload(ctx context.Context, bucket string, key string, uploadID string, parts []Part) error {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/xml"}}
+	resp, err := c.do(ctx, http.MethodPost, bucket, key, url.Values{"uploadId": {uploadID}}, header, bytes.NewReader(body), int64(len(body)), sha256Hex(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 can report a failed completion in a 200 response.
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return fmt.Errorf("read CompleteMultipartUpload response: %w", err)
+	}
+	var doc errorDocument
+	if xml.Unmarshal(respBody, &doc) == nil && doc.Code != "" {
+		return &Error{StatusCode: resp.StatusCode, Code: doc.Code, Message: doc.Message}
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards an upload and any parts it holds.
+func (c *Client) AbortMultipartUpload(ctx context.Context, bucket string, key string, uploadID string) error {
+	resp, err := c.do(ctx, http.MethodDelete, bucket, key, url.Values{"uploadId": {uploadID}}, nil, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ObjectInfo is what HeadObject and ListObjects report about an object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// HeadObject returns an object's size, ETag and modification time.
+func (c *Client) HeadObject(ctx context.Context, bucket string, key string) (ObjectInfo, error) {
+	resp, err := c.do(ctx, http.MethodHead, bucket, key, nil, nil, nil, 0, "")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+	info := ObjectInfo{Key: key, Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = lastModified
+	}
+	return info, nil
+}
+
+// GetObject opens the object's bytes from offset to the end. A non-empty
+// etag makes the read fail with PreconditionFailed if the object has since
+// been replaced.
+func (c *Client) GetObject(ctx context.Context, bucket string, key string, offset int64, etag string) (io.ReadCloser, error) {
+	return c.GetObjectRange(ctx, bucket, key, offset, -1, etag)
+}
+
+// GetObjectRange is GetObject limited to length bytes; a negative length
+// reads to the end.
+func (c *Client) GetObjectRange(ctx context.Context, bucket string, key string, offset int64, length int64, etag string) (io.ReadCloser, error) {
+	header := http.Header{}
+	ranged := offset > 0 || length >= 0
+	if ranged {
+		end := ""
+		if length >= 0 {
+			end = strconv.FormatInt(offset+length-1, 10)
+		}
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-"+end)
+	}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, nil, header, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	if ranged && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GetObject range from %d returned status %d", offset, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string `xml:"Key"`
+		Size         int64  `xml:"Size"`
+		ETag         string `xml:"ETag"`
+		LastModified string `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// ListObjects calls fn for every object under prefix, in key order,
+// following continuation tokens until the listing ends or fn fails.
+func (c *Client) ListObjects(ctx context.Context, bucket string, prefix string, fn func(ObjectInfo) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.do(ctx, http.MethodGet, bucket, "", query, nil, nil, 0, "")
+		if err != nil {
+			return err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decode ListObjectsV2 response: %w", err)
+		}
+		for _, content := range result.Contents {
+			info := ObjectInfo{Key: content.Key, Size: content.Size, ETag: content.ETag}
+			if lastModified, err := time.Parse(time.RFC3339Nano, content.LastModified); err == nil {
+				info.LastModified = lastModified
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		if result.NextContinuationToken == "" {
+			return errors.New("ListObjectsV2 truncated without a continuation token")
+		}
+		token = result.NextContinuationToken
+	}
+}
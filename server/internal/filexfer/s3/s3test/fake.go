@@ -0,0 +1,239 @@
+// Package s3test is an in-memory S3 stand-in for tests. It speaks just
+// enough of the path-style REST API for the s3 package's client: objects,
+// listings and multipart uploads.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type object struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+}
+
+type upload struct {
+	bucket string
+	key    string
+	parts  map[int][]byte
+}
+
+// Server is an http.Handler holding buckets in memory. Every bucket exists.
+type Server struct {
+	mu      sync.Mutex
+	objects map[string]object
+	uploads map[string]*upload
+	nextID  int
+	// FailPart, when set, fails UploadPart for the parts it returns true for.
+	FailPart func(key string, part int) bool
+	// ListPageSize caps the keys per ListObjectsV2 page; 0 means 1000.
+	ListPageSize int
+	requests     map[string]int
+}
+
+func New() *Server {
+	return &Server{
+		objects:  make(map[string]object),
+		uploads:  make(map[string]*upload),
+		requests: make(map[string]int),
+	}
+}
+
+// Put stores an object directly.
+func (s *Server) Put(bucket string, key string, data []byte, lastModified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = object{data: slices.Clone(data), etag: etagOf(data), lastModified: lastModified}
+}
+
+// Object returns a stored object's bytes.
+func (s *Server) Object(bucket string, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[bucket+"/"+key]
+	return obj.data, ok
+}
+
+// Uploads is the number of multipart uploads neither completed nor aborted.
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// Requests is how many requests of op, e.g. "UploadPart", were served.
+func (s *Server) Requests(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[op]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		writeError(w, http.StatusBadRequest, "InvalidBucketName")
+		return
+	}
+	query := r.URL.Query()
+	// Read bodies before locking so concurrent streamed parts do not
+	// serialize behind each other.
+	body, err := io.ReadAll(r.Body)
+	if err != nil || (r.ContentLength >= 0 && int64(len(body)) != r.ContentLength) {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case query.Has("uploads") && r.Method == http.MethodPost:
+		s.requests["CreateMultipartUpload"]++
+		s.nextID++
+		id := fmt.Sprintf("upload-%d", s.nextID)
+		s.uploads[id] = &upload{bucket: bucket, key: key, parts: make(map[int][]byte)}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", bucket, key, id)
+	case query.Has("uploadId"):
+		s.handleUpload(w, r, bucket, key, query, body)
+	case key == "" && query.Get("list-type") == "2" && r.Method == http.MethodGet:
+		s.listObjects(w, bucket, query)
+	case r.Method == http.MethodPut:
+		s.requests["PutObject"]++
+		obj := object{data: body, etag: etagOf(body), lastModified: time.Now()}
+		s.objects[bucket+"/"+key] = obj
+		w.Header().Set("ETag", obj.etag)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.requests["GetObject"]++
+		obj, ok := s.objects[bucket+"/"+key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", obj.etag)
+		http.ServeContent(w, r, "", obj.lastModified, bytes.NewReader(obj.data))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, bucket string, query map[string][]string) {
+	s.requests["ListObjectsV2"]++
+	prefix := bucket + "/" + strings.Join(query["prefix"], "")
+	after := strings.Join(query["continuation-token"], "")
+	var keys []string
+	for name := range s.objects {
+		key := strings.TrimPrefix(name, bucket+"/")
+		if strings.HasPrefix(name, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	pageSize := s.ListPageSize
+	if pageSize <= 0 {
+		pageSize = 1000
+	}
+	truncated := len(keys) > pageSize
+	if truncated {
+		keys = keys[:pageSize]
+	}
+	var out bytes.Buffer
+	out.WriteString("<ListBucketResult>")
+	for _, key := range keys {
+		obj := s.objects[bucket+"/"+key]
+		out.WriteString("<Contents><Key>")
+		_ = xml.EscapeText(&out, []byte(key))
+		fmt.Fprintf(&out, "</Key><Size>%d</Size><ETag>%s</ETag><LastModified>%s</LastModified></Contents>",
+			len(obj.data), obj.etag, obj.lastModified.UTC().Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&out, "<IsTruncated>%t</IsTruncated>", truncated)
+	if truncated {
+		// The last key doubles as the continuation token.
+		out.WriteString("<NextContinuationToken>")
+		_ = xml.EscapeText(&out, []byte(keys[len(keys)-1]))
+		out.WriteString("</NextContinuationToken>")
+	}
+	out.WriteString("</ListBucketResult>")
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(out.Bytes())
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, bucket string, key string, query map[string][]string, body []byte) {
+	id := query["uploadId"][0]
+	up, ok := s.uploads[id]
+	if !ok || up.bucket != bucket || up.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		s.requests["UploadPart"]++
+		part, err := strconv.Atoi(strings.Join(query["partNumber"], ""))
+		if err != nil || part < 1 || part > 10000 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		if s.FailPart != nil && s.FailPart(key, part) {
+			writeError(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		up.parts[part] = body
+		w.Header().Set("ETag", etagOf(body))
+	case http.MethodPost:
+		s.requests["CompleteMultipartUpload"]++
+		var req struct {
+			Parts []struct {
+				Number int    `xml:"PartNumber"`
+				ETag   string `xml:"ETag"`
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+			writeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var data []byte
+		for i, part := range req.Parts {
+			partData, ok := up.parts[part.Number]
+			if !ok || etagOf(partData) != part.ETag {
+				writeError(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			if i > 0 && part.Number <= req.Parts[i-1].Number {
+				writeError(w, http.StatusBadRequest, "InvalidPartOrder")
+				return
+			}
+			data = append(data, partData...)
+		}
+		obj := object{data: data, etag: etagOf(data), lastModified: time.Now()}
+		s.objects[bucket+"/"+key] = obj
+		delete(s.uploads, id)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key><ETag>%s</ETag></CompleteMultipartUploadResult>", key, obj.etag)
+	case http.MethodDelete:
+		s.requests["AbortMultipartUpload"]++
+		delete(s.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
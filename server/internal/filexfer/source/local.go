@@ -0,0 +1,76 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type localSource struct {
+	root string
+}
+
+func newLocalSource(directory string) (Source, error) {
+	if !filepath.IsAbs(directory) {
+		return nil, errors.New("directory must be an absolute path")
+	}
+	stat, err := os.Stat(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("directory does not exist")
+		}
+		return nil, errors.New("directory is not usable")
+	}
+	if !stat.IsDir() {
+		return nil, errors.New("directory is not a directory")
+	}
+	if _, err := os.ReadDir(directory); err != nil {
+		return nil, errors.New("directory is not readable")
+	}
+	return localSource{root: filepath.Clean(directory)}, nil
+}
+
+func (s localSource) Root() string {
+	return s.root
+}
+
+func (s localSource) Path(rel string) string {
+	return filepath.Clean(filepath.Join(s.root, filepath.FromSlash(rel)))
+}
+
+func (s localSource) Walk(ctx context.Context, fn WalkFunc) error {
+	return filepath.WalkDir(s.root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if path == s.root || d.IsDir() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), info)
+	})
+}
+
+func (s localSource) Open(_ context.Context, path string) (File, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
+
+func (s localSource) Stat(_ context.Context, path string) (fs.FileInfo, error) {
+	return os.Stat(path)
+}
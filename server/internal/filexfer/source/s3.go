@@ -0,0 +1,228 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jolynch/pinch/internal/filexfer/s3"
+)
+
+// s3Source serves the objects under an s3://bucket/prefix root. Keys map to
+// paths by stripping the prefix; keys that are not valid slash paths (empty
+// or dot segments, trailing slash directory markers) are skipped. Object
+// mtimes are truncated to whole seconds because HEAD reports Last-Modified
+// at that precision while listings carry milliseconds.
+type s3Source struct {
+	client *s3.Client
+	root   string
+	bucket string
+	prefix string
+}
+
+func newS3Source(bucket string, prefix string) (Source, error) {
+	client, err := s3.New(s3.ConfigFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	return &s3Source{client: client, root: canonicalS3Root(bucket, prefix), bucket: bucket, prefix: prefix}, nil
+}
+
+func parseS3Root(root string) (string, string, error) {
+	if !s3.IsURL(root) {
+		return "", "", fmt.Errorf("unsupported directory scheme in %q", root)
+	}
+	return s3.ParseURL(root)
+}
+
+func canonicalS3Root(bucket string, prefix string) string {
+	if prefix == "" {
+		return "s3://" + bucket
+	}
+	return "s3://" + bucket + "/" + prefix
+}
+
+func (s *s3Source) Root() string {
+	return s.root
+}
+
+func (s *s3Source) Path(rel string) string {
+	return s.root + "/" + rel
+}
+
+// key maps a path under the root back to its object key.
+func (s *s3Source) key(p string) (string, error) {
+	rel, ok := strings.CutPrefix(p, s.root+"/")
+	if !ok || !fs.ValidPath(rel) {
+		return "", &fs.PathError{Op: "open", Path: p, Err: fs.ErrInvalid}
+	}
+	if s.prefix == "" {
+		return rel, nil
+	}
+	return s.prefix + "/" + rel, nil
+}
+
+func (s *s3Source) Walk(ctx context.Context, fn WalkFunc) error {
+	listPrefix := ""
+	if s.prefix != "" {
+		listPrefix = s.prefix + "/"
+	}
+	return s.client.ListObjects(ctx, s.bucket, listPrefix, func(obj s3.ObjectInfo) error {
+		rel := strings.TrimPrefix(obj.Key, listPrefix)
+		if !fs.ValidPath(rel) || rel == "." {
+			return nil
+		}
+		return fn(rel, objectInfo{name: path.Base(rel), size: obj.Size, mtime: obj.LastModified.Truncate(time.Second)})
+	})
+}
+
+func (s *s3Source) head(ctx context.Context, op string, p string) (s3.ObjectInfo, error) {
+	key, err := s.key(p)
+	if err != nil {
+		return s3.ObjectInfo{}, err
+	}
+	obj, err := s.client.HeadObject(ctx, s.bucket, key)
+	if err != nil {
+		if s3.IsCode(err, "NoSuchKey") {
+			return s3.ObjectInfo{}, &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+		}
+		return s3.ObjectInfo{}, err
+	}
+	return obj, nil
+}
+
+func (s *s3Source) Open(ctx context.Context, p string) (File, error) {
+	obj, err := s.head(ctx, "open", p)
+	if err != nil {
+		return nil, err
+	}
+	return &s3File{
+		ctx:    ctx,
+		client: s.client,
+		bucket: s.bucket,
+		key:    obj.Key,
+		etag:   obj.ETag,
+		info:   objectInfo{name: path.Base(obj.Key), size: obj.Size, mtime: obj.LastModified.Truncate(time.Second)},
+	}, nil
+}
+
+func (s *s3Source) Stat(ctx context.Context, p string) (fs.FileInfo, error) {
+	obj, err := s.head(ctx, "stat", p)
+	if err != nil {
+		return nil, err
+	}
+	return objectInfo{name: path.Base(obj.Key), size: obj.Size, mtime: obj.LastModified.Truncate(time.Second)}, nil
+}
+
+// s3File reads an object with ranged GETs pinned to the ETag seen at open,
+// so a replaced object fails the read instead of mixing versions. It keeps
+// one response body open and reuses it while reads stay sequential, which
+// is how SEND reads a window; a ReadAt that finds the body in use by
+// another goroutine makes its own bounded GET instead of waiting. Reads run
+// under the context given to Open, since io.ReaderAt carries none, so open
+// with the context of the request doing the reads.
+type s3File struct {
+	ctx    context.Context
+	client *s3.Client
+	bucket string
+	key    string
+	etag   string
+	info   objectInfo
+
+	// mu guards the sequential body and its position.
+	mu   sync.Mutex
+	body io.ReadCloser
+	pos  int64
+}
+
+func (f *s3File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= f.info.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !f.mu.TryLock() {
+		return f.readRange(p, off)
+	}
+	defer f.mu.Unlock()
+	if f.body == nil || off != f.pos {
+		f.closeBody()
+		body, err := f.client.GetObject(f.ctx, f.bucket, f.key, off, f.etag)
+		if err != nil {
+			return 0, err
+		}
+		f.body, f.pos = body, off
+	}
+	n, err := io.ReadFull(f.body, p)
+	f.pos += int64(n)
+	if err != nil {
+		f.closeBody()
+		if f.pos >= f.info.size {
+			return n, io.EOF
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// readRange serves one ReadAt with its own GET, leaving the sequential body
+// to the read that holds it.
+func (f *s3File) readRange(p []byte, off int64) (int, error) {
+	length := min(int64(len(p)), f.info.size-off)
+	body, err := f.client.GetObjectRange(f.ctx, f.bucket, f.key, off, length, f.etag)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:length])
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *s3File) closeBody() {
+	if f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *s3File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closeBody()
+	return nil
+}
+
+// objectInfo is the fs.FileInfo of an object: a regular 0644 file with no
+// owner or inode.
+type objectInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+}
+
+func (i objectInfo) Name() string       { return i.name }
+func (i objectInfo) Size() int64        { return i.size }
+func (i objectInfo) Mode() fs.FileMode  { return 0o644 }
+func (i objectInfo) ModTime() time.Time { return i.mtime }
+func (i objectInfo) IsDir() bool        { return false }
+func (i objectInfo) Sys() any           { return nil }
@@ -0,0 +1,153 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jolynch/pinch/internal/filexfer/s3/s3test"
+)
+
+func allowRemoteRootsForTest(t *testing.T, roots ...string) {
+	t.Helper()
+	if err := SetAllowedRemoteRoots(roots); err != nil {
+		t.Fatalf("SetAllowedRemoteRoots: %v", err)
+	}
+	t.Cleanup(func() { _ = SetAllowedRemoteRoots(nil) })
+}
+
+func TestNewChecksRemoteRootAllowlist(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	if _, err := New("s3://bucket/data"); err == nil {
+		t.Fatalf("expected remote roots off by default")
+	}
+	allowRemoteRootsForTest(t, "s3://bucket/data/", "s3://other")
+	for root, allowed := range map[string]bool{
+		"s3://bucket/data":      true,
+		"s3://bucket/data/sub/": true,
+		"s3://bucket/database":  false,
+		"s3://bucket":           false,
+		"s3://other/anything":   true,
+		"s3://another":          false,
+	} {
+		_, err := New(root)
+		if (err == nil) != allowed {
+			t.Fatalf("New(%q): allowed=%t err=%v", root, allowed, err)
+		}
+	}
+	if err := SetAllowedRemoteRoots([]string{"gs://bucket"}); err == nil {
+		t.Fatalf("expected non-s3 allowlist entry rejected")
+	}
+	if IsRemote("/data/s3://x") || !IsRemote("s3://bucket") {
+		t.Fatalf("IsRemote must only match an s3:// prefix")
+	}
+}
+
+func TestS3FileReadAtReusesSequentialGets(t *testing.T) {
+	fake := s3test.New()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	fake.Put("bucket", "root/file", []byte("0123456789"), time.Now())
+	allowRemoteRootsForTest(t, "s3://bucket")
+
+	src, err := New("s3://bucket/root")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := src.Open(ctx, src.Path("missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+	f, err := src.Open(ctx, src.Path("file"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	base := fake.Requests("GetObject")
+
+	buf := make([]byte, 4)
+	for _, off := range []int64{0, 4} {
+		if n, err := f.ReadAt(buf, off); n != 4 || err != nil {
+			t.Fatalf("ReadAt(%d) = %d, %v", off, n, err)
+		}
+	}
+	if n, err := f.ReadAt(buf, 8); n != 2 || err != io.EOF || string(buf[:n]) != "89" {
+		t.Fatalf("ReadAt(8) = %d %q, %v", n, buf[:n], err)
+	}
+	if got := fake.Requests("GetObject") - base; got != 1 {
+		t.Fatalf("expected one GET for sequential reads, got %d", got)
+	}
+	if n, err := f.ReadAt(buf[:2], 3); n != 2 || err != nil || string(buf[:2]) != "34" {
+		t.Fatalf("ReadAt(3) = %d %q, %v", n, buf[:2], err)
+	}
+
+	// A replaced object fails the pinned read rather than mixing versions.
+	fake.Put("bucket", "root/file", []byte("abcdefghij"), time.Now())
+	if _, err := f.ReadAt(buf, 0); err == nil {
+		t.Fatalf("expected reading a replaced object to fail")
+	}
+}
+
+func TestS3FileReadAtIsSafeForConcurrentUse(t *testing.T) {
+	fake := s3test.New()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	data := []byte(strings.Repeat("0123456789abcdef", 64))
+	fake.Put("bucket", "root/file", data, time.Now())
+	allowRemoteRootsForTest(t, "s3://bucket")
+
+	src, err := New("s3://bucket/root")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	f, err := src.Open(context.Background(), src.Path("file"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	// A read that finds the sequential body busy makes its own bounded GET.
+	sf := f.(*s3File)
+	sf.mu.Lock()
+	buf := make([]byte, 8)
+	if n, err := f.ReadAt(buf, int64(len(data)-4)); n != 4 || err != io.EOF || string(buf[:n]) != "cdef" {
+		sf.mu.Unlock()
+		t.Fatalf("busy ReadAt at the tail = %d %q, %v", n, buf[:n], err)
+	}
+	if n, err := f.ReadAt(buf, 16); n != 8 || err != nil || string(buf) != "01234567" {
+		sf.mu.Unlock()
+		t.Fatalf("busy ReadAt(16) = %d %q, %v", n, buf[:n], err)
+	}
+	sf.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 16)
+			for off := int64(i * 16); off < int64(len(data)); off += 128 {
+				n, err := f.ReadAt(buf, off)
+				if err != nil && err != io.EOF {
+					t.Errorf("ReadAt(%d): %v", off, err)
+					return
+				}
+				if string(buf[:n]) != string(data[off:off+int64(n)]) || n != 16 {
+					t.Errorf("ReadAt(%d) = %q, want %q", off, buf[:n], data[off:off+16])
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,107 @@
+// Package source abstracts where a transfer root's files live: a local
+// directory or an object-store prefix. TXFER lists a root through Walk and
+// SEND reads its files through Open; everything after the bytes are read
+// (framing, compression, hashing) does not care which one it is.
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// File is an open file of a root. Local roots return *os.File, which SEND
+// also uses for splice, fadvise, direct I/O and sparse detection.
+type File interface {
+	io.ReaderAt
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// WalkFunc is called for every regular file under a root with its path
+// relative to the root, in slash form.
+type WalkFunc func(rel string, info fs.FileInfo) error
+
+type Source interface {
+	// Root is the canonical root, as recorded in the transfer and manifest.
+	Root() string
+	// Path joins a slash-separated relative path onto the root.
+	Path(rel string) string
+	// Walk visits the root's files in lexical order.
+	Walk(ctx context.Context, fn WalkFunc) error
+	// Open opens the file at a path returned by Path.
+	Open(ctx context.Context, path string) (File, error)
+	// Stat describes the file currently at path, which may differ from an
+	// already open File if it was replaced.
+	Stat(ctx context.Context, path string) (fs.FileInfo, error)
+}
+
+// IsRemote reports whether root names an object-store location rather than
+// a local path.
+func IsRemote(root string) bool {
+	return strings.HasPrefix(root, "s3://")
+}
+
+var (
+	allowedRemoteMu    sync.RWMutex
+	allowedRemoteRoots []string
+)
+
+// SetAllowedRemoteRoots sets the object-store roots New accepts, as
+// s3://bucket or s3://bucket/prefix URLs; a root is allowed when it is one of
+// them or lies beneath one. The server reads object-store roots with its own
+// credentials, so none are allowed until this is called.
+func SetAllowedRemoteRoots(roots []string) error {
+	canonical := make([]string, 0, len(roots))
+	for _, root := range roots {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		if !IsRemote(root) {
+			return fmt.Errorf("unsupported remote root %q (want s3://bucket[/prefix])", root)
+		}
+		bucket, prefix, err := parseS3Root(root)
+		if err != nil {
+			return err
+		}
+		canonical = append(canonical, canonicalS3Root(bucket, prefix))
+	}
+	allowedRemoteMu.Lock()
+	allowedRemoteRoots = canonical
+	allowedRemoteMu.Unlock()
+	return nil
+}
+
+// remoteRootAllowed reports whether the canonical root is, or is beneath, an
+// allowed remote root.
+func remoteRootAllowed(root string) bool {
+	allowedRemoteMu.RLock()
+	defer allowedRemoteMu.RUnlock()
+	for _, allowed := range allowedRemoteRoots {
+		if root == allowed || strings.HasPrefix(root, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns the source for root, checking that it is usable. Errors are
+// suitable to show the client.
+func New(root string) (Source, error) {
+	if IsRemote(root) {
+		bucket, prefix, err := parseS3Root(root)
+		if err != nil {
+			return nil, err
+		}
+		canonical := canonicalS3Root(bucket, prefix)
+		if !remoteRootAllowed(canonical) {
+			return nil, fmt.Errorf("object-store root %s is not allowed on this server", canonical)
+		}
+		return newS3Source(bucket, prefix)
+	}
+	return newLocalSource(root)
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrTransferCancelled is what TransferGate.Wait returns once CANCEL has
+// been applied to the transfer.
+var ErrTransferCancelled = errors.New("transfer cancelled")
+
+// TransferGate lets in-flight SENDs observe CANCEL, PAUSE and RESUME. A
+// SEND waits on it between frames; the gate outlives the transfer's store
+// entry, so a stream that started before CANCEL still sees it.
+type TransferGate struct {
+	mu        sync.Mutex
+	cancelled chan struct{}
+	// resumed is non-nil while paused and is closed by resume.
+	resumed chan struct{}
+}
+
+func newTransferGate() *TransferGate {
+	return &TransferGate{cancelled: make(chan struct{})}
+}
+
+func (g *TransferGate) cancel() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.cancelled:
+	default:
+		close(g.cancelled)
+	}
+}
+
+func (g *TransferGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *TransferGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+// Wait returns nil at once unless the transfer is paused, in which case it
+// blocks until RESUME. It returns ErrTransferCancelled after CANCEL, or the
+// context's error. A nil gate never blocks.
+func (g *TransferGate) Wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	for {
+		g.mu.Lock()
+		resumed := g.resumed
+		g.mu.Unlock()
+		select {
+		case <-g.cancelled:
+			return ErrTransferCancelled
+		default:
+		}
+		if resumed == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-g.cancelled:
+			return ErrTransferCancelled
+		case <-resumed:
+		}
+	}
+}
@@ -4,21 +4,30 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	intencoding "github.com/jolynch/pinch/internal/filexfer/encoding"
 	"github.com/jolynch/pinch/internal/filexfer/policy"
+	"github.com/jolynch/pinch/internal/filexfer/source"
 	"github.com/zeebo/xxh3"
 )
 
 const (
 	defaultTransferTTL = 10 * time.Minute
+	// maxTransferTTL caps the lease a TXFER may ask for, unless the server
+	// default is longer.
+	maxTransferTTL = 24 * time.Hour
+	// maxReapInterval bounds the wait between sweeps, so expired hash state
+	// is dropped even while no transfer is close to its expiry.
+	maxReapInterval = 30 * time.Second
 	// State enum for transfer lifecycle.
 	TransferStateStarted uint8 = iota
 	TransferStateRunning
@@ -26,6 +35,13 @@ const (
 	TransferStateMissing
 )
 
+// Link hint sources: the TXFER probe result or a client's passive estimate
+// carried on SEND.
+const (
+	LinkSourceProbe  = "probe"
+	LinkSourceClient = "client"
+)
+
 type CompressionMode = policy.CompressionMode
 
 const (
@@ -40,6 +56,11 @@ type Transfer struct {
 	Directory string
 	Mode      string
 	LinkMbps  int64
+	// LinkRTTMS, LinkSource ("probe" or "client") and LinkUpdatedAt
+	// describe where the current LinkMbps hint came from.
+	LinkRTTMS     int64
+	LinkSource    string
+	LinkUpdatedAt time.Time
 	Concurrency int
 	NumFiles  int
 	TotalSize int64
@@ -48,9 +69,25 @@ type Transfer struct {
 	State     []uint8
 	PathHash  []xxh3.Uint128
 	FileSize  []int64
+	FileMtime []int64
+	FileInode []uint64
 	AckedSize []int64
 	CreatedAt time.Time
+	// ExpiresAt is pushed out by TTL on every SEND frame, ACK, RENEW,
+	// RESUME and WATCH heartbeat; the transfer is reaped once it passes.
 	ExpiresAt time.Time
+	TTL       time.Duration
+	// Paused is set by PAUSE and cleared by RESUME; SENDs wait between
+	// frames while it is set, and the transfer does not expire.
+	Paused bool
+	// Source reads the transfer root. TXFER builds it once so SENDs on
+	// object-store roots reuse one client; nil means build on demand.
+	Source source.Source
+
+	// pathIndex maps each recorded PathHash to its file id so WATCH can
+	// look up an existing path without scanning every entry. It is only
+	// touched under the store lock and never leaves it.
+	pathIndex map[xxh3.Uint128]uint64
 }
 
 type TransferFileState struct {
@@ -62,6 +99,8 @@ type TransferFileStateUpdate struct {
 	FileID   uint64
 	PathHash xxh3.Uint128
 	FileSize int64
+	MtimeNS  int64
+	Inode    uint64
 }
 
 type FileRef struct {
@@ -70,6 +109,11 @@ type FileRef struct {
 	Path       string
 	Directory  string
 	FileSize   int64
+	// MtimeNS and Inode are what TXFER observed; zero means unrecorded.
+	MtimeNS int64
+	Inode   uint64
+	// Source is the transfer's cached root source, if TXFER set one.
+	Source source.Source
 }
 
 type FileLookupError struct {
@@ -89,6 +133,10 @@ type transferStore struct {
 	transfers    map[string]Transfer
 	fileHashes   map[fileHashKey]fileHashState
 	windowHashes map[windowHashKey]*windowHashState
+	gates        map[string]*TransferGate
+	// wake tells reapExpiredLoop that a lease may now end sooner than its
+	// next sweep.
+	wake chan struct{}
 }
 
 type fileHashKey struct {
@@ -126,19 +174,43 @@ type AckEntry struct {
 }
 
 var (
-	ttl     = defaultTransferTTL
+	// ttl is the lease, in nanoseconds, of transfers that do not ask for
+	// their own; see SetDefaultTransferTTL.
+	ttl     atomic.Int64
 	manager = newTransferStore()
 )
 
 func init() {
+	ttl.Store(int64(defaultTransferTTL))
 	go manager.reapExpiredLoop()
 }
 
+// SetDefaultTransferTTL sets the lease of transfers created without a TTL
+// of their own; d <= 0 restores the 10 minute default.
+func SetDefaultTransferTTL(d time.Duration) {
+	if d <= 0 {
+		d = defaultTransferTTL
+	}
+	ttl.Store(int64(d))
+}
+
+func DefaultTransferTTL() time.Duration {
+	return time.Duration(ttl.Load())
+}
+
+// capTransferTTL bounds a requested lease by maxTransferTTL, or by the
+// server default when that is longer.
+func capTransferTTL(d time.Duration) time.Duration {
+	return min(d, max(maxTransferTTL, DefaultTransferTTL()))
+}
+
 func newTransferStore() *transferStore {
 	return &transferStore{
 		transfers:    make(map[string]Transfer),
 		fileHashes:   make(map[fileHashKey]fileHashState),
 		windowHashes: make(map[windowHashKey]*windowHashState),
+		gates:        make(map[string]*TransferGate),
+		wake:         make(chan struct{}, 1),
 	}
 }
 
@@ -153,6 +225,8 @@ func (s *transferStore) create(transfer Transfer) bool {
 		return false
 	}
 	s.transfers[transfer.ID] = transfer
+	s.gates[transfer.ID] = newTransferGate()
+	s.wakeReaper()
 	return true
 }
 
@@ -183,17 +257,70 @@ func (s *transferStore) setTransferHints(txferID string, mode string, linkMbps i
 	transfer.Mode = strings.ToLower(strings.TrimSpace(mode))
 	transfer.LinkMbps = linkMbps
 	transfer.Concurrency = concurrency
+	if linkMbps > 0 {
+		transfer.LinkSource = LinkSourceProbe
+		transfer.LinkUpdatedAt = time.Now()
+	}
 	s.transfers[txferID] = transfer
 	return true
 }
 
+// updateTransferLink records a client link estimate when linkMbps > 0 and
+// returns the transfer's current link hint.
+func (s *transferStore) updateTransferLink(txferID string, linkMbps int64, rttMS int64) (int64, bool) {
+	if linkMbps <= 0 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		transfer, ok := s.transfers[txferID]
+		return transfer.LinkMbps, ok
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transfer, ok := s.transfers[txferID]
+	if !ok {
+		return 0, false
+	}
+	transfer.LinkMbps = linkMbps
+	if rttMS > 0 {
+		transfer.LinkRTTMS = rttMS
+	}
+	transfer.LinkSource = LinkSourceClient
+	transfer.LinkUpdatedAt = time.Now()
+	s.transfers[txferID] = transfer
+	return linkMbps, true
+}
+
 func (s *transferStore) appendFileStates(txferID string, updates []TransferFileStateUpdate, state uint8) {
 	if len(updates) == 0 {
 		return
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.appendFileStatesLocked(txferID, updates, state)
+}
+
+// appendFile registers one file found after TXFER (WATCH) under the next
+// free file id, allocated under the store lock so concurrent WATCH streams
+// never hand out the same id. A path that is already registered keeps its
+// id and is not re-recorded; added reports whether a new id was allocated.
+func (s *transferStore) appendFile(txferID string, update TransferFileStateUpdate) (uint64, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	transfer, ok := s.transfers[txferID]
+	if !ok {
+		return 0, false, false
+	}
+	if idx, ok := transfer.pathIndex[update.PathHash]; ok {
+		return idx, false, true
+	}
+	update.FileID = uint64(transfer.NumFiles)
+	s.appendFileStatesLocked(txferID, []TransferFileStateUpdate{update}, TransferStateStarted)
+	return update.FileID, true, true
+}
+
+func (s *transferStore) appendFileStatesLocked(txferID string, updates []TransferFileStateUpdate, state uint8) {
 	transfer, ok := s.transfers[txferID]
 	if !ok {
 		return
@@ -208,6 +335,8 @@ func (s *transferStore) appendFileStates(txferID string, updates []TransferFileS
 		transfer.State = append(transfer.State, make([]uint8, growBy)...)
 		transfer.PathHash = append(transfer.PathHash, make([]xxh3.Uint128, growBy)...)
 		transfer.FileSize = append(transfer.FileSize, make([]int64, growBy)...)
+		transfer.FileMtime = append(transfer.FileMtime, make([]int64, growBy)...)
+		transfer.FileInode = append(transfer.FileInode, make([]uint64, growBy)...)
 		transfer.AckedSize = append(transfer.AckedSize, make([]int64, growBy)...)
 		for i := oldLen; i < n; i++ {
 			transfer.State[i] = TransferStateStarted
@@ -222,9 +351,24 @@ func (s *transferStore) appendFileStates(txferID string, updates []TransferFileS
 			transfer.NumFiles = idx + 1
 		}
 
+		if transfer.State[idx] == TransferStateDone && update.FileSize > transfer.AckedSize[idx] {
+			// The file grew after it was fully acked (WATCH): it is
+			// pending again until the new tail is acked.
+			transfer.State[idx] = TransferStateRunning
+			transfer.Done--
+		}
 		transfer.TotalSize += update.FileSize - transfer.FileSize[idx]
 		transfer.FileSize[idx] = update.FileSize
+		transfer.FileMtime[idx] = update.MtimeNS
+		transfer.FileInode[idx] = update.Inode
+		if old := transfer.PathHash[idx]; old != update.PathHash && transfer.pathIndex[old] == uint64(idx) {
+			delete(transfer.pathIndex, old)
+		}
 		transfer.PathHash[idx] = update.PathHash
+		if transfer.pathIndex == nil {
+			transfer.pathIndex = make(map[xxh3.Uint128]uint64)
+		}
+		transfer.pathIndex[update.PathHash] = uint64(idx)
 		if shouldAdvanceState(transfer.State[idx], state) {
 			transfer.State[idx] = state
 		}
@@ -253,13 +397,13 @@ func (s *transferStore) appendFileHashChunk(txferID string, fileID uint64, offse
 		}
 	}
 	if !state.valid || state.finalized {
-		state.expiresAt = time.Now().Add(ttl)
+		state.expiresAt = time.Now().Add(s.leaseLocked(txferID))
 		s.fileHashes[key] = state
 		return true
 	}
 	if offset != state.hashedSize {
 		state.valid = false
-		state.expiresAt = time.Now().Add(ttl)
+		state.expiresAt = time.Now().Add(s.leaseLocked(txferID))
 		s.fileHashes[key] = state
 		return true
 	}
@@ -267,14 +411,14 @@ func (s *transferStore) appendFileHashChunk(txferID string, fileID uint64, offse
 	if len(chunk) > 0 {
 		if _, err := state.hasher.Write(chunk); err != nil {
 			state.valid = false
-			state.expiresAt = time.Now().Add(ttl)
+			state.expiresAt = time.Now().Add(s.leaseLocked(txferID))
 			s.fileHashes[key] = state
 			return true
 		}
 	}
 	state.hashedSize += int64(len(chunk))
 	state.hashToken = ""
-	state.expiresAt = time.Now().Add(ttl)
+	state.expiresAt = time.Now().Add(s.leaseLocked(txferID))
 	s.fileHashes[key] = state
 	return true
 }
@@ -291,7 +435,7 @@ func (s *transferStore) finalizeFileHash(txferID string, fileID uint64) (string,
 	state.hashToken = formatXXH128HashToken(state.hasher.Sum128())
 	state.finalized = true
 	state.hasher = nil
-	state.expiresAt = time.Now().Add(ttl)
+	state.expiresAt = time.Now().Add(s.leaseLocked(txferID))
 	s.fileHashes[key] = state
 	return state.hashToken, true
 }
@@ -323,7 +467,7 @@ func (s *transferStore) setFileCompressionMode(txferID string, fileID uint64, mo
 	}
 	state.latestComp = uint8(mode)
 	state.hasLatestComp = true
-	state.expiresAt = time.Now().Add(ttl)
+	state.expiresAt = time.Now().Add(s.leaseLocked(txferID))
 	s.fileHashes[key] = state
 	return true
 }
@@ -352,10 +496,98 @@ func (s *transferStore) verifyFileHashToken(txferID string, fileID uint64, expec
 func (s *transferStore) delete(txferID string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.deleteLocked(txferID)
+}
+
+// cancel stops the transfer's in-flight SENDs and forgets it.
+func (s *transferStore) cancel(txferID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if gate, ok := s.gates[txferID]; ok {
+		gate.cancel()
+	}
+	return s.deleteLocked(txferID)
+}
+
+func (s *transferStore) setPaused(txferID string, paused bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transfer, ok := s.transfers[txferID]
+	if !ok {
+		return false
+	}
+	if gate, ok := s.gates[txferID]; ok {
+		if paused {
+			gate.pause()
+		} else {
+			gate.resume()
+		}
+	}
+	// A paused transfer does not expire, so RESUME starts a fresh lease
+	// rather than waking to one that ran out during the pause.
+	if transfer.Paused && !paused {
+		transfer.ExpiresAt = time.Now().Add(transfer.lease())
+		s.wakeReaper()
+	}
+	transfer.Paused = paused
+	s.transfers[txferID] = transfer
+	return true
+}
+
+func (s *transferStore) setSource(txferID string, src source.Source) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transfer, ok := s.transfers[txferID]
+	if !ok {
+		return false
+	}
+	transfer.Source = src
+	s.transfers[txferID] = transfer
+	return true
+}
+
+// renew pushes the transfer's expiry out by its lease, first replacing the
+// lease with newTTL when that is > 0, and returns the new expiry.
+func (s *transferStore) renew(txferID string, newTTL time.Duration) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	transfer, ok := s.transfers[txferID]
+	if !ok {
+		return time.Time{}, false
+	}
+	if newTTL > 0 {
+		transfer.TTL = capTransferTTL(newTTL)
+		s.wakeReaper()
+	}
+	transfer.ExpiresAt = time.Now().Add(transfer.lease())
+	s.transfers[txferID] = transfer
+	return transfer.ExpiresAt, true
+}
+
+// leaseLocked is how long the transfer's hash state lives without use.
+func (s *transferStore) leaseLocked(txferID string) time.Duration {
+	return s.transfers[txferID].lease()
+}
+
+func (t Transfer) lease() time.Duration {
+	if t.TTL > 0 {
+		return t.TTL
+	}
+	return DefaultTransferTTL()
+}
+
+func (s *transferStore) gate(txferID string) *TransferGate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gates[txferID]
+}
+
+func (s *transferStore) deleteLocked(txferID string) bool {
 	if _, ok := s.transfers[txferID]; !ok {
 		return false
 	}
 	delete(s.transfers, txferID)
+	delete(s.gates, txferID)
 	for key := range s.fileHashes {
 		if key.txferID == txferID {
 			delete(s.fileHashes, key)
@@ -377,9 +609,12 @@ func (s *transferStore) get(txferID string) (Transfer, bool) {
 		return Transfer{}, false
 	}
 	out := transfer
+	out.pathIndex = nil
 	out.State = append([]uint8(nil), transfer.State...)
 	out.PathHash = append([]xxh3.Uint128(nil), transfer.PathHash...)
 	out.FileSize = append([]int64(nil), transfer.FileSize...)
+	out.FileMtime = append([]int64(nil), transfer.FileMtime...)
+	out.FileInode = append([]uint64(nil), transfer.FileInode...)
 	out.AckedSize = append([]int64(nil), transfer.AckedSize...)
 	return out, true
 }
@@ -402,9 +637,14 @@ func (s *transferStore) getFileStates(txferID string) ([]TransferFileState, bool
 }
 
 func (s *transferStore) resolveFileRef(txferID string, fileID uint64, fullPathRaw string) (FileRef, error) {
-	fullPath := filepath.Clean(fullPathRaw)
-	if !filepath.IsAbs(fullPath) {
-		return FileRef{}, &FileLookupError{Code: http.StatusBadRequest, Msg: "path must be absolute"}
+	// Object-store paths are compared verbatim: cleaning would fold the
+	// scheme's "//", and the digest check pins them to manifest entries.
+	fullPath := fullPathRaw
+	if !source.IsRemote(fullPath) {
+		fullPath = filepath.Clean(fullPathRaw)
+		if !filepath.IsAbs(fullPath) {
+			return FileRef{}, &FileLookupError{Code: http.StatusBadRequest, Msg: "path must be absolute"}
+		}
 	}
 
 	s.mu.RLock()
@@ -420,6 +660,9 @@ func (s *transferStore) resolveFileRef(txferID string, fileID uint64, fullPathRa
 	directory := transfer.Directory
 	expectedDigest := transfer.PathHash[fileID]
 	fileSize := transfer.FileSize[fileID]
+	mtimeNS := transfer.FileMtime[fileID]
+	inode := transfer.FileInode[fileID]
+	src := transfer.Source
 	s.mu.RUnlock()
 
 	if !pathWithinRoot(directory, fullPath) {
@@ -437,6 +680,9 @@ func (s *transferStore) resolveFileRef(txferID string, fileID uint64, fullPathRa
 		Path:       fullPath,
 		Directory:  directory,
 		FileSize:   fileSize,
+		MtimeNS:    mtimeNS,
+		Inode:      inode,
+		Source:     src,
 	}, nil
 }
 
@@ -445,6 +691,7 @@ func (s *transferStore) resetForTest() {
 	s.transfers = make(map[string]Transfer)
 	s.fileHashes = make(map[fileHashKey]fileHashState)
 	s.windowHashes = make(map[windowHashKey]*windowHashState)
+	s.gates = make(map[string]*TransferGate)
 	s.mu.Unlock()
 }
 
@@ -460,9 +707,12 @@ func (s *transferStore) listForTest() []Transfer {
 	out := make([]Transfer, 0, len(s.transfers))
 	for _, transfer := range s.transfers {
 		copyTransfer := transfer
+		copyTransfer.pathIndex = nil
 		copyTransfer.State = append([]uint8(nil), transfer.State...)
 		copyTransfer.PathHash = append([]xxh3.Uint128(nil), transfer.PathHash...)
 		copyTransfer.FileSize = append([]int64(nil), transfer.FileSize...)
+		copyTransfer.FileMtime = append([]int64(nil), transfer.FileMtime...)
+		copyTransfer.FileInode = append([]uint64(nil), transfer.FileInode...)
 		copyTransfer.AckedSize = append([]int64(nil), transfer.AckedSize...)
 		out = append(out, copyTransfer)
 	}
@@ -500,6 +750,31 @@ func (s *transferStore) acknowledgeFiles(entries []AckEntry) bool {
 	return ok
 }
 
+// refreshFile re-records a file that changed after TXFER and forgets its
+// acknowledged progress, since those bytes described the old content.
+func (s *transferStore) refreshFile(txferID string, update TransferFileStateUpdate) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transfer, ok := s.transfers[txferID]
+	if !ok || update.FileID >= uint64(len(transfer.State)) {
+		return false
+	}
+	idx := int(update.FileID)
+	if transfer.State[idx] == TransferStateDone || transfer.State[idx] == TransferStateMissing {
+		transfer.Done--
+	}
+	transfer.DoneSize -= transfer.AckedSize[idx]
+	transfer.AckedSize[idx] = 0
+	transfer.TotalSize += update.FileSize - transfer.FileSize[idx]
+	transfer.FileSize[idx] = update.FileSize
+	transfer.FileMtime[idx] = update.MtimeNS
+	transfer.FileInode[idx] = update.Inode
+	transfer.State[idx] = TransferStateRunning
+	s.transfers[txferID] = transfer
+	return true
+}
+
 func (s *transferStore) acknowledgeFileLocked(txferID string, fileID uint64, ackBytes int64) bool {
 	transfer, ok := s.transfers[txferID]
 	if !ok {
@@ -576,46 +851,94 @@ func (s *transferStore) clipTransfer(txferID string) bool {
 	transfer.State = slices.Clip(transfer.State)
 	transfer.PathHash = slices.Clip(transfer.PathHash)
 	transfer.FileSize = slices.Clip(transfer.FileSize)
+	transfer.FileMtime = slices.Clip(transfer.FileMtime)
+	transfer.FileInode = slices.Clip(transfer.FileInode)
 	transfer.AckedSize = slices.Clip(transfer.AckedSize)
 	s.transfers[txferID] = transfer
 	return true
 }
 
+// wakeReaper makes reapExpiredLoop recompute its next sweep, for a lease
+// that may end before the one it is waiting on.
+func (s *transferStore) wakeReaper() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// reapExpiredLoop sweeps when the soonest lease ends, and at least every
+// maxReapInterval, so a short per-transfer TTL is enforced on time.
 func (s *transferStore) reapExpiredLoop() {
-	interval := ttl / 2
-	if interval < 30*time.Second {
-		interval = 30 * time.Second
-	}
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	for now := range ticker.C {
-		s.mu.Lock()
-		for txferID, transfer := range s.transfers {
-			if !transfer.ExpiresAt.After(now) {
-				delete(s.transfers, txferID)
-			}
+	timer := time.NewTimer(s.nextReapWait(time.Now()))
+	for {
+		select {
+		case <-timer.C:
+			s.reapExpired(time.Now())
+		case <-s.wake:
+			timer.Stop()
 		}
-		for key, state := range s.fileHashes {
-			if !state.expiresAt.After(now) {
-				delete(s.fileHashes, key)
-				continue
-			}
-			if _, ok := s.transfers[key.txferID]; !ok {
-				delete(s.fileHashes, key)
-			}
+		timer.Reset(s.nextReapWait(time.Now()))
+	}
+}
+
+// nextReapWait is how long until the soonest running transfer expires,
+// capped at maxReapInterval.
+func (s *transferStore) nextReapWait(now time.Time) time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	wait := maxReapInterval
+	for _, transfer := range s.transfers {
+		if !transfer.Paused {
+			wait = min(wait, transfer.ExpiresAt.Sub(now))
 		}
-		for key, ws := range s.windowHashes {
-			if !ws.expiresAt.After(now) {
-				delete(s.windowHashes, key)
-				continue
-			}
-			if _, ok := s.transfers[key.txferID]; !ok {
-				delete(s.windowHashes, key)
+	}
+	return max(wait, 0)
+}
+
+func (s *transferStore) reapExpired(now time.Time) []Transfer {
+	var expired []Transfer
+	s.mu.Lock()
+	for txferID, transfer := range s.transfers {
+		if !transfer.Paused && !transfer.ExpiresAt.After(now) {
+			delete(s.transfers, txferID)
+			// A reaped transfer ends its SENDs like CANCEL, so none stays
+			// parked on a pause nobody can resume.
+			if gate, ok := s.gates[txferID]; ok {
+				gate.cancel()
+				delete(s.gates, txferID)
 			}
+			expired = append(expired, transfer)
+		}
+	}
+	// Hash state of a paused transfer is kept with it, so a window can
+	// still finish after RESUME.
+	for key, state := range s.fileHashes {
+		transfer, ok := s.transfers[key.txferID]
+		if !ok || (!transfer.Paused && !state.expiresAt.After(now)) {
+			delete(s.fileHashes, key)
 		}
-		s.mu.Unlock()
 	}
+	for key, ws := range s.windowHashes {
+		transfer, ok := s.transfers[key.txferID]
+		if !ok || (!transfer.Paused && !ws.expiresAt.After(now)) {
+			delete(s.windowHashes, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, transfer := range expired {
+		slog.Info(
+			"transfer.expired",
+			"txfer", transfer.ID,
+			"directory", transfer.Directory,
+			"files", transfer.NumFiles,
+			"done", transfer.Done,
+			"created_at", transfer.CreatedAt,
+			"expires_at", transfer.ExpiresAt,
+		)
+	}
+	return expired
 }
 
 func validHashToken(raw string) bool {
@@ -648,7 +971,7 @@ func (s *transferStore) setWindowHashToken(txferID string, fileID uint64, endByt
 	key := windowHashKey{txferID: txferID, fileID: fileID, endBytes: endBytes}
 	ws := &windowHashState{
 		hashToken: normalizeHashToken(token),
-		expiresAt: time.Now().Add(ttl),
+		expiresAt: time.Now().Add(s.leaseLocked(txferID)),
 	}
 	s.windowHashes[key] = ws
 	return true
@@ -676,6 +999,7 @@ func NewTransfer(directory string, numFiles int, totalSize int64) (Transfer, err
 			return Transfer{}, err
 		}
 		now := time.Now()
+		lease := DefaultTransferTTL()
 		transfer := Transfer{
 			ID:        txferID,
 			Directory: directory,
@@ -689,9 +1013,12 @@ func NewTransfer(directory string, numFiles int, totalSize int64) (Transfer, err
 			State:     make([]uint8, numFiles),
 			PathHash:  make([]xxh3.Uint128, numFiles),
 			FileSize:  make([]int64, numFiles),
+			FileMtime: make([]int64, numFiles),
+			FileInode: make([]uint64, numFiles),
 			AckedSize: make([]int64, numFiles),
 			CreatedAt: now,
-			ExpiresAt: now.Add(ttl),
+			ExpiresAt: now.Add(lease),
+			TTL:       lease,
 		}
 		for i := range transfer.State {
 			transfer.State[i] = TransferStateStarted
@@ -741,6 +1068,13 @@ func RegisterTransferFileStates(txferID string, updates []TransferFileStateUpdat
 	manager.appendFileStates(txferID, updates, state)
 }
 
+// AppendTransferFile registers a file under the transfer's next free file id
+// (ignoring update.FileID) and returns the id, or the existing id when the
+// path is already registered. added is false in that case.
+func AppendTransferFile(txferID string, update TransferFileStateUpdate) (fileID uint64, added bool, ok bool) {
+	return manager.appendFile(txferID, update)
+}
+
 func DeleteTransfer(txferID string) bool {
 	return manager.delete(txferID)
 }
@@ -749,10 +1083,45 @@ func GetTransfer(txferID string) (Transfer, bool) {
 	return manager.get(txferID)
 }
 
+// CancelTransfer ends the transfer's in-flight SENDs with
+// ErrTransferCancelled and releases its state.
+func CancelTransfer(txferID string) bool {
+	return manager.cancel(txferID)
+}
+
+func PauseTransfer(txferID string) bool {
+	return manager.setPaused(txferID, true)
+}
+
+func ResumeTransfer(txferID string) bool {
+	return manager.setPaused(txferID, false)
+}
+
+// SetTransferSource caches the source TXFER opened the transfer's root with.
+func SetTransferSource(txferID string, src source.Source) bool {
+	return manager.setSource(txferID, src)
+}
+
+// RenewTransfer extends the transfer's lease from now, first setting the
+// lease to newTTL (capped) when newTTL > 0, and returns the new expiry.
+func RenewTransfer(txferID string, newTTL time.Duration) (time.Time, bool) {
+	return manager.renew(txferID, newTTL)
+}
+
+// GetTransferGate returns the gate SENDs wait on between frames, or nil
+// when the transfer is unknown.
+func GetTransferGate(txferID string) *TransferGate {
+	return manager.gate(txferID)
+}
+
 func SetTransferHints(txferID string, mode string, linkMbps int64, concurrency int) bool {
 	return manager.setTransferHints(txferID, mode, linkMbps, concurrency)
 }
 
+func UpdateTransferLink(txferID string, linkMbps int64, rttMS int64) (int64, bool) {
+	return manager.updateTransferLink(txferID, linkMbps, rttMS)
+}
+
 func GetFileRef(txferID string, fileID uint64, fullPathRaw string) (FileRef, error) {
 	return manager.resolveFileRef(txferID, fileID, fullPathRaw)
 }
@@ -762,6 +1131,9 @@ func GetFile(txferID string, fileID uint64, fullPathRaw string) (*os.File, FileR
 	if err != nil {
 		return nil, FileRef{}, err
 	}
+	if source.IsRemote(ref.Path) {
+		return nil, FileRef{}, &FileLookupError{Code: http.StatusUnprocessableEntity, Msg: "object-store files can only be read with SEND"}
+	}
 	fd, err := os.Open(ref.Path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -780,6 +1152,10 @@ func AcknowledgeTransferFile(txferID string, fileID uint64, ackBytes int64) bool
 	return manager.acknowledgeFiles([]AckEntry{{TxferID: txferID, FileID: fileID, AckBytes: ackBytes}})
 }
 
+func RefreshTransferFile(txferID string, update TransferFileStateUpdate) bool {
+	return manager.refreshFile(txferID, update)
+}
+
 func AcknowledgeTransferFiles(entries []AckEntry) bool {
 	return manager.acknowledgeFiles(entries)
 }
@@ -857,6 +1233,9 @@ func formatXXH128HashToken(v xxh3.Uint128) string {
 }
 
 func pathWithinRoot(root string, p string) bool {
+	if source.IsRemote(root) {
+		return strings.HasPrefix(p, root+"/")
+	}
 	root = filepath.Clean(root)
 	p = filepath.Clean(p)
 	rel, err := filepath.Rel(root, p)
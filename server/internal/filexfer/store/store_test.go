@@ -1,10 +1,13 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -133,6 +136,83 @@ func TestRegisterTransferFileStateMultipleIDs(t *testing.T) {
 	}
 }
 
+func TestAppendTransferFileAllocatesUniqueIDs(t *testing.T) {
+	resetTransferStore()
+
+	transfer, err := NewTransfer("/tmp/x", 1, 0)
+	if err != nil {
+		t.Fatalf("NewTransfer returned error: %v", err)
+	}
+	RegisterTransferFileStates(transfer.ID, []TransferFileStateUpdate{{FileID: 0, PathHash: xxh3.Hash128([]byte("/tmp/x/0")), FileSize: 1}}, TransferStateStarted)
+
+	const writers = 8
+	ids := make([]uint64, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, added, ok := AppendTransferFile(transfer.ID, TransferFileStateUpdate{
+				FileID:   99,
+				PathHash: xxh3.Hash128([]byte("/tmp/x/new" + strconv.Itoa(i))),
+				FileSize: int64(i),
+			})
+			if !ok || !added {
+				t.Errorf("append %d: added=%t ok=%t", i, added, ok)
+			}
+			ids[i] = id
+		}()
+	}
+	wg.Wait()
+	seen := make(map[uint64]bool, writers)
+	for i, id := range ids {
+		if id == 0 || id > writers || seen[id] {
+			t.Fatalf("append %d got id %d; all ids: %v", i, id, ids)
+		}
+		seen[id] = true
+	}
+
+	id, added, ok := AppendTransferFile(transfer.ID, TransferFileStateUpdate{PathHash: xxh3.Hash128([]byte("/tmp/x/new3")), FileSize: 10})
+	if !ok || added || id != ids[3] {
+		t.Fatalf("expected existing id %d for a registered path, got id=%d added=%t ok=%t", ids[3], id, added, ok)
+	}
+	stored, _ := GetTransfer(transfer.ID)
+	if stored.NumFiles != writers+1 || stored.FileSize[ids[3]] != 3 {
+		t.Fatalf("unexpected store after appends: files=%d sizes=%v", stored.NumFiles, stored.FileSize)
+	}
+	if _, _, ok := AppendTransferFile("missing", TransferFileStateUpdate{}); ok {
+		t.Fatalf("expected append to a missing transfer to fail")
+	}
+}
+
+func TestAppendTransferFileLooksUpPathsByHash(t *testing.T) {
+	resetTransferStore()
+
+	transfer, err := NewTransfer("/tmp/x", 2, 0)
+	if err != nil {
+		t.Fatalf("NewTransfer returned error: %v", err)
+	}
+	oldHash := xxh3.Hash128([]byte("/tmp/x/a"))
+	newHash := xxh3.Hash128([]byte("/tmp/x/b"))
+	RegisterTransferFileStates(transfer.ID, []TransferFileStateUpdate{
+		{FileID: 0, PathHash: xxh3.Hash128([]byte("/tmp/x/0"))},
+		{FileID: 1, PathHash: oldHash},
+	}, TransferStateStarted)
+
+	if id, added, ok := AppendTransferFile(transfer.ID, TransferFileStateUpdate{PathHash: oldHash}); !ok || added || id != 1 {
+		t.Fatalf("expected the TXFER path at id 1, got id=%d added=%t ok=%t", id, added, ok)
+	}
+
+	// Re-recording id 1 under another path drops the old path from the index.
+	RegisterTransferFileStates(transfer.ID, []TransferFileStateUpdate{{FileID: 1, PathHash: newHash}}, TransferStateStarted)
+	if id, added, ok := AppendTransferFile(transfer.ID, TransferFileStateUpdate{PathHash: newHash}); !ok || added || id != 1 {
+		t.Fatalf("expected the re-recorded path at id 1, got id=%d added=%t ok=%t", id, added, ok)
+	}
+	if id, added, ok := AppendTransferFile(transfer.ID, TransferFileStateUpdate{PathHash: oldHash}); !ok || !added || id != 2 {
+		t.Fatalf("expected the replaced path to get a new id 2, got id=%d added=%t ok=%t", id, added, ok)
+	}
+}
+
 func TestSetTransferStateDoesNotRegressDoneToStarted(t *testing.T) {
 	resetTransferStore()
 
@@ -539,3 +619,199 @@ func TestGetFileSuccess(t *testing.T) {
 		t.Fatalf("unexpected ref size: %d", ref.FileSize)
 	}
 }
+
+func TestReapExpiredRemovesOnlyExpiredTransfers(t *testing.T) {
+	resetTransferStore()
+
+	transfer, err := NewTransfer("/tmp/x", 1, 1)
+	if err != nil {
+		t.Fatalf("NewTransfer returned error: %v", err)
+	}
+
+	if expired := manager.reapExpired(transfer.ExpiresAt.Add(-time.Second)); len(expired) != 0 {
+		t.Fatalf("expected no expired transfers, got %d", len(expired))
+	}
+	if _, ok := GetTransfer(transfer.ID); !ok {
+		t.Fatalf("transfer %q reaped before expiry", transfer.ID)
+	}
+
+	expired := manager.reapExpired(transfer.ExpiresAt)
+	if len(expired) != 1 || expired[0].ID != transfer.ID {
+		t.Fatalf("expected transfer %q to expire, got %+v", transfer.ID, expired)
+	}
+	if _, ok := GetTransfer(transfer.ID); ok {
+		t.Fatalf("transfer %q still present after expiry", transfer.ID)
+	}
+}
+
+func TestReapExpiredLoopEnforcesShortTransferTTL(t *testing.T) {
+	resetTransferStore()
+
+	transfer, err := NewTransfer("/tmp/x", 1, 1)
+	if err != nil {
+		t.Fatalf("NewTransfer returned error: %v", err)
+	}
+	// The default lease is minutes, so only a sweep scheduled from this
+	// transfer's own lease reaps it within the deadline.
+	if _, ok := RenewTransfer(transfer.ID, 50*time.Millisecond); !ok {
+		t.Fatalf("RenewTransfer returned false")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := GetTransfer(transfer.ID); !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("transfer %q with a 50ms ttl was not reaped within 2s", transfer.ID)
+}
+
+func TestReapExpiredSkipsPausedTransfers(t *testing.T) {
+	resetTransferStore()
+
+	transfer, err := NewTransfer("/tmp/x", 1, 1)
+	if err != nil {
+		t.Fatalf("NewTransfer returned error: %v", err)
+	}
+	if !PauseTransfer(transfer.ID) {
+		t.Fatalf("PauseTransfer returned false")
+	}
+	if expired := manager.reapExpired(transfer.ExpiresAt.Add(time.Hour)); len(expired) != 0 {
+		t.Fatalf("paused transfer was reaped past its expiry")
+	}
+	if wait := manager.nextReapWait(transfer.ExpiresAt.Add(time.Hour)); wait != maxReapInterval {
+		t.Fatalf("expected a paused transfer not to schedule a sweep, got %s", wait)
+	}
+
+	// RESUME starts a fresh lease rather than waking to an expired one.
+	resumedAt := time.Now()
+	if !ResumeTransfer(transfer.ID) {
+		t.Fatalf("ResumeTransfer returned false")
+	}
+	stored, _ := GetTransfer(transfer.ID)
+	if stored.ExpiresAt.Before(resumedAt.Add(stored.TTL)) {
+		t.Fatalf("expected resume to renew the lease, expires in %s", time.Until(stored.ExpiresAt))
+	}
+	if expired := manager.reapExpired(stored.ExpiresAt); len(expired) != 1 {
+		t.Fatalf("expected the resumed transfer to expire at its new deadline, got %d", len(expired))
+	}
+}
+
+func TestUpdateTransferLinkReplacesProbeHint(t *testing.T) {
+	resetTransferStore()
+
+	transfer, err := NewTransfer("/tmp/x", 0, 0)
+	if err != nil {
+		t.Fatalf("NewTransfer returned error: %v", err)
+	}
+	if ok := SetTransferHints(transfer.ID, "fast", 1000, 4); !ok {
+		t.Fatalf("SetTransferHints returned false")
+	}
+	if mbps, ok := UpdateTransferLink(transfer.ID, 0, 0); !ok || mbps != 1000 {
+		t.Fatalf("expected probe hint 1000, got %d ok=%v", mbps, ok)
+	}
+	stored, _ := GetTransfer(transfer.ID)
+	if stored.LinkSource != LinkSourceProbe {
+		t.Fatalf("expected probe source, got %q", stored.LinkSource)
+	}
+
+	if mbps, ok := UpdateTransferLink(transfer.ID, 2500, 12); !ok || mbps != 2500 {
+		t.Fatalf("expected client hint 2500, got %d ok=%v", mbps, ok)
+	}
+	stored, _ = GetTransfer(transfer.ID)
+	if stored.LinkMbps != 2500 || stored.LinkRTTMS != 12 || stored.LinkSource != LinkSourceClient || stored.LinkUpdatedAt.IsZero() {
+		t.Fatalf("unexpected link state: %+v", stored)
+	}
+	if _, ok := UpdateTransferLink("missing", 10, 1); ok {
+		t.Fatalf("expected missing transfer to report false")
+	}
+}
+
+func TestTransferGatePauseResumeAndCancel(t *testing.T) {
+	resetTransferStore()
+
+	transfer, err := NewTransfer("/tmp/x", 1, 1)
+	if err != nil {
+		t.Fatalf("NewTransfer returned error: %v", err)
+	}
+	gate := GetTransferGate(transfer.ID)
+	if gate == nil {
+		t.Fatalf("expected gate for transfer %q", transfer.ID)
+	}
+	if err := gate.Wait(context.Background()); err != nil {
+		t.Fatalf("running transfer should not block: %v", err)
+	}
+
+	if !PauseTransfer(transfer.ID) {
+		t.Fatalf("PauseTransfer returned false")
+	}
+	if stored, _ := GetTransfer(transfer.ID); !stored.Paused {
+		t.Fatalf("expected transfer to report paused")
+	}
+	waited := make(chan error, 1)
+	go func() { waited <- gate.Wait(context.Background()) }()
+	select {
+	case err := <-waited:
+		t.Fatalf("paused transfer did not block, err=%v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if !ResumeTransfer(transfer.ID) {
+		t.Fatalf("ResumeTransfer returned false")
+	}
+	if err := <-waited; err != nil {
+		t.Fatalf("expected resume to release waiter, got %v", err)
+	}
+
+	PauseTransfer(transfer.ID)
+	go func() { waited <- gate.Wait(context.Background()) }()
+	if !CancelTransfer(transfer.ID) {
+		t.Fatalf("CancelTransfer returned false")
+	}
+	if err := <-waited; !errors.Is(err, ErrTransferCancelled) {
+		t.Fatalf("expected ErrTransferCancelled, got %v", err)
+	}
+	if _, ok := GetTransfer(transfer.ID); ok {
+		t.Fatalf("transfer %q still present after cancel", transfer.ID)
+	}
+	if GetTransferGate(transfer.ID) != nil || CancelTransfer(transfer.ID) || PauseTransfer(transfer.ID) {
+		t.Fatalf("expected cancelled transfer to be unknown")
+	}
+}
+
+func TestRenewTransferExtendsAndCapsLease(t *testing.T) {
+	resetTransferStore()
+	SetDefaultTransferTTL(time.Hour)
+	defer SetDefaultTransferTTL(0)
+
+	transfer, err := NewTransfer("/tmp/x", 1, 1)
+	if err != nil {
+		t.Fatalf("NewTransfer returned error: %v", err)
+	}
+	if transfer.TTL != time.Hour || transfer.ExpiresAt.Sub(transfer.CreatedAt) != time.Hour {
+		t.Fatalf("expected the default 1h lease, got ttl=%s expires=%s", transfer.TTL, transfer.ExpiresAt.Sub(transfer.CreatedAt))
+	}
+
+	expiresAt, ok := RenewTransfer(transfer.ID, 48*time.Hour)
+	if !ok {
+		t.Fatalf("RenewTransfer returned false")
+	}
+	stored, _ := GetTransfer(transfer.ID)
+	if stored.TTL != maxTransferTTL || !stored.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected lease capped at %s, got ttl=%s", maxTransferTTL, stored.TTL)
+	}
+	if until := time.Until(expiresAt); until <= 23*time.Hour {
+		t.Fatalf("expected expiry about a day out, got %s", until)
+	}
+
+	// A renewal without a TTL keeps the lease and only moves the expiry, so
+	// a transfer that is still in use outlives its original deadline.
+	if _, ok := RenewTransfer(transfer.ID, 0); !ok {
+		t.Fatalf("RenewTransfer returned false")
+	}
+	if expired := manager.reapExpired(transfer.ExpiresAt.Add(time.Minute)); len(expired) != 0 {
+		t.Fatalf("renewed transfer was reaped at its original expiry")
+	}
+	if _, ok := RenewTransfer("missing", 0); ok {
+		t.Fatalf("expected missing transfer to report false")
+	}
+}
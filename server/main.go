@@ -6,16 +6,21 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
+	"path/filepath"
 	"runtime/trace"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -26,41 +31,54 @@ import (
 	"github.com/jolynch/pinch/internal/cmd/filexfercli"
 	"github.com/jolynch/pinch/internal/filexfer/ftcp"
 	"github.com/jolynch/pinch/internal/filexfer/limit"
+	"github.com/jolynch/pinch/internal/filexfer/source"
+	intstore "github.com/jolynch/pinch/internal/filexfer/store"
+	"github.com/jolynch/pinch/metrics"
 	"github.com/jolynch/pinch/state"
+	"github.com/jolynch/pinch/tracing"
 	"github.com/jolynch/pinch/utils"
 )
 
 var (
 	listen       = "127.0.0.1:8080"
 	fileListener = "127.0.0.1:3453"
+	adminListen  = ""
 	inputDir     = "/var/lib/pinch/in"
 	outputDir    = "/var/lib/pinch/out"
 	keysDir      = "/var/lib/pinch/keys"
 	tokenLength  = 8
 	bufSizeBytes = 128 * 1024 // Usually pipes are 64KiB, we bump it slightly
-	serverKey    *age.X25519Identity
+	serverKeys   *ftcp.Keyring
 	fsFileRate   = ""
 	fsFileBurst  = "1MiB"
 )
 
+func readAgeIdentityFile(keyPath string) (*age.X25519Identity, error) {
+	raw, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read key file %s: %w", keyPath, err)
+	}
+	lines := strings.Split(string(raw), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, parseErr := age.ParseX25519Identity(line)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid existing key file %s: %w", keyPath, parseErr)
+		}
+		return identity, nil
+	}
+	return nil, fmt.Errorf("existing key file %s has no identity", keyPath)
+}
+
 func loadServerAgeIdentity(dir string) (*age.X25519Identity, error) {
 	keyPath := path.Join(dir, "key")
-	if raw, err := os.ReadFile(keyPath); err == nil {
-		lines := strings.Split(string(raw), "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" || strings.HasPrefix(line, "#") {
-				continue
-			}
-			identity, parseErr := age.ParseX25519Identity(line)
-			if parseErr != nil {
-				return nil, fmt.Errorf("invalid existing key file %s: %w", keyPath, parseErr)
-			}
-			return identity, nil
-		}
-		return nil, fmt.Errorf("existing key file %s has no identity", keyPath)
-	} else if !os.IsNotExist(err) {
-		return nil, fmt.Errorf("read key file %s: %w", keyPath, err)
+	if identity, err := readAgeIdentityFile(keyPath); err == nil {
+		return identity, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
 	}
 
 	identity, err := age.GenerateX25519Identity()
@@ -78,6 +96,63 @@ func loadServerAgeIdentity(dir string) (*age.X25519Identity, error) {
 	return identity, nil
 }
 
+// loadServerKeyring loads the primary identity from keysDir/key (creating it
+// if missing) plus any previous identities kept as keysDir/key.<n> (n all
+// digits) during a rotation. Clients may AUTH with any of them; only the
+// primary is announced. Other key.* names, such as editor backups, are
+// skipped.
+func loadServerKeyring(dir string) (*age.X25519Identity, []*age.X25519Identity, error) {
+	primary, err := loadServerAgeIdentity(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	matches, err := filepath.Glob(path.Join(dir, "key.*"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(matches)
+	others := make([]*age.X25519Identity, 0, len(matches))
+	for _, keyPath := range matches {
+		if !isRotatedKeyName(filepath.Base(keyPath)) {
+			slog.Warn("keys.skipped", "path", keyPath, "reason", "not key.<n>")
+			continue
+		}
+		identity, err := readAgeIdentityFile(keyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		others = append(others, identity)
+	}
+	return primary, others, nil
+}
+
+func isRotatedKeyName(name string) bool {
+	suffix, ok := strings.CutPrefix(name, "key.")
+	if !ok || suffix == "" {
+		return false
+	}
+	for _, r := range suffix {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func reloadServerKeys() error {
+	primary, others, err := loadServerKeyring(keysDir)
+	if err != nil {
+		return err
+	}
+	if serverKeys == nil {
+		serverKeys = ftcp.NewKeyring(primary, others...)
+	} else {
+		serverKeys.Set(primary, others...)
+	}
+	slog.Info("keys.loaded", "primary", primary.Recipient().String(), "accepted", len(serverKeys.Recipients()))
+	return nil
+}
+
 func compress(
 	fifos utils.FifoPair,
 	timeout time.Duration,
@@ -117,9 +192,7 @@ func compress(
 		compressor,
 	)
 
-	log.Printf("[%s][pinch]: Spawning pipeline with timeout [%s] -> [%s]", name, timeout, pipeline)
-	log.Printf("[%s][pinch]: Produce data to   [%s]", name, input)
-	log.Printf("[%s][pinch]: Consume data from [%s]", name, output)
+	slog.Info("pipeline.started", "handle", name, "op", "pinch", "timeout", timeout, "pipeline", pipeline, "input", input, "output", output)
 
 	cmdTerm, cmdKill := utils.KillAfter(timeout)
 	cmd := exec.Command(
@@ -136,7 +209,7 @@ func compress(
 	err := cmd.Run()
 
 	if err != nil {
-		log.Printf("[%s][pinch]: Failed! with error %s", name, err)
+		slog.Warn("pipeline.failed", "handle", name, "op", "pinch", "err", err, "elapsed", time.Since(start))
 		// Try to remove the hash files
 		state.CleanupDigests(output)
 		state.FinishPipeline(
@@ -153,8 +226,7 @@ func compress(
 	} else {
 		// Hack to make carriage returns into newlines
 		msg := strings.ReplaceAll(stderr.String(), "\r", "\r\n")
-		log.Printf("[%s][pinch]: Succeeded after waiting [%s]", name, time.Since(start))
-		log.Print("[" + name + "]\n" + msg)
+		slog.Info("pipeline.succeeded", "handle", name, "op", "pinch", "elapsed", time.Since(start), "stderr", msg)
 		var xxhash string = "UNKNOWN"
 		var blake3 string = "UNKNOWN"
 		xfd, err := os.Open(output + ".xxh128")
@@ -181,7 +253,7 @@ func compress(
 			output,
 		)
 	}
-	log.Printf("[%s][pinch]: Done", name)
+	slog.Info("pipeline.done", "handle", name, "op", "pinch")
 }
 
 func decompress(
@@ -211,9 +283,7 @@ func decompress(
 		output,
 	)
 
-	log.Printf("[%s][unpinch]: Spawning pipeline with timeout [%s] -> [%s]", name, timeout, pipeline)
-	log.Printf("[%s][unpinch]: Produce data to   [%s]", name, input)
-	log.Printf("[%s][unpinch]: Consume data from [%s]", name, output)
+	slog.Info("pipeline.started", "handle", name, "op", "unpinch", "timeout", timeout, "pipeline", pipeline, "input", input, "output", output)
 
 	cmdTerm, cmdKill := utils.KillAfter(timeout)
 	cmd := exec.Command(
@@ -230,7 +300,7 @@ func decompress(
 	err := cmd.Run()
 
 	if err != nil {
-		log.Printf("[%s][unpinch]: Failed! with error %s", name, err)
+		slog.Warn("pipeline.failed", "handle", name, "op", "unpinch", "err", err, "elapsed", time.Since(start))
 		// Try to remove the hash files and record a failure
 		state.CleanupDigests(output)
 		state.FinishPipeline(
@@ -245,8 +315,7 @@ func decompress(
 			output,
 		)
 	} else {
-		log.Printf("[%s][unpinch]: Succeeded after waiting %s", name, time.Since(start))
-		log.Print("[" + name + "]\n" + stderr.String())
+		slog.Info("pipeline.succeeded", "handle", name, "op", "unpinch", "elapsed", time.Since(start), "stderr", stderr.String())
 		var xxhash string = "UNKNOWN"
 		var blake3 string = "UNKNOWN"
 		xfd, err := os.Open(output + ".xxh128")
@@ -274,7 +343,7 @@ func decompress(
 		)
 	}
 
-	log.Printf("[%s][unpinch]: Done", name)
+	slog.Info("pipeline.done", "handle", name, "op", "unpinch")
 }
 
 func pinch(w http.ResponseWriter, req *http.Request) {
@@ -321,7 +390,7 @@ func pinch(w http.ResponseWriter, req *http.Request) {
 		if len(k[0]) > 0 {
 			encKey = k[0]
 		} else {
-			encKey = serverKey.Recipient().String()
+			encKey = serverKeys.Primary().Recipient().String()
 		}
 	}
 
@@ -371,10 +440,10 @@ func pinch(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if minLevel != 0 {
-		log.Printf("Setting minlevel to %d", minLevel)
+		slog.Debug("pipeline.min_level", "min_level", minLevel)
 		response.CompressionParams.MinLevel = minLevel
 	} else {
-		log.Printf("Not setting minlevel")
+		slog.Debug("pipeline.min_level", "min_level", "unset")
 	}
 
 	if encKey != nil {
@@ -416,7 +485,7 @@ func pinch(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
-		log.Printf("ERROR %s", err)
+		slog.Warn("http.encode_failed", "err", err)
 	}
 }
 
@@ -498,7 +567,7 @@ func unpinch(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
-		log.Printf("ERROR %s", err)
+		slog.Warn("http.encode_failed", "err", err)
 	}
 }
 
@@ -525,17 +594,64 @@ func getStatus(w http.ResponseWriter, req *http.Request) {
 
 	start := time.Now()
 	value, ok := state.WaitForPipeline(name, waitFor)
-	log.Printf("[%s][status] Waited for %s", name, time.Since(start))
+	slog.Info("status.waited", "handle", name, "elapsed", time.Since(start))
 	if ok {
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(value); err != nil {
-			log.Printf("[%s][status] failed to encode response: %v", name, err)
+			slog.Warn("http.encode_failed", "handle", name, "path", "status", "err", err)
 		}
 	} else {
 		http.Error(w, "Could not find handle: "+name, http.StatusNotFound)
 	}
 }
 
+func reloadKeys(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Must POST to reload keys", http.StatusMethodNotAllowed)
+		return
+	}
+	slog.Info("keys.reload", "source", "admin", "dir", keysDir)
+	if err := reloadServerKeys(); err != nil {
+		slog.Warn("keys.reload_failed", "source", "admin", "dir", keysDir, "err", err)
+		http.Error(w, fmt.Sprintf("Key reload failed: %s", err), http.StatusInternalServerError)
+		return
+	}
+	type resp struct {
+		Primary  string   `json:"primary"`
+		Accepted []string `json:"accepted"`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp{
+		Primary:  serverKeys.Primary().Recipient().String(),
+		Accepted: serverKeys.Recipients(),
+	}); err != nil {
+		slog.Warn("http.encode_failed", "path", "admin", "err", err)
+	}
+}
+
+// validateAdminListen only allows loopback addresses: the admin endpoints
+// have no authentication of their own.
+func validateAdminListen(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%s is not a loopback address", addr)
+	}
+	return nil
+}
+
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/keys/reload", reloadKeys)
+	return mux
+}
+
 func handleIO(w http.ResponseWriter, req *http.Request) {
 	var name string
 	name = strings.TrimPrefix(req.URL.Path, "/io/")
@@ -572,22 +688,22 @@ func writeChunk(name string, w http.ResponseWriter, req *http.Request) {
 		readFinished <- true
 	}
 
-	log.Printf("[%s][write]: Copying to pipe [%s]", name, path.Join(inputDir, name))
+	slog.Info("io.write_started", "handle", name, "pipe", path.Join(inputDir, name))
 	buf := make([]byte, bufSizeBytes)
 	bytesWritten, err := io.CopyBuffer(fd, req.Body, buf)
 	if err != nil {
-		log.Printf("[%s][write]: Failed due to %s", name, err)
+		slog.Warn("io.write_failed", "handle", name, "err", err)
 		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
 		return
 	} else {
 		if bytesWritten > 0 {
-			log.Printf("[%s][write]: Copied %d bytes to pipe", name, bytesWritten)
+			slog.Info("io.write_done", "handle", name, "bytes", bytesWritten)
 			w.Header().Set("X-Pinch-Bytes-Written", strconv.FormatInt(bytesWritten, 10))
 		}
 	}
 
 	if !partial {
-		log.Printf("[%s][write]: Closing writer due to lack of partial flag", name)
+		slog.Info("io.writer_closed", "handle", name, "reason", "not partial")
 		state.MaybeReleaseWriter(name)
 	}
 
@@ -600,13 +716,13 @@ func writeChunk(name string, w http.ResponseWriter, req *http.Request) {
 func doReadChunk(w http.ResponseWriter, name string, finished chan bool) {
 	fd, err := os.Open(path.Join(outputDir, name))
 	if err != nil {
-		log.Printf("[%s][read]: ERROR: no such handle: %s", name, err)
+		slog.Warn("io.read_failed", "handle", name, "err", err)
 		http.Error(w, "No such handle: "+name, http.StatusNotFound)
 		finished <- false
 		return
 	} else {
 		defer fd.Close()
-		log.Printf("[%s][read]: Opened [%s]", name, path.Join(outputDir, name))
+		slog.Info("io.read_opened", "handle", name, "pipe", path.Join(outputDir, name))
 	}
 
 	w.Header().Set("Connection", "Keep-Alive")
@@ -617,19 +733,19 @@ func doReadChunk(w http.ResponseWriter, name string, finished chan bool) {
 	w.Header().Add("Trailer", "X-Pinch-XXH128")
 	w.Header().Add("Trailer", "X-Pinch-BLAKE3")
 
-	log.Printf("[%s][read]: Copying from pipe [%s]", name, path.Join(outputDir, name))
+	slog.Info("io.read_started", "handle", name, "pipe", path.Join(outputDir, name))
 	w.Header().Set("Content-Type", "application/octet-stream")
 
 	buf := make([]byte, bufSizeBytes)
 	bytesRead, err := io.CopyBuffer(w, fd, buf)
 	if err != nil {
-		log.Printf("[%s] [read] ERROR: %s", name, err)
+		slog.Warn("io.read_failed", "handle", name, "err", err)
 		http.Error(w, fmt.Sprintf("Error reading from %s: %s", name, err), http.StatusInternalServerError)
 		finished <- false
 		return
 	} else {
 		if bytesRead > 0 {
-			log.Printf("[%s][read]: Copied %d bytes from pipe", name, bytesRead)
+			slog.Info("io.read_done", "handle", name, "bytes", bytesRead)
 		}
 		w.Header().Set("X-Pinch-Bytes-Read", strconv.FormatInt(bytesRead, 10))
 
@@ -647,23 +763,23 @@ func readChunk(name string, w http.ResponseWriter, req *http.Request) {
 func token(length int) string {
 	b := make([]byte, length)
 	if _, err := rand.Read(b); err != nil {
-		log.Printf("Failed to generate random token bytes: %v", err)
+		slog.Warn("token.failed", "err", err)
 		return ""
 	}
 	return hex.EncodeToString(b)
 }
 
 func die(duration time.Duration) {
-	log.Printf("Will die after %s", duration)
+	slog.Info("server.die_scheduled", "after", duration)
 	time.Sleep(duration)
-	log.Printf("Goodbye dying now ...")
+	slog.Info("server.dying")
 	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
 }
 
 func makeDirs(path string) bool {
 	err := os.MkdirAll(path, 0o777)
 	if err != nil {
-		log.Println(err)
+		slog.Warn("dir.create_failed", "path", path, "err", err)
 		return false
 	}
 	return true
@@ -676,7 +792,7 @@ func cleanupDir(dirPath string) error {
 	}
 	for _, d := range entries {
 		entryPath := path.Join(dirPath, d.Name())
-		log.Printf("[cleanup] Cleaning up %s", entryPath)
+		slog.Info("dir.cleanup", "path", entryPath)
 		if err := os.RemoveAll(entryPath); err != nil {
 			return err
 		}
@@ -684,6 +800,19 @@ func cleanupDir(dirPath string) error {
 	return nil
 }
 
+// newLogHandler builds the process log handler. Setting it as the slog
+// default also routes the standard library logger through it.
+func newLogHandler(format string, w io.Writer) (slog.Handler, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		return slog.NewTextHandler(w, nil), nil
+	case "json":
+		return slog.NewJSONHandler(w, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+}
+
 func shouldRunCLI(args []string) bool {
 	return len(args) > 1 && args[1] == "cli"
 }
@@ -695,6 +824,7 @@ func main() {
 
 	flag.StringVar(&listen, "listen", listen, "The address to listen on")
 	flag.StringVar(&fileListener, "file-listen", fileListener, "The file transfer TCP listen address")
+	flag.StringVar(&adminListen, "admin-listen", adminListen, "Loopback address to serve /admin/keys/reload on (empty disables it; SIGHUP always reloads keys)")
 	flag.StringVar(&inputDir, "in", inputDir, "The directory to create input pipes in")
 	flag.StringVar(&outputDir, "out", outputDir, "The directory to create output pipes in")
 	flag.StringVar(&keysDir, "keys", keysDir, "The directory to create output pipes in")
@@ -705,10 +835,27 @@ func main() {
 	fsFileTimeLimit := flag.Duration("fs-file-time-limit", 0, "Per-request wall-clock limit for file-listener responses (0 disables)")
 	fsRequireAuth := flag.Bool("fs-require-auth", false, "Require AUTH before using file-listen commands")
 	fsTraceFile := flag.String("fs-trace", "", "Write runtime/trace output to this file")
+	fsTransferTTL := flag.Duration("fs-transfer-ttl", intstore.DefaultTransferTTL(), "How long a file-listener transfer lives without a SEND, ACK or RENEW; TXFER ttl= may ask for up to max(24h, this)")
+	fsRemoteRoots := flag.String("fs-remote-roots", "", "Comma-separated s3://bucket[/prefix] roots TXFER may read with the server's AWS credentials (empty disables object-store roots)")
+	fsAuditDir := flag.String("fs-audit-dir", "", "Write a per-transfer audit file (<txferid>.audit.jsonl) recording who pulled which files to this directory")
+	logFormat := flag.String("log-format", "text", "Log output format: text or json")
+	traceExport := flag.String("trace-export", "", "Export OpenTelemetry spans to this OTLP/HTTP endpoint (http://host:4318) or file path")
 	dieAfter := flag.Duration("die-after", 0, "Die after this duration. Zero seconds indicates live forever")
 
 	flag.Parse()
 
+	logHandler, err := newLogHandler(*logFormat, os.Stderr)
+	if err != nil {
+		log.Fatalf("Invalid -log-format: %v", err)
+	}
+	slog.SetDefault(slog.New(logHandler))
+
+	stopSpans, err := tracing.Setup("pinch-server", *traceExport)
+	if err != nil {
+		log.Fatalf("Invalid -trace-export: %v", err)
+	}
+	defer stopSpans()
+
 	if *fsTraceFile != "" {
 		tf, err := os.Create(*fsTraceFile)
 		if err != nil {
@@ -739,18 +886,41 @@ func main() {
 	if !makeDirs(keysDir) {
 		log.Fatalf("Could not setup key directory, dying")
 	}
-	var err error
-	serverKey, err = loadServerAgeIdentity(keysDir)
-	if err != nil {
+	if err := reloadServerKeys(); err != nil {
 		log.Fatalf("AGE key setup failed: %v", err)
 	}
-	log.Printf("Public key %s", serverKey.Recipient().String())
+	if *fsTransferTTL <= 0 {
+		log.Fatalf("Invalid -fs-transfer-ttl: must be > 0")
+	}
+	intstore.SetDefaultTransferTTL(*fsTransferTTL)
+	if *fsRemoteRoots != "" {
+		if err := source.SetAllowedRemoteRoots(strings.Split(*fsRemoteRoots, ",")); err != nil {
+			log.Fatalf("Invalid -fs-remote-roots: %v", err)
+		}
+	}
+	var auditLog *ftcp.AuditLog
+	if *fsAuditDir != "" {
+		auditLog, err = ftcp.NewAuditLog(*fsAuditDir)
+		if err != nil {
+			log.Fatalf("Audit log setup failed: %v", err)
+		}
+	}
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			slog.Info("keys.reload", "source", "sighup", "dir", keysDir)
+			if err := reloadServerKeys(); err != nil {
+				slog.Warn("keys.reload_failed", "source", "sighup", "dir", keysDir, "err", err)
+			}
+		}
+	}()
 
-	log.Printf("Scanning input directory [%s] to clean up.", inputDir)
+	slog.Info("dir.scan", "kind", "input", "path", inputDir)
 	if err := cleanupDir(inputDir); err != nil {
 		log.Fatalf("failed cleaning input directory %s: %v", inputDir, err)
 	}
-	log.Printf("Scanning output directory [%s] to clean up", outputDir)
+	slog.Info("dir.scan", "kind", "output", "path", outputDir)
 	if err := cleanupDir(outputDir); err != nil {
 		log.Fatalf("failed cleaning output directory %s: %v", outputDir, err)
 	}
@@ -761,8 +931,17 @@ func main() {
 	mux.HandleFunc("/unpinch", unpinch)
 	mux.HandleFunc("/io/", handleIO)
 	mux.HandleFunc("/status/", getStatus)
+	mux.Handle("/metrics", metrics.Default.Handler())
+	if *fsRequireAuth {
+		slog.Info("listener.http_fs_disabled", "reason", "fs-require-auth")
+	} else {
+		mux.Handle("/fs/", ftcp.NewHTTPHandler(ftcp.HTTPOptions{
+			AuditLog: auditLog,
+			Limiter:  fileStreamLimiter,
+		}))
+	}
 	socketWriteBufBytes := utils.MaxSocketWriteBufferBytes()
-	log.Printf("Detected ideal socket write buffer of size %d", socketWriteBufBytes)
+	slog.Info("socket.write_buffer", "bytes", socketWriteBufBytes)
 
 	fileLn, err := net.Listen("tcp", fileListener)
 	if err != nil {
@@ -770,10 +949,11 @@ func main() {
 	}
 	defer fileLn.Close()
 	go func() {
-		log.Printf("File transfer listener at %s", fileListener)
+		slog.Info("listener.started", "proto", "ftcp", "addr", fileListener)
 		if serveErr := ftcp.Serve(fileLn, ftcp.ServerOptions{
 			RequireAuth:            *fsRequireAuth,
-			ServerIdentity:         serverKey,
+			Keyring:                serverKeys,
+			AuditLog:               auditLog,
 			Limiter:                fileStreamLimiter,
 			SocketWriteBufferBytes: socketWriteBufBytes,
 		}); serveErr != nil {
@@ -781,11 +961,29 @@ func main() {
 		}
 	}()
 
+	if adminListen != "" {
+		if err := validateAdminListen(adminListen); err != nil {
+			log.Fatalf("Invalid -admin-listen: %v", err)
+		}
+		adminLn, err := net.Listen("tcp", adminListen)
+		if err != nil {
+			log.Fatalf("Failed to bind admin listener at %s: %v", adminListen, err)
+		}
+		defer adminLn.Close()
+		go func() {
+			slog.Info("listener.started", "proto", "admin", "addr", adminListen)
+			adminServer := &http.Server{Handler: newAdminMux(), ReadHeaderTimeout: 5 * time.Second}
+			if serveErr := adminServer.Serve(adminLn); serveErr != nil {
+				log.Fatalf("Admin listener stopped: %v", serveErr)
+			}
+		}()
+	}
+
 	if *dieAfter > 0 {
 		go die(*dieAfter)
 	}
 
-	log.Printf("Listening at %s/pinch", listen)
+	slog.Info("listener.started", "proto", "http", "addr", listen)
 	server := &http.Server{
 		Addr:              listen,
 		Handler:           mux,
@@ -801,7 +999,5 @@ func main() {
 	}
 	if err := server.ListenAndServe(); err != nil {
 		log.Fatalf("Failed to bind, is another server listening at this address? error=%v", err)
-	} else {
-		log.Printf("Success!")
 	}
 }
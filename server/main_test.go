@@ -1,6 +1,16 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestShouldRunCLI(t *testing.T) {
 	tests := []struct {
@@ -23,3 +33,133 @@ func TestShouldRunCLI(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadServerKeyringIncludesRotatedKeys(t *testing.T) {
+	dir := t.TempDir()
+	primary, others, err := loadServerKeyring(dir)
+	if err != nil {
+		t.Fatalf("loadServerKeyring: %v", err)
+	}
+	if len(others) != 0 {
+		t.Fatalf("expected no previous keys, got %d", len(others))
+	}
+	if err := os.Rename(filepath.Join(dir, "key"), filepath.Join(dir, "key.1")); err != nil {
+		t.Fatalf("rotate key: %v", err)
+	}
+
+	rotated, others, err := loadServerKeyring(dir)
+	if err != nil {
+		t.Fatalf("loadServerKeyring after rotation: %v", err)
+	}
+	if rotated.String() == primary.String() {
+		t.Fatalf("expected a new primary key after rotation")
+	}
+	if len(others) != 1 || others[0].String() != primary.String() {
+		t.Fatalf("expected previous primary to stay accepted, got %d keys", len(others))
+	}
+
+	// Editor leftovers next to the key are not rotated keys.
+	for _, name := range []string{"key.swp", "key.bak", "key.1~"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("# scratch\n"), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if _, others, err := loadServerKeyring(dir); err != nil || len(others) != 1 {
+		t.Fatalf("expected backups to be skipped, got %d keys err=%v", len(others), err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "key.2"), []byte("# no identity\n"), 0o600); err != nil {
+		t.Fatalf("write bad key: %v", err)
+	}
+	if _, _, err := loadServerKeyring(dir); err == nil {
+		t.Fatalf("expected error for key file without identity")
+	}
+}
+
+func TestReloadKeysEndpoint(t *testing.T) {
+	prevDir, prevKeys := keysDir, serverKeys
+	t.Cleanup(func() { keysDir, serverKeys = prevDir, prevKeys })
+	keysDir = t.TempDir()
+	serverKeys = nil
+	if err := reloadServerKeys(); err != nil {
+		t.Fatalf("reloadServerKeys: %v", err)
+	}
+	keys := serverKeys
+	before := keys.Primary().Recipient().String()
+
+	rec := httptest.NewRecorder()
+	reloadKeys(rec, httptest.NewRequest(http.MethodGet, "/admin/keys/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET, got %d", rec.Code)
+	}
+
+	if err := os.Rename(filepath.Join(keysDir, "key"), filepath.Join(keysDir, "key.1")); err != nil {
+		t.Fatalf("rotate key: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	reloadKeys(rec, httptest.NewRequest(http.MethodPost, "/admin/keys/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Primary  string   `json:"primary"`
+		Accepted []string `json:"accepted"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if serverKeys != keys {
+		t.Fatalf("expected keyring to be updated in place")
+	}
+	if body.Primary == before || len(body.Accepted) != 2 || body.Accepted[1] != before {
+		t.Fatalf("unexpected reload response: %+v", body)
+	}
+}
+
+func TestAdminListenerIsLoopbackOnly(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8079", "[::1]:8079", "localhost:8079"} {
+		if err := validateAdminListen(addr); err != nil {
+			t.Fatalf("validateAdminListen(%q): %v", addr, err)
+		}
+	}
+	for _, addr := range []string{":8079", "0.0.0.0:8079", "10.0.0.1:8079", "example.com:8079", "8079"} {
+		if err := validateAdminListen(addr); err == nil {
+			t.Fatalf("expected validateAdminListen(%q) to fail", addr)
+		}
+	}
+	rec := httptest.NewRecorder()
+	newAdminMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/keys/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected the admin mux to route reload, got %d", rec.Code)
+	}
+}
+
+func TestNewLogHandlerFormats(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := newLogHandler("json", &buf)
+	if err != nil {
+		t.Fatalf("newLogHandler(json) failed: %v", err)
+	}
+	slog.New(h).Info("window.sent", "txfer", "tx1", "fid", 2)
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected JSON log line, got %q: %v", buf.String(), err)
+	}
+	if rec["msg"] != "window.sent" || rec["txfer"] != "tx1" || rec["fid"] != float64(2) {
+		t.Fatalf("unexpected JSON record: %v", rec)
+	}
+
+	buf.Reset()
+	h, err = newLogHandler("text", &buf)
+	if err != nil {
+		t.Fatalf("newLogHandler(text) failed: %v", err)
+	}
+	slog.New(h).Info("ack.accepted", "txfer", "tx1")
+	if got := buf.String(); !strings.Contains(got, "msg=ack.accepted txfer=tx1") {
+		t.Fatalf("unexpected text record: %q", got)
+	}
+
+	if _, err := newLogHandler("xml", &buf); err == nil {
+		t.Fatalf("expected error for unknown log format")
+	}
+}
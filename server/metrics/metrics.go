@@ -0,0 +1,278 @@
+// Package metrics is a small, dependency-free Prometheus text exposition
+// registry. It supports labelled counters, gauges and histograms, which is
+// all the pinch server needs for its /metrics endpoint.
+package metrics
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultLatencyBuckets covers sub-millisecond to multi-minute latencies in
+// seconds.
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Default is the process-wide registry served by Handler.
+var Default = NewRegistry()
+
+type Registry struct {
+	mu      sync.Mutex
+	byName  map[string]*family
+	ordered []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]*family)}
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       atomicFloat
+	buckets     []atomic.Uint64
+	count       atomic.Uint64
+}
+
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(v float64) {
+	for {
+		old := f.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if f.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+func (r *Registry) register(name, help, typ string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.byName[name]; ok {
+		if existing.typ != typ || len(existing.labels) != len(labels) {
+			panic("metrics: conflicting registration for " + name)
+		}
+		return existing
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  append([]string(nil), labels...),
+		buckets: append([]float64(nil), buckets...),
+		series:  make(map[string]*series),
+	}
+	r.byName[name] = f
+	r.ordered = append(r.ordered, f)
+	return f
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic("metrics: wrong number of label values for " + f.name)
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok = f.series[key]; ok {
+		return s
+	}
+	s = &series{labelValues: append([]string(nil), values...)}
+	if f.typ == "histogram" {
+		s.buckets = make([]atomic.Uint64, len(f.buckets))
+	}
+	f.series[key] = s
+	return s
+}
+
+type CounterVec struct{ f *family }
+type GaugeVec struct{ f *family }
+type HistogramVec struct{ f *family }
+
+type Counter struct{ s *series }
+type Gauge struct{ s *series }
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.register(name, help, "counter", nil, labels)}
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(name, help, "gauge", nil, labels)}
+}
+
+// NewHistogramVec registers a histogram; buckets are upper bounds in
+// ascending order and +Inf is implied.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &HistogramVec{f: r.register(name, help, "histogram", sorted, labels)}
+}
+
+func (v *CounterVec) With(labelValues ...string) Counter {
+	return Counter{s: v.f.with(labelValues)}
+}
+
+func (v *GaugeVec) With(labelValues ...string) Gauge {
+	return Gauge{s: v.f.with(labelValues)}
+}
+
+func (v *HistogramVec) With(labelValues ...string) Histogram {
+	return Histogram{s: v.f.with(labelValues), buckets: v.f.buckets}
+}
+
+// Add increases the counter; negative values are ignored.
+func (c Counter) Add(v float64) {
+	if v > 0 {
+		c.s.value.Add(v)
+	}
+}
+
+func (c Counter) Inc() { c.s.value.Add(1) }
+
+func (c Counter) Value() float64 { return c.s.value.Load() }
+
+func (g Gauge) Add(v float64) { g.s.value.Add(v) }
+func (g Gauge) Set(v float64) { g.s.value.Set(v) }
+func (g Gauge) Inc()          { g.s.value.Add(1) }
+func (g Gauge) Dec()          { g.s.value.Add(-1) }
+
+func (g Gauge) Value() float64 { return g.s.value.Load() }
+
+func (h Histogram) Observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.s.buckets[i].Add(1)
+			break
+		}
+	}
+	h.s.count.Add(1)
+	h.s.value.Add(v)
+}
+
+func (h Histogram) Count() uint64 { return h.s.count.Load() }
+
+// WriteText writes every registered metric in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.ordered...)
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		f.writeText(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (f *family) writeText(b *strings.Builder) {
+	f.mu.RLock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labelValues, "\xff") < strings.Join(all[j].labelValues, "\xff")
+	})
+
+	b.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+	b.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+	for _, s := range all {
+		if f.typ != "histogram" {
+			b.WriteString(f.name + formatLabels(f.labels, s.labelValues, "", "") + " " + formatFloat(s.value.Load()) + "\n")
+			continue
+		}
+		cumulative := uint64(0)
+		for i, upper := range f.buckets {
+			cumulative += s.buckets[i].Load()
+			b.WriteString(f.name + "_bucket" + formatLabels(f.labels, s.labelValues, "le", formatFloat(upper)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		count := s.count.Load()
+		b.WriteString(f.name + "_bucket" + formatLabels(f.labels, s.labelValues, "le", "+Inf") + " " + strconv.FormatUint(count, 10) + "\n")
+		b.WriteString(f.name + "_sum" + formatLabels(f.labels, s.labelValues, "", "") + " " + formatFloat(s.value.Load()) + "\n")
+		b.WriteString(f.name + "_count" + formatLabels(f.labels, s.labelValues, "", "") + " " + strconv.FormatUint(count, 10) + "\n")
+	}
+}
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		parts = append(parts, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabelValue(v string) string { return labelValueEscaper.Replace(v) }
+func escapeHelp(v string) string       { return helpEscaper.Replace(v) }
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Must GET metrics", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
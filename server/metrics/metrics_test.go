@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	r := NewRegistry()
+	sent := r.NewCounterVec("test_sent_bytes_total", "Bytes sent.", "comp")
+	active := r.NewGaugeVec("test_active", "Active things.", "verb")
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1}, "kind")
+
+	sent.With("zstd").Add(100)
+	sent.With("zstd").Add(-5)
+	sent.With(`we"ird`).Inc()
+	active.With("SEND").Inc()
+	active.With("SEND").Inc()
+	active.With("SEND").Dec()
+	latency.With("recv").Observe(0.05)
+	latency.With("recv").Observe(0.5)
+	latency.With("recv").Observe(5)
+
+	var out bytes.Buffer
+	if err := r.WriteText(&out); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	want := strings.Join([]string{
+		"# HELP test_active Active things.",
+		"# TYPE test_active gauge",
+		`test_active{verb="SEND"} 1`,
+		"# HELP test_latency_seconds Latency.",
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{kind="recv",le="0.1"} 1`,
+		`test_latency_seconds_bucket{kind="recv",le="1"} 2`,
+		`test_latency_seconds_bucket{kind="recv",le="+Inf"} 3`,
+		`test_latency_seconds_sum{kind="recv"} 5.55`,
+		`test_latency_seconds_count{kind="recv"} 3`,
+		"# HELP test_sent_bytes_total Bytes sent.",
+		"# TYPE test_sent_bytes_total counter",
+		`test_sent_bytes_total{comp="we\"ird"} 1`,
+		`test_sent_bytes_total{comp="zstd"} 100`,
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Fatalf("unexpected exposition:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestRegistryReusesFamilyAndIsConcurrencySafe(t *testing.T) {
+	r := NewRegistry()
+	a := r.NewCounterVec("test_total", "Total.")
+	b := r.NewCounterVec("test_total", "Total.")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				a.With().Inc()
+				b.With().Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := a.With().Value(); got != 16000 {
+		t.Fatalf("expected 16000, got %v", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Total.").With().Inc()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type: %q", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "test_total 1\n") {
+		t.Fatalf("unexpected body: %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for POST, got %d", rec.Code)
+	}
+}
@@ -1,11 +1,13 @@
 package state
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"path"
 	"sync"
 	"time"
+
+	"github.com/jolynch/pinch/metrics"
 )
 
 type Checksums struct {
@@ -36,6 +38,18 @@ type Writer struct {
 var (
 	processes sync.Map
 	writers   sync.Map
+
+	pipelinesFinished = metrics.Default.NewCounterVec(
+		"pinch_pipelines_total",
+		"Finished pinch/unpinch pipelines by result.",
+		"result",
+	)
+	pipelineSeconds = metrics.Default.NewHistogramVec(
+		"pinch_pipeline_duration_seconds",
+		"Wall-clock duration of finished pinch/unpinch pipelines.",
+		metrics.DefaultLatencyBuckets,
+		"result",
+	)
 )
 
 func PreparePipeline(name string) {
@@ -45,6 +59,14 @@ func PreparePipeline(name string) {
 
 func FinishPipeline(name string, pipeline PipelineResult, stateTTL time.Duration, output string) {
 	PreparePipeline(name)
+	result := "failure"
+	if pipeline.Success {
+		result = "success"
+	}
+	pipelinesFinished.With(result).Inc()
+	if !pipeline.Start.IsZero() {
+		pipelineSeconds.With(result).Observe(time.Since(pipeline.Start).Seconds())
+	}
 
 	val, ok := processes.Load(name)
 	if ok {
@@ -88,21 +110,21 @@ func WaitForPipeline(name string, waitFor time.Duration) (value PipelineResult,
 }
 
 func cleanupPipeline(name string, start time.Time, expire time.Duration, output string) {
-	log.Printf("[%s][cleanup]: Waiting %s before cleaning up state", name, expire)
+	slog.Debug("pipeline.cleanup_scheduled", "handle", name, "after", expire)
 	time.Sleep(expire)
 
 	val, ok := processes.Load(name)
 	if ok {
 		pr := val.(processResult)
 		if pr.result.Start == start {
-			log.Printf("[%s][cleanup]: Cleaned up for [%s]", name, start.Format(time.RFC3339))
+			slog.Info("pipeline.cleaned", "handle", name, "start", start.Format(time.RFC3339))
 			processes.Delete(name)
 			CleanupDigests(output)
 		} else {
-			log.Printf("[%s][cleanup]: Detected handle re-use", name)
+			slog.Info("pipeline.cleanup_skipped", "handle", name, "reason", "handle reused")
 		}
 	} else {
-		log.Printf("[%s][cleanup]: No process state found ...", name)
+		slog.Info("pipeline.cleanup_skipped", "handle", name, "reason", "no process state")
 		CleanupDigests(output)
 	}
 	MaybeReleaseWriter(name)
@@ -118,7 +140,7 @@ func AcquireWriter(writeDir string, name string) Writer {
 	if !ok {
 		fd, err := os.OpenFile(path.Join(writeDir, name), os.O_WRONLY, 0666)
 		if err != nil {
-			log.Printf("[%s][state]: could not open writer: %s", name, err)
+			slog.Warn("pipeline.writer_failed", "handle", name, "err", err)
 			return Writer{Fd: nil}
 		}
 
@@ -134,7 +156,7 @@ func AcquireWriter(writeDir string, name string) Writer {
 func MaybeReleaseWriter(name string) {
 	value, hasWriter := writers.Load(name)
 	if hasWriter {
-		log.Printf("[%s][cleanup]: Cleaning up open writer", name)
+		slog.Debug("pipeline.writer_released", "handle", name)
 		writers.Delete(name)
 		value.(Writer).Fd.Close()
 	}
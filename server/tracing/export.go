@@ -0,0 +1,255 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const scopeName = "github.com/jolynch/pinch"
+
+// OTLP/JSON shapes, see opentelemetry-proto's ExportTraceServiceRequest.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusError      = 2
+)
+
+func otlpAttributeValue(v any) otlpValue {
+	str := func(s string) otlpValue { return otlpValue{StringValue: &s} }
+	integer := func(i int64) otlpValue {
+		s := strconv.FormatInt(i, 10)
+		return otlpValue{IntValue: &s}
+	}
+	switch x := v.(type) {
+	case string:
+		return str(x)
+	case bool:
+		return otlpValue{BoolValue: &x}
+	case int:
+		return integer(int64(x))
+	case int32:
+		return integer(int64(x))
+	case int64:
+		return integer(x)
+	case uint32:
+		return integer(int64(x))
+	case uint64:
+		if x > math.MaxInt64 {
+			return str(strconv.FormatUint(x, 10))
+		}
+		return integer(int64(x))
+	case float64:
+		return otlpValue{DoubleValue: &x}
+	case time.Duration:
+		return str(x.String())
+	case fmt.Stringer:
+		return str(x.String())
+	case error:
+		return str(x.Error())
+	default:
+		return str(fmt.Sprint(x))
+	}
+}
+
+// EncodeOTLP renders spans as an OTLP/JSON ExportTraceServiceRequest.
+func EncodeOTLP(service string, spans []SpanData) ([]byte, error) {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if !s.Parent.IsZero() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		for _, attr := range s.Attributes {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: attr.Key, Value: otlpAttributeValue(attr.Value)})
+		}
+		if s.Err {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.StatusMessage}
+		}
+		out = append(out, span)
+	}
+	return json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: otlpAttributeValue(service)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: out}},
+	}}})
+}
+
+// FileExporter appends one OTLP/JSON request per batch, one per line, which
+// is the layout the OpenTelemetry collector's file exporter uses.
+type FileExporter struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open trace file %s: %w", path, err)
+	}
+	return &FileExporter{w: f}, nil
+}
+
+func (e *FileExporter) Export(_ context.Context, service string, spans []SpanData) error {
+	raw, err := EncodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(raw)
+	return err
+}
+
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.w.Close()
+}
+
+// OTLPExporter posts OTLP/JSON batches to an OTLP/HTTP traces endpoint.
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func NewOTLPExporter(endpoint string) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return &OTLPExporter{
+		endpoint: u.String(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, service string, spans []SpanData) error {
+	raw, err := EncodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP export to %s failed: %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Close() error { return nil }
+
+// NewExporter builds an exporter from a destination spec: an http(s) URL is
+// an OTLP/HTTP endpoint (defaulting the path to /v1/traces), anything else,
+// optionally prefixed with "file:", is a local file. An empty spec returns
+// nil.
+func NewExporter(spec string) (Exporter, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return NewOTLPExporter(spec)
+	default:
+		return NewFileExporter(strings.TrimPrefix(spec, "file:"))
+	}
+}
+
+// Setup installs a default tracer for service exporting to spec and returns
+// its shutdown function. An empty spec leaves tracing disabled.
+func Setup(service string, spec string) (func() error, error) {
+	exporter, err := NewExporter(spec)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func() error { return nil }, nil
+	}
+	t := NewTracer(service, exporter)
+	SetDefault(t)
+	return func() error {
+		SetDefault(nil)
+		return t.Shutdown()
+	}, nil
+}
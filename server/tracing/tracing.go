@@ -0,0 +1,400 @@
+// Package tracing is a small, dependency-free span recorder that speaks W3C
+// traceparent for propagation and exports OTLP/JSON, either to an OTLP/HTTP
+// collector or to a local file. It covers what pinch needs to follow one
+// transfer across the CLI and the file server.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsZero() bool { return t == TraceID{} }
+func (s SpanID) IsZero() bool  { return s == SpanID{} }
+
+// SpanContext identifies a span, locally or on the other side of a
+// connection.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return !sc.TraceID.IsZero() && !sc.SpanID.IsZero()
+}
+
+// TraceParent formats sc as a W3C traceparent value.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceParent parses a W3C traceparent value
+// (version-traceid-spanid-flags).
+func ParseTraceParent(raw string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(raw), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, errors.New("invalid traceparent")
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, errors.New("invalid traceparent version")
+	}
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, errors.New("invalid traceparent trace id")
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, errors.New("invalid traceparent span id")
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, errors.New("invalid traceparent flags")
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("invalid traceparent ids")
+	}
+	sc.Sampled = flags[0]&0x01 != 0
+	return sc, nil
+}
+
+// SpanData is a finished span handed to an Exporter.
+type SpanData struct {
+	Name          string
+	TraceID       TraceID
+	SpanID        SpanID
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Err           bool
+	StatusMessage string
+}
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Exporter ships finished spans somewhere.
+type Exporter interface {
+	Export(ctx context.Context, service string, spans []SpanData) error
+	Close() error
+}
+
+const (
+	defaultBatchSize     = 256
+	defaultFlushInterval = time.Second
+	defaultQueueSize     = 4096
+)
+
+// Tracer records spans and exports them in the background in batches.
+type Tracer struct {
+	service  string
+	exporter Exporter
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	dropped  atomic.Uint64
+
+	mu       sync.RWMutex
+	closed   bool
+	closeErr error
+}
+
+func NewTracer(service string, exporter Exporter) *Tracer {
+	t := &Tracer{
+		service:  service,
+		exporter: exporter,
+		queue:    make(chan SpanData, defaultQueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.loop()
+	return t
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, defaultBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_ = t.exporter.Export(ctx, t.service, batch)
+		cancel()
+		batch = make([]SpanData, 0, defaultBatchSize)
+	}
+	for {
+		select {
+		case span, ok := <-t.queue:
+			if !ok {
+				export()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= defaultBatchSize {
+				export()
+			}
+		case ack := <-t.flush:
+		drain:
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+				default:
+					break drain
+				}
+			}
+			export()
+			close(ack)
+		case <-ticker.C:
+			export()
+		}
+	}
+}
+
+// Flush exports every span ended so far.
+func (t *Tracer) Flush() {
+	if t == nil || t.isClosed() {
+		return
+	}
+	ack := make(chan struct{})
+	select {
+	case t.flush <- ack:
+		<-ack
+	case <-t.done:
+	}
+}
+
+// Shutdown flushes pending spans and closes the exporter.
+func (t *Tracer) Shutdown() error {
+	if t == nil {
+		return nil
+	}
+	t.Flush()
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return t.closeErr
+	}
+	t.closed = true
+	close(t.queue)
+	t.mu.Unlock()
+	<-t.done
+	err := t.exporter.Close()
+	t.mu.Lock()
+	t.closeErr = err
+	t.mu.Unlock()
+	return err
+}
+
+func (t *Tracer) isClosed() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.closed
+}
+
+// Dropped reports how many spans were discarded because the export queue
+// was full.
+func (t *Tracer) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	return t.dropped.Load()
+}
+
+func (t *Tracer) enqueue(span SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Span is an in-flight span. A nil *Span is valid and records nothing.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	start  time.Time
+
+	mu    sync.Mutex
+	attrs []Attribute
+	err   bool
+	msg   string
+	ended bool
+}
+
+// SpanContext returns the span's identity, or the zero value for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttributes adds key/value pairs, in the style of log/slog.
+func (s *Span) SetAttributes(kv ...any) {
+	if s == nil {
+		return
+	}
+	attrs := kvAttributes(kv)
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed when err is non-nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = true
+	s.msg = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span; later calls are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := SpanData{
+		Name:          s.name,
+		TraceID:       s.sc.TraceID,
+		SpanID:        s.sc.SpanID,
+		Parent:        s.parent,
+		Start:         s.start,
+		End:           time.Now(),
+		Attributes:    s.attrs,
+		Err:           s.err,
+		StatusMessage: s.msg,
+	}
+	s.mu.Unlock()
+	s.tracer.enqueue(data)
+}
+
+func kvAttributes(kv []any) []Attribute {
+	attrs := make([]Attribute, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		attrs = append(attrs, Attribute{Key: key, Value: kv[i+1]})
+	}
+	return attrs
+}
+
+type spanContextKey struct{}
+type remoteContextKey struct{}
+
+// ContextWithRemoteSpanContext makes sc the parent of the next span started
+// from ctx, for spans continuing a trace begun by a peer.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteContextKey{}, sc)
+}
+
+// SpanFromContext returns the active span, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanContextKey{}).(*Span)
+	return s
+}
+
+// TraceParent returns the traceparent value for the active span in ctx, or
+// "" when nothing is being traced.
+func TraceParent(ctx context.Context) string {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceParent()
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault installs the process-wide tracer used by Start. Passing nil
+// disables tracing.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Default returns the process-wide tracer, or nil.
+func Default() *Tracer {
+	return defaultTracer.Load()
+}
+
+// Start begins a span named name under the active or remote parent in ctx
+// using the default tracer. Without a default tracer it returns ctx and a nil
+// span.
+func Start(ctx context.Context, name string, kv ...any) (context.Context, *Span) {
+	return Default().Start(ctx, name, kv...)
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, kv ...any) (context.Context, *Span) {
+	if t == nil || t.isClosed() {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span := &Span{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+		attrs:  kvAttributes(kv),
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.sc.TraceID = parent.sc.TraceID
+		span.sc.Sampled = parent.sc.Sampled
+		span.parent = parent.sc.SpanID
+	} else if remote, ok := ctx.Value(remoteContextKey{}).(SpanContext); ok && remote.IsValid() {
+		span.sc.TraceID = remote.TraceID
+		span.sc.Sampled = remote.Sampled
+		span.parent = remote.SpanID
+	} else {
+		_, _ = rand.Read(span.sc.TraceID[:])
+		span.sc.Sampled = true
+	}
+	_, _ = rand.Read(span.sc.SpanID[:])
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
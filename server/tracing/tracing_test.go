@@ -0,0 +1,210 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (m *memoryExporter) Export(_ context.Context, _ string, spans []SpanData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, spans...)
+	return nil
+}
+
+func (m *memoryExporter) Close() error { return nil }
+
+func (m *memoryExporter) byName() map[string]SpanData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]SpanData, len(m.spans))
+	for _, s := range m.spans {
+		out[s.Name] = s
+	}
+	return out
+}
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	const raw = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(raw)
+	if err != nil {
+		t.Fatalf("ParseTraceParent failed: %v", err)
+	}
+	if !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected span context: %+v", sc)
+	}
+	if got := sc.TraceParent(); got != raw {
+		t.Fatalf("TraceParent()=%q want %q", got, raw)
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceParent(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestStartLinksChildrenAndRemoteParents(t *testing.T) {
+	exp := &memoryExporter{}
+	tracer := NewTracer("test", exp)
+
+	ctx, root := tracer.Start(context.Background(), "root", "k", "v")
+	_, child := tracer.Start(ctx, "child")
+	child.SetError(errors.New("boom"))
+	child.End()
+	root.End()
+
+	remote, err := ParseTraceParent(TraceParent(ctx))
+	if err != nil {
+		t.Fatalf("TraceParent from ctx did not parse: %v", err)
+	}
+	_, server := tracer.Start(ContextWithRemoteSpanContext(context.Background(), remote), "server")
+	server.End()
+
+	if err := tracer.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	spans := exp.byName()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	if spans["child"].TraceID != spans["root"].TraceID || spans["child"].Parent != spans["root"].SpanID {
+		t.Fatalf("child not linked to root: %+v", spans["child"])
+	}
+	if !spans["child"].Err || spans["child"].StatusMessage != "boom" {
+		t.Fatalf("expected child error status, got %+v", spans["child"])
+	}
+	if spans["server"].TraceID != spans["root"].TraceID || spans["server"].Parent != spans["root"].SpanID {
+		t.Fatalf("server span not linked to remote parent: %+v", spans["server"])
+	}
+	if !spans["root"].Parent.IsZero() {
+		t.Fatalf("root span should have no parent")
+	}
+	if len(spans["root"].Attributes) != 1 || spans["root"].Attributes[0].Key != "k" {
+		t.Fatalf("unexpected root attributes: %+v", spans["root"].Attributes)
+	}
+
+	if _, span := tracer.Start(context.Background(), "after-shutdown"); span != nil {
+		t.Fatalf("expected nil span after shutdown")
+	}
+}
+
+func TestNilTracerIsNoop(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "noop")
+	span.SetAttributes("k", 1)
+	span.SetError(errors.New("x"))
+	span.End()
+	if TraceParent(ctx) != "" {
+		t.Fatalf("expected empty traceparent without a tracer")
+	}
+}
+
+func TestFileExporterWritesOTLPLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	shutdown, err := Setup("pinch-test", "file:"+path)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	_, span := Start(context.Background(), "ftcp.send", "fid", uint64(7), "bytes", int64(42))
+	span.End()
+	if err := shutdown(); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if Default() != nil {
+		t.Fatalf("expected default tracer cleared after shutdown")
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read trace file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one OTLP line, got %d", len(lines))
+	}
+	var req otlpRequest
+	if err := json.Unmarshal([]byte(lines[0]), &req); err != nil {
+		t.Fatalf("decode OTLP line: %v", err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected OTLP envelope: %s", lines[0])
+	}
+	if got := *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; got != "pinch-test" {
+		t.Fatalf("unexpected service.name %q", got)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 || spans[0].Name != "ftcp.send" || len(spans[0].TraceID) != 32 || len(spans[0].SpanID) != 16 {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+	if len(spans[0].Attributes) != 2 || *spans[0].Attributes[0].Value.IntValue != "7" {
+		t.Fatalf("unexpected attributes: %+v", spans[0].Attributes)
+	}
+}
+
+func TestOTLPExporterPostsToTracesPath(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+		body  []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		body = raw
+		mu.Unlock()
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	exp, err := NewExporter(srv.URL)
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	tracer := NewTracer("pinch-test", exp)
+	_, span := tracer.Start(context.Background(), "ftcp.txfer")
+	span.End()
+	tracer.Flush()
+	if err := tracer.Shutdown(); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || paths[0] != "/v1/traces" {
+		t.Fatalf("unexpected export paths: %v", paths)
+	}
+	if !strings.Contains(string(body), `"name":"ftcp.txfer"`) {
+		t.Fatalf("unexpected export body: %s", body)
+	}
+
+	if _, err := NewExporter("http://"); err == nil {
+		t.Fatalf("expected error for endpoint without host")
+	}
+}
@@ -1,7 +1,7 @@
 package utils
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"path"
 	"syscall"
@@ -24,7 +24,7 @@ func openToSetFifoSize(filePath string, flag int, bufSize int) *os.File {
 	if ferr == nil {
 		trySetFifoSize(filePath, fd, bufSize)
 	} else {
-		log.Printf("[fifo] Failed to open fifo [%s]: %s", filePath, ferr)
+		slog.Warn("fifo.open_failed", "path", filePath, "err", ferr)
 		return nil
 	}
 	return fd
@@ -39,9 +39,9 @@ func trySetFifoSize(filePath string, fd *os.File, bufSize int) {
 		uintptr(bufSize),
 	)
 	if errno != 0 {
-		log.Printf("[fifo][%s] Failed to set pipe size - %s", filePath, errno)
+		slog.Warn("fifo.resize_failed", "path", filePath, "err", errno)
 	} else {
-		log.Printf("[fifo][%s] Succeeded at setting pipe size to %d", filePath, bufSize)
+		slog.Debug("fifo.resized", "path", filePath, "bytes", bufSize)
 	}
 }
 
@@ -62,7 +62,7 @@ func MakeFifoPair(inDir, outDir, handle string, bufSize int) FifoPair {
 		if in != nil {
 			in.Close()
 		}
-		log.Printf("[%s][fifo]: Could not open writer: %+v", handle, w)
+		slog.Warn("fifo.writer_failed", "handle", handle)
 		return FifoPair{Handle: handle, In: nil, Out: nil}
 	}
 
@@ -88,7 +88,7 @@ type FifoPair struct {
 }
 
 func (fp FifoPair) Close() {
-	log.Printf("[%s][fifo] Closing", fp.Handle)
+	slog.Debug("fifo.closed", "handle", fp.Handle)
 
 	state.MaybeReleaseWriter(fp.Handle)
 	if fp.In != nil {